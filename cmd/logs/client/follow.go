@@ -7,12 +7,18 @@ import (
 	"strings"
 
 	"github.com/QubitProducts/logspray/proto/logspray"
+	"github.com/golang/protobuf/ptypes/timestamp"
 )
 
 func doFollow(ctx context.Context, client logspray.LogServiceClient, args []string) {
 	siglabels := map[string]bool{"job": true}
 	initial := true
 
+	// The time of the last message seen, and the last index seen in
+	// each stream, let us pick up where we left off on reconnecting.
+	var since *timestamp.Timestamp
+	lastIndex := map[string]uint64{}
+
 Reconnect:
 	for {
 		select {
@@ -27,7 +33,12 @@ Reconnect:
 			initial = false
 		}
 		tr := &logspray.TailRequest{
-			Query: strings.Join(args, " "),
+			Query:    strings.Join(args, " "),
+			Backfill: backfill,
+		}
+		if since != nil {
+			tr.Backfill = 0
+			tr.Since = since
 		}
 
 		cctx, cancel := context.WithCancel(ctx)
@@ -70,8 +81,17 @@ Reconnect:
 				continue
 			case logspray.Message_STREAMEND:
 				delete(hdrs, m.StreamID)
+				delete(lastIndex, m.StreamID)
+				continue
+			}
+
+			if idx, ok := lastIndex[m.StreamID]; ok && m.Index <= idx {
 				continue
 			}
+			lastIndex[m.StreamID] = m.Index
+			if m.Time != nil {
+				since = m.Time
+			}
 
 			hdr, _ := hdrs[m.StreamID]
 
@@ -2,7 +2,13 @@ package client
 
 import (
 	"encoding/json"
+	"io"
 	"text/template"
+
+	"github.com/Masterminds/sprig"
+	"github.com/golang/protobuf/ptypes"
+
+	"github.com/QubitProducts/logspray/proto/logspray"
 )
 
 var formattingFuncMap = template.FuncMap{
@@ -13,3 +19,24 @@ func formatJSON(i interface{}) string {
 	bs, _ := json.Marshal(i)
 	return string(bs)
 }
+
+// NewTemplate compiles a template, as passed to the --fmt flag, for
+// formatting messages with WriteMessage.
+func NewTemplate(format string) (*template.Template, error) {
+	return template.New("out").
+		Funcs(sprig.TxtFuncMap()).
+		Funcs(formattingFuncMap).
+		Parse(format + "\n")
+}
+
+// WriteMessage formats a message using a template from NewTemplate.
+func WriteMessage(w io.Writer, tmpl *template.Template, m *logspray.Message) error {
+	tm := map[string]interface{}{}
+	tm["Text"] = m.Text
+	mtime, _ := ptypes.Timestamp(m.Time)
+	tm["Time"] = mtime
+	tm["Labels"] = m.Labels
+	tm["ID"], _ = m.ID()
+
+	return tmpl.ExecuteTemplate(w, "out", tm)
+}
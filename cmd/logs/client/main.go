@@ -22,9 +22,7 @@ import (
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/status"
 
-	"github.com/Masterminds/sprig"
 	"github.com/golang/glog"
-	"github.com/golang/protobuf/ptypes"
 	"github.com/spf13/cobra"
 
 	"github.com/QubitProducts/logspray/cmd/logs/root"
@@ -41,6 +39,9 @@ var (
 	grepv      bool
 	offset     uint64
 	count      uint64
+	cursor     string
+	reverse    bool
+	backfill   int64
 	startTime  = cliTime(time.Now().Add(-1 * time.Hour))
 	endTime    = cliTime(time.Now())
 
@@ -71,8 +72,11 @@ func init() {
 	clientCmd.Flags().BoolVar(&grepv, "grep-v", false, "Negate regex match")
 	clientCmd.Flags().Uint64Var(&count, "count", 10, "number of values to return")
 	clientCmd.Flags().Uint64Var(&offset, "offset", 0, "number of values to skip")
+	clientCmd.Flags().StringVar(&cursor, "cursor", "", "resume a search after the cursor printed by a previous one")
+	clientCmd.Flags().BoolVar(&reverse, "reverse", false, "search for the newest log entries first")
 
 	clientCmd.Flags().BoolVarP(&follow, "follow", "f", false, "follow logs")
+	clientCmd.Flags().Int64Var(&backfill, "backfill", 0, "number of recent log entries to show before following")
 	clientCmd.Flags().BoolVar(&listLabels, "labels", false, "list labels and label values")
 	clientCmd.Flags().BoolVar(&search, "search", false, "search for log entries")
 }
@@ -145,10 +149,7 @@ func run(cmd *cobra.Command, args []string) error {
 	defer conn.Close()
 	client := logspray.NewLogServiceClient(conn)
 
-	outTmpl, err = template.New("out").
-		Funcs(sprig.TxtFuncMap()).
-		Funcs(formattingFuncMap).
-		Parse(format + "\n")
+	outTmpl, err = NewTemplate(format)
 	if err != nil {
 		fatalf("failed to compile output template, %v", err)
 	}
@@ -178,14 +179,7 @@ func outputMessage(m *logspray.Message) {
 	if glog.V(2) {
 		glog.Infof("Raw: %#v\n", *m)
 	}
-	tm := map[string]interface{}{}
-	tm["Text"] = m.Text
-	mtime, _ := ptypes.Timestamp(m.Time)
-	tm["Time"] = mtime
-	tm["Labels"] = m.Labels
-	tm["ID"], _ = m.ID()
-
-	if err := outTmpl.ExecuteTemplate(os.Stdout, "out", tm); err != nil {
+	if err := WriteMessage(os.Stdout, outTmpl, m); err != nil {
 		fatalf("Got error executing template, %v", err)
 	}
 }
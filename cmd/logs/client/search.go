@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
 	"reflect"
 	"strings"
 	"time"
@@ -18,11 +19,13 @@ func doSearch(ctx context.Context, client logspray.LogServiceClient, args []stri
 	et, _ := ptypes.TimestampProto(time.Time(endTime))
 
 	sr := &logspray.SearchRequest{
-		From:   st,
-		To:     et,
-		Offset: offset,
-		Count:  count,
-		Query:  strings.Join(args, " "),
+		From:    st,
+		To:      et,
+		Offset:  offset,
+		Count:   count,
+		Reverse: reverse,
+		Cursor:  cursor,
+		Query:   strings.Join(args, " "),
 	}
 	res, err := client.SearchStream(ctx, sr)
 	if err != nil {
@@ -48,6 +51,9 @@ func doSearch(ctx context.Context, client logspray.LogServiceClient, args []stri
 	for {
 		m, err := res.Recv()
 		if err == io.EOF {
+			if cs := res.Trailer().Get(logspray.CursorTrailer); len(cs) > 0 {
+				fmt.Fprintf(os.Stderr, "-- next page: --cursor=%s\n", cs[0])
+			}
 			return
 		}
 		if err != nil {
@@ -0,0 +1,129 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package index
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/spf13/cobra"
+
+	"github.com/QubitProducts/logspray/cmd/logs/client"
+	"github.com/QubitProducts/logspray/indexer"
+	"github.com/QubitProducts/logspray/proto/logspray"
+	"github.com/QubitProducts/logspray/ql"
+)
+
+var (
+	catFormat string
+	catStart  string
+	catEnd    string
+)
+
+func init() {
+	catCmd.Flags().StringVar(&catFormat, "fmt", "{{.Text}}", "Go template to use to format each output line")
+	catCmd.Flags().StringVar(&catStart, "start", "", "Only show messages from this time, in RFC3339 format")
+	catCmd.Flags().StringVar(&catEnd, "end", "", "Only show messages up to this time, in RFC3339 format")
+}
+
+var catCmd = &cobra.Command{
+	Use:     "cat DIR|FILE [QUERY]...",
+	Short:   "print the messages in shard files that match a query",
+	Example: `logs index cat data/ job=myjob "error"`,
+	Args:    cobra.MinimumNArgs(1),
+	RunE:    runCat,
+}
+
+func parseOptTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func runCat(cmd *cobra.Command, args []string) error {
+	tmpl, err := client.NewTemplate(catFormat)
+	if err != nil {
+		return fmt.Errorf("failed to compile output template, %w", err)
+	}
+	pl, err := ql.CompilePipeline(strings.Join(args[1:], " "))
+	if err != nil {
+		return err
+	}
+	from, err := parseOptTime(catStart)
+	if err != nil {
+		return fmt.Errorf("invalid start time, %w", err)
+	}
+	to, err := parseOptTime(catEnd)
+	if err != nil {
+		return fmt.Errorf("invalid end time, %w", err)
+	}
+
+	fns, err := shardFiles(args[0])
+	if err != nil {
+		return err
+	}
+	for _, fn := range fns {
+		if err := catFile(fn, tmpl, pl, from, to); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", fn, err)
+		}
+	}
+	return nil
+}
+
+func catFile(fn string, tmpl *template.Template, pl *ql.Pipeline, from, to time.Time) error {
+	sfi, err := indexer.OpenShardFileIterator(fn)
+	if err != nil {
+		return err
+	}
+	defer sfi.Close()
+
+	hdr := sfi.Header()
+	if !pl.Match(hdr, nil, true) {
+		return nil
+	}
+	sfi.SkipCorrupt(func(err error) {
+		fmt.Fprintf(os.Stderr, "%s: skipping corrupt data, %v\n", fn, err)
+	})
+
+	msgFunc := pl.MessageFunc(logspray.MakeFlattenStreamFunc(func(m *logspray.Message) error {
+		return client.WriteMessage(os.Stdout, tmpl, m)
+	}))
+	if err = msgFunc(hdr); err != nil {
+		return err
+	}
+
+	sfi.Next() // the header
+	for sfi.Next() {
+		m := sfi.Message()
+		m.StreamID = hdr.StreamID
+		t, _ := ptypes.Timestamp(m.Time)
+		if (!from.IsZero() && t.Before(from)) || (!to.IsZero() && t.After(to)) {
+			continue
+		}
+		if !pl.Match(hdr, m, false) {
+			continue
+		}
+		if err = msgFunc(m); err != nil {
+			return err
+		}
+	}
+	return sfi.Err()
+}
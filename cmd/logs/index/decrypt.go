@@ -0,0 +1,155 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package index
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/openpgp"
+
+	"github.com/QubitProducts/logspray/indexer"
+)
+
+var (
+	decryptKey        string
+	decryptPassphrase string
+	decryptOut        string
+)
+
+func init() {
+	decryptCmd.Flags().StringVar(&decryptKey, "key", "", "OpenPGP private keyring holding a key the shards were encrypted to")
+	decryptCmd.Flags().StringVar(&decryptPassphrase, "passphrase-file", "", "File holding the passphrase of the private key")
+	decryptCmd.Flags().StringVar(&decryptOut, "out", "restored", "Directory to write the decrypted shards to")
+}
+
+var decryptCmd = &cobra.Command{
+	Use:   "decrypt DIR|FILE...",
+	Short: "decrypt encrypted shards",
+	Long: `decrypt restores the files of shards that were encrypted by a server
+	started with --index.encrypt-keyring. Each shard is written to its own
+	directory within the output directory, which can be read with cat, or
+	copied into the index directory of a server to be searched. Encrypted shards
+	are found in an index directory, or may be given as the FILE.tar.gpg items
+	uploaded to remote storage.`,
+	Example:      `logs index decrypt --key private.asc --out restored data/`,
+	Args:         cobra.MinimumNArgs(1),
+	RunE:         runDecrypt,
+	SilenceUsage: true,
+}
+
+// encryptedShards returns the encrypted shards at path, by shard ID.
+// path may be an encrypted pack, or a directory holding them.
+func encryptedShards(path string) (map[string]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		id := strings.TrimSuffix(filepath.Base(path), ".tar.gpg")
+		if filepath.Base(path) == indexer.EncryptedFileName {
+			id = filepath.Base(filepath.Dir(path))
+		}
+		return map[string]string{id: path}, nil
+	}
+
+	res := map[string]string{}
+	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && info.Name() == indexer.EncryptedFileName {
+			res[filepath.Base(filepath.Dir(p))] = p
+		}
+		return nil
+	})
+	return res, err
+}
+
+// unlockKeys returns an openpgp.PromptFunction that decrypts private
+// keys with passphrase.
+func unlockKeys(passphrase []byte) openpgp.PromptFunction {
+	tried := false
+	return func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		if tried || symmetric || passphrase == nil {
+			return nil, errors.New("no valid passphrase for the private key")
+		}
+		tried = true
+		for _, k := range keys {
+			if k.PrivateKey != nil && k.PrivateKey.Encrypted {
+				k.PrivateKey.Decrypt(passphrase)
+			}
+		}
+		return nil, nil
+	}
+}
+
+func runDecrypt(cmd *cobra.Command, args []string) error {
+	if decryptKey == "" {
+		return errors.New("a private key must be given with --key")
+	}
+	keyring, err := indexer.ReadKeyRing(decryptKey)
+	if err != nil {
+		return err
+	}
+	var passphrase []byte
+	if decryptPassphrase != "" {
+		if passphrase, err = ioutil.ReadFile(decryptPassphrase); err != nil {
+			return fmt.Errorf("failed to read passphrase, %w", err)
+		}
+		passphrase = bytes.TrimRight(passphrase, "\r\n")
+	}
+
+	failed := 0
+	for _, path := range args {
+		shards, err := encryptedShards(path)
+		if err != nil {
+			return err
+		}
+		for id, fn := range shards {
+			dir := filepath.Join(decryptOut, id)
+			if err := decryptShard(fn, keyring, unlockKeys(passphrase), dir); err != nil {
+				fmt.Printf("%s: %v\n", fn, err)
+				failed++
+				continue
+			}
+			fmt.Printf("%s: decrypted to %s\n", fn, dir)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to decrypt %d shards", failed)
+	}
+	return nil
+}
+
+func decryptShard(fn string, keyring openpgp.EntityList, prompt openpgp.PromptFunction, dir string) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return indexer.DecryptShard(f, keyring, prompt, dir)
+}
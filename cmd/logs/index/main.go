@@ -0,0 +1,68 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/QubitProducts/logspray/cmd/logs/root"
+)
+
+func init() {
+	root.RootCmd.AddCommand(indexCmd)
+	indexCmd.AddCommand(verifyCmd)
+	indexCmd.AddCommand(repairCmd)
+	indexCmd.AddCommand(statsCmd)
+	indexCmd.AddCommand(catCmd)
+	indexCmd.AddCommand(decryptCmd)
+}
+
+var indexCmd = &cobra.Command{
+	Use:   "index",
+	Short: "tools for working with a server's index data",
+	Long: `index provides offline tools for checking, repairing and reading
+	the shard files in a server's index directory.`,
+}
+
+// shardFiles returns the shard files at path, either a single file,
+// or all the files in an index directory.
+func shardFiles(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+
+	var fns []string
+	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(p, ".pb.log") {
+			fns = append(fns, p)
+		}
+		return nil
+	})
+	sort.Strings(fns)
+	return fns, err
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package index
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/QubitProducts/logspray/indexer"
+)
+
+var repairCmd = &cobra.Command{
+	Use:   "repair DIR|FILE...",
+	Short: "rewrite damaged shard files, dropping corrupt records",
+	Long: `repair checks each shard file, as verify does, and rewrites those
+	with problems, dropping any corrupt records and partial writes. It must not
+	be run against the index directory of a running server.`,
+	Args:         cobra.MinimumNArgs(1),
+	RunE:         runRepair,
+	SilenceUsage: true,
+}
+
+func runRepair(cmd *cobra.Command, args []string) error {
+	failed := 0
+	for _, path := range args {
+		fns, err := shardFiles(path)
+		if err != nil {
+			return err
+		}
+		for _, fn := range fns {
+			if checkFile(fn).ok() {
+				continue
+			}
+			dropped, err := indexer.RepairShardFile(fn)
+			if err != nil {
+				fmt.Printf("%s: repair failed, %v\n", fn, err)
+				failed++
+				continue
+			}
+			fmt.Printf("%s: repaired, dropped %d corrupt records or blocks\n", fn, dropped)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to repair %d files", failed)
+	}
+	return nil
+}
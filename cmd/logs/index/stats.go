@@ -0,0 +1,164 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package index
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/spf13/cobra"
+
+	"github.com/QubitProducts/logspray/indexer"
+)
+
+var showLabelStats bool
+
+func init() {
+	statsCmd.Flags().BoolVar(&showLabelStats, "labels", true, "show counts for each label value")
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats DIR|FILE...",
+	Short: "show message counts, sizes and time ranges for shards and labels",
+	Long: `stats reads every message in the shard files, and summarises them
+	by shard, and by label value. Sizes for shards are the bytes used on disk,
+	for labels they are the bytes of message text.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runStats,
+}
+
+// summary accumulates the stats for a shard or a label value.
+type summary struct {
+	files       int
+	messages    int
+	bytes       int64
+	first, last time.Time
+}
+
+func (s *summary) add(t time.Time) {
+	s.messages++
+	if s.first.IsZero() || t.Before(s.first) {
+		s.first = t
+	}
+	if t.After(s.last) {
+		s.last = t
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func runStats(cmd *cobra.Command, args []string) error {
+	shards := map[string]*summary{}
+	labels := map[string]*summary{}
+	for _, path := range args {
+		fns, err := shardFiles(path)
+		if err != nil {
+			return err
+		}
+		for _, fn := range fns {
+			fi, err := os.Stat(fn)
+			if err != nil {
+				return err
+			}
+			id := filepath.Base(filepath.Dir(fn))
+			ss, ok := shards[id]
+			if !ok {
+				ss = &summary{}
+				shards[id] = ss
+			}
+			ss.files++
+			ss.bytes += fi.Size()
+
+			if err = statFile(fn, ss, labels); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", fn, err)
+			}
+		}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SHARD\tFILES\tMESSAGES\tBYTES\tFIRST\tLAST")
+	for _, k := range sortedKeys(shards) {
+		s := shards[k]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%s\n", k, s.files, s.messages, s.bytes, formatTime(s.first), formatTime(s.last))
+	}
+	if showLabelStats {
+		fmt.Fprintln(tw, "\nLABEL\t\tMESSAGES\tBYTES\tFIRST\tLAST")
+		for _, k := range sortedKeys(labels) {
+			s := labels[k]
+			fmt.Fprintf(tw, "%s\t\t%d\t%d\t%s\t%s\n", k, s.messages, s.bytes, formatTime(s.first), formatTime(s.last))
+		}
+	}
+	return tw.Flush()
+}
+
+// statFile adds the messages in a file to the stats for its shard, and
+// for each of their labels.
+func statFile(fn string, shard *summary, labels map[string]*summary) error {
+	sfi, err := indexer.OpenShardFileIterator(fn)
+	if err != nil {
+		return err
+	}
+	defer sfi.Close()
+
+	sfi.SkipCorrupt(func(err error) {
+		fmt.Fprintf(os.Stderr, "%s: skipping corrupt data, %v\n", fn, err)
+	})
+	hdr := sfi.Header()
+	sfi.Next() // the header
+	for sfi.Next() {
+		m := sfi.Message()
+		t, _ := ptypes.Timestamp(m.Time)
+		shard.add(t)
+
+		ls := map[string]string{}
+		for k, v := range hdr.Labels {
+			ls[k] = v
+		}
+		for k, v := range m.Labels {
+			ls[k] = v
+		}
+		for k, v := range ls {
+			kv := k + "=" + v
+			s, ok := labels[kv]
+			if !ok {
+				s = &summary{}
+				labels[kv] = s
+			}
+			s.add(t)
+			s.bytes += int64(len(m.Text))
+		}
+	}
+	return sfi.Err()
+}
+
+func sortedKeys(m map[string]*summary) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
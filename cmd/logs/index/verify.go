@@ -0,0 +1,101 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package index
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/QubitProducts/logspray/indexer"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:          "verify DIR|FILE...",
+	Short:        "check shard files for corrupt or truncated records",
+	Args:         cobra.MinimumNArgs(1),
+	RunE:         runVerify,
+	SilenceUsage: true,
+}
+
+// fileCheck is the result of checking a shard file.
+type fileCheck struct {
+	messages int
+	corrupt  []error
+	torn     int64 // bytes of a partial write at the end of the file
+	err      error // set if the file could not be read
+}
+
+func (c fileCheck) ok() bool {
+	return c.err == nil && c.torn == 0 && len(c.corrupt) == 0
+}
+
+func checkFile(fn string) fileCheck {
+	var c fileCheck
+	if c.torn, c.err = indexer.TornTail(fn); c.err != nil {
+		return c
+	}
+
+	sfi, err := indexer.OpenShardFileIterator(fn)
+	if err != nil {
+		c.err = err
+		return c
+	}
+	defer sfi.Close()
+
+	sfi.SkipCorrupt(func(err error) {
+		c.corrupt = append(c.corrupt, err)
+	})
+	sfi.Next() // the header
+	for sfi.Next() {
+		c.messages++
+	}
+	c.err = sfi.Err()
+	return c
+}
+
+func runVerify(cmd *cobra.Command, args []string) error {
+	total, bad := 0, 0
+	for _, path := range args {
+		fns, err := shardFiles(path)
+		if err != nil {
+			return err
+		}
+		for _, fn := range fns {
+			total++
+			c := checkFile(fn)
+			if c.ok() {
+				continue
+			}
+			bad++
+			if c.err != nil {
+				fmt.Printf("%s: unreadable, %v\n", fn, c.err)
+			}
+			if c.torn > 0 {
+				fmt.Printf("%s: truncated, %d bytes of a partial write at the end\n", fn, c.torn)
+			}
+			for _, err := range c.corrupt {
+				fmt.Printf("%s: corrupt, %v\n", fn, err)
+			}
+		}
+	}
+
+	fmt.Printf("%d of %d files have problems\n", bad, total)
+	if bad > 0 {
+		return fmt.Errorf("found %d damaged files", bad)
+	}
+	return nil
+}
@@ -60,6 +60,9 @@ var (
 	insecure       bool
 	svcj           string
 	oauth2TokenURL string
+	acks           bool
+	replayMax      int
+	replayDir      string
 )
 
 func init() {
@@ -76,6 +79,9 @@ func init() {
 	readerCmd.Flags().BoolVar(&insecure, "tls.insecure", false, "Turn off transport cert verification")
 	readerCmd.Flags().StringVar(&svcj, "service", "", "Google service account file")
 	readerCmd.Flags().StringVar(&oauth2TokenURL, "oauth2.token_url", "", "URL for oauth2 tokens")
+	readerCmd.Flags().BoolVar(&acks, "acks", false, "Resend messages the server hasn't acknowledged after reconnecting, the server must support LogStreamV2")
+	readerCmd.Flags().IntVar(&replayMax, "replay.max-messages", 100000, "Maximum unacknowledged messages kept per stream to resend, with --acks, 0 disables the limit")
+	readerCmd.Flags().StringVar(&replayDir, "replay.dir", "", "Directory to keep unacknowledged messages in, rather than memory, with --acks, those left by a previous reader are resent at startup")
 }
 
 var readerCmd = &cobra.Command{
@@ -153,7 +159,15 @@ func run(*cobra.Command, []string) {
 		defer conn.Close()
 
 		client := logspray.NewLogServiceClient(conn)
-		outSink = remote.New(client)
+		var ropts []remote.Opt
+		if acks {
+			ropts = append(ropts, remote.WithAcks(replayMax, replayDir))
+		}
+		rs := remote.New(client, ropts...)
+		if err := rs.ResendBuffered(); err != nil {
+			glog.Errorf("failed resending replay buffers, %v", err)
+		}
+		outSink = rs
 	}
 
 	targetRules := relabel.Config{}
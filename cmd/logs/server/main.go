@@ -28,19 +28,23 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/openpgp"
 	"golang.org/x/net/context"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 
 	"github.com/golang/glog"
+	"github.com/graymeta/stow"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
@@ -63,10 +67,30 @@ var (
 	indexDir      string
 	shardDuration time.Duration
 	retention     time.Duration
+	maxBytes      int64
+	highWater     float64
+	lowWater      float64
 	searchGrace   time.Duration
+	bloomTokens   int
+	bloomFPRate   float64
+	compression   string
+	blockSize     int
+	tidxRecords   int
+	tidxBytes     int64
+	archiveGzip   int
+	stowKind      string
+	stowConfig    []string
+	stowCont      string
+	evictAfter    time.Duration
+	encryptTo     string
+	fsync         string
+	fsyncInterval time.Duration
+	dedupWindow   time.Duration
 
 	grafanaBasicAuthUser string
 	grafanaBasicAuthPass string
+
+	shutdownTimeout time.Duration
 )
 
 func init() {
@@ -85,10 +109,30 @@ func init() {
 	serverCmd.Flags().StringVar(&indexDir, "index.dir", "data", "Directory to store the index data in")
 	serverCmd.Flags().DurationVar(&shardDuration, "index.shard-duration", 15*time.Minute, "Length of eacch index shard")
 	serverCmd.Flags().DurationVar(&retention, "index.retention", 0*time.Hour, "Length of eacch index shard, 0 disables pruning")
+	serverCmd.Flags().Int64Var(&maxBytes, "index.max-bytes", 0, "Maximum disk space used by the index, the oldest shards are pruned to stay within it, 0 disables the limit")
+	serverCmd.Flags().Float64Var(&highWater, "index.high-watermark", 1, "Fraction of index.max-bytes used at which pruning starts")
+	serverCmd.Flags().Float64Var(&lowWater, "index.low-watermark", 0.9, "Fraction of index.max-bytes used at which pruning stops")
 	serverCmd.Flags().DurationVar(&searchGrace, "index.search-grace", 15*time.Minute, "shards started +/- search grace will be included in searches")
+	serverCmd.Flags().IntVar(&bloomTokens, "index.bloom-tokens", 10000, "Expected distinct tokens per stream in a shard, used to size bloom filters, 0 disables them")
+	serverCmd.Flags().Float64Var(&bloomFPRate, "index.bloom-fp-rate", 0.01, "Bloom filter false positive rate")
+	serverCmd.Flags().StringVar(&compression, "index.compression", "none", "Compression for index shard files, one of none, snappy or gzip")
+	serverCmd.Flags().IntVar(&blockSize, "index.block-size", 64*1024, "Uncompressed size of the compressed blocks in shard files")
+	serverCmd.Flags().IntVar(&tidxRecords, "index.time-index-records", 1000, "Records between marks in the time index of uncompressed shard files, 0 disables the limit")
+	serverCmd.Flags().Int64Var(&tidxBytes, "index.time-index-bytes", 64*1024, "Bytes between marks in the time index of uncompressed shard files, 0 disables the limit")
+	serverCmd.Flags().IntVar(&archiveGzip, "index.archive-gzip-level", 0, "Recompress archived shards with gzip at this level, 0 disables recompression")
+	serverCmd.Flags().StringVar(&stowKind, "index.stow-kind", "", "Kind of stow location to upload closed shards to, empty disables uploads")
+	serverCmd.Flags().StringSliceVar(&stowConfig, "index.stow-config", nil, "Stow location configuration, as key=value pairs")
+	serverCmd.Flags().StringVar(&stowCont, "index.stow-container", "logspray", "Stow container to upload closed shards to")
+	serverCmd.Flags().DurationVar(&evictAfter, "index.evict-after", 0, "Remove local copies of uploaded shards unused for this long, 0 keeps them")
+	serverCmd.Flags().StringVar(&encryptTo, "index.encrypt-keyring", "", "OpenPGP public keyring, closed shards are encrypted to every key in it, and can no longer be searched")
+	serverCmd.Flags().StringVar(&fsync, "index.fsync", "rotate", "When shard files are synced to disk, one of rotate, interval or write, which requires index.compression=none. With rotate, LogStreamV2 acknowledges messages once written rather than synced")
+	serverCmd.Flags().DurationVar(&fsyncInterval, "index.fsync-interval", time.Second, "How often shard files are synced to disk with index.fsync=interval")
+	serverCmd.Flags().DurationVar(&dedupWindow, "index.dedup-window", time.Hour, "How long the last Index of an idle stream is remembered, to drop messages resent within it, 0 remembers every stream")
 
 	serverCmd.Flags().StringVar(&grafanaBasicAuthUser, "grafana.user", os.Getenv("GRAFANA_BASICAUTH_USER"), "User for grafana simplejson basic auth")
 	serverCmd.Flags().StringVar(&grafanaBasicAuthPass, "grafana.pass", os.Getenv("GRAFANA_BASICAUTH_PASS"), "Password for grafana simplejson basic auth")
+
+	serverCmd.Flags().DurationVar(&shutdownTimeout, "shutdown-timeout", 10*time.Second, "How long to wait for streams to finish when shutting down, before they are cut off")
 }
 
 // rootCmd represents the base command when called without any subcommands
@@ -215,11 +259,40 @@ func run(*cobra.Command, []string) error {
 
 	var indx *indexer.Indexer
 	if indexDir != "" {
+		scfg := stow.ConfigMap{}
+		for _, kv := range stowConfig {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 {
+				glog.Fatalf("Invalid stow config %q, expected key=value", kv)
+			}
+			scfg[parts[0]] = parts[1]
+		}
+
+		var recipients []openpgp.Entity
+		if encryptTo != "" {
+			el, err := indexer.ReadKeyRing(encryptTo)
+			if err != nil {
+				glog.Fatalf("Unable to read encryption keyring, %v", err)
+			}
+			for _, e := range el {
+				recipients = append(recipients, *e)
+			}
+		}
+
 		indx, err = indexer.New(
 			indexer.WithDataDir(indexDir),
 			indexer.WithSharDuration(shardDuration),
 			indexer.WithSearchGrace(searchGrace),
 			indexer.WithRetention(retention),
+			indexer.WithMaxBytes(maxBytes, highWater, lowWater),
+			indexer.WithBloomFilter(bloomTokens, bloomFPRate),
+			indexer.WithCompression(compression, blockSize),
+			indexer.WithTimeIndex(tidxRecords, tidxBytes),
+			indexer.WithArchiveGzipLevel(archiveGzip),
+			indexer.WithStow(stowKind, scfg, stowCont, evictAfter),
+			indexer.WithEncryptTo(recipients),
+			indexer.WithFsync(fsync, fsyncInterval),
+			indexer.WithDedupWindow(dedupWindow),
 		)
 		if err != nil {
 			glog.Fatalf("Unable to create index, err = %v", err)
@@ -239,11 +312,41 @@ func run(*cobra.Command, []string) error {
 		glog.Fatalf("Failed to register endpoints, %v", err)
 	}
 
+	// Streams are given shutdownTimeout to finish, and then the active
+	// shard is closed before exiting, so that the messages written to
+	// it are kept. grpcServer is served through srv, and ServeHTTP
+	// doesn't support GracefulStop, so srv is shut down instead.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		glog.Infof("Received %v, shutting down", sig)
+		sctx, cancel := context.WithTimeout(ctx, shutdownTimeout)
+		if err := srv.Shutdown(sctx); err != nil {
+			glog.Warningf("Streams still open after %v, stopping them, %v", shutdownTimeout, err)
+			grpcServer.Stop()
+			srv.Close()
+		}
+		cancel()
+		if indx != nil {
+			if err := indx.Close(); err != nil {
+				glog.Errorf("Failed to close index, %v", err)
+			}
+		}
+		glog.Flush()
+		os.Exit(0)
+	}()
+
 	e := errgroup.Group{}
 
 	e.Go(func() error {
 		glog.Infof("grpc at: %s\n", tlsAddr)
-		return srv.Serve(tls.NewListener(conn, srv.TLSConfig))
+		err := srv.Serve(tls.NewListener(conn, srv.TLSConfig))
+		if err == http.ErrServerClosed {
+			// The signal handler exits once the index is closed.
+			select {}
+		}
+		return err
 	})
 
 	if adminAddr != "" {
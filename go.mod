@@ -19,6 +19,7 @@ require (
 	github.com/gogo/protobuf v1.1.1
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
 	github.com/golang/protobuf v1.2.0
+	github.com/golang/snappy v1.0.0
 	github.com/google/uuid v1.0.0 // indirect
 	github.com/graymeta/stow v0.1.0
 	github.com/grpc-ecosystem/go-grpc-middleware v1.0.0
@@ -16,7 +16,9 @@
 package indexer
 
 import (
+	"compress/gzip"
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
@@ -32,18 +34,30 @@ import (
 
 	"github.com/graymeta/stow"
 	//_ "github.com/graymeta/stow/google"
-	//_ "github.com/graymeta/stow/local"
+	_ "github.com/graymeta/stow/local"
 	//_ "github.com/graymeta/stow/s3"
 )
 
 type shardArchive struct {
 	dataDir     string
+	stowKind    string
 	stowConfig  stow.ConfigMap
+	stowCont    string
+	evictAfter  time.Duration
+	store       *remoteStore
 	retention   time.Duration
 	encryptTo   []openpgp.Entity
 	gzipLevel   int
 	searchGrace time.Duration
 
+	// When the data directory uses more than highWater of maxBytes,
+	// the oldest shards are pruned until it uses less than lowWater.
+	maxBytes  int64
+	highWater float64
+	lowWater  float64
+
+	pruneLock sync.Mutex // held while pruning
+
 	sync.RWMutex
 	history      map[time.Time][]*Shard
 	historyOrder []time.Time
@@ -57,6 +71,8 @@ func NewArchive(opts ...ArchiveOpt) (*shardArchive, error) {
 		dataDir:     "data",
 		history:     map[time.Time][]*Shard{},
 		searchGrace: time.Minute * 15,
+		highWater:   1,
+		lowWater:    0.9,
 	}
 	for _, o := range opts {
 		a, err = o(a)
@@ -83,15 +99,47 @@ func NewArchive(opts ...ArchiveOpt) (*shardArchive, error) {
 			return filepath.SkipDir
 		}
 
-		t := ulid.Time(uid.Time())
-		shards = append(shards, &Shard{
+		// The start of shards closed before manifests were kept
+		// is only known from their ID.
+		s := &Shard{
 			id:         uid.String(),
-			shardStart: t,
+			shardStart: ulid.Time(uid.Time()),
 			dataDir:    path,
-		})
+			sealed:     true,
+		}
+		m, err := readManifest(path)
+		switch {
+		case err == nil:
+			s.shardStart = m.Start
+			s.manifest = m
+		case !os.IsNotExist(err):
+			glog.Errorf("failed reading manifest of shard %s, %v", s.id, err)
+		}
+		shards = append(shards, s)
 		return filepath.SkipDir
 	})
 
+	if a.stowKind != "" {
+		a.store = &remoteStore{kind: a.stowKind, config: a.stowConfig, container: a.stowCont}
+		if a.evictAfter > 0 {
+			go a.evictLoop()
+		}
+	}
+
+	for _, s := range shards {
+		s.loadEncrypted()
+		if a.store != nil {
+			s.loadRemote(a.store)
+		}
+		s.truncateTornTails()
+
+		// Shards left without a manifest, by a crash, or from before
+		// manifests were kept, are only placed by the times of their
+		// messages once it is built.
+		if s.manifest == nil && !s.isEncrypted() {
+			s.shardStart = s.loadManifest().Start
+		}
+	}
 	a.Add(shards...)
 
 	return a, nil
@@ -118,6 +166,30 @@ func WithArchiveStowConfig(scfg stow.ConfigMap) ArchiveOpt {
 	}
 }
 
+// WithArchiveStowLocation uploads shards added to the archive to the
+// named container of a stow location of the given kind, configured by
+// WithArchiveStowConfig. An empty kind disables uploads.
+func WithArchiveStowLocation(kind, container string) ArchiveOpt {
+	return func(a *shardArchive) (*shardArchive, error) {
+		if kind != "" && container == "" {
+			return nil, fmt.Errorf("a stow container must be given")
+		}
+		a.stowKind = kind
+		a.stowCont = container
+		return a, nil
+	}
+}
+
+// WithArchiveEvictAfter removes the local copy of uploaded shards once
+// they haven't been used for d. Evicted shards are fetched again when
+// they are searched. 0 keeps the local copies.
+func WithArchiveEvictAfter(d time.Duration) ArchiveOpt {
+	return func(a *shardArchive) (*shardArchive, error) {
+		a.evictAfter = d
+		return a, nil
+	}
+}
+
 func WithArchiveRetention(d time.Duration) ArchiveOpt {
 	return func(a *shardArchive) (*shardArchive, error) {
 		a.retention = d
@@ -125,6 +197,29 @@ func WithArchiveRetention(d time.Duration) ArchiveOpt {
 	}
 }
 
+// WithArchiveMaxBytes limits the disk space used by the data directory
+// to max bytes. Once more than highWater of max is used, the oldest
+// shards are pruned until less than lowWater of max is used. A max of 0
+// disables the limit.
+func WithArchiveMaxBytes(max int64, highWater, lowWater float64) ArchiveOpt {
+	return func(a *shardArchive) (*shardArchive, error) {
+		if max < 0 {
+			return nil, fmt.Errorf("archive size limit must not be negative")
+		}
+		if lowWater <= 0 || lowWater > highWater || highWater > 1 {
+			return nil, fmt.Errorf("archive watermarks must satisfy 0 < low <= high <= 1")
+		}
+		a.maxBytes = max
+		a.highWater = highWater
+		a.lowWater = lowWater
+		return a, nil
+	}
+}
+
+// WithArchiveEncryptTo encrypts the files of shards to the given
+// recipients as they are added to the archive. Encrypted shards are no
+// longer searched, they can be restored with DecryptShard. Shards are
+// only uploaded once encrypted.
 func WithArchiveEncryptTo(ent []openpgp.Entity) ArchiveOpt {
 	return func(a *shardArchive) (*shardArchive, error) {
 		a.encryptTo = ent
@@ -132,8 +227,14 @@ func WithArchiveEncryptTo(ent []openpgp.Entity) ArchiveOpt {
 	}
 }
 
+// WithArchiveGzipCompression rewrites the files of shards added to the
+// archive using gzip compressed blocks, at the given compression level.
+// A level of 0 leaves files as they were written.
 func WithArchiveGzipCompression(level int) ArchiveOpt {
 	return func(a *shardArchive) (*shardArchive, error) {
+		if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+			return nil, fmt.Errorf("invalid gzip compression level %d", level)
+		}
 		a.gzipLevel = level
 		return a, nil
 	}
@@ -155,6 +256,86 @@ func (sa *shardArchive) Add(shards ...*Shard) {
 	sort.Slice(sa.historyOrder, func(i, j int) bool { return sa.historyOrder[i].Before(sa.historyOrder[j]) })
 
 	go sa.prune()
+	if sa.gzipLevel != 0 || len(sa.encryptTo) != 0 || sa.store != nil {
+		go func() {
+			if sa.gzipLevel != 0 {
+				sa.recompress(shards)
+			}
+			ss := shards
+			if len(sa.encryptTo) != 0 {
+				ss = sa.encrypt(shards)
+			}
+			if sa.store != nil {
+				sa.upload(ss)
+			}
+		}()
+	}
+}
+
+// recompress rewrites the files of shards with gzip compression.
+func (sa *shardArchive) recompress(shards []*Shard) {
+	comp := compression{codec: codecGzip, level: sa.gzipLevel, blockSize: defaultBlockSize}
+	for _, s := range shards {
+		glog.V(2).Infof("Compressing archived shard %v", s.id)
+		s.recompress(comp)
+	}
+}
+
+// encrypt encrypts the files of shards, and returns those that are
+// encrypted. Shards that failed are left as they are, and are not
+// uploaded, until they are encrypted when the archive is next opened.
+func (sa *shardArchive) encrypt(shards []*Shard) []*Shard {
+	var encrypted []*Shard
+	for _, s := range shards {
+		if !s.isEncrypted() {
+			glog.V(2).Infof("Encrypting archived shard %v", s.id)
+		}
+		if err := s.encrypt(sa.encryptTo); err != nil {
+			encryptErrors.Inc()
+			glog.Errorf("failed to encrypt shard %s, %v", s.id, err)
+			continue
+		}
+		encrypted = append(encrypted, s)
+	}
+	return encrypted
+}
+
+// upload puts the files of shards in the remote store.
+func (sa *shardArchive) upload(shards []*Shard) {
+	for _, s := range shards {
+		glog.V(2).Infof("Uploading archived shard %v", s.id)
+		if err := s.upload(sa.store); err != nil {
+			glog.Errorf("failed to upload shard %s, %v", s.id, err)
+			remoteUploadErrors.Inc()
+		}
+	}
+}
+
+// evictLoop periodically evicts the local copies of uploaded shards
+// that haven't been used recently.
+func (sa *shardArchive) evictLoop() {
+	interval := sa.evictAfter / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	for range time.Tick(interval) {
+		sa.evict(time.Now().Add(-sa.evictAfter))
+	}
+}
+
+// evict removes the local copies of uploaded shards that haven't been
+// used since t.
+func (sa *shardArchive) evict(t time.Time) {
+	sa.RLock()
+	var shards []*Shard
+	for _, ts := range sa.historyOrder {
+		shards = append(shards, sa.history[ts]...)
+	}
+	sa.RUnlock()
+
+	for _, s := range shards {
+		s.evict(t)
+	}
 }
 
 func (sa *shardArchive) findShards(from, to time.Time) []shardSet {
@@ -180,59 +361,200 @@ func (sa *shardArchive) findShards(from, to time.Time) []shardSet {
 	return qs
 }
 
-func (sa *shardArchive) Search(ctx context.Context, msgFunc logspray.MessageFunc, matcher ql.MatchFunc, from, to time.Time, reverse bool) error {
+// shards returns the archived shards that may hold messages between
+// from and to.
+func (sa *shardArchive) shards(from, to time.Time) []*Shard {
+	var res []*Shard
+	for _, shardSet := range sa.findShards(from.Add(-2*sa.searchGrace), to.Add(sa.searchGrace)) {
+		res = append(res, shardSet...)
+	}
+	return res
+}
+
+func (sa *shardArchive) Search(ctx context.Context, msgFunc logspray.MessageFunc, matcher ql.MatchFunc, tokens ql.TokenFilter, from, to time.Time, reverse bool) error {
 	glog.V(2).Infof("searching archive shards from %v to %v", from, to)
-	foundShardSets := sa.findShards(from.Add(-2*sa.searchGrace), to.Add(sa.searchGrace))
-	glog.V(2).Infof("found %v archived shards", len(foundShardSets))
+	shards := sa.shards(from, to)
+	glog.V(2).Infof("found %v archived shards", len(shards))
+
+	return mergeSearch(ctx, shards, msgFunc, matcher, tokens, from, to, reverse, nil, nil)
+}
 
-	for _, shardSet := range foundShardSets {
+// Explain lists the archived shards that a search from from to to
+// would read.
+func (sa *shardArchive) Explain(matcher ql.MatchFunc, from, to time.Time) ([]*logspray.ExplainShard, error) {
+	var res []*logspray.ExplainShard
+	for _, shardSet := range sa.findShards(from.Add(-2*sa.searchGrace), to.Add(sa.searchGrace)) {
 		for _, ss := range shardSet {
-			if err := ss.Search(ctx, msgFunc, matcher, from, to, reverse); err != nil {
-				return err
+			es, err := ss.explain(matcher, from, to, false)
+			if err != nil {
+				return nil, err
 			}
+			res = append(res, es)
 		}
 	}
 
-	return nil
+	return res, nil
 }
 
-// prune old shards
+// prune deletes shards older than the retention period, and then
+// deletes the oldest shards until the data directory is within its
+// size limit.
 func (sa *shardArchive) prune() {
-	if sa.retention == 0 {
+	sa.pruneLock.Lock()
+	defer sa.pruneLock.Unlock()
+
+	if sa.retention == 0 && sa.maxBytes == 0 {
 		glog.V(2).Infof("Log pruning is disabled")
 		return
 	}
-	sa.Lock()
-	defer sa.Unlock()
 
-	pivotTime := time.Now().Add(-1 * (sa.retention))
-	glog.V(1).Infof("Starting archive prune for shards more than %s old (before %s)", sa.retention, pivotTime)
-	pivot := 0
-	for i := range sa.historyOrder {
-		if sa.historyOrder[i].After(pivotTime) {
-			break
+	if sa.retention != 0 {
+		pivotTime := time.Now().Add(-1 * (sa.retention))
+		glog.V(1).Infof("Starting archive prune for shards more than %s old (before %s)", sa.retention, pivotTime)
+
+		sa.RLock()
+		pivot := 0
+		for i := range sa.historyOrder {
+			if sa.historyOrder[i].After(pivotTime) {
+				break
+			}
+			pivot = i
 		}
-		pivot = i
+		var old []*Shard
+		for _, t := range sa.historyOrder[:pivot] {
+			old = append(old, sa.history[t]...)
+		}
+		sa.RUnlock()
+
+		sa.pruneShards(sa.remove(old), "retention")
 	}
-	oldTs := sa.historyOrder[:pivot]
-	sa.historyOrder = sa.historyOrder[pivot:]
 
-	var oldShards []*Shard
-	for _, t := range oldTs {
-		oldShards = append(oldShards, sa.history[t]...)
-		delete(sa.history, t)
+	if sa.maxBytes == 0 {
+		return
 	}
+	used := dirSize(sa.dataDir)
+	if float64(used) > sa.highWater*float64(sa.maxBytes) {
+		target := int64(sa.lowWater * float64(sa.maxBytes))
+		glog.V(1).Infof("Starting archive prune, %d bytes used, pruning to %d bytes", used, target)
+
+		sa.RLock()
+		var shards []*Shard
+		for _, t := range sa.historyOrder {
+			shards = append(shards, sa.history[t]...)
+		}
+		sa.RUnlock()
+
+		// Uploaded shards can be fetched again, so are evicted before
+		// any shards are deleted.
+		for _, s := range shards {
+			if used <= target {
+				break
+			}
+			used -= s.evict(time.Now())
+		}
+		var old []*Shard
+		for _, s := range shards {
+			if used <= target {
+				break
+			}
+			if s.uploaded() {
+				continue
+			}
+			used -= dirSize(s.dataDir)
+			old = append(old, s)
+		}
 
-	if len(oldShards) > 0 {
-		go sa.pruneShards(oldShards)
+		sa.pruneShards(sa.remove(old), "size")
+		if used > target {
+			glog.Errorf("Pruned all archived shards, %d bytes still in use", used)
+		}
 	}
 }
 
-func (sa *shardArchive) pruneShards(ss []*Shard) {
+// remove removes shards from the archive and returns those that were
+// found.
+func (sa *shardArchive) remove(shards []*Shard) []*Shard {
+	if len(shards) == 0 {
+		return nil
+	}
+	sa.Lock()
+	defer sa.Unlock()
+
+	var old []*Shard
+	for _, s := range shards {
+		ss := sa.history[s.shardStart]
+		for i := range ss {
+			if ss[i] == s {
+				old = append(old, s)
+				ss = append(ss[:i], ss[i+1:]...)
+				break
+			}
+		}
+		if len(ss) == 0 {
+			delete(sa.history, s.shardStart)
+		} else {
+			sa.history[s.shardStart] = ss
+		}
+	}
+	order := sa.historyOrder[:0]
+	for _, t := range sa.historyOrder {
+		if _, ok := sa.history[t]; ok {
+			order = append(order, t)
+		}
+	}
+	sa.historyOrder = order
+	return old
+}
+
+// pruneShards deletes shards, and their remote copies.
+func (sa *shardArchive) pruneShards(ss []*Shard, reason string) {
+	if len(ss) == 0 {
+		return
+	}
 	glog.V(1).Infof("Deleteing %v shards", len(ss))
 	for _, s := range ss {
 		glog.V(1).Infof("Deleteing shard %v (%s)", s.id, s.dataDir)
+		s.remoteLock.Lock()
+		if s.remote != nil {
+			if err := s.store.remove(s.remote); err != nil {
+				glog.Errorf("failed to delete remote copy of shard %s, %v", s.id, err)
+			}
+		}
+		s.remoteLock.Unlock()
+		s.useLock.Lock()
 		_ = os.RemoveAll(s.dataDir)
+		s.useLock.Unlock()
 		glog.V(1).Infof("Done deleteing shard %v", s.id)
 	}
+	prunedShards.WithLabelValues(reason).Add(float64(len(ss)))
+}
+
+// stats returns the number of shards in the archive, the start of the
+// oldest, and the number of messages in those with a manifest.
+func (sa *shardArchive) stats() (shards int, oldest time.Time, messages uint64) {
+	sa.RLock()
+	defer sa.RUnlock()
+
+	for _, ss := range sa.history {
+		shards += len(ss)
+		for _, s := range ss {
+			messages += s.messages()
+		}
+	}
+	if len(sa.historyOrder) > 0 {
+		oldest = sa.historyOrder[0]
+	}
+	return shards, oldest, messages
+}
+
+// dirSize returns the total size of the files under dir.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
 }
@@ -0,0 +1,107 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/graymeta/stow"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Once the data directory is over its high water mark, uploaded shards
+// are evicted, and then the oldest shards deleted, until it is under
+// its low water mark.
+func TestArchive_PruneSize(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+	stowDir, err := ioutil.TempDir("", "logspray-stow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stowDir)
+
+	sa, err := NewArchive(
+		WithArchiveDataDir(dataDir),
+		WithArchiveStowConfig(stow.ConfigMap{"path": stowDir}),
+		WithArchiveStowLocation("local", "shards"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	var shards []*Shard
+	for i := 0; i < 4; i++ {
+		s, _ := writeTestShard(t, dataDir, start.Add(time.Duration(i)*time.Minute), compression{}, 2, 200)
+		shards = append(shards, s)
+		sa.Lock()
+		sa.history[s.shardStart] = []*Shard{s}
+		sa.historyOrder = append(sa.historyOrder, s.shardStart)
+		sa.Unlock()
+	}
+	// The newer shards are uploaded, so that they are evicted before
+	// the oldest shards are deleted.
+	sa.upload([]*Shard{shards[1], shards[3]})
+	if !shards[1].uploaded() || !shards[3].uploaded() {
+		t.Fatalf("shards were not uploaded")
+	}
+
+	used := dirSize(dataDir)
+	pruned := testutil.ToFloat64(prunedShards.WithLabelValues("size"))
+
+	// Under the high water mark nothing is pruned.
+	sa.maxBytes, sa.highWater, sa.lowWater = used*2, 0.9, 0.5
+	sa.prune()
+	if got := dirSize(dataDir); got != used {
+		t.Fatalf("pruned %d bytes while under the high water mark", used-got)
+	}
+
+	sa.maxBytes = used
+	sa.prune()
+
+	if got, target := dirSize(dataDir), int64(sa.lowWater*float64(sa.maxBytes)); got > target {
+		t.Fatalf("%d bytes used after pruning, want at most %d", got, target)
+	}
+	fileCount := func(s *Shard) int {
+		fns, _ := filepath.Glob(filepath.Join(s.dataDir, "*.pb.log"))
+		return len(fns)
+	}
+	if _, err := os.Stat(shards[0].dataDir); !os.IsNotExist(err) {
+		t.Fatalf("oldest shard was not deleted, %v", err)
+	}
+	for _, i := range []int{1, 3} {
+		if n := fileCount(shards[i]); n != 0 {
+			t.Fatalf("uploaded shard %d was not evicted, %d files left", i, n)
+		}
+	}
+	if n := fileCount(shards[2]); n != 2 {
+		t.Fatalf("shard 2 has %d files, want 2", n)
+	}
+	if ss := sa.shards(start, start.Add(time.Hour)); len(ss) != 3 || ss[0] != shards[1] {
+		t.Fatalf("archive holds shards %v, want the newest 3", ss)
+	}
+	if got := testutil.ToFloat64(prunedShards.WithLabelValues("size")) - pruned; got != 1 {
+		t.Fatalf("counted %v shards pruned for size, want 1", got)
+	}
+}
@@ -0,0 +1,742 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+
+	"github.com/QubitProducts/logspray/proto/logspray"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/snappy"
+)
+
+// blockCodec is the compression used for the blocks of a shard file.
+type blockCodec byte
+
+const (
+	// codecNone writes files without blocks.
+	codecNone blockCodec = iota
+	codecSnappy
+	codecGzip
+)
+
+func (c blockCodec) String() string {
+	switch c {
+	case codecNone:
+		return "none"
+	case codecSnappy:
+		return "snappy"
+	case codecGzip:
+		return "gzip"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(c))
+	}
+}
+
+// parseCodec returns the codec with the given name.
+func parseCodec(name string) (blockCodec, error) {
+	for _, c := range []blockCodec{codecNone, codecSnappy, codecGzip} {
+		if c.String() == name {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown compression %q, must be one of none, snappy or gzip", name)
+}
+
+// compression configures how shard files are written.
+type compression struct {
+	codec     blockCodec
+	level     int // gzip compression level
+	blockSize int // uncompressed size at which a block is written
+}
+
+const (
+	// blockIndexMagic ends a file that has a block index. It can't be
+	// mistaken for the size trailer of the last block, which is never
+	// larger than maxBlockSize.
+	blockIndexMagic = "LSbi"
+	// maxBlockSize limits the size of blocks, before or after
+	// compression.
+	maxBlockSize = 3 * maxMessageSize
+	// blockInfoLen is the size of an entry in the block index.
+	blockInfoLen = 32
+	// defaultBlockSize is the uncompressed size of blocks, unless
+	// set otherwise.
+	defaultBlockSize = 64 << 10
+	// maxResync limits how far past a corrupt record we look for the
+	// next valid one.
+	maxResync = 1 << 20
+)
+
+// blockInfo describes a block of a shard file. min and max are the range
+// of the message times within the block, in nanoseconds.
+type blockInfo struct {
+	offset   int64
+	size     uint32 // compressed size, excluding the framing
+	count    uint32
+	min, max int64
+}
+
+// unknownTimes is used for blocks found without a block index.
+func (b *blockInfo) unknownTimes() {
+	b.min, b.max = math.MinInt64, math.MaxInt64
+}
+
+// overlaps reports whether the block might hold messages between
+// from and to.
+func (b blockInfo) overlaps(from, to int64) bool {
+	return b.max >= from && b.min <= to
+}
+
+// end returns the offset following the block.
+func (b blockInfo) end(v fileVersion) int64 {
+	return b.offset + int64(b.size) + int64(v.frameLen())
+}
+
+func (c compression) compress(src []byte) ([]byte, error) {
+	switch c.codec {
+	case codecSnappy:
+		return snappy.Encode(nil, src), nil
+	case codecGzip:
+		buf := &bytes.Buffer{}
+		w, err := gzip.NewWriterLevel(buf, c.level)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(src); err != nil {
+			return nil, err
+		}
+		if err = w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("can't compress blocks with codec %v", c.codec)
+	}
+}
+
+func decompress(codec blockCodec, src []byte) ([]byte, error) {
+	switch codec {
+	case codecNone:
+		return src, nil
+	case codecSnappy:
+		n, err := snappy.DecodedLen(src)
+		if err != nil {
+			return nil, err
+		}
+		if n > maxBlockSize {
+			return nil, fmt.Errorf("block size %d is too large", n)
+		}
+		return snappy.Decode(nil, src)
+	case codecGzip:
+		r, err := gzip.NewReader(bytes.NewReader(src))
+		if err != nil {
+			return nil, err
+		}
+		bs, err := ioutil.ReadAll(io.LimitReader(r, maxBlockSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(bs) > maxBlockSize {
+			return nil, errors.New("block is too large")
+		}
+		return bs, nil
+	default:
+		return nil, fmt.Errorf("unknown block codec %v", codec)
+	}
+}
+
+// blockWriter writes the records of a v2 shard file. With codecNone
+// each record is written as it arrives. Otherwise records are
+// buffered, and written in compressed blocks once blockSize bytes are
+// pending.
+type blockWriter struct {
+	w      io.WriterAt
+	comp   compression
+	offset int64 // the end of the data written so far
+
+	pending  bytes.Buffer
+	count    uint32
+	min, max int64
+	blocks   []blockInfo
+
+	// files without blocks only
+	tidx timeIndex
+}
+
+// newBlockWriter starts a shard file, writing the magic and the
+// stream header. Files without blocks are given a time index, as
+// set by tidx.
+func newBlockWriter(w io.WriterAt, comp compression, tidx timeIndexConfig, hdr *logspray.Message) (*blockWriter, error) {
+	bw := &blockWriter{w: w, comp: comp, tidx: timeIndex{cfg: tidx}}
+	bw.reset()
+
+	magic := append([]byte(fileMagicV2), byte(comp.codec))
+	if _, err := w.WriteAt(magic, 0); err != nil {
+		return nil, fmt.Errorf("failed writing file magic, %w", err)
+	}
+	sz, err := writePBMessageToFile(w, int64(len(magic)), hdr)
+	if err != nil {
+		return nil, fmt.Errorf("failed writing file header, %w", err)
+	}
+	bw.offset = int64(len(magic)) + int64(sz)
+	return bw, nil
+}
+
+func (bw *blockWriter) reset() {
+	bw.pending.Reset()
+	bw.count = 0
+	bw.min, bw.max = math.MaxInt64, math.MinInt64
+}
+
+func (bw *blockWriter) write(m *logspray.Message) error {
+	if bw.comp.codec == codecNone {
+		sz, err := writePBMessageToFile(bw.w, bw.offset, m)
+		if err != nil {
+			return err
+		}
+		ns := int64(math.MinInt64)
+		if m.Time != nil {
+			if t, err := ptypes.Timestamp(m.Time); err == nil {
+				ns = t.UnixNano()
+			}
+		}
+		bw.tidx.add(bw.offset, ns)
+		bw.offset += int64(sz)
+		return nil
+	}
+
+	bs, err := proto.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("marshal protobuf failed, %w", err)
+	}
+	if len(bs) > maxMessageSize {
+		return fmt.Errorf("marshaled protobuf is %d bytes too large", len(bs)-maxMessageSize)
+	}
+	appendFrame(&bw.pending, bs)
+	bw.count++
+	if m.Time != nil {
+		if t, err := ptypes.Timestamp(m.Time); err == nil {
+			ns := t.UnixNano()
+			if ns < bw.min {
+				bw.min = ns
+			}
+			if ns > bw.max {
+				bw.max = ns
+			}
+		}
+	}
+
+	if bw.pending.Len() >= bw.comp.blockSize {
+		return bw.flush()
+	}
+	return nil
+}
+
+// flush writes any pending records as a block.
+func (bw *blockWriter) flush() error {
+	if bw.count == 0 {
+		return nil
+	}
+	bs, err := bw.comp.compress(bw.pending.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed compressing block, %w", err)
+	}
+	if len(bs) > maxBlockSize {
+		return fmt.Errorf("compressed block is %d bytes too large", len(bs)-maxBlockSize)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(bs)+fileV2.frameLen()))
+	appendFrame(buf, bs)
+	if _, err = bw.w.WriteAt(buf.Bytes(), bw.offset); err != nil {
+		return fmt.Errorf("failed writing block, %w", err)
+	}
+
+	bw.blocks = append(bw.blocks, blockInfo{
+		offset: bw.offset,
+		size:   uint32(len(bs)),
+		count:  bw.count,
+		min:    bw.min,
+		max:    bw.max,
+	})
+	bw.offset += int64(buf.Len())
+	bw.reset()
+	return nil
+}
+
+// finish flushes any pending records, and writes the block index.
+func (bw *blockWriter) finish() error {
+	if bw.comp.codec == codecNone {
+		return nil
+	}
+	if err := bw.flush(); err != nil {
+		return err
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(bw.blocks)*blockInfoLen+8))
+	for _, b := range bw.blocks {
+		binary.Write(buf, binary.LittleEndian, b.offset)
+		binary.Write(buf, binary.LittleEndian, b.size)
+		binary.Write(buf, binary.LittleEndian, b.count)
+		binary.Write(buf, binary.LittleEndian, b.min)
+		binary.Write(buf, binary.LittleEndian, b.max)
+	}
+	binary.Write(buf, binary.LittleEndian, uint32(len(bw.blocks)))
+	buf.WriteString(blockIndexMagic)
+	if _, err := bw.w.WriteAt(buf.Bytes(), bw.offset); err != nil {
+		return fmt.Errorf("failed writing block index, %w", err)
+	}
+	bw.offset += int64(buf.Len())
+	return nil
+}
+
+// pendingRecords returns a copy of the records not yet written in a
+// block.
+func (bw *blockWriter) pendingRecords() []byte {
+	return append([]byte(nil), bw.pending.Bytes()...)
+}
+
+// readBlockIndex reads the blocks of a file, from the block index if
+// the file has a valid one, or by walking the blocks from start
+// otherwise. The end of the block data is returned. If a corrupt block
+// is found while walking, the blocks before it are returned with the
+// error.
+func readBlockIndex(r io.ReaderAt, v fileVersion, start, size int64) ([]blockInfo, int64, error) {
+	bs, end, err := readBlockIndexEntries(r, v, start, size)
+	if err == nil && bs != nil {
+		return bs, end, nil
+	}
+	if err != nil {
+		glog.Errorf("ignoring block index, %v", err)
+	}
+	bs, end, err = walkBlocks(r, v, start, size)
+	return bs, end, err
+}
+
+// readBlockIndexEntries reads the block index at the end of the file,
+// returning nil if there is none.
+func readBlockIndexEntries(r io.ReaderAt, v fileVersion, start, size int64) ([]blockInfo, int64, error) {
+	tail := make([]byte, 8)
+	if size-start < 8 {
+		return nil, 0, nil
+	}
+	if _, err := r.ReadAt(tail, size-8); err != nil {
+		return nil, 0, fmt.Errorf("failed reading block index, %w", err)
+	}
+	if string(tail[4:]) != blockIndexMagic {
+		return nil, 0, nil
+	}
+
+	n := int64(binary.LittleEndian.Uint32(tail))
+	end := size - 8 - n*blockInfoLen
+	if end < start {
+		return nil, 0, corruptf("corrupt block index")
+	}
+	ibs := make([]byte, n*blockInfoLen)
+	if _, err := r.ReadAt(ibs, end); err != nil {
+		return nil, 0, fmt.Errorf("failed reading block index, %w", err)
+	}
+
+	bs := make([]blockInfo, n)
+	for i := range bs {
+		e := ibs[i*blockInfoLen:]
+		bs[i] = blockInfo{
+			offset: int64(binary.LittleEndian.Uint64(e)),
+			size:   binary.LittleEndian.Uint32(e[8:]),
+			count:  binary.LittleEndian.Uint32(e[12:]),
+			min:    int64(binary.LittleEndian.Uint64(e[16:])),
+			max:    int64(binary.LittleEndian.Uint64(e[24:])),
+		}
+		if bs[i].offset < start || bs[i].end(v) > end {
+			return nil, 0, corruptf("corrupt block index")
+		}
+	}
+	return bs, end, nil
+}
+
+// walkBlocks finds the blocks between start and end using their
+// framing. The blocks found before any corrupt one are returned, with
+// the offset following them.
+func walkBlocks(r io.ReaderAt, v fileVersion, start, end int64) ([]blockInfo, int64, error) {
+	var bs []blockInfo
+	szbs := make([]byte, 4)
+	off := start
+	for off < end {
+		if _, err := r.ReadAt(szbs, off); err != nil {
+			return bs, off, corruptf("failed reading block size header at offset %d, %w", off, err)
+		}
+		b := blockInfo{offset: off, size: binary.LittleEndian.Uint32(szbs)}
+		if b.size > maxBlockSize || b.end(v) > end {
+			return bs, off, corruptf("block size %d at offset %d is invalid", b.size, off)
+		}
+		if _, err := r.ReadAt(szbs, b.end(v)-4); err != nil {
+			return bs, off, fmt.Errorf("failed reading block size trailer at offset %d, %w", off, err)
+		}
+		if binary.LittleEndian.Uint32(szbs) != b.size {
+			return bs, off, corruptf("header and trail size mismatch for block at offset %d", off)
+		}
+		b.unknownTimes()
+		bs = append(bs, b)
+		off = b.end(v)
+	}
+	return bs, off, nil
+}
+
+// findBlock looks for the start of a valid block frame between from
+// and end, no more than maxResync bytes past from.
+func findBlock(r io.ReaderAt, v fileVersion, from, end int64) (int64, bool) {
+	szbs := make([]byte, 4)
+	for o := from; o < end && o <= from+maxResync; o++ {
+		if _, err := r.ReadAt(szbs, o); err != nil {
+			return 0, false
+		}
+		b := blockInfo{offset: o, size: binary.LittleEndian.Uint32(szbs)}
+		if b.size > maxBlockSize || b.end(v) > end {
+			continue
+		}
+		if _, err := r.ReadAt(szbs, b.end(v)-4); err != nil || binary.LittleEndian.Uint32(szbs) != b.size {
+			continue
+		}
+		if v.crcLen() != 0 {
+			bs := make([]byte, int(b.size)+v.crcLen())
+			if _, err := r.ReadAt(bs, o+4); err != nil {
+				continue
+			}
+			if binary.LittleEndian.Uint32(bs) != crc32.Checksum(bs[v.crcLen():], crcTable) {
+				continue
+			}
+		}
+		return o, true
+	}
+	return 0, false
+}
+
+// readBlock reads and decompresses a block, returning its messages. If
+// a corrupt record is found the messages before it are returned with
+// the error.
+func readBlock(r io.ReaderAt, v fileVersion, codec blockCodec, b blockInfo) ([]*logspray.Message, error) {
+	bs := make([]byte, b.end(v)-b.offset)
+	if _, err := r.ReadAt(bs, b.offset); err != nil {
+		return nil, fmt.Errorf("failed reading block at offset %d, %w", b.offset, err)
+	}
+	if binary.LittleEndian.Uint32(bs) != b.size || binary.LittleEndian.Uint32(bs[len(bs)-4:]) != b.size {
+		return nil, corruptf("header and trail size mismatch for block at offset %d", b.offset)
+	}
+	data := bs[4+v.crcLen() : len(bs)-4]
+	if v.crcLen() != 0 && binary.LittleEndian.Uint32(bs[4:]) != crc32.Checksum(data, crcTable) {
+		return nil, corruptf("checksum mismatch for block at offset %d", b.offset)
+	}
+	raw, err := decompress(codec, data)
+	if err != nil {
+		return nil, corruptf("failed decompressing block at offset %d, %w", b.offset, err)
+	}
+	return decodeRecords(raw, v)
+}
+
+// decodeRecords decodes a sequence of records. If a corrupt record is
+// found the messages before it are returned with the error.
+func decodeRecords(raw []byte, v fileVersion) ([]*logspray.Message, error) {
+	var msgs []*logspray.Message
+	sr := io.NewSectionReader(bytes.NewReader(raw), 0, int64(len(raw)))
+	for {
+		m, err := readMessageFromFile(sr, v)
+		if err == io.EOF {
+			return msgs, nil
+		}
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, m)
+	}
+}
+
+// recordReader reads the messages following the header of a shard
+// file, forwards or in reverse. Blocks that hold no messages between
+// from and to, according to the block index, are skipped.
+type recordReader struct {
+	name    string
+	sr      *io.SectionReader
+	version fileVersion
+	codec   blockCodec
+	start   int64 // the end of the file header
+	reverse bool
+	off     int64 // the offset of the last record or block read
+	pos     int64 // the position of the last read attempt
+
+	// skipCorrupt causes corrupt records and blocks to be reported
+	// and skipped, rather than returned as errors.
+	skipCorrupt bool
+
+	// onCorrupt, if set, is called for corrupt data that is skipped,
+	// rather than logging it.
+	onCorrupt func(error)
+
+	from, to int64
+
+	// files with blocks only
+	minOff  int64       // blocks before minOff are skipped
+	maxOff  int64       // blocks after maxOff are skipped
+	blocks  []blockInfo // nil until loaded
+	pending []byte      // records not yet written to a block
+	msgs    []*logspray.Message
+}
+
+// newRecordReader returns a reader for the records of the file named
+// fn. sr must be positioned at the end of the file header. For files
+// with blocks, blocks lists the blocks of the file, or is nil if they
+// should be read from the file when first needed. For files without
+// blocks, marks is the time index of the file, if it has one, and
+// only the part of the file it shows might hold messages between from
+// and to is read.
+func newRecordReader(fn string, sr *io.SectionReader, v fileVersion, codec blockCodec, blocks []blockInfo, pending []byte, marks []timeMark, from, to time.Time, reverse, skipCorrupt bool) *recordReader {
+	start, _ := sr.Seek(0, io.SeekCurrent)
+	r := &recordReader{
+		name:        fn,
+		sr:          sr,
+		version:     v,
+		codec:       codec,
+		start:       start,
+		reverse:     reverse,
+		skipCorrupt: skipCorrupt,
+		from:        math.MinInt64,
+		to:          math.MaxInt64,
+		maxOff:      math.MaxInt64,
+		pending:     pending,
+	}
+	if !from.IsZero() {
+		r.from = from.UnixNano()
+	}
+	if !to.IsZero() {
+		r.to = to.UnixNano()
+	}
+
+	if codec == codecNone {
+		if marks != nil {
+			mstart, mend, ok := markRange(marks, r.from, r.to, sr.Size())
+			if !ok {
+				mstart, mend = start, start
+			}
+			r.sr = io.NewSectionReader(sr, 0, mend)
+			r.start = mstart
+			r.sr.Seek(mstart, io.SeekStart)
+		}
+		if reverse {
+			r.sr.Seek(0, io.SeekEnd)
+		}
+		return r
+	}
+	if blocks != nil {
+		r.setBlocks(blocks)
+	}
+	return r
+}
+
+// setBlocks sets the blocks to be read, dropping those outside of the
+// time range.
+func (r *recordReader) setBlocks(blocks []blockInfo) {
+	r.blocks = make([]blockInfo, 0, len(blocks))
+	for _, b := range blocks {
+		if b.overlaps(r.from, r.to) && b.offset >= r.minOff && b.offset <= r.maxOff {
+			r.blocks = append(r.blocks, b)
+		}
+	}
+}
+
+// resumeAt skips the records, or blocks, before the one at off, or
+// those after it if reading in reverse. It must be called before the
+// first read.
+func (r *recordReader) resumeAt(off int64) {
+	if r.codec != codecNone {
+		if r.reverse {
+			r.maxOff = off
+			if r.sr.Size() > off {
+				r.pending = nil
+			}
+		} else {
+			r.minOff = off
+		}
+		if r.blocks != nil {
+			r.setBlocks(r.blocks)
+		}
+		return
+	}
+
+	if off < r.start || off >= r.sr.Size() {
+		return
+	}
+	if !r.reverse {
+		if pos, _ := r.sr.Seek(0, io.SeekCurrent); off > pos {
+			r.sr.Seek(off, io.SeekStart)
+		}
+		return
+	}
+	r.sr.Seek(off, io.SeekStart)
+	if _, err := readMessageFromFile(r.sr, r.version); err != nil {
+		r.sr.Seek(0, io.SeekEnd)
+		return
+	}
+	end, _ := r.sr.Seek(0, io.SeekCurrent)
+	r.sr = io.NewSectionReader(r.sr, 0, end)
+	r.sr.Seek(0, io.SeekEnd)
+}
+
+// loadBlocks reads the blocks of the file from its block index, or by
+// walking the file.
+func (r *recordReader) loadBlocks() error {
+	size := r.sr.Size()
+	blocks, off, err := readBlockIndex(r.sr, r.version, r.start, size)
+	for err != nil {
+		if !r.skipCorrupt || !isCorrupt(err) {
+			return err
+		}
+		r.report(err)
+		next, ok := findBlock(r.sr, r.version, off+1, size)
+		if !ok {
+			break
+		}
+		var more []blockInfo
+		more, off, err = walkBlocks(r.sr, r.version, next, size)
+		blocks = append(blocks, more...)
+	}
+	r.setBlocks(blocks)
+	return nil
+}
+
+// report logs, and counts, corrupt data that is being skipped.
+func (r *recordReader) report(err error) {
+	if r.onCorrupt != nil {
+		r.onCorrupt(err)
+		return
+	}
+	glog.Errorf("skipping corrupt data in %s, %v", r.name, err)
+	corruptRecords.Inc()
+}
+
+// read returns the next message, or io.EOF once all have been read.
+func (r *recordReader) read() (*logspray.Message, error) {
+	if r.codec == codecNone {
+		for {
+			m, err := r.readRecord()
+			if err == nil || err == io.EOF || !r.skipCorrupt || !isCorrupt(err) {
+				return m, err
+			}
+			r.report(fmt.Errorf("record at offset %d, %w", r.pos, err))
+			if !r.resync() {
+				return nil, io.EOF
+			}
+		}
+	}
+
+	if r.blocks == nil {
+		if err := r.loadBlocks(); err != nil {
+			return nil, err
+		}
+	}
+	for len(r.msgs) == 0 {
+		err := r.nextBlock()
+		if err == nil {
+			continue
+		}
+		if err == io.EOF || !r.skipCorrupt || !isCorrupt(err) {
+			return nil, err
+		}
+		r.report(err)
+	}
+
+	var m *logspray.Message
+	if r.reverse {
+		m, r.msgs = r.msgs[len(r.msgs)-1], r.msgs[:len(r.msgs)-1]
+	} else {
+		m, r.msgs = r.msgs[0], r.msgs[1:]
+	}
+	return m, nil
+}
+
+// readRecord reads the next record of a file without blocks.
+func (r *recordReader) readRecord() (*logspray.Message, error) {
+	r.pos, _ = r.sr.Seek(0, io.SeekCurrent)
+	if r.reverse {
+		m, err := readMessageFromFileEnd(r.sr, r.version, r.start)
+		r.off, _ = r.sr.Seek(0, io.SeekCurrent)
+		return m, err
+	}
+	r.off = r.pos
+	return readMessageFromFile(r.sr, r.version)
+}
+
+// resync looks for the next valid record after a corrupt one, leaving
+// the reader positioned to read it. false is returned if none is found
+// within maxResync bytes.
+func (r *recordReader) resync() bool {
+	if !r.reverse {
+		for o := r.pos + 1; o < r.sr.Size() && o <= r.pos+maxResync; o++ {
+			r.sr.Seek(o, io.SeekStart)
+			if _, err := readMessageFromFile(r.sr, r.version); err == nil {
+				r.sr.Seek(o, io.SeekStart)
+				return true
+			}
+		}
+		return false
+	}
+
+	for e := r.pos - 1; e > r.start && e >= r.pos-maxResync; e-- {
+		r.sr.Seek(e, io.SeekStart)
+		if _, err := readMessageFromFileEnd(r.sr, r.version, r.start); err == nil {
+			r.sr.Seek(e, io.SeekStart)
+			return true
+		}
+	}
+	return false
+}
+
+// nextBlock loads the messages of the next block to be read. The
+// pending records come after all the blocks.
+func (r *recordReader) nextBlock() error {
+	var err error
+	switch {
+	case r.reverse && r.pending != nil:
+		r.off = r.sr.Size()
+		r.msgs, err = decodeRecords(r.pending, r.version)
+		r.pending = nil
+	case len(r.blocks) > 0:
+		var b blockInfo
+		if r.reverse {
+			b, r.blocks = r.blocks[len(r.blocks)-1], r.blocks[:len(r.blocks)-1]
+		} else {
+			b, r.blocks = r.blocks[0], r.blocks[1:]
+		}
+		r.off = b.offset
+		r.msgs, err = readBlock(r.sr, r.version, r.codec, b)
+	case !r.reverse && r.pending != nil:
+		r.off = r.sr.Size()
+		r.msgs, err = decodeRecords(r.pending, r.version)
+		r.pending = nil
+	default:
+		return io.EOF
+	}
+	return err
+}
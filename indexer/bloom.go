@@ -0,0 +1,141 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// bloomMagic starts every bloom filter sidecar file.
+const bloomMagic = "LSBF\x01"
+
+// bloomConfig sizes the bloom filters kept for each shard file.
+type bloomConfig struct {
+	tokens int     // expected number of distinct tokens per file
+	fpRate float64 // false positive rate at that number of tokens
+}
+
+// newFilter returns a new, empty, bloom filter, or nil if bloom
+// filters are disabled.
+func (c bloomConfig) newFilter() *bloomFilter {
+	if c.tokens <= 0 || c.fpRate <= 0 || c.fpRate >= 1 {
+		return nil
+	}
+	n := float64(c.tokens)
+	m := math.Ceil(-n * math.Log(c.fpRate) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Round(m/n*math.Ln2))
+	return &bloomFilter{
+		k:    uint32(k),
+		bits: make([]uint64, (uint64(m)+63)/64),
+	}
+}
+
+// bloomFilter is a set of tokens that may report false positives,
+// but never false negatives.
+type bloomFilter struct {
+	k    uint32
+	bits []uint64
+}
+
+// locations returns the k bit positions for s, using double hashing.
+func (b *bloomFilter) locations(s string, f func(uint64)) {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	m := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < uint64(b.k); i++ {
+		f((h1 + i*h2) % m)
+	}
+}
+
+func (b *bloomFilter) add(s string) {
+	b.locations(s, func(l uint64) {
+		b.bits[l/64] |= 1 << (l % 64)
+	})
+}
+
+func (b *bloomFilter) has(s string) bool {
+	res := true
+	b.locations(s, func(l uint64) {
+		if b.bits[l/64]&(1<<(l%64)) == 0 {
+			res = false
+		}
+	})
+	return res
+}
+
+// bloomFileName returns the name of the bloom filter sidecar for a
+// shard file.
+func bloomFileName(fn string) string {
+	return strings.TrimSuffix(fn, ".pb.log") + ".bloom"
+}
+
+func writeBloomFilter(fn string, b *bloomFilter) error {
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	w.WriteString(bloomMagic)
+	binary.Write(w, binary.LittleEndian, b.k)
+	binary.Write(w, binary.LittleEndian, uint64(len(b.bits)))
+	binary.Write(w, binary.LittleEndian, b.bits)
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed writing bloom filter, %w", err)
+	}
+	return f.Close()
+}
+
+func readBloomFilter(fn string) (*bloomFilter, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	magic := make([]byte, len(bloomMagic))
+	if _, err = io.ReadFull(r, magic); err != nil || string(magic) != bloomMagic {
+		return nil, errors.New("not a bloom filter")
+	}
+
+	b := &bloomFilter{}
+	var n uint64
+	if err = binary.Read(r, binary.LittleEndian, &b.k); err != nil {
+		return nil, fmt.Errorf("failed reading bloom filter, %w", err)
+	}
+	if err = binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, fmt.Errorf("failed reading bloom filter, %w", err)
+	}
+	if b.k == 0 || n == 0 || n > 1<<28 {
+		return nil, errors.New("corrupt bloom filter")
+	}
+	b.bits = make([]uint64, n)
+	if err = binary.Read(r, binary.LittleEndian, b.bits); err != nil {
+		return nil, fmt.Errorf("failed reading bloom filter, %w", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// cursorVersion is the first byte of every encoded cursor.
+const cursorVersion = 1
+
+// Cursor is the position of a message in the results of a search.
+// Searches resumed from a cursor return the messages that follow it,
+// in the same order.
+type Cursor struct {
+	Time   time.Time
+	Shard  string
+	Stream string
+	Index  uint64
+
+	// Offset is the offset of the record, or block of records, in
+	// its file, which was compressed with codec. It lets a resumed
+	// search skip the start of the file.
+	Offset int64
+	codec  blockCodec
+}
+
+// after reports whether messages at the position of c follow those at
+// p in a search, or precede them if reverse is set.
+func (c *Cursor) after(p *Cursor, reverse bool) bool {
+	if reverse {
+		return c.Before(p)
+	}
+	return p.Before(c)
+}
+
+// Before reports whether c precedes p. Positions are ordered by time,
+// and then by shard, stream and index, so that messages with the same
+// time are always in the same order.
+func (c *Cursor) Before(p *Cursor) bool {
+	switch {
+	case !c.Time.Equal(p.Time):
+		return c.Time.Before(p.Time)
+	case c.Shard != p.Shard:
+		return c.Shard < p.Shard
+	case c.Stream != p.Stream:
+		return c.Stream < p.Stream
+	default:
+		return c.Index < p.Index
+	}
+}
+
+// String encodes the cursor as an opaque string.
+func (c *Cursor) String() string {
+	buf := &bytes.Buffer{}
+	buf.WriteByte(cursorVersion)
+	binary.Write(buf, binary.LittleEndian, c.Time.UnixNano())
+	binary.Write(buf, binary.LittleEndian, c.Index)
+	binary.Write(buf, binary.LittleEndian, c.Offset)
+	buf.WriteByte(byte(c.codec))
+	for _, s := range []string{c.Shard, c.Stream} {
+		binary.Write(buf, binary.LittleEndian, uint16(len(s)))
+		buf.WriteString(s)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf.Bytes())
+}
+
+// ParseCursor decodes a cursor returned by Cursor.String.
+func ParseCursor(s string) (*Cursor, error) {
+	bs, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor, %w", err)
+	}
+	r := bytes.NewReader(bs)
+	if v, err := r.ReadByte(); err != nil || v != cursorVersion {
+		return nil, errors.New("invalid cursor version")
+	}
+
+	c := &Cursor{}
+	var ns int64
+	var codec byte
+	for _, v := range []interface{}{&ns, &c.Index, &c.Offset, &codec} {
+		if err = binary.Read(r, binary.LittleEndian, v); err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+	}
+	c.Time = time.Unix(0, ns)
+	c.codec = blockCodec(codec)
+	for _, s := range []*string{&c.Shard, &c.Stream} {
+		var n uint16
+		if err = binary.Read(r, binary.LittleEndian, &n); err != nil || int(n) > r.Len() {
+			return nil, errors.New("invalid cursor")
+		}
+		str := make([]byte, n)
+		r.Read(str)
+		*s = string(str)
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("invalid cursor")
+	}
+	return c, nil
+}
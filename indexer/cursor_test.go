@@ -0,0 +1,171 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// searchCursors runs a merged search of shards, returning the text of
+// the messages found and the cursor of each, as a client would see it.
+func searchCursors(t *testing.T, shards []*Shard, from, to time.Time, reverse bool) ([]string, []*Cursor) {
+	t.Helper()
+	var cs []*Cursor
+	got := searchTexts(t, shards, from, to, reverse, nil, func(c *Cursor) {
+		pc, err := ParseCursor(c.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs = append(cs, pc)
+	})
+	return got, cs
+}
+
+func TestCursor_String(t *testing.T) {
+	c := &Cursor{
+		Time:   time.Unix(0, 1234567890),
+		Shard:  "shard",
+		Stream: "stream",
+		Index:  42,
+		Offset: 1 << 40,
+		codec:  codecGzip,
+	}
+	pc, err := ParseCursor(c.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pc.Time.Equal(c.Time) || pc.Shard != c.Shard || pc.Stream != c.Stream || pc.Index != c.Index || pc.Offset != c.Offset || pc.codec != c.codec {
+		t.Fatalf("got %+v, want %+v", pc, c)
+	}
+	for _, s := range []string{"", "!", c.String()[:10], c.String() + "AA"} {
+		if _, err := ParseCursor(s); err == nil {
+			t.Fatalf("expected an error parsing %q", s)
+		}
+	}
+}
+
+func TestCursor_Resume(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	start := time.Now().Truncate(time.Minute)
+	from, to := start, start.Add(time.Minute)
+	for _, codec := range testCodecs {
+		s, want := writeTestShard(t, dataDir, start, compression{codec: codec, blockSize: 256}, 3, 60)
+		for _, reverse := range []bool{false, true} {
+			exp, cs := searchCursors(t, []*Shard{s}, from, to, reverse)
+			if reverse && fmt.Sprint(exp) != fmt.Sprint(reversed(want)) || !reverse && fmt.Sprint(exp) != fmt.Sprint(want) {
+				t.Fatalf("%v: search found %v", codec, exp)
+			}
+
+			// Cursors into the middle of a block share an offset
+			// with the next message of the same stream.
+			midBlock := codec == codecNone
+			for k := 0; k+3 < len(cs); k++ {
+				midBlock = midBlock || cs[k].Offset == cs[k+3].Offset
+			}
+			if !midBlock {
+				t.Fatalf("%v: no cursor points into the middle of a block", codec)
+			}
+
+			for k := range cs {
+				got := searchTexts(t, []*Shard{s}, from, to, reverse, cs[k], nil)
+				if fmt.Sprint(got) != fmt.Sprint(exp[k+1:]) {
+					t.Fatalf("%v, reverse %v: resuming after message %d got %v, want %v", codec, reverse, k, got, exp[k+1:])
+				}
+			}
+		}
+		os.RemoveAll(s.dataDir)
+	}
+}
+
+// Cursors from before a shard was recompressed have offsets into the
+// old file, which must not be used.
+func TestCursor_ResumeRecompressed(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	start := time.Now().Truncate(time.Minute)
+	from, to := start, start.Add(time.Minute)
+	for _, codec := range []blockCodec{codecNone, codecSnappy} {
+		s, _ := writeTestShard(t, dataDir, start, compression{codec: codec, blockSize: 256}, 3, 60)
+		var exps [2][]string
+		var css [2][]*Cursor
+		for i, reverse := range []bool{false, true} {
+			exps[i], css[i] = searchCursors(t, []*Shard{s}, from, to, reverse)
+		}
+
+		s.recompress(compression{codec: codecGzip, level: gzip.DefaultCompression, blockSize: 1024})
+		for _, f := range s.findFiles(nil, time.Time{}, time.Time{}) {
+			sfi, err := OpenShardFileIterator(f.fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sfi.rr.codec != codecGzip {
+				t.Fatalf("%s was not recompressed", f.fn)
+			}
+			sfi.Close()
+		}
+
+		for i, reverse := range []bool{false, true} {
+			for _, k := range []int{0, 10, 31, 59} {
+				got := searchTexts(t, []*Shard{s}, from, to, reverse, css[i][k], nil)
+				if fmt.Sprint(got) != fmt.Sprint(exps[i][k+1:]) {
+					t.Fatalf("%v, reverse %v: resuming after message %d got %v, want %v", codec, reverse, k, got, exps[i][k+1:])
+				}
+			}
+		}
+		os.RemoveAll(s.dataDir)
+	}
+}
+
+// A cursor pointing into a shard that has since been pruned resumes
+// from its time in the remaining shards.
+func TestCursor_PrunedShard(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	start := time.Now().Truncate(time.Minute)
+	from, to := start, start.Add(2*time.Minute)
+	first, want1 := writeTestShard(t, dataDir, start, compression{codec: codecSnappy, blockSize: 256}, 3, 30)
+	second, want2 := writeTestShard(t, dataDir, start.Add(time.Minute), compression{codec: codecSnappy, blockSize: 256}, 3, 30)
+
+	_, cs := searchCursors(t, []*Shard{first, second}, from, to, false)
+	got := searchTexts(t, []*Shard{second}, from, to, false, cs[10], nil)
+	if fmt.Sprint(got) != fmt.Sprint(want2) {
+		t.Fatalf("resuming from a pruned shard got %v, want %v", got, want2)
+	}
+
+	_, cs = searchCursors(t, []*Shard{first, second}, from, to, true)
+	got = searchTexts(t, []*Shard{first}, from, to, true, cs[10], nil)
+	if fmt.Sprint(got) != fmt.Sprint(reversed(want1)) {
+		t.Fatalf("resuming from a pruned shard in reverse got %v, want %v", got, reversed(want1))
+	}
+}
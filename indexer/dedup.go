@@ -0,0 +1,110 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrDuplicate is returned when writing a message whose Index is no
+// greater than that of a message already written to its stream. The
+// message is dropped.
+var ErrDuplicate = errors.New("duplicate message")
+
+// highWaterMarks tracks the highest Index written to each stream, so
+// that messages sent again, by a client retrying after an error, or by
+// more than one reader shipping the same stream, can be dropped.
+// Messages without an Index are never treated as duplicates.
+type highWaterMarks struct {
+	sync.Mutex
+	marks map[string]highWater
+}
+
+// highWater is the highest Index written to a stream, and the start of
+// the shard it was written to.
+type highWater struct {
+	index uint64
+	shard time.Time
+}
+
+func newHighWaterMarks() *highWaterMarks {
+	return &highWaterMarks{marks: map[string]highWater{}}
+}
+
+// mark records index as written to stream id, in the shard starting at
+// shard. It returns the previous mark, and false if the message is a
+// duplicate.
+func (hw *highWaterMarks) mark(id string, index uint64, shard time.Time) (highWater, bool) {
+	hw.Lock()
+	defer hw.Unlock()
+	prev, ok := hw.marks[id]
+	if index == 0 {
+		return prev, true
+	}
+	if ok && index <= prev.index {
+		return prev, false
+	}
+	hw.marks[id] = highWater{index: index, shard: shard}
+	return prev, true
+}
+
+// index returns the mark of stream id.
+func (hw *highWaterMarks) index(id string) uint64 {
+	hw.Lock()
+	defer hw.Unlock()
+	return hw.marks[id].index
+}
+
+// unmark restores the mark of stream id to prev, after failing to
+// write index, unless a later message has been written since.
+func (hw *highWaterMarks) unmark(id string, index uint64, prev highWater) {
+	hw.Lock()
+	defer hw.Unlock()
+	if hw.marks[id].index != index {
+		return
+	}
+	if prev.index == 0 {
+		delete(hw.marks, id)
+		return
+	}
+	hw.marks[id] = prev
+}
+
+// seed records the indexes of streams written to a shard found when
+// the index is opened.
+func (hw *highWaterMarks) seed(indexes map[string]uint64, shard time.Time) {
+	hw.Lock()
+	defer hw.Unlock()
+	for id, index := range indexes {
+		if index > hw.marks[id].index {
+			hw.marks[id] = highWater{index: index, shard: shard}
+		}
+	}
+}
+
+// forget drops the marks of streams not written to since the shard
+// starting at before.
+func (hw *highWaterMarks) forget(before time.Time) {
+	hw.Lock()
+	defer hw.Unlock()
+	for id, m := range hw.marks {
+		if m.shard.Before(before) {
+			delete(hw.marks, id)
+		}
+	}
+}
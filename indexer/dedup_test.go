@@ -0,0 +1,110 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHighWaterMarks_Mark(t *testing.T) {
+	shard := time.Now().Truncate(time.Minute)
+	tests := []struct {
+		id    string
+		index uint64
+		ok    bool
+		prev  uint64
+	}{
+		{"a", 1, true, 0},
+		{"a", 2, true, 1},
+		{"a", 2, false, 2},
+		{"a", 1, false, 2},
+		{"b", 1, true, 0},
+		{"a", 0, true, 2}, // messages without an Index are never duplicates
+		{"a", 5, true, 2},
+		{"a", 3, false, 5},
+	}
+
+	hw := newHighWaterMarks()
+	for i, tt := range tests {
+		prev, ok := hw.mark(tt.id, tt.index, shard)
+		if ok != tt.ok || prev.index != tt.prev {
+			t.Fatalf("%d: mark(%s, %d) = %d, %v, want %d, %v", i, tt.id, tt.index, prev.index, ok, tt.prev, tt.ok)
+		}
+	}
+	if got := hw.index("a"); got != 5 {
+		t.Fatalf("index of a is %d, want 5", got)
+	}
+
+	// A failed write restores the previous mark, unless a later
+	// message was written since.
+	prev, _ := hw.mark("a", 6, shard)
+	hw.unmark("a", 6, prev)
+	if got := hw.index("a"); got != 5 {
+		t.Fatalf("index of a after unmark is %d, want 5", got)
+	}
+	prev, _ = hw.mark("a", 6, shard)
+	hw.mark("a", 7, shard)
+	hw.unmark("a", 6, prev)
+	if got := hw.index("a"); got != 7 {
+		t.Fatalf("index of a after a stale unmark is %d, want 7", got)
+	}
+	prev, _ = hw.mark("c", 1, shard)
+	hw.unmark("c", 1, prev)
+	if _, ok := hw.marks["c"]; ok {
+		t.Fatalf("mark of a stream with no other writes was kept")
+	}
+}
+
+func TestHighWaterMarks_ForgetAndSeed(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	hw := newHighWaterMarks()
+	hw.seed(map[string]uint64{"old": 10, "idle": 20}, now.Add(-2*time.Hour))
+	hw.seed(map[string]uint64{"idle": 15, "recent": 5}, now.Add(-time.Hour))
+	hw.seed(map[string]uint64{"recent": 8}, now.Add(-30*time.Minute))
+	hw.mark("old", 11, now.Add(-90*time.Minute))
+	hw.mark("active", 1, now)
+
+	tests := []struct {
+		id    string
+		index uint64
+	}{
+		{"old", 11},
+		{"idle", 20}, // a later shard with a lower Index doesn't lower the mark
+		{"recent", 8},
+		{"active", 1},
+	}
+	for _, tt := range tests {
+		if got := hw.index(tt.id); got != tt.index {
+			t.Fatalf("index of %s is %d, want %d", tt.id, got, tt.index)
+		}
+	}
+
+	// Streams last written to before the cutoff are forgotten, and
+	// no longer treated as duplicates.
+	hw.forget(now.Add(-time.Hour))
+	for id, want := range map[string]uint64{"old": 0, "idle": 0, "recent": 8, "active": 1} {
+		if got := hw.index(id); got != want {
+			t.Fatalf("index of %s after forgetting is %d, want %d", id, got, want)
+		}
+	}
+	if _, ok := hw.mark("idle", 1, now); !ok {
+		t.Fatalf("message of a forgotten stream was a duplicate")
+	}
+	if _, ok := hw.mark("recent", 8, now); ok {
+		t.Fatalf("message of a remembered stream was not a duplicate")
+	}
+}
@@ -11,4 +11,50 @@
 //
 // The dataDir should be laid out as follows:
 // dataDir/shardID/streamID.pb.log
+// dataDir/shardID/streamID.bloom : bloom filter of the stream's tokens
+// dataDir/shardID/streamID.tidx : time index of an uncompressed stream
+// dataDir/shardID/postings.json : index of the stream header labels
+// dataDir/shardID/manifest.json : the time range, streams, last Indexes and labels of a shard
+// dataDir/shardID/remote.json : where the shard was uploaded to remote storage
+// dataDir/shardID/sealed.tar.gpg : the shard's files, encrypted with OpenPGP
+//
+// Closed shards may be uploaded to a stow container as a tar of their files,
+// named shardID.tar. Once uploaded, all but manifest.json and remote.json may be
+// evicted, and are fetched again when the shard is searched.
+//
+// If recipients are configured, shards are encrypted as they are archived, by
+// replacing their files with a tar of them encrypted to the recipients. Only
+// encrypted shards are uploaded, as shardID.tar.gpg. Encrypted shards are not
+// searched, they can be restored with the private key of a recipient, by
+// DecryptShard. They are deleted once they pass the retention period, like
+// any other shard.
+//
+// Shard files written by this version start with the magic LSv2, and a byte
+// identifying the codec used to compress blocks of records, none, snappy or
+// gzip. Each record is framed by its size, as a little endian uint32, and a
+// CRC-32C of the protobuf before it, and its size again after it. The first
+// record is the stream header.
+//
+// Unless compression is disabled, the remaining records are gathered into
+// blocks which are compressed, and framed in the same way. When a file is
+// closed an index of the offset and time range of each block is appended,
+// followed by the number of blocks and the magic LSbi, so searches can skip
+// blocks outside of the time range. Records not yet written in a block are
+// held in memory, and are lost if the server stops before the file is closed.
+//
+// Files are synced to disk when their shard is closed, when the index is
+// closed, and, depending on the fsync policy, periodically or after every
+// message. With interval or write the pending records are written as a block
+// before each sync, so blocks may be smaller than the configured size.
+//
+// Files written without compression have a sparse time index instead,
+// giving the offset of a record every so many records or bytes, and the
+// range of message times up to the next. It is kept in memory while the
+// file is active, and saved alongside it when it is closed.
+//
+// Searches skip, and log, records and blocks that fail their checks. On start
+// up any partially written record at the end of a file is truncated.
+//
+// Older files, without a magic, can still be read. Their records are framed
+// by uint16 sizes, without CRCs, and are never compressed.
 package indexer
@@ -0,0 +1,190 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/glog"
+	"golang.org/x/crypto/openpgp"
+	// Keys without hash preferences default to RIPEMD160.
+	_ "golang.org/x/crypto/ripemd160"
+)
+
+// EncryptedFileName is the name of the encrypted pack of a shard's
+// files, within its directory.
+const EncryptedFileName = "sealed.tar.gpg"
+
+// encrypt replaces the files of a closed shard with a pack of them
+// encrypted to the recipients in to. Encrypted shards can't be
+// searched until they are decrypted with DecryptShard. It waits for
+// searches of the shard to finish. A shard that was uploaded before it
+// was encrypted is fetched if it was evicted, and its unencrypted
+// remote copy is removed, so that it is uploaded again once encrypted.
+func (s *Shard) encrypt(to []openpgp.Entity) error {
+	s.useLock.Lock()
+	defer s.useLock.Unlock()
+	s.remoteLock.Lock()
+	defer s.remoteLock.Unlock()
+	if s.encrypted {
+		return nil
+	}
+	if s.evicted {
+		if err := s.store.download(s, s.remote); err != nil {
+			return fmt.Errorf("failed to fetch shard %s, %w", s.id, err)
+		}
+		s.evicted = false
+	}
+
+	ents := make([]*openpgp.Entity, len(to))
+	for i := range to {
+		ents[i] = &to[i]
+	}
+
+	fn := filepath.Join(s.dataDir, EncryptedFileName)
+	f, err := os.Create(fn + ".tmp")
+	if err != nil {
+		return err
+	}
+	w, err := openpgp.Encrypt(f, ents, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	if err == nil {
+		err = packShard(s.dataDir, w)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(fn+".tmp", fn)
+	}
+	if err != nil {
+		os.Remove(fn + ".tmp")
+		return err
+	}
+
+	s.filesLock.Lock()
+	s.files = map[string]*ShardFile{}
+	s.filesLock.Unlock()
+	s.encrypted = true
+
+	fis, err := ioutil.ReadDir(s.dataDir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if fi.Name() == EncryptedFileName || fi.Name() == remoteFileName {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.dataDir, fi.Name())); err != nil {
+			glog.Errorf("failed to remove %s from encrypted shard %s, %v", fi.Name(), s.id, err)
+		}
+	}
+
+	// The manifest is kept, without the label summary, so that the
+	// shard is still known when the archive is reopened.
+	s.cacheLock.Lock()
+	if s.manifest != nil {
+		m := *s.manifest
+		m.Labels = nil
+		s.manifest = &m
+	}
+	m := s.manifest
+	s.cacheLock.Unlock()
+	if m != nil {
+		if err := writeManifest(s.dataDir, m); err != nil {
+			return err
+		}
+	}
+
+	if s.remote != nil && !s.remote.Encrypted {
+		if err := s.store.remove(s.remote); err != nil {
+			glog.Errorf("failed to remove unencrypted remote copy of shard %s, %v", s.id, err)
+		}
+		if err := os.Remove(filepath.Join(s.dataDir, remoteFileName)); err != nil {
+			return err
+		}
+		s.remote = nil
+	}
+	return nil
+}
+
+// loadEncrypted marks a shard found on disk as encrypted if it has
+// an encrypted pack of its files.
+func (s *Shard) loadEncrypted() {
+	if _, err := os.Stat(filepath.Join(s.dataDir, EncryptedFileName)); err != nil {
+		return
+	}
+	s.remoteLock.Lock()
+	s.encrypted = true
+	s.remoteLock.Unlock()
+}
+
+// isEncrypted reports whether the shard's files are encrypted.
+func (s *Shard) isEncrypted() bool {
+	s.remoteLock.Lock()
+	defer s.remoteLock.Unlock()
+	return s.encrypted
+}
+
+// DecryptShard reads the encrypted pack of a shard from r, and
+// unpacks its files into dir, which must exist. keyring must hold a
+// private key that the shard was encrypted to. prompt is called if the
+// key needs a passphrase.
+func DecryptShard(r io.Reader, keyring openpgp.EntityList, prompt openpgp.PromptFunction, dir string) error {
+	md, err := openpgp.ReadMessage(r, keyring, prompt, nil)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt shard, %w", err)
+	}
+	if err = unpackShard(md.UnverifiedBody, dir); err != nil {
+		return fmt.Errorf("failed to unpack shard, %w", err)
+	}
+	// The integrity of the message is checked once it is fully read.
+	if _, err = io.Copy(ioutil.Discard, md.UnverifiedBody); err != nil {
+		return fmt.Errorf("failed to decrypt shard, %w", err)
+	}
+	if md.SignatureError != nil {
+		return fmt.Errorf("failed to verify shard, %w", md.SignatureError)
+	}
+	return nil
+}
+
+// ReadKeyRing reads an OpenPGP keyring from fn, which may be ASCII
+// armored or binary.
+func ReadKeyRing(fn string) (openpgp.EntityList, error) {
+	bs, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	el, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(bs))
+	if err != nil {
+		el, err = openpgp.ReadKeyRing(bytes.NewReader(bs))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring %s, %w", fn, err)
+	}
+	if len(el) == 0 {
+		return nil, errors.New("keyring has no keys")
+	}
+	return el, nil
+}
@@ -0,0 +1,262 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/QubitProducts/logspray/proto/logspray"
+	"github.com/graymeta/stow"
+	"golang.org/x/crypto/openpgp"
+)
+
+// waitFor waits for up to a few seconds for cond to hold, as shards
+// are encrypted and uploaded in the background as they are archived.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestArchive_Encrypt(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	ent, err := openpgp.NewEntity("test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sa, err := NewArchive(
+		WithArchiveDataDir(dataDir),
+		WithArchiveEncryptTo([]openpgp.Entity{*ent}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Truncate(time.Minute)
+	old, want := writeTestShard(t, dataDir, now.Add(-3*time.Hour), compression{codec: codecSnappy, blockSize: 256}, 2, 10)
+	older, _ := writeTestShard(t, dataDir, now.Add(-2*time.Hour), compression{codec: codecSnappy, blockSize: 256}, 2, 10)
+	recent, _ := writeTestShard(t, dataDir, now, compression{codec: codecSnappy, blockSize: 256}, 2, 10)
+
+	// Shards are encrypted as soon as they are archived.
+	sa.Add(old, older, recent)
+	for _, s := range []*Shard{old, older, recent} {
+		waitFor(t, "shard "+s.id+" to be encrypted", s.isEncrypted)
+	}
+	if got := searchTexts(t, []*Shard{recent}, now, now.Add(time.Minute), false, nil, nil); len(got) != 0 {
+		t.Fatalf("search of an encrypted shard found %v", got)
+	}
+	if fns, _ := filepath.Glob(filepath.Join(recent.dataDir, "*.pb.log")); len(fns) != 0 {
+		t.Fatalf("files of an encrypted shard were left, %v", fns)
+	}
+
+	// Encrypted shards can be restored with the private key.
+	f, err := os.Open(filepath.Join(old.dataDir, EncryptedFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	restored, err := ioutil.TempDir("", "logspray-restored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(restored)
+	if err := DecryptShard(f, openpgp.EntityList{ent}, nil, restored); err != nil {
+		t.Fatal(err)
+	}
+	rs := &Shard{id: old.id, dataDir: restored, shardStart: old.shardStart, sealed: true}
+	got := searchTexts(t, []*Shard{rs}, old.shardStart, old.shardStart.Add(time.Minute), false, nil, nil)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("restored shard search got %v, want %v", got, want)
+	}
+
+	// Encrypted shards are deleted once they pass the retention
+	// period.
+	sa.retention = 90 * time.Minute
+	sa.prune()
+	if _, err := os.Stat(old.dataDir); !os.IsNotExist(err) {
+		t.Fatalf("encrypted shard past the retention period was not deleted, %v", err)
+	}
+	if n, _, _ := sa.stats(); n != 2 {
+		t.Fatalf("archive holds %d shards after pruning, want 2", n)
+	}
+	if _, err := os.Stat(filepath.Join(recent.dataDir, EncryptedFileName)); err != nil {
+		t.Fatalf("encrypted shard within the retention period was deleted, %v", err)
+	}
+}
+
+func TestArchive_EncryptRemote(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+	stowDir, err := ioutil.TempDir("", "logspray-stow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stowDir)
+
+	ent, err := openpgp.NewEntity("test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	openArchive := func(opts ...ArchiveOpt) *shardArchive {
+		sa, err := NewArchive(append([]ArchiveOpt{
+			WithArchiveDataDir(dataDir),
+			WithArchiveStowConfig(stow.ConfigMap{"path": stowDir}),
+			WithArchiveStowLocation("local", "shards"),
+		}, opts...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sa
+	}
+	items := func(pattern string) []string {
+		fns, _ := filepath.Glob(filepath.Join(stowDir, "shards", pattern))
+		return fns
+	}
+
+	// A shard uploaded, and evicted, before encryption was enabled.
+	start := time.Now().Truncate(time.Minute)
+	plain, want := writeTestShard(t, dataDir, start.Add(-time.Hour), compression{codec: codecNone}, 2, 10)
+	sa := openArchive()
+	shards := sa.shards(plain.shardStart, plain.shardStart)
+	if len(shards) != 1 {
+		t.Fatalf("archive holds %d shards, want 1", len(shards))
+	}
+	waitFor(t, "shard to be uploaded", shards[0].uploaded)
+	sa.evict(time.Now())
+
+	// Shards are uploaded as soon as they are encrypted, and the
+	// unencrypted copy is replaced.
+	writeTestShard(t, dataDir, start, compression{codec: codecNone}, 2, 10)
+	sa = openArchive(WithArchiveEncryptTo([]openpgp.Entity{*ent}))
+	waitFor(t, "shards to be uploaded encrypted", func() bool {
+		return len(items("*.tar.gpg")) == 2
+	})
+	if fns := items("*.tar"); len(fns) != 0 {
+		t.Fatalf("unencrypted remote copies were left, %v", fns)
+	}
+	shards = sa.shards(start.Add(-2*time.Hour), start.Add(time.Hour))
+	if len(shards) != 2 {
+		t.Fatalf("archive holds %d shards, want 2", len(shards))
+	}
+	for _, s := range shards {
+		if !s.isEncrypted() || !s.uploaded() {
+			t.Fatalf("shard %s is not encrypted and uploaded", s.id)
+		}
+	}
+
+	// The encrypted copy can be evicted, and restored from the
+	// remote copy.
+	sa.evict(time.Now())
+	if _, err := os.Stat(filepath.Join(shards[0].dataDir, EncryptedFileName)); !os.IsNotExist(err) {
+		t.Fatalf("encrypted shard was not evicted, %v", err)
+	}
+	f, err := os.Open(items(shards[0].id + ".tar.gpg")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	restored, err := ioutil.TempDir("", "logspray-restored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(restored)
+	if err := DecryptShard(f, openpgp.EntityList{ent}, nil, restored); err != nil {
+		t.Fatal(err)
+	}
+	rs := &Shard{id: plain.id, dataDir: restored, shardStart: plain.shardStart, sealed: true}
+	got := searchTexts(t, []*Shard{rs}, plain.shardStart, plain.shardStart.Add(time.Minute), false, nil, nil)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("restored shard search got %v, want %v", got, want)
+	}
+
+	sa.pruneShards(sa.remove(shards), "retention")
+	if fns := items("*"); len(fns) != 0 {
+		t.Fatalf("remote copies were not pruned, found %v", fns)
+	}
+}
+
+// Shards aren't encrypted while they are being searched.
+func TestShard_EncryptWaitsForSearch(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	ent, err := openpgp.NewEntity("test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Truncate(time.Minute)
+	s, want := writeTestShard(t, dataDir, start, compression{codec: codecNone}, 2, 10)
+
+	searching := make(chan struct{})
+	release := make(chan struct{})
+	searched := make(chan []string)
+	go func() {
+		var got []string
+		mergeSearch(context.Background(), []*Shard{s}, func(m *logspray.Message) error {
+			if m.ControlMessage != logspray.Message_NONE {
+				return nil
+			}
+			if got == nil {
+				close(searching)
+				<-release
+			}
+			got = append(got, m.Text)
+			return nil
+		}, nil, nil, start, start.Add(time.Minute), false, nil, nil)
+		searched <- got
+	}()
+
+	<-searching
+	encrypted := make(chan error)
+	go func() { encrypted <- s.encrypt([]openpgp.Entity{*ent}) }()
+	select {
+	case err := <-encrypted:
+		t.Fatalf("shard was encrypted during a search, %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if got := <-searched; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("search got %v, want %v", got, want)
+	}
+	if err := <-encrypted; err != nil {
+		t.Fatal(err)
+	}
+	if !s.isEncrypted() {
+		t.Fatalf("shard was not encrypted")
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"fmt"
+	"os"
+)
+
+// fsyncPolicy sets when the files of the active shard are synced to
+// disk. Records that haven't been synced can be lost if the machine
+// stops, though not if only the server does.
+type fsyncPolicy int
+
+const (
+	// fsyncRotate syncs the files of a shard when it is closed.
+	fsyncRotate fsyncPolicy = iota
+	// fsyncInterval also syncs the files of the active shard
+	// periodically.
+	fsyncInterval
+	// fsyncWrite syncs every message as it is written. Each message
+	// is written as a block of its own, so this is best used without
+	// compression.
+	fsyncWrite
+)
+
+func (p fsyncPolicy) String() string {
+	switch p {
+	case fsyncRotate:
+		return "rotate"
+	case fsyncInterval:
+		return "interval"
+	case fsyncWrite:
+		return "write"
+	default:
+		return fmt.Sprintf("fsyncPolicy(%d)", int(p))
+	}
+}
+
+func parseFsyncPolicy(name string) (fsyncPolicy, error) {
+	for _, p := range []fsyncPolicy{fsyncRotate, fsyncInterval, fsyncWrite} {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown fsync policy %q, must be one of rotate, interval or write", name)
+}
+
+// syncDir syncs a directory, so that the files created in, or renamed
+// into, it are kept if the machine stops.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// replaceFile atomically replaces fn with bs. The new content is
+// synced before it is renamed into place, so that a file that is
+// found is complete.
+func replaceFile(fn string, bs []byte) error {
+	tmp := fn + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(bs)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, fn)
+}
@@ -16,8 +16,12 @@
 package indexer
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"sort"
 	"strings"
 	"time"
@@ -31,11 +35,16 @@ import (
 
 func adhocFilterToQuery(base string, afs []simplejson.QueryAdhocFilter) (string, error) {
 	terms := []string{base}
+	if len(afs) > 0 && strings.TrimSpace(base) != "" {
+		// The base query may contain an "or", the filters must
+		// apply to the whole of it.
+		terms = []string{"(" + base + ")"}
+	}
 
 	for _, af := range afs {
 		var op string
 		switch af.Operator {
-		case "=", "!=", "!~":
+		case "=", "!=", "!~", "<", ">":
 			op = af.Operator
 		case "=~":
 			op = "~"
@@ -50,47 +59,147 @@ func adhocFilterToQuery(base string, afs []simplejson.QueryAdhocFilter) (string,
 
 // GrafanaQuery implements the Grafana Simple JSON Query request
 func (idx *Indexer) GrafanaQuery(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
-	query, err := adhocFilterToQuery(target, args.Filters)
+	ss, err := idx.GrafanaQuerySeries(ctx, target, args)
 	if err != nil {
 		return nil, err
 	}
+	if len(ss) > 1 {
+		return nil, fmt.Errorf("query returned %d series, only one is supported", len(ss))
+	}
 
-	matcher, err := ql.Compile(query)
-	if err != nil {
-		return nil, err
+	data := []simplejson.DataPoint{}
+	for _, s := range ss {
+		for _, p := range s.Points {
+			t, _ := ptypes.Timestamp(p.Time)
+			data = append(data, simplejson.DataPoint{Time: t, Value: p.Value})
+		}
 	}
 
-	hits := map[time.Time]float64{}
-	var tvals []time.Time
-	for qtime := args.From; qtime.Before(args.To); qtime = qtime.Add(args.Interval) {
-		t := qtime.Truncate(args.Interval)
-		hits[t] = 0
-		tvals = append(tvals, t)
+	return data, nil
+}
+
+// GrafanaQuerySeries runs a Grafana timeserie query as an aggregation.
+// Unlike GrafanaQuery, the query may return several series.
+func (idx *Indexer) GrafanaQuerySeries(ctx context.Context, target string, args simplejson.QueryArguments) ([]*logspray.Series, error) {
+	agg, err := ql.CompileAggregation(target)
+	if err != nil {
+		return nil, err
 	}
 
-	msgFunc := func(m *logspray.Message) error {
-		if m.ControlMessage != 0 {
-			return nil
+	matcher := agg.Pipeline.Match
+	if len(args.Filters) > 0 {
+		query, err := adhocFilterToQuery("", args.Filters)
+		if err != nil {
+			return nil, err
 		}
-		t, _ := ptypes.Timestamp(m.Time)
-		if t.Before(args.From) || t.After(args.To) {
-			return nil
+		filter, err := ql.Compile(query)
+		if err != nil {
+			return nil, err
+		}
+		matcher = func(hdr, m *logspray.Message, headerOnly bool) bool {
+			return agg.Pipeline.Match(hdr, m, headerOnly) && filter(hdr, m, headerOnly)
 		}
-		hits[t.Truncate(args.Interval)]++
-		return nil
 	}
 
-	err = idx.Search(ctx, msgFunc, matcher, args.From, args.To, false)
-	if err != nil {
-		return nil, err
-	}
+	return idx.aggregate(ctx, agg, matcher, args.From, args.To, args.Interval)
+}
 
-	data := []simplejson.DataPoint{}
-	for _, t := range tvals {
-		data = append(data, simplejson.DataPoint{Time: t, Value: hits[t]})
+type grafanaQueryRequest struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	IntervalMS    int64 `json:"intervalMs"`
+	MaxDataPoints int   `json:"maxDataPoints"`
+	Targets       []struct {
+		Target string `json:"target"`
+		Type   string `json:"type"`
+	} `json:"targets"`
+	AdhocFilters []simplejson.QueryAdhocFilter `json:"adhocFilters"`
+}
+
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	DataPoints [][2]float64 `json:"datapoints"`
+}
+
+// seriesName names a series for display in Grafana
+func seriesName(target string, ls map[string]string) string {
+	if len(ls) == 0 {
+		return target
+	}
+	var ks []string
+	for k := range ls {
+		ks = append(ks, k)
 	}
+	sort.Strings(ks)
+	var strs []string
+	for _, k := range ks {
+		strs = append(strs, fmt.Sprintf("%s=%q", k, ls[k]))
+	}
+	return "{" + strings.Join(strs, ", ") + "}"
+}
 
-	return data, nil
+// GrafanaQueryHandler wraps the handler for the Grafana Simple JSON /query
+// endpoint. Timeserie queries are handled directly, so that a single target
+// can return a series for each group of an aggregation. Table queries are
+// passed on to next.
+func (idx *Indexer) GrafanaQueryHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		req := grafanaQueryRequest{}
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, t := range req.Targets {
+			if t.Type != "" && t.Type != "timeserie" {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		args := simplejson.QueryArguments{
+			QueryCommonArguments: simplejson.QueryCommonArguments{
+				From:    req.Range.From,
+				To:      req.Range.To,
+				Filters: req.AdhocFilters,
+			},
+			Interval: time.Duration(req.IntervalMS) * time.Millisecond,
+			MaxDPs:   req.MaxDataPoints,
+		}
+
+		out := []grafanaSeries{}
+		for _, t := range req.Targets {
+			ss, err := idx.GrafanaQuerySeries(r.Context(), t.Target, args)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			for _, s := range ss {
+				gs := grafanaSeries{Target: seriesName(t.Target, s.Labels), DataPoints: [][2]float64{}}
+				for _, p := range s.Points {
+					t, _ := ptypes.Timestamp(p.Time)
+					gs.DataPoints = append(gs.DataPoints, [2]float64{p.Value, float64(t.UnixNano() / int64(time.Millisecond))})
+				}
+				out = append(out, gs)
+			}
+		}
+
+		bs, err := json.Marshal(out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(bs)
+	})
 }
 
 // GrafanaQueryTable implements the Grafana Simple JSON Query request for tables
@@ -143,7 +252,7 @@ func (idx *Indexer) GrafanaQueryTable(ctx context.Context, target string, args s
 		return nil
 	})
 
-	err = idx.Search(ctx, msgFunc, matcher, args.From, args.To, false)
+	err = idx.Search(ctx, msgFunc, matcher, nil, args.From, args.To, false)
 	if err != nil && err != context.Canceled {
 		return nil, err
 	}
@@ -213,7 +322,7 @@ func (idx *Indexer) GrafanaAnnotations(ctx context.Context, query string, args s
 		}
 		return nil
 	})
-	err = idx.Search(ctx, msgFunc, matcher, args.From, args.To, true)
+	err = idx.Search(ctx, msgFunc, matcher, nil, args.From, args.To, true)
 	if err != nil && err != context.Canceled {
 		return nil, err
 	}
@@ -247,9 +356,14 @@ func (idx *Indexer) GrafanaSearch(ctx context.Context, target string) ([]string,
 		return res, err
 	}
 
-	lvs, _, err := idx.LabelValues(parts[0], time.Now().Add(-1*time.Hour), time.Now(), 100)
+	prefix := ""
+	if len(parts) == 2 {
+		prefix = parts[1]
+	}
+	match := func(v string) bool { return strings.HasPrefix(v, prefix) }
+	lvs, _, err := idx.LabelValues(parts[0], time.Now().Add(-1*time.Hour), time.Now(), 100, match)
 	for i := range lvs {
-		res = append(res, parts[0]+"="+lvs[i])
+		res = append(res, parts[0]+"="+lvs[i].Value)
 	}
 
 	return res, err
@@ -275,9 +389,9 @@ func (idx *Indexer) GrafanaAdhocFilterTags(ctx context.Context) ([]simplejson.Ta
 func (idx *Indexer) GrafanaAdhocFilterTagValues(ctx context.Context, key string) ([]simplejson.TagValuer, error) {
 	res := []simplejson.TagValuer{}
 
-	lvs, _, err := idx.LabelValues(key, time.Now().Add(-1*time.Hour), time.Now(), 100)
+	lvs, _, err := idx.LabelValues(key, time.Now().Add(-1*time.Hour), time.Now(), 100, nil)
 	for i := range lvs {
-		res = append(res, simplejson.TagStringValue(lvs[i]))
+		res = append(res, simplejson.TagStringValue(lvs[i].Value))
 	}
 
 	return res, err
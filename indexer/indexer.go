@@ -16,16 +16,21 @@
 package indexer
 
 import (
+	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/QubitProducts/logspray/proto/logspray"
 	"github.com/QubitProducts/logspray/ql"
 	"github.com/QubitProducts/logspray/sinks"
+	"github.com/graymeta/stow"
 	"github.com/oklog/ulid"
+	"golang.org/x/crypto/openpgp"
 )
 
 // Indexer implements a queryable index for storage of logspray
@@ -34,16 +39,43 @@ type Indexer struct {
 	shardDuration time.Duration
 	searchGrace   time.Duration
 	retention     time.Duration
+	maxBytes      int64
+	highWater     float64
+	lowWater      float64
 	grafanaMaxRes int
 	dataDir       string
+	bloom         bloomConfig
+	comp          compression
+	tidx          timeIndexConfig
+	gzipLevel     int
+	stowKind      string
+	stowConfig    stow.ConfigMap
+	stowCont      string
+	evictAfter    time.Duration
+	encryptTo     []openpgp.Entity
+	fsync         fsyncPolicy
+	fsyncInterval time.Duration
+	dedupWindow   time.Duration
 
 	id string
 
+	// Messages are written to the active shard while holding a read
+	// lock, so that it isn't closed while they are being written.
 	sync.RWMutex
 	activeShard *Shard
 	archive     *shardArchive
+	closed      bool
+	marks       *highWaterMarks
+	synced      *highWaterMarks
+	diskUsed    *sizeCache
+
+	stop    chan struct{}
+	closing sync.WaitGroup // shards being closed after rotation
 }
 
+// errIndexClosed is returned when writing to a closed index.
+var errIndexClosed = errors.New("index is closed")
+
 // Opt defines an index option function.
 type Opt func(i *Indexer) error
 
@@ -66,6 +98,15 @@ func New(opts ...Opt) (*Indexer, error) {
 		searchGrace:   time.Minute * 1,
 		grafanaMaxRes: 500,
 		dataDir:       "data",
+		bloom:         bloomConfig{tokens: 10000, fpRate: 0.01},
+		comp:          compression{codec: codecNone, blockSize: defaultBlockSize},
+		tidx:          timeIndexConfig{records: 1000, bytes: defaultBlockSize},
+		highWater:     1,
+		lowWater:      0.9,
+		dedupWindow:   time.Hour,
+		stop:          make(chan struct{}),
+		marks:         newHighWaterMarks(),
+		synced:        newHighWaterMarks(),
 	}
 
 	for _, o := range opts {
@@ -74,16 +115,46 @@ func New(opts ...Opt) (*Indexer, error) {
 		}
 	}
 
+	// Syncing after every message flushes every message as a block of
+	// its own, which costs more than compression saves.
+	if indx.fsync == fsyncWrite && indx.comp.codec != codecNone {
+		return nil, fmt.Errorf("fsync policy write cannot be used with compressed shard files")
+	}
+
 	arch, err := NewArchive(
 		WithArchiveDataDir(indx.dataDir),
 		WithArchiveRetention(indx.retention),
+		WithArchiveMaxBytes(indx.maxBytes, indx.highWater, indx.lowWater),
 		WithArchiveSearchGrace(indx.searchGrace),
+		WithArchiveGzipCompression(indx.gzipLevel),
+		WithArchiveStowConfig(indx.stowConfig),
+		WithArchiveStowLocation(indx.stowKind, indx.stowCont),
+		WithArchiveEvictAfter(indx.evictAfter),
+		WithArchiveEncryptTo(indx.encryptTo),
 	)
 	if err != nil {
 		return nil, err
 	}
 
 	indx.archive = arch
+	indx.diskUsed = &sizeCache{dir: indx.dataDir, maxAge: diskUsedMaxAge}
+
+	// Streams written to within the dedup window before the index was
+	// last closed may still be resent. The manifests of shards left
+	// open by a crash are built when the archive is opened, so they are
+	// seeded too.
+	var since time.Time
+	if indx.dedupWindow > 0 {
+		since = time.Now().Add(-indx.dedupWindow)
+	}
+	for _, s := range arch.shards(since, time.Now()) {
+		indx.marks.seed(s.indexes(), s.shardStart)
+		indx.synced.seed(s.indexes(), s.shardStart)
+	}
+
+	if indx.fsync == fsyncInterval {
+		go indx.syncLoop()
+	}
 
 	return indx, nil
 }
@@ -96,6 +167,20 @@ func WithSharDuration(d time.Duration) Opt {
 	}
 }
 
+// WithDedupWindow sets how long the highest Index written to a stream
+// is remembered after the stream was last written to, so that messages
+// resent within it are dropped as duplicates. It is at least the
+// duration of a shard. A window of 0 remembers every stream.
+func WithDedupWindow(d time.Duration) Opt {
+	return func(i *Indexer) error {
+		if d < 0 {
+			return fmt.Errorf("dedup window must not be negative")
+		}
+		i.dedupWindow = d
+		return nil
+	}
+}
+
 // WithSearchGrace shards +/- this grace period will be included in
 // searches.
 func WithSearchGrace(d time.Duration) Opt {
@@ -113,6 +198,19 @@ func WithRetention(d time.Duration) Opt {
 	}
 }
 
+// WithMaxBytes limits the disk space used by the index to max bytes.
+// Once more than highWater of max is used, the oldest shards are
+// deleted until less than lowWater of max is used. A max of 0 disables
+// the limit.
+func WithMaxBytes(max int64, highWater, lowWater float64) Opt {
+	return func(i *Indexer) error {
+		i.maxBytes = max
+		i.highWater = highWater
+		i.lowWater = lowWater
+		return nil
+	}
+}
+
 // WithDataDir lets you set the base filesystem path to store
 // data to.
 func WithDataDir(d string) Opt {
@@ -122,8 +220,159 @@ func WithDataDir(d string) Opt {
 	}
 }
 
-// Close this index
+// WithBloomFilter sizes the bloom filter kept for each shard file, used to
+// skip files when searching message text. tokens is the number of distinct
+// tokens expected in a file, and fpRate the false positive rate wanted at
+// that number of tokens. Setting tokens to 0 disables the bloom filters.
+func WithBloomFilter(tokens int, fpRate float64) Opt {
+	return func(i *Indexer) error {
+		if tokens > 0 && (fpRate <= 0 || fpRate >= 1) {
+			return fmt.Errorf("bloom filter false positive rate must be between 0 and 1")
+		}
+		i.bloom = bloomConfig{tokens: tokens, fpRate: fpRate}
+		return nil
+	}
+}
+
+// WithCompression sets the compression used for shard files, one of
+// none, snappy or gzip. Messages are compressed in blocks of blockSize
+// bytes. Shard files are not compressed by default, and compression
+// cannot be combined with the write fsync policy.
+func WithCompression(codec string, blockSize int) Opt {
+	return func(i *Indexer) error {
+		c, err := parseCodec(codec)
+		if err != nil {
+			return err
+		}
+		if blockSize <= 0 || blockSize > maxMessageSize {
+			return fmt.Errorf("block size must be between 1 and %d bytes", maxMessageSize)
+		}
+		i.comp = compression{codec: c, level: gzip.DefaultCompression, blockSize: blockSize}
+		return nil
+	}
+}
+
+// WithTimeIndex sets how often a mark is added to the time index of
+// shard files written without compression, which lets searches skip
+// the parts of a file outside of their time range. A mark is added
+// every records records, or bytes bytes, whichever comes first. 0
+// disables either limit.
+func WithTimeIndex(records int, bytes int64) Opt {
+	return func(i *Indexer) error {
+		if records < 0 || bytes < 0 {
+			return fmt.Errorf("time index intervals must not be negative")
+		}
+		i.tidx = timeIndexConfig{records: records, bytes: bytes}
+		return nil
+	}
+}
+
+// WithArchiveGzipLevel recompresses the files of shards, once they are
+// archived, using gzip at the given level. 0 disables recompression.
+func WithArchiveGzipLevel(level int) Opt {
+	return func(i *Indexer) error {
+		i.gzipLevel = level
+		return nil
+	}
+}
+
+// WithStow uploads closed shards to the named container of a stow
+// location of the given kind and config. Local copies of uploaded
+// shards are evicted once they haven't been used for evictAfter, and
+// fetched again when they are searched. An evictAfter of 0 keeps the
+// local copies.
+func WithStow(kind string, config stow.ConfigMap, container string, evictAfter time.Duration) Opt {
+	return func(i *Indexer) error {
+		i.stowKind = kind
+		i.stowConfig = config
+		i.stowCont = container
+		i.evictAfter = evictAfter
+		return nil
+	}
+}
+
+// WithEncryptTo encrypts the files of shards to the given recipients
+// when they are closed. Encrypted shards can't be searched, but can be
+// restored by the holder of a recipient's private key.
+func WithEncryptTo(to []openpgp.Entity) Opt {
+	return func(i *Indexer) error {
+		i.encryptTo = to
+		return nil
+	}
+}
+
+// WithFsync sets when the files of the active shard are synced to
+// disk, one of rotate, when the shard is closed, interval, every
+// interval, or write, after every message. The write policy cannot be
+// used with compressed shard files.
+func WithFsync(policy string, interval time.Duration) Opt {
+	return func(i *Indexer) error {
+		p, err := parseFsyncPolicy(policy)
+		if err != nil {
+			return err
+		}
+		if p == fsyncInterval && interval <= 0 {
+			return fmt.Errorf("fsync interval must be greater than 0")
+		}
+		i.fsync = p
+		i.fsyncInterval = interval
+		return nil
+	}
+}
+
+// Synced returns the highest Index of a stream that has been synced to
+// disk, or 0 if none of the stream's recent messages have been. When
+// that is depends on the fsync policy. With the rotate policy files
+// are only synced when a shard is closed, which would leave clients
+// holding a shard's worth of messages, so the highest Index written is
+// returned instead.
+func (idx *Indexer) Synced(streamID string) uint64 {
+	if idx.fsync == fsyncRotate {
+		return idx.marks.index(streamID)
+	}
+	return idx.synced.index(streamID)
+}
+
+// syncLoop periodically syncs the files of the active shard, until
+// the index is closed.
+func (idx *Indexer) syncLoop() {
+	t := time.NewTicker(idx.fsyncInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-idx.stop:
+			return
+		}
+		idx.RLock()
+		s := idx.activeShard
+		idx.RUnlock()
+		if s != nil {
+			s.sync()
+		}
+	}
+}
+
+// Close this index. Messages being written are finished, and the
+// active shard is closed, syncing its files and writing its manifest.
+// It is added to the archive when the index is next opened. No more
+// messages can be written once the index is closed.
 func (idx *Indexer) Close() error {
+	idx.Lock()
+	if idx.closed {
+		idx.Unlock()
+		return nil
+	}
+	idx.closed = true
+	s := idx.activeShard
+	idx.activeShard = nil
+	idx.Unlock()
+
+	close(idx.stop)
+	if s != nil {
+		s.close()
+	}
+	idx.closing.Wait()
 	return nil
 }
 
@@ -137,40 +386,82 @@ func (idx *Indexer) AddSource(ctx context.Context, id string, labels map[string]
 
 // WriteMessage writes a message to the log stream.
 func (w *MessageWriter) WriteMessage(ctx context.Context, m *logspray.Message) error {
-	var err error
 	shardStart := time.Now().Truncate(w.indx.shardDuration)
 
 	w.indx.RLock()
-	if w.indx.activeShard == nil || shardStart.After(w.indx.activeShard.shardStart) {
-		oldShard := w.indx.activeShard
+	if !w.indx.closed && (w.indx.activeShard == nil || shardStart.After(w.indx.activeShard.shardStart)) {
 		w.indx.RUnlock()
-
-		w.indx.Lock()
-		w.indx.activeShard, err = newShard(
-			shardStart,
-			w.indx.dataDir,
-			w.indx.id,
-		)
-		if err != nil {
-			w.indx.Unlock()
+		if err := w.indx.rotate(shardStart); err != nil {
 			return err
 		}
-
-		w.indx.Unlock()
 		w.indx.RLock()
+	}
+	defer w.indx.RUnlock()
 
-		if oldShard != nil {
-			go func() {
-				oldShard.close()
-				w.indx.archive.Add(oldShard)
-			}()
-		}
+	if w.indx.closed {
+		return errIndexClosed
 	}
 	shard := w.indx.activeShard
 
-	w.indx.RUnlock()
+	prev, ok := w.indx.marks.mark(m.StreamID, m.Index, shard.shardStart)
+	if !ok {
+		duplicateMessages.Inc()
+		return ErrDuplicate
+	}
+	if err := shard.writeMessage(ctx, m, w.labels); err != nil {
+		w.indx.marks.unmark(m.StreamID, m.Index, prev)
+		return err
+	}
+	return nil
+}
 
-	return shard.writeMessage(ctx, m, w.labels)
+// rotate replaces the active shard with one starting at shardStart,
+// unless another writer already has. The old shard is closed, and
+// added to the archive, once the messages being written to it are
+// finished.
+func (idx *Indexer) rotate(shardStart time.Time) error {
+	idx.Lock()
+	defer idx.Unlock()
+	if idx.closed || (idx.activeShard != nil && !shardStart.After(idx.activeShard.shardStart)) {
+		return nil
+	}
+
+	s, err := newShard(
+		shardStart,
+		idx.dataDir,
+		idx.id,
+		idx.bloom,
+		idx.comp,
+		idx.tidx,
+		idx.fsync,
+		idx.synced,
+	)
+	if err != nil {
+		return err
+	}
+	oldShard := idx.activeShard
+	idx.activeShard = s
+
+	if oldShard != nil {
+		// Streams are remembered for the dedup window after they
+		// were last written to, and for at least a shard.
+		if idx.dedupWindow > 0 {
+			before := shardStart.Add(-idx.dedupWindow)
+			if before.After(oldShard.shardStart) {
+				before = oldShard.shardStart
+			}
+			idx.marks.forget(before)
+			idx.synced.forget(before)
+		}
+
+		idx.closing.Add(1)
+		go func() {
+			defer idx.closing.Done()
+			oldShard.close()
+			idx.archive.Add(oldShard)
+		}()
+	}
+	return nil
 }
 
 // Close closes the remote stream.
@@ -178,31 +469,165 @@ func (w *MessageWriter) Close() error {
 	return nil
 }
 
-// Labels lists all the label names in the current index
-func (idx *Indexer) Labels(from, to time.Time) ([]string, error) {
+// labelShards returns the label summaries of the active and archived
+// shards holding messages between from and to.
+func (idx *Indexer) labelShards(from, to time.Time) ([]*labelSummary, error) {
+	if to.Before(from) {
+		return nil, fmt.Errorf("time to must be after time from")
+	}
 	idx.RLock()
 	s := idx.activeShard
 	idx.RUnlock()
 
-	res := s.Labels()
+	ss := idx.archive.shards(from, to)
+	if s != nil && to.After(s.shardStart) {
+		ss = append(ss, s)
+	}
 
+	var res []*labelSummary
+	for _, sh := range ss {
+		if ls := sh.labels(); ls.overlaps(from, to) {
+			res = append(res, ls)
+		}
+	}
 	return res, nil
 }
 
-// LabelValues returns all the known values for a given label.
-func (idx *Indexer) LabelValues(name string, from, to time.Time, count int) ([]string, int, error) {
+// Labels lists the label names used by messages between from and to.
+func (idx *Indexer) Labels(from, to time.Time) ([]string, error) {
+	lss, err := idx.labelShards(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]struct{}{}
+	for _, ls := range lss {
+		for k := range ls.Counts {
+			names[k] = struct{}{}
+		}
+	}
+
+	res := make([]string, 0, len(names))
+	for k := range names {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+
+	return res, nil
+}
+
+// LabelValue is a label value, and the number of messages using it.
+type LabelValue struct {
+	Value string
+	Count uint64
+}
+
+// LabelValues returns the values of a label used by messages between
+// from and to, and accepted by match, if it isn't nil. Values are
+// ordered by the number of messages using them, counted across the
+// shards overlapping the range, most common first. If count is greater
+// than zero only that many values are returned. The total number of
+// values accepted is returned too.
+func (idx *Indexer) LabelValues(name string, from, to time.Time, count int, match func(string) bool) ([]LabelValue, int, error) {
+	lss, err := idx.labelShards(from, to)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	vals := map[string]uint64{}
+	for _, ls := range lss {
+		for v, n := range ls.Counts[name] {
+			if match != nil && !match(v) {
+				continue
+			}
+			vals[v] += n
+		}
+	}
+
+	res := make([]LabelValue, 0, len(vals))
+	for v, n := range vals {
+		res = append(res, LabelValue{Value: v, Count: n})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Value < res[j].Value
+	})
+
+	total := len(res)
+	if count > 0 && len(res) > count {
+		res = res[:count]
+	}
+	return res, total, nil
+}
+
+// Aggregate evaluates an aggregation query, returning series with a
+// point at each step between from and to.
+func (idx *Indexer) Aggregate(ctx context.Context, agg *ql.Aggregation, from, to time.Time, step time.Duration) ([]*logspray.Series, error) {
+	return idx.aggregate(ctx, agg, agg.Pipeline.Match, from, to, step)
+}
+
+func (idx *Indexer) aggregate(ctx context.Context, agg *ql.Aggregation, matcher ql.MatchFunc, from, to time.Time, step time.Duration) ([]*logspray.Series, error) {
+	ag, err := agg.Aggregator(from, to, step)
+	if err != nil {
+		return nil, err
+	}
+
+	msgFunc := agg.Pipeline.MessageFunc(logspray.MakeFlattenStreamFunc(func(m *logspray.Message) error {
+		if m.ControlMessage != 0 {
+			return nil
+		}
+		ag.Add(m)
+		return nil
+	}))
+
+	sfrom, sto := ag.SearchRange()
+	if err = idx.Search(ctx, msgFunc, matcher, agg.Pipeline.Tokens, sfrom, sto, false); err != nil {
+		return nil, err
+	}
+
+	return ag.Series(), nil
+}
+
+// Explain lists the shards, and the files within them, that a search
+// from from to to would read. Files that matcher rules out using only
+// their stream header are marked as pruned.
+func (idx *Indexer) Explain(matcher ql.MatchFunc, from, to time.Time) ([]*logspray.ExplainShard, error) {
+	if to.Before(from) {
+		return nil, fmt.Errorf("time to must be after time from")
+	}
 	idx.RLock()
 	s := idx.activeShard
 	idx.RUnlock()
 
-	res := s.LabelValues(name)
+	res, err := idx.archive.Explain(matcher, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	if s != nil && to.After(s.shardStart) {
+		es, err := s.explain(matcher, from, to, true)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, es)
+	}
 
-	return res, len(res), nil
+	return res, nil
 }
 
 // Search queries the index for documents matching the provided
 // search query.
-func (idx *Indexer) Search(ctx context.Context, msgFunc logspray.MessageFunc, matcher ql.MatchFunc, from, to time.Time, reverse bool) error {
+func (idx *Indexer) Search(ctx context.Context, msgFunc logspray.MessageFunc, matcher ql.MatchFunc, tokens ql.TokenFilter, from, to time.Time, reverse bool) error {
+	return idx.SearchAfter(ctx, msgFunc, matcher, tokens, from, to, reverse, nil, nil)
+}
+
+// SearchAfter is as Search, but resumes from a cursor, if after is
+// not nil, returning only the messages that follow it. If pos is not
+// nil it is called with the cursor for each message before it is
+// passed to msgFunc, so that a later search can resume from it.
+func (idx *Indexer) SearchAfter(ctx context.Context, msgFunc logspray.MessageFunc, matcher ql.MatchFunc, tokens ql.TokenFilter, from, to time.Time, reverse bool, after *Cursor, pos func(*Cursor)) error {
 	if to.Before(from) {
 		return fmt.Errorf("time to must be after time from")
 	}
@@ -210,31 +635,10 @@ func (idx *Indexer) Search(ctx context.Context, msgFunc logspray.MessageFunc, ma
 	s := idx.activeShard
 	idx.RUnlock()
 
-	if reverse {
-		if s != nil && to.After(s.shardStart) {
-			err := s.Search(ctx, msgFunc, matcher, from, to, reverse)
-			if err != nil {
-				return err
-			}
-		}
-
-		err := idx.archive.Search(ctx, msgFunc, matcher, from, to, reverse)
-		if err != nil {
-			return err
-		}
-	} else {
-		err := idx.archive.Search(ctx, msgFunc, matcher, from, to, reverse)
-		if err != nil {
-			return err
-		}
-
-		if s != nil && to.After(s.shardStart) {
-			err = s.Search(ctx, msgFunc, matcher, from, to, reverse)
-			if err != nil {
-				return err
-			}
-		}
+	shards := idx.archive.shards(from, to)
+	if s != nil && to.After(s.shardStart) {
+		shards = append(shards, s)
 	}
 
-	return nil
+	return mergeSearch(ctx, shards, msgFunc, matcher, tokens, from, to, reverse, after, pos)
 }
@@ -0,0 +1,754 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QubitProducts/logspray/proto/logspray"
+	"github.com/QubitProducts/logspray/ql"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/oklog/ulid"
+)
+
+// writeStream writes messages with the Indexes from first to last to
+// a stream of idx, returning the errors.
+func writeStream(t *testing.T, idx *Indexer, streamID string, first, last int) []error {
+	t.Helper()
+	w, err := idx.AddSource(context.Background(), streamID, map[string]string{"job": "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errs []error
+	for i := first; i <= last; i++ {
+		ts, _ := ptypes.TimestampProto(time.Now())
+		errs = append(errs, w.WriteMessage(context.Background(), &logspray.Message{
+			StreamID: streamID,
+			Index:    uint64(i),
+			Time:     ts,
+			Text:     fmt.Sprintf("line %d", i),
+		}))
+	}
+	return errs
+}
+
+// countMessages counts the messages found by a search of the last
+// hour.
+func countMessages(t *testing.T, idx *Indexer) int {
+	t.Helper()
+	n := 0
+	err := idx.Search(context.Background(), func(m *logspray.Message) error {
+		if m.ControlMessage == logspray.Message_NONE {
+			n++
+		}
+		return nil
+	}, nil, nil, time.Now().Add(-time.Hour), time.Now().Add(time.Minute), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// Messages written before a crash, and resent after the restart, are
+// dropped.
+func TestIndexer_RestartAfterCrash(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	streamID := ulid.MustNew(ulid.Now(), rand.Reader).String()
+	crashed, err := New(WithDataDir(dataDir), WithSharDuration(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range writeStream(t, crashed, streamID, 1, 10) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// crashed is never closed, so its shard has no manifest.
+
+	idx, err := New(WithDataDir(dataDir), WithSharDuration(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	for i, err := range writeStream(t, idx, streamID, 5, 12) {
+		if i+5 <= 10 && err != ErrDuplicate {
+			t.Fatalf("resent message %d was not dropped, %v", i+5, err)
+		}
+		if i+5 > 10 && err != nil {
+			t.Fatalf("new message %d was not written, %v", i+5, err)
+		}
+	}
+	if n := countMessages(t, idx); n != 12 {
+		t.Fatalf("found %d messages, want 12", n)
+	}
+}
+
+func TestIndexer_Fsync(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	if _, err := New(WithDataDir(dataDir), WithFsync("sometimes", 0)); err == nil {
+		t.Fatalf("expected an error for an unknown fsync policy")
+	}
+	if _, err := New(WithDataDir(dataDir), WithFsync("interval", 0)); err == nil {
+		t.Fatalf("expected an error for an fsync interval of 0")
+	}
+	if _, err := New(WithDataDir(dataDir), WithFsync("write", 0), WithCompression("snappy", defaultBlockSize)); err == nil {
+		t.Fatalf("expected an error syncing compressed files on every write")
+	}
+
+	tests := []struct {
+		policy string
+		wait   time.Duration
+	}{
+		// Files are only synced on rotation, so the messages
+		// written are reported instead.
+		{policy: "rotate"},
+		{policy: "write"},
+		{policy: "interval", wait: 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			idx, err := New(WithDataDir(dataDir), WithSharDuration(time.Hour), WithFsync(tt.policy, 10*time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer idx.Close()
+
+			streamID := ulid.MustNew(ulid.Now(), rand.Reader).String()
+			for i := 1; i <= 5; i++ {
+				if err := writeStream(t, idx, streamID, i, i)[0]; err != nil {
+					t.Fatal(err)
+				}
+				if tt.wait == 0 && idx.Synced(streamID) != uint64(i) {
+					t.Fatalf("message %d was not synced as it was written, synced %d", i, idx.Synced(streamID))
+				}
+			}
+
+			deadline := time.Now().Add(time.Second)
+			for idx.Synced(streamID) != 5 {
+				if time.Now().After(deadline) {
+					t.Fatalf("messages were not synced, synced %d", idx.Synced(streamID))
+				}
+				time.Sleep(tt.wait)
+			}
+		})
+	}
+}
+
+func TestIndexer_Close(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	idx, err := New(WithDataDir(dataDir), WithSharDuration(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamID := ulid.MustNew(ulid.Now(), rand.Reader).String()
+	for _, err := range writeStream(t, idx, streamID, 1, 10) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := idx.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Close(); err != nil {
+		t.Fatalf("closing an index twice failed, %v", err)
+	}
+	if errs := writeStream(t, idx, streamID, 11, 11); errs[0] != errIndexClosed {
+		t.Fatalf("writing to a closed index returned %v", errs[0])
+	}
+
+	// Closing the index closes the active shard, so it is reopened
+	// with a manifest, rather than as a crashed shard.
+	idx, err = New(WithDataDir(dataDir), WithSharDuration(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	ss := idx.archive.shards(time.Now().Add(-time.Hour), time.Now())
+	if len(ss) != 1 || ss[0].messages() != 10 {
+		t.Fatalf("expected the closed shard, with 10 messages, found %v", ss)
+	}
+	if n := countMessages(t, idx); n != 10 {
+		t.Fatalf("found %d messages, want 10", n)
+	}
+}
+
+// Messages resent by a stream that was idle for longer than a shard
+// are dropped, while it is within the dedup window, including after
+// the index is reopened.
+func TestIndexer_DedupWindow(t *testing.T) {
+	const shardDuration = 50 * time.Millisecond
+	tests := []struct {
+		name   string
+		window time.Duration
+		dup    bool
+	}{
+		{name: "within window", window: time.Hour, dup: true},
+		{name: "no window", window: 0, dup: true},
+		{name: "past window", window: shardDuration, dup: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataDir, err := ioutil.TempDir("", "logspray-data")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dataDir)
+
+			open := func() *Indexer {
+				idx, err := New(WithDataDir(dataDir), WithSharDuration(shardDuration), WithDedupWindow(tt.window))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return idx
+			}
+			idle := ulid.MustNew(ulid.Now(), rand.Reader).String()
+			busy := ulid.MustNew(ulid.Now(), rand.Reader).String()
+
+			idx := open()
+			for _, err := range writeStream(t, idx, idle, 1, 5) {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Another stream rotates through several shards while
+			// the first is idle.
+			for i := 1; i <= 5; i++ {
+				time.Sleep(shardDuration)
+				if errs := writeStream(t, idx, busy, i, i); errs[0] != nil {
+					t.Fatal(errs[0])
+				}
+			}
+
+			check := func(what string, idx *Indexer) {
+				t.Helper()
+				err := writeStream(t, idx, idle, 5, 5)[0]
+				if tt.dup && err != ErrDuplicate {
+					t.Fatalf("%s: resent message was not dropped, %v", what, err)
+				}
+				if !tt.dup && err != nil {
+					t.Fatalf("%s: resent message past the window was not written, %v", what, err)
+				}
+			}
+			check("idle", idx)
+
+			if err := idx.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if tt.dup {
+				idx = open()
+				defer idx.Close()
+				check("reopened", idx)
+			}
+		})
+	}
+}
+
+// labelSpans are time ranges over the index written by
+// openLabelIndex.
+type labelSpans struct {
+	all, closed, active, none [2]time.Time
+}
+
+// openLabelIndex opens an index holding a closed shard, from two hours
+// ago, and an active shard, with messages from the last minute.
+func openLabelIndex(t *testing.T, dataDir string) (*Indexer, labelSpans) {
+	t.Helper()
+	type stream struct {
+		labels map[string]string
+		n      int
+		// every tenth message from the first has level set.
+		level string
+	}
+	write := func(w func(*logspray.Message, map[string]string) error, start time.Time, ss []stream) {
+		t.Helper()
+		for _, st := range ss {
+			streamID := ulid.MustNew(ulid.Now(), rand.Reader).String()
+			for i := 0; i < st.n; i++ {
+				ts, _ := ptypes.TimestampProto(start.Add(time.Duration(i) * time.Second))
+				m := &logspray.Message{StreamID: streamID, Index: uint64(i + 1), Time: ts, Text: fmt.Sprintf("line %d", i)}
+				if st.level != "" && i%10 == 0 {
+					m.Labels = map[string]string{"level": st.level}
+				}
+				if err := w(m, st.labels); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	closedStart := time.Now().Add(-2 * time.Hour).Truncate(time.Hour)
+	closed, err := newShard(closedStart, dataDir, "test", bloomConfig{}, compression{codec: codecNone}, timeIndexConfig{}, fsyncRotate, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(func(m *logspray.Message, labels map[string]string) error {
+		return closed.writeMessage(context.Background(), m, labels)
+	}, closedStart, []stream{
+		{labels: map[string]string{"job": "api"}, n: 12, level: "error"},
+		{labels: map[string]string{"job": "web"}, n: 3},
+	})
+	closed.close()
+
+	idx, err := New(WithDataDir(dataDir), WithSharDuration(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	write(func(m *logspray.Message, labels map[string]string) error {
+		w, _ := idx.AddSource(context.Background(), m.StreamID, labels)
+		return w.WriteMessage(context.Background(), m)
+	}, now.Add(-time.Minute), []stream{
+		{labels: map[string]string{"job": "api"}, n: 4, level: "warn"},
+		{labels: map[string]string{"job": "worker", "host": "h1"}, n: 3},
+	})
+
+	return idx, labelSpans{
+		all:    [2]time.Time{now.Add(-3 * time.Hour), now.Add(time.Minute)},
+		closed: [2]time.Time{closedStart, closedStart.Add(time.Minute)},
+		active: [2]time.Time{now.Add(-2 * time.Minute), now.Add(time.Minute)},
+		none:   [2]time.Time{closedStart.Add(10 * time.Minute), closedStart.Add(20 * time.Minute)},
+	}
+}
+
+// Label names are summarised across the closed shards in the archive,
+// from their manifests, and the active shard.
+func TestIndexer_Labels(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+	idx, spans := openLabelIndex(t, dataDir)
+	defer idx.Close()
+
+	tests := []struct {
+		name  string
+		span  [2]time.Time
+		names []string
+	}{
+		{name: "all", span: spans.all, names: []string{"host", "job", "level"}},
+		{name: "closed", span: spans.closed, names: []string{"job", "level"}},
+		{name: "active", span: spans.active, names: []string{"host", "job", "level"}},
+		{name: "none", span: spans.none, names: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, err := idx.Labels(tt.span[0], tt.span[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(names) != fmt.Sprint(tt.names) {
+				t.Fatalf("got %v, want %v", names, tt.names)
+			}
+		})
+	}
+
+	if _, err := idx.Labels(spans.active[1], spans.active[0]); err == nil {
+		t.Fatalf("expected an error for a reversed time range")
+	}
+}
+
+// Label values are counted across the closed shards in the archive
+// and the active shard.
+func TestIndexer_LabelValues(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+	idx, spans := openLabelIndex(t, dataDir)
+	defer idx.Close()
+
+	hasPrefix := func(p string) func(string) bool {
+		return func(v string) bool { return strings.HasPrefix(v, p) }
+	}
+	tests := []struct {
+		name   string
+		label  string
+		span   [2]time.Time
+		count  int
+		match  func(string) bool
+		values []LabelValue
+		total  int
+	}{
+		{
+			name: "all", label: "job", span: spans.all,
+			values: []LabelValue{{"api", 16}, {"web", 3}, {"worker", 3}},
+			total:  3,
+		},
+		{
+			name: "closed", label: "job", span: spans.closed,
+			values: []LabelValue{{"api", 12}, {"web", 3}},
+			total:  2,
+		},
+		{
+			name: "active", label: "job", span: spans.active,
+			values: []LabelValue{{"api", 4}, {"worker", 3}},
+			total:  2,
+		},
+		{
+			name: "message labels", label: "level", span: spans.all,
+			values: []LabelValue{{"error", 2}, {"warn", 1}},
+			total:  2,
+		},
+		{
+			name: "count", label: "job", span: spans.all, count: 2,
+			values: []LabelValue{{"api", 16}, {"web", 3}},
+			total:  3,
+		},
+		{
+			name: "prefix", label: "job", span: spans.all, match: hasPrefix("w"),
+			values: []LabelValue{{"web", 3}, {"worker", 3}},
+			total:  2,
+		},
+		{
+			name: "regex", label: "job", span: spans.all, match: regexp.MustCompile(`er$`).MatchString,
+			values: []LabelValue{{"worker", 3}},
+			total:  1,
+		},
+		{
+			name: "unknown", label: "region", span: spans.all,
+			values: []LabelValue{},
+			total:  0,
+		},
+		{
+			name: "none", label: "job", span: spans.none,
+			values: []LabelValue{},
+			total:  0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, total, err := idx.LabelValues(tt.label, tt.span[0], tt.span[1], tt.count, tt.match)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(values, tt.values) || total != tt.total {
+				t.Fatalf("got %v of %d, want %v of %d", values, total, tt.values, tt.total)
+			}
+		})
+	}
+}
+
+// openAggregateIndex opens an index holding a closed shard, started
+// an hour before the active shard, and the same requests logged by an
+// api and a web stream in both. The start of each shard is returned.
+func openAggregateIndex(t *testing.T, dataDir string) (*Indexer, time.Time, time.Time) {
+	t.Helper()
+	reqs := []struct {
+		offset time.Duration
+		job    string
+		status string
+		path   string
+	}{
+		{10 * time.Second, "api", "200", "/"},
+		{20 * time.Second, "api", "500", "/"},
+		{30 * time.Second, "web", "200", "/"},
+		{70 * time.Second, "api", "200", "/login"},
+		{80 * time.Second, "api", "200", "/"},
+		{90 * time.Second, "api", "404", "/login"},
+		{100 * time.Second, "web", "200", "/login"},
+		{150 * time.Second, "api", "200", "/"},
+		{300 * time.Second, "api", "200", "/"},
+	}
+	write := func(w func(*logspray.Message, map[string]string) error, start time.Time) {
+		t.Helper()
+		ids := map[string]string{}
+		idxs := map[string]uint64{}
+		for _, r := range reqs {
+			if _, ok := ids[r.job]; !ok {
+				ids[r.job] = ulid.MustNew(ulid.Now(), rand.Reader).String()
+			}
+			idxs[r.job]++
+			ts, _ := ptypes.TimestampProto(start.Add(r.offset))
+			m := &logspray.Message{
+				StreamID: ids[r.job],
+				Index:    idxs[r.job],
+				Time:     ts,
+				Text:     fmt.Sprintf("status=%s path=%s", r.status, r.path),
+			}
+			if err := w(m, map[string]string{"job": r.job}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	activeStart := time.Now().Truncate(time.Hour)
+	closedStart := activeStart.Add(-time.Hour)
+	closed, err := newShard(closedStart, dataDir, "test", bloomConfig{}, compression{codec: codecNone}, timeIndexConfig{}, fsyncRotate, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(func(m *logspray.Message, labels map[string]string) error {
+		return closed.writeMessage(context.Background(), m, labels)
+	}, closedStart)
+	closed.close()
+
+	idx, err := New(WithDataDir(dataDir), WithSharDuration(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(func(m *logspray.Message, labels map[string]string) error {
+		w, _ := idx.AddSource(context.Background(), m.StreamID, labels)
+		return w.WriteMessage(context.Background(), m)
+	}, activeStart)
+	return idx, closedStart, activeStart
+}
+
+// seriesString formats series as their labels followed by their value
+// at each of n steps from start, or _ if they have no point there.
+func seriesString(ss []*logspray.Series, start time.Time, step time.Duration, n int) string {
+	var strs []string
+	for _, s := range ss {
+		var ls []string
+		for k, v := range s.Labels {
+			ls = append(ls, fmt.Sprintf("%s=%q", k, v))
+		}
+		sort.Strings(ls)
+		str := "{" + strings.Join(ls, ",") + "}"
+		pt := 0
+		for i := 0; i < n; i++ {
+			t := start.Add(time.Duration(i) * step)
+			if pt < len(s.Points) {
+				if pts, _ := ptypes.Timestamp(s.Points[pt].Time); pts.Equal(t) {
+					str += fmt.Sprintf(" %v", s.Points[pt].Value)
+					pt++
+					continue
+				}
+			}
+			str += " _"
+		}
+		strs = append(strs, str)
+	}
+	return strings.Join(strs, ";")
+}
+
+// Aggregations count the messages in closed and active shards alike,
+// grouped by the labels of their stream header or those extracted by
+// the query.
+func TestIndexer_Aggregate(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+	idx, closedStart, activeStart := openAggregateIndex(t, dataDir)
+	defer idx.Close()
+
+	tests := []struct {
+		q   string
+		exp string
+	}{
+		{
+			`job=api`,
+			`{} 2 3 1`,
+		},
+		{
+			`count_over_time({job=api} [2m])`,
+			`{} 5 4 1`,
+		},
+		{
+			`rate({job=api} [1m])`,
+			`{} 0.03333333333333333 0.05 0.016666666666666666`,
+		},
+		{
+			`count_over_time({job=*} [1m]) by (job)`,
+			`{job="api"} 2 3 1;{job="web"} 1 1 0`,
+		},
+		{
+			`count_over_time({job=api | logfmt} [1m]) by (status)`,
+			`{status="200"} 1 2 1;{status="404"} 0 1 0;{status="500"} 1 0 0`,
+		},
+		{
+			`sum by (path) (count_over_time({job=* | logfmt} [1m]) by (job, path))`,
+			`{path="/"} 3 1 1;{path="/login"} 0 3 0`,
+		},
+		{
+			`count_over_time({job=api | logfmt | status>=400} [1m]) by (path)`,
+			`{path="/"} 1 0 0;{path="/login"} 0 1 0`,
+		},
+		{
+			`count_over_time({job=other} [1m])`,
+			`{} 0 0 0`,
+		},
+	}
+	for _, tt := range tests {
+		for name, start := range map[string]time.Time{"closed": closedStart, "active": activeStart} {
+			t.Run(name+" "+tt.q, func(t *testing.T) {
+				agg, err := ql.CompileAggregation(tt.q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ss, err := idx.Aggregate(context.Background(), agg, start, start.Add(3*time.Minute), time.Minute)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := seriesString(ss, start, time.Minute, 3); got != tt.exp {
+					t.Fatalf("\nexpected: %#v\ngot: %#v", tt.exp, got)
+				}
+			})
+		}
+	}
+
+	// A window spanning both shards counts the messages in each.
+	agg, err := ql.CompileAggregation(`count_over_time({job=*} [1h]) by (job)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, err := idx.Aggregate(context.Background(), agg, closedStart, activeStart.Add(time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, exp := seriesString(ss, closedStart, time.Hour, 2), `{job="api"} 7 7;{job="web"} 2 2`; got != exp {
+		t.Fatalf("\nexpected: %#v\ngot: %#v", exp, got)
+	}
+}
+
+// Explain lists the closed and active shards a search reads, and the
+// files in them that the query rules out by their header.
+func TestIndexer_Explain(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+	idx, closedStart, activeStart := openAggregateIndex(t, dataDir)
+	defer idx.Close()
+
+	// explainString formats shards as their start, whether they
+	// are active, and the jobs of their files, marking those pruned.
+	explainString := func(ess []*logspray.ExplainShard) string {
+		var strs []string
+		for _, es := range ess {
+			st, _ := ptypes.Timestamp(es.Start)
+			var jobs []string
+			for _, f := range es.Files {
+				job := f.Labels["job"]
+				if f.Pruned {
+					job = "-" + job
+				}
+				jobs = append(jobs, job)
+			}
+			sort.Strings(jobs)
+			strs = append(strs, fmt.Sprintf("%s active=%v %v", st.Format("15:04"), es.Active, jobs))
+		}
+		return strings.Join(strs, ";")
+	}
+	closedStr, activeStr := closedStart.Format("15:04"), activeStart.Format("15:04")
+
+	tests := []struct {
+		name     string
+		q        string
+		from, to time.Time
+		exp      string
+		archived string
+	}{
+		{
+			name: "all",
+			from: closedStart, to: activeStart.Add(time.Hour),
+			exp:      closedStr + " active=false [api web];" + activeStr + " active=true [api web]",
+			archived: closedStr + " active=false [api web]",
+		},
+		{
+			name: "pruned",
+			q:    `job=web`,
+			from: closedStart, to: activeStart.Add(time.Hour),
+			exp:      closedStr + " active=false [-api web];" + activeStr + " active=true [-api web]",
+			archived: closedStr + " active=false [-api web]",
+		},
+		{
+			name: "text",
+			q:    `job=api or "/login"`,
+			from: closedStart, to: activeStart.Add(time.Hour),
+			exp:      closedStr + " active=false [api web];" + activeStr + " active=true [api web]",
+			archived: closedStr + " active=false [api web]",
+		},
+		{
+			name: "not",
+			q:    `not job=api`,
+			from: closedStart, to: activeStart.Add(time.Hour),
+			exp:      closedStr + " active=false [-api web];" + activeStr + " active=true [-api web]",
+			archived: closedStr + " active=false [-api web]",
+		},
+		{
+			name: "closed",
+			q:    `job=api`,
+			from: closedStart, to: closedStart.Add(time.Minute),
+			exp:      closedStr + " active=false [-web api]",
+			archived: closedStr + " active=false [-web api]",
+		},
+		{
+			name: "active",
+			q:    `job=api`,
+			from: activeStart, to: activeStart.Add(time.Minute),
+			exp: activeStr + " active=true [-web api]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var matcher ql.MatchFunc
+			if tt.q != "" {
+				if matcher, err = ql.Compile(tt.q); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ess, err := idx.Explain(matcher, tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := explainString(ess); got != tt.exp {
+				t.Fatalf("\nexpected: %#v\ngot: %#v", tt.exp, got)
+			}
+			ess, err = idx.archive.Explain(matcher, tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := explainString(ess); got != tt.archived {
+				t.Fatalf("archive\nexpected: %#v\ngot: %#v", tt.archived, got)
+			}
+		})
+	}
+
+	if _, err := idx.Explain(nil, activeStart, closedStart); err == nil {
+		t.Fatalf("expected an error for a reversed time range")
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"time"
+
+	"github.com/QubitProducts/logspray/proto/logspray"
+	"github.com/golang/protobuf/ptypes"
+)
+
+// labelSummary lists the label values used in a shard, by stream
+// headers and messages, with the number of messages using each, and
+// the time range of its messages. It is kept in the manifest of closed
+// shards so that label queries needn't read their files.
+type labelSummary struct {
+	// From and To are the times of the first and last messages, they
+	// are zero if the shard has no messages.
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Counts maps label=value to the number of messages with that
+	// label, either in the message or its stream header.
+	Counts map[string]map[string]uint64 `json:"counts"`
+}
+
+// overlaps reports whether the shard may hold messages between from
+// and to. Shards without messages overlap nothing.
+func (ls *labelSummary) overlaps(from, to time.Time) bool {
+	if ls.From.IsZero() || ls.To.IsZero() {
+		return false
+	}
+	return !ls.To.Before(from) && !ls.From.After(to)
+}
+
+// labelCounts counts the messages using each label value in an active
+// shard. Header labels are counted per stream, so that each message
+// only updates the counts of its own labels.
+type labelCounts struct {
+	msgs        map[string]map[string]uint64
+	streams     map[string]*streamCount
+	n           uint64
+	first, last time.Time
+}
+
+// streamCount is the number of messages in a stream, and the highest
+// Index among them.
+type streamCount struct {
+	labels map[string]string
+	n      uint64
+	index  uint64
+}
+
+func newLabelCounts() *labelCounts {
+	return &labelCounts{
+		msgs:    map[string]map[string]uint64{},
+		streams: map[string]*streamCount{},
+	}
+}
+
+// addStream records the header labels of a stream.
+func (lc *labelCounts) addStream(id string, labels map[string]string) {
+	if _, ok := lc.streams[id]; !ok {
+		lc.streams[id] = &streamCount{labels: labels}
+	}
+}
+
+// add counts a message in stream id. Control messages aren't counted.
+func (lc *labelCounts) add(id string, m *logspray.Message) {
+	if m.ControlMessage != logspray.Message_NONE {
+		return
+	}
+	for k, v := range m.Labels {
+		vs, ok := lc.msgs[k]
+		if !ok {
+			vs = map[string]uint64{}
+			lc.msgs[k] = vs
+		}
+		vs[v]++
+	}
+	if sc, ok := lc.streams[id]; ok {
+		sc.n++
+		if m.Index > sc.index {
+			sc.index = m.Index
+		}
+	}
+	lc.n++
+	if m.Time == nil {
+		return
+	}
+	t, err := ptypes.Timestamp(m.Time)
+	if err != nil {
+		return
+	}
+	if lc.first.IsZero() || t.Before(lc.first) {
+		lc.first = t
+	}
+	if t.After(lc.last) {
+		lc.last = t
+	}
+}
+
+// summary returns a label summary of the counts so far.
+func (lc *labelCounts) summary() *labelSummary {
+	ls := &labelSummary{
+		From:   lc.first,
+		To:     lc.last,
+		Counts: make(map[string]map[string]uint64, len(lc.msgs)),
+	}
+	add := func(k, v string, n uint64) {
+		vs, ok := ls.Counts[k]
+		if !ok {
+			vs = map[string]uint64{}
+			ls.Counts[k] = vs
+		}
+		vs[v] += n
+	}
+	for k, vs := range lc.msgs {
+		for v, n := range vs {
+			add(k, v, n)
+		}
+	}
+	for _, sc := range lc.streams {
+		for k, v := range sc.labels {
+			add(k, v, sc.n)
+		}
+	}
+	return ls
+}
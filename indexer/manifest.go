@@ -0,0 +1,132 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// manifestFileName is the name of the manifest within a shard's
+// directory.
+const manifestFileName = "manifest.json"
+
+// shardManifest describes a closed shard, so that its state can be
+// restored when the server restarts without reading its files.
+type shardManifest struct {
+	// Start is the start of the shard's time bucket, and End the
+	// time at which it was closed.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Streams lists the IDs of the streams written to the shard, and
+	// Indexes the highest Index written to each of them.
+	Streams  []string          `json:"streams"`
+	Indexes  map[string]uint64 `json:"indexes,omitempty"`
+	Messages uint64            `json:"messages"`
+	// Labels is left out of the manifests of encrypted shards.
+	Labels *labelSummary `json:"labels,omitempty"`
+}
+
+// newManifest returns the manifest of a shard with the given counts,
+// closed at end.
+func (s *Shard) newManifest(lc *labelCounts, end time.Time) *shardManifest {
+	m := &shardManifest{
+		Start:    s.shardStart,
+		End:      end,
+		Streams:  []string{},
+		Indexes:  map[string]uint64{},
+		Messages: lc.n,
+		Labels:   lc.summary(),
+	}
+	for id, sc := range lc.streams {
+		m.Streams = append(m.Streams, id)
+		if sc.index != 0 {
+			m.Indexes[id] = sc.index
+		}
+	}
+	sort.Strings(m.Streams)
+	return m
+}
+
+// buildManifest reads every message in a shard to build its manifest.
+// It is only needed for shards closed before manifests were kept, whose
+// start and end are taken from the times of their messages.
+func buildManifest(s *Shard) (*shardManifest, error) {
+	if err := s.fetch(); err != nil {
+		return nil, err
+	}
+	lc := newLabelCounts()
+	for _, f := range s.findFiles(nil, time.Time{}, time.Time{}) {
+		sfi, err := OpenShardFileIterator(f.fn)
+		if err != nil {
+			return nil, err
+		}
+		sfi.SkipCorrupt(func(error) { corruptRecords.Inc() })
+		lc.addStream(f.id, sfi.Header().Labels)
+		for sfi.Next() {
+			lc.add(f.id, sfi.Message())
+		}
+		err = sfi.Err()
+		sfi.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s, %w", f.fn, err)
+		}
+	}
+	m := s.newManifest(lc, lc.last)
+	if !lc.first.IsZero() && lc.first.Before(m.Start) {
+		m.Start = lc.first
+	}
+	if m.End.Before(m.Start) {
+		m.End = m.Start
+	}
+	return m, nil
+}
+
+func writeManifest(dir string, m *shardManifest) error {
+	bs, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to marshal shard manifest, %w", err)
+	}
+
+	if err = replaceFile(filepath.Join(dir, manifestFileName), bs); err != nil {
+		return fmt.Errorf("failed to write shard manifest, %w", err)
+	}
+	return nil
+}
+
+func readManifest(dir string) (*shardManifest, error) {
+	bs, err := ioutil.ReadFile(filepath.Join(dir, manifestFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &shardManifest{}
+	if err = json.Unmarshal(bs, m); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal shard manifest, %w", err)
+	}
+	if m.Start.IsZero() {
+		return nil, errors.New("shard manifest has no start time")
+	}
+	if m.Labels != nil && m.Labels.Counts == nil {
+		return nil, errors.New("shard manifest label summary has no counts")
+	}
+	return m, nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/QubitProducts/logspray/proto/logspray"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/oklog/ulid"
+)
+
+// writeCrashedShard writes messages to a shard that is never closed,
+// as if the server crashed, leaving it without a manifest.
+func writeCrashedShard(t *testing.T, dataDir string, start time.Time, n int) (*Shard, string) {
+	t.Helper()
+	s, err := newShard(start, dataDir, "test", bloomConfig{}, compression{codec: codecNone}, timeIndexConfig{}, fsyncRotate, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamID := ulid.MustNew(ulid.Now(), rand.Reader).String()
+	for i := 0; i < n; i++ {
+		ts, _ := ptypes.TimestampProto(start.Add(time.Duration(i) * time.Second))
+		m := &logspray.Message{StreamID: streamID, Index: uint64(i + 1), Time: ts, Text: fmt.Sprintf("line %d", i)}
+		if err := s.writeMessage(context.Background(), m, map[string]string{"job": "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s, streamID
+}
+
+func TestArchive_BuildMissingManifests(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	crashed, streamID := writeCrashedShard(t, dataDir, start, 10)
+
+	sa, err := NewArchive(WithArchiveDataDir(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(crashed.dataDir, manifestFileName)); err != nil {
+		t.Fatalf("manifest was not written at startup, %v", err)
+	}
+
+	// The shard is placed by the times of its messages, not the time
+	// in its ID.
+	ss := sa.shards(start, start.Add(time.Minute))
+	if len(ss) != 1 || ss[0].id != crashed.id {
+		t.Fatalf("expected the crashed shard, found %v", ss)
+	}
+	s := ss[0]
+	if !s.shardStart.Equal(start) {
+		t.Fatalf("shard starts at %v, want %v", s.shardStart, start)
+	}
+	if s.messages() != 10 {
+		t.Fatalf("manifest counted %d messages, want 10", s.messages())
+	}
+	if idx := s.indexes()[streamID]; idx != 10 {
+		t.Fatalf("manifest has index %d for the stream, want 10", idx)
+	}
+}
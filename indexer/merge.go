@@ -0,0 +1,240 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"container/heap"
+	"context"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/QubitProducts/logspray/proto/logspray"
+	"github.com/QubitProducts/logspray/ql"
+)
+
+// maxOpenFiles limits the number of archived files a merge keeps
+// open. Beyond it, the searches of files are suspended, and resumed
+// when they are next needed.
+var maxOpenFiles = 256
+
+// searchHeap orders the file searches of a merge by the position of
+// their next message, earliest first, or latest first for reverse
+// searches.
+type searchHeap struct {
+	fss     []*fileSearch
+	reverse bool
+}
+
+func (h *searchHeap) Len() int      { return len(h.fss) }
+func (h *searchHeap) Swap(i, j int) { h.fss[i], h.fss[j] = h.fss[j], h.fss[i] }
+func (h *searchHeap) Less(i, j int) bool {
+	if h.reverse {
+		return h.fss[j].pos.Before(&h.fss[i].pos)
+	}
+	return h.fss[i].pos.Before(&h.fss[j].pos)
+}
+func (h *searchHeap) Push(x interface{}) { h.fss = append(h.fss, x.(*fileSearch)) }
+func (h *searchHeap) Pop() interface{} {
+	fs := h.fss[len(h.fss)-1]
+	h.fss = h.fss[:len(h.fss)-1]
+	return fs
+}
+
+// mergeShard is a shard waiting to be opened by a merge, and the time
+// range of its messages, which is zero if it isn't known.
+type mergeShard struct {
+	s           *Shard
+	first, last time.Time
+}
+
+func (ms mergeShard) known() bool {
+	return !ms.first.IsZero() && !ms.last.IsZero()
+}
+
+// mergeSearch searches the files of shards, passing matching messages
+// to msgFunc in time order, or reverse time order, across all of the
+// files. Each file's header is passed to msgFunc before any of its
+// messages. Shards are only opened once the merge reaches the time
+// range of their messages, and are skipped if it is outside from and
+// to. If after is set only messages following it are passed on. If
+// pos is set it is called with the position of each message before it
+// is passed to msgFunc. No more than maxOpenFiles archived files are
+// held open at once.
+func mergeSearch(ctx context.Context, shards []*Shard, msgFunc logspray.MessageFunc, matcher ql.MatchFunc, tokens ql.TokenFilter, from, to time.Time, reverse bool, after *Cursor, pos func(*Cursor)) error {
+	if after != nil {
+		if reverse && after.Time.Before(to) {
+			to = after.Time
+		}
+		if !reverse && after.Time.After(from) {
+			from = after.Time
+		}
+	}
+
+	var mss []mergeShard
+	for _, s := range shards {
+		ms := mergeShard{s: s}
+		ms.first, ms.last = s.timeRange()
+		if ms.known() && (ms.last.Before(from) || ms.first.After(to)) {
+			continue
+		}
+		mss = append(mss, ms)
+	}
+	// Shards whose time range isn't known are opened first.
+	sort.SliceStable(mss, func(i, j int) bool {
+		if mss[i].known() != mss[j].known() {
+			return !mss[i].known()
+		}
+		if reverse {
+			return mss[i].last.After(mss[j].last)
+		}
+		return mss[i].first.Before(mss[j].first)
+	})
+
+	h := &searchHeap{reverse: reverse}
+	defer func() {
+		for _, fs := range h.fss {
+			fs.close()
+		}
+	}()
+
+	// openFiles counts the files held open by the searches in h.
+	// release suspends searches, starting from the end of the heap,
+	// where they tend to be furthest from being needed, until there
+	// is room to open another file.
+	openFiles := 0
+	release := func() {
+		for i := len(h.fss) - 1; i >= 0 && openFiles >= maxOpenFiles; i-- {
+			if fs := h.fss[i]; fs.file != nil {
+				fs.suspend()
+				openFiles--
+			}
+		}
+	}
+
+	// The shards opened are held until the merge is done, so that
+	// their files aren't replaced while they are being read.
+	var opened []*Shard
+	defer func() {
+		for _, s := range opened {
+			s.useLock.RUnlock()
+		}
+	}()
+
+	open := func(s *Shard) error {
+		s.useLock.RLock()
+		opened = append(opened, s)
+		if err := s.fetch(); err != nil {
+			return err
+		}
+		for _, f := range s.candidates(matcher, from, to) {
+			release()
+			fs, err := f.newSearch(matcher, tokens, from, to, reverse)
+			if err != nil {
+				return err
+			}
+			if fs == nil {
+				continue
+			}
+			f := f
+			fs.reopen = func() (*fileSearch, error) {
+				return f.newSearch(matcher, nil, from, to, reverse)
+			}
+			fs.pos.Shard = s.id
+			if after != nil && after.Shard == s.id && after.Stream == fs.pos.Stream && after.codec == fs.codec {
+				fs.rr.resumeAt(after.Offset)
+			}
+			if err = msgFunc(fs.hdr); err != nil {
+				fs.close()
+				return err
+			}
+			if err = fs.advance(); err != nil {
+				fs.close()
+				if err == io.EOF {
+					continue
+				}
+				return err
+			}
+			if fs.file != nil {
+				openFiles++
+			}
+			heap.Push(h, fs)
+		}
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if len(mss) > 0 {
+			ms := mss[0]
+			reached := h.Len() == 0 || !ms.known()
+			if !reached {
+				t := h.fss[0].pos.Time
+				if reverse {
+					reached = !ms.last.Before(t)
+				} else {
+					reached = !ms.first.After(t)
+				}
+			}
+			if reached {
+				mss = mss[1:]
+				if err := open(ms.s); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if h.Len() == 0 {
+			return nil
+		}
+
+		fs := h.fss[0]
+		if after == nil || fs.pos.after(after, reverse) {
+			if pos != nil {
+				p := fs.pos
+				pos(&p)
+			}
+			if err := msgFunc(fs.next); err != nil {
+				return err
+			}
+		}
+		resumed := fs.suspended()
+		if resumed {
+			release()
+		}
+		err := fs.advance()
+		if resumed && fs.file != nil {
+			openFiles++
+		}
+		switch {
+		case err == io.EOF:
+			heap.Pop(h)
+			if fs.file != nil {
+				openFiles--
+			}
+			fs.close()
+		case err != nil:
+			return err
+		default:
+			heap.Fix(h, 0)
+		}
+	}
+}
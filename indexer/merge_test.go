@@ -0,0 +1,116 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/QubitProducts/logspray/proto/logspray"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/oklog/ulid"
+)
+
+// writeTestShard writes n messages, a second apart from start, to a
+// closed shard. The messages are spread across streams files in turn,
+// so that their times are interleaved. The text of the messages is
+// returned in time order.
+func writeTestShard(t *testing.T, dataDir string, start time.Time, comp compression, streams, n int) (*Shard, []string) {
+	t.Helper()
+	comp.level = gzip.DefaultCompression
+	s, err := newShard(start, dataDir, "test", bloomConfig{}, comp, timeIndexConfig{records: 4}, fsyncRotate, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for k := 0; k < streams; k++ {
+		ids = append(ids, ulid.MustNew(ulid.Now(), rand.Reader).String())
+	}
+	var want []string
+	for i := 0; i < n; i++ {
+		ts, _ := ptypes.TimestampProto(start.Add(time.Duration(i) * time.Second))
+		k := i % streams
+		m := &logspray.Message{StreamID: ids[k], Index: uint64(i/streams + 1), Time: ts, Text: fmt.Sprintf("line %d", i)}
+		if err := s.writeMessage(context.Background(), m, map[string]string{"job": "test", "stream": fmt.Sprint(k)}); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, m.Text)
+	}
+	s.close()
+	return s, want
+}
+
+// searchTexts runs a merged search of shards, returning the text of
+// the messages found.
+func searchTexts(t *testing.T, shards []*Shard, from, to time.Time, reverse bool, after *Cursor, pos func(*Cursor)) []string {
+	t.Helper()
+	var got []string
+	err := mergeSearch(context.Background(), shards, func(m *logspray.Message) error {
+		if m.ControlMessage == logspray.Message_NONE {
+			got = append(got, m.Text)
+		}
+		return nil
+	}, nil, nil, from, to, reverse, after, pos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func reversed(ss []string) []string {
+	res := make([]string, len(ss))
+	for i, s := range ss {
+		res[len(ss)-1-i] = s
+	}
+	return res
+}
+
+func TestMergeSearch_MaxOpenFiles(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	defer func(n int) { maxOpenFiles = n }(maxOpenFiles)
+
+	start := time.Now().Truncate(time.Minute)
+	for _, codec := range testCodecs {
+		const streams = 5
+		s, want := writeTestShard(t, dataDir, start, compression{codec: codec, blockSize: 256}, streams, 50)
+
+		for _, max := range []int{1, 2, streams} {
+			for _, reverse := range []bool{false, true} {
+				maxOpenFiles = max
+				got := searchTexts(t, []*Shard{s}, start, start.Add(time.Minute), reverse, nil, nil)
+				exp := want
+				if reverse {
+					exp = reversed(want)
+				}
+				if fmt.Sprint(got) != fmt.Sprint(exp) {
+					t.Fatalf("%v, %d open files, reverse %v: got %v, want %v", codec, max, reverse, got, exp)
+				}
+			}
+		}
+		os.RemoveAll(s.dataDir)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/QubitProducts/logspray/proto/logspray"
+	"github.com/golang/glog"
+)
+
+// postingsFileName is the name of the postings index within a
+// shard's directory.
+const postingsFileName = "postings.json"
+
+// postings is an inverted index of the stream header labels of the
+// files in a closed shard. It lets searches rule out files without
+// opening them to read their headers.
+type postings struct {
+	// Files lists the base names of the shard's files, and Streams
+	// their stream IDs.
+	Files   []string `json:"files"`
+	Streams []string `json:"streams"`
+	// Labels maps label=value to the files, by index into Files,
+	// whose header has that label.
+	Labels map[string]map[string][]int `json:"labels"`
+
+	hdrs map[string]*logspray.Message
+}
+
+// buildPostings indexes the headers of the files in fs. Files whose
+// header cannot be read are left out, and so can't be ruled out by
+// a search.
+func buildPostings(fs []*ShardFile) *postings {
+	p := &postings{Labels: map[string]map[string][]int{}}
+	for _, f := range fs {
+		hdr, err := f.Header()
+		if err != nil {
+			glog.Errorf("not indexing %s, %v", f.fn, err)
+			continue
+		}
+		i := len(p.Files)
+		p.Files = append(p.Files, filepath.Base(f.fn))
+		p.Streams = append(p.Streams, hdr.StreamID)
+		for k, v := range hdr.Labels {
+			if _, ok := p.Labels[k]; !ok {
+				p.Labels[k] = map[string][]int{}
+			}
+			p.Labels[k][v] = append(p.Labels[k][v], i)
+		}
+	}
+	p.buildHeaders()
+	return p
+}
+
+// buildHeaders recreates the header of each file from the index.
+func (p *postings) buildHeaders() {
+	p.hdrs = map[string]*logspray.Message{}
+	for i, fn := range p.Files {
+		p.hdrs[fn] = &logspray.Message{
+			ControlMessage: logspray.Message_SETHEADER,
+			StreamID:       p.Streams[i],
+			Labels:         map[string]string{},
+		}
+	}
+	for k, vs := range p.Labels {
+		for v, is := range vs {
+			for _, i := range is {
+				p.hdrs[p.Files[i]].Labels[k] = v
+			}
+		}
+	}
+}
+
+// header returns the header of the file with base name fn. ok is
+// false if the file is not in the index.
+func (p *postings) header(fn string) (*logspray.Message, bool) {
+	hdr, ok := p.hdrs[fn]
+	return hdr, ok
+}
+
+// covers reports whether every file in fs is in the index.
+func (p *postings) covers(fs []*ShardFile) bool {
+	for _, f := range fs {
+		if _, ok := p.hdrs[filepath.Base(f.fn)]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func writePostings(dir string, p *postings) error {
+	bs, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to marshal postings, %w", err)
+	}
+
+	if err = replaceFile(filepath.Join(dir, postingsFileName), bs); err != nil {
+		return fmt.Errorf("failed to write postings, %w", err)
+	}
+	return nil
+}
+
+func readPostings(dir string) (*postings, error) {
+	bs, err := ioutil.ReadFile(filepath.Join(dir, postingsFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &postings{}
+	if err = json.Unmarshal(bs, p); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal postings, %w", err)
+	}
+	if len(p.Files) != len(p.Streams) {
+		return nil, fmt.Errorf("corrupt postings, %d files but %d streams", len(p.Files), len(p.Streams))
+	}
+	for k, vs := range p.Labels {
+		for v, is := range vs {
+			for _, i := range is {
+				if i < 0 || i >= len(p.Files) {
+					return nil, fmt.Errorf("corrupt postings, bad file index for %s=%s", k, v)
+				}
+			}
+		}
+	}
+	p.buildHeaders()
+	return p, nil
+}
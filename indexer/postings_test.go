@@ -0,0 +1,147 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/QubitProducts/logspray/proto/logspray"
+	"github.com/QubitProducts/logspray/ql"
+)
+
+func TestShard_Candidates(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	s, _ := writeTestShard(t, dataDir, start, compression{}, 3, 30)
+	matcher, err := ql.Compile("stream=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want string
+	for _, f := range s.findFiles(nil, time.Time{}, time.Time{}) {
+		hdr, err := f.Header()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Labels["stream"] == "1" {
+			want = f.fn
+			continue
+		}
+		// Empty the other files, so that their headers can only be
+		// known from the postings.
+		if err := os.Truncate(f.fn, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	candidates := func(s *Shard) []string {
+		var fns []string
+		for _, f := range s.candidates(matcher, start, start.Add(time.Minute)) {
+			fns = append(fns, f.fn)
+		}
+		return fns
+	}
+	if got := candidates(s); len(got) != 1 || got[0] != want {
+		t.Fatalf("postings gave candidates %v, want %v", got, want)
+	}
+
+	// The postings written when the shard was closed are read back
+	// when the shard is reopened.
+	reopen := func() *Shard {
+		return &Shard{id: s.id, shardStart: start, dataDir: s.dataDir, sealed: true}
+	}
+	if got := candidates(reopen()); len(got) != 1 || got[0] != want {
+		t.Fatalf("postings read from disk gave candidates %v, want %v", got, want)
+	}
+
+	// Without postings, files with unreadable headers can't be ruled
+	// out.
+	if err := os.Remove(filepath.Join(s.dataDir, postingsFileName)); err != nil {
+		t.Fatal(err)
+	}
+	if got := candidates(reopen()); len(got) != 3 {
+		t.Fatalf("rebuilt postings gave candidates %v, want all 3 files", got)
+	}
+}
+
+func TestArchive_RebuildMissingPostings(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	s, texts := writeTestShard(t, dataDir, start, compression{}, 3, 30)
+	pfn := filepath.Join(s.dataDir, postingsFileName)
+	if err := os.Remove(pfn); err != nil {
+		t.Fatal(err)
+	}
+
+	sa, err := NewArchive(WithArchiveDataDir(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	matcher, err := ql.Compile("stream=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err = sa.Search(context.Background(), func(m *logspray.Message) error {
+		if m.ControlMessage == logspray.Message_NONE {
+			got = append(got, m.Text)
+		}
+		return nil
+	}, matcher, nil, start, start.Add(time.Minute), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for i, text := range texts {
+		if i%3 == 1 {
+			want = append(want, text)
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	p, err := readPostings(s.dataDir)
+	if err != nil {
+		t.Fatalf("postings were not rebuilt, %v", err)
+	}
+	if !p.covers(s.findFiles(nil, time.Time{}, time.Time{})) {
+		t.Fatalf("rebuilt postings cover files %v", p.Files)
+	}
+	if fs := p.Labels["stream"]["1"]; len(fs) != 1 {
+		t.Fatalf("rebuilt postings have files %v for stream=1", fs)
+	}
+	if _, err := os.Stat(pfn + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temporary postings file left behind, %v", err)
+	}
+}
@@ -0,0 +1,401 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/graymeta/stow"
+)
+
+// remoteFileName is the name of the file, within a shard's directory,
+// recording where the shard was uploaded.
+const remoteFileName = "remote.json"
+
+// remoteInfo records the upload of a shard to remote storage.
+type remoteInfo struct {
+	// Item is the stow ID of the item holding the packed shard.
+	Item     string    `json:"item"`
+	Uploaded time.Time `json:"uploaded"`
+	// Encrypted is set if the item is the encrypted pack of the shard.
+	Encrypted bool `json:"encrypted,omitempty"`
+}
+
+// remoteStore is the stow container that closed shards are uploaded
+// to. The location is dialled the first time it is needed.
+type remoteStore struct {
+	kind      string
+	config    stow.ConfigMap
+	container string
+
+	once sync.Once
+	cont stow.Container
+	err  error
+}
+
+// dial returns the stow container, creating it if it doesn't exist.
+func (rs *remoteStore) dial() (stow.Container, error) {
+	rs.once.Do(func() {
+		loc, err := stow.Dial(rs.kind, rs.config)
+		if err != nil {
+			rs.err = fmt.Errorf("failed to dial %s stow location, %w", rs.kind, err)
+			return
+		}
+		cursor := stow.CursorStart
+		for rs.cont == nil {
+			var cs []stow.Container
+			cs, cursor, err = loc.Containers(rs.container, cursor)
+			if err != nil {
+				rs.err = fmt.Errorf("failed to list stow containers, %w", err)
+				return
+			}
+			for _, c := range cs {
+				if c.Name() == rs.container {
+					rs.cont = c
+					return
+				}
+			}
+			if stow.IsCursorEnd(cursor) {
+				break
+			}
+		}
+		if rs.cont, err = loc.CreateContainer(rs.container); err != nil {
+			rs.err = fmt.Errorf("failed to create stow container %s, %w", rs.container, err)
+		}
+	})
+	return rs.cont, rs.err
+}
+
+// upload packs the files of a closed shard, and puts them in the
+// store.
+func (rs *remoteStore) upload(s *Shard) (*remoteInfo, error) {
+	cont, err := rs.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	// Encrypted shards are uploaded as they are.
+	if s.encrypted {
+		f, err := os.Open(filepath.Join(s.dataDir, EncryptedFileName))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		item, err := cont.Put(s.id+".tar.gpg", f, fi.Size())
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload shard, %w", err)
+		}
+		return &remoteInfo{Item: item.ID(), Uploaded: time.Now(), Encrypted: true}, nil
+	}
+
+	tmp, err := ioutil.TempFile(s.dataDir, "pack*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err = packShard(s.dataDir, tmp); err != nil {
+		return nil, fmt.Errorf("failed to pack shard, %w", err)
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	item, err := cont.Put(s.id+".tar", tmp, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload shard, %w", err)
+	}
+	return &remoteInfo{Item: item.ID(), Uploaded: time.Now()}, nil
+}
+
+// download fetches the packed files of a shard from the store, and
+// unpacks them into its directory.
+func (rs *remoteStore) download(s *Shard, ri *remoteInfo) error {
+	cont, err := rs.dial()
+	if err != nil {
+		return err
+	}
+	item, err := cont.Item(ri.Item)
+	if err != nil {
+		return fmt.Errorf("failed to find shard item %s, %w", ri.Item, err)
+	}
+	r, err := item.Open()
+	if err != nil {
+		return fmt.Errorf("failed to download shard, %w", err)
+	}
+	defer r.Close()
+
+	if err = unpackShard(r, s.dataDir); err != nil {
+		return fmt.Errorf("failed to unpack shard, %w", err)
+	}
+	return nil
+}
+
+// remove deletes the packed files of a shard from the store.
+func (rs *remoteStore) remove(ri *remoteInfo) error {
+	cont, err := rs.dial()
+	if err != nil {
+		return err
+	}
+	return cont.RemoveItem(ri.Item)
+}
+
+// packed reports whether a file in a shard's directory is packed for
+// upload. Files written in passing, and the record of the upload
+// itself, are left out.
+func packed(name string) bool {
+	return name != remoteFileName && !strings.HasSuffix(name, ".tmp")
+}
+
+// packShard writes a tar of the files in dir to w.
+func packShard(dir string, w io.Writer) error {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() || !packed(fi.Name()) {
+			continue
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		f, err := os.Open(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(tw, f, fi.Size())
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// unpackShard extracts a tar written by packShard into dir. Each file
+// is written alongside its final name, and renamed into place once it
+// is complete.
+func unpackShard(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := hdr.Name
+		if name != filepath.Base(name) || !packed(name) || hdr.Typeflag != tar.TypeReg {
+			return fmt.Errorf("unexpected file %q in packed shard", name)
+		}
+
+		fn := filepath.Join(dir, name)
+		f, err := os.Create(fn + ".tmp")
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(fn+".tmp", fn)
+		}
+		if err != nil {
+			os.Remove(fn + ".tmp")
+			return err
+		}
+	}
+}
+
+// evictable reports whether a file in a shard's directory is removed
+// when the shard is evicted. The manifest, and the record of the
+// upload, are kept so that the shard can still be found and queried
+// for labels.
+func evictable(name string) bool {
+	return name != remoteFileName && name != manifestFileName
+}
+
+func writeRemoteInfo(dir string, ri *remoteInfo) error {
+	bs, err := json.Marshal(ri)
+	if err != nil {
+		return fmt.Errorf("failed to marshal remote info, %w", err)
+	}
+
+	tmp := filepath.Join(dir, remoteFileName+".tmp")
+	if err = ioutil.WriteFile(tmp, bs, 0666); err != nil {
+		return fmt.Errorf("failed to write remote info, %w", err)
+	}
+	if err = os.Rename(tmp, filepath.Join(dir, remoteFileName)); err != nil {
+		return fmt.Errorf("failed to write remote info, %w", err)
+	}
+	return nil
+}
+
+func readRemoteInfo(dir string) (*remoteInfo, error) {
+	bs, err := ioutil.ReadFile(filepath.Join(dir, remoteFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	ri := &remoteInfo{}
+	if err = json.Unmarshal(bs, ri); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal remote info, %w", err)
+	}
+	return ri, nil
+}
+
+// upload puts the files of a closed shard in the remote store, if it
+// hasn't already been uploaded.
+func (s *Shard) upload(rs *remoteStore) error {
+	s.remoteLock.Lock()
+	defer s.remoteLock.Unlock()
+
+	s.store = rs
+	if s.remote != nil {
+		return nil
+	}
+	ri, err := rs.upload(s)
+	if err != nil {
+		return err
+	}
+	if err = writeRemoteInfo(s.dataDir, ri); err != nil {
+		return err
+	}
+	s.remote = ri
+	s.lastUsed = ri.Uploaded
+	return nil
+}
+
+// fetch downloads the files of an evicted shard, so that it can be
+// searched. It does nothing for shards with local files.
+func (s *Shard) fetch() error {
+	s.remoteLock.Lock()
+	defer s.remoteLock.Unlock()
+
+	s.lastUsed = time.Now()
+	if !s.evicted || s.encrypted {
+		return nil
+	}
+	glog.V(2).Infof("Fetching evicted shard %v", s.id)
+	if err := s.store.download(s, s.remote); err != nil {
+		return fmt.Errorf("failed to fetch shard %s, %w", s.id, err)
+	}
+	remoteFetches.Inc()
+
+	s.filesLock.Lock()
+	s.files = nil
+	s.filesLock.Unlock()
+	s.evicted = false
+	return nil
+}
+
+// evict removes the local copy of the files of an uploaded shard that
+// hasn't been used since before t. It returns the number of bytes
+// freed.
+func (s *Shard) evict(t time.Time) int64 {
+	s.useLock.Lock()
+	defer s.useLock.Unlock()
+	s.remoteLock.Lock()
+	defer s.remoteLock.Unlock()
+
+	if s.remote == nil || s.evicted || s.lastUsed.After(t) {
+		return 0
+	}
+	glog.V(2).Infof("Evicting shard %v", s.id)
+
+	fis, err := ioutil.ReadDir(s.dataDir)
+	if err != nil {
+		glog.Errorf("failed to evict shard %s, %v", s.id, err)
+		return 0
+	}
+	var freed int64
+	for _, fi := range fis {
+		if !evictable(fi.Name()) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.dataDir, fi.Name())); err != nil {
+			glog.Errorf("failed to evict shard %s, %v", s.id, err)
+			continue
+		}
+		freed += fi.Size()
+	}
+
+	s.filesLock.Lock()
+	s.files = map[string]*ShardFile{}
+	s.filesLock.Unlock()
+	s.evicted = true
+	evictedShards.Inc()
+	return freed
+}
+
+// uploaded reports whether the shard has been uploaded to the remote
+// store.
+func (s *Shard) uploaded() bool {
+	s.remoteLock.Lock()
+	defer s.remoteLock.Unlock()
+	return s.remote != nil
+}
+
+// loadRemote restores the upload state of a shard found on disk. A
+// shard that was uploaded, but has no files, was evicted.
+func (s *Shard) loadRemote(rs *remoteStore) {
+	ri, err := readRemoteInfo(s.dataDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			glog.Errorf("failed to read remote info for shard %s, %v", s.id, err)
+		}
+		return
+	}
+	fns, _ := filepath.Glob(filepath.Join(s.dataDir, "*.pb.log"))
+
+	s.remoteLock.Lock()
+	defer s.remoteLock.Unlock()
+	s.store = rs
+	s.remote = ri
+	s.encrypted = s.encrypted || ri.Encrypted
+	s.lastUsed = time.Now()
+	s.evicted = len(fns) == 0 && !s.encrypted
+	if s.evicted {
+		s.files = map[string]*ShardFile{}
+	}
+}
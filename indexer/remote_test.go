@@ -0,0 +1,132 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/QubitProducts/logspray/proto/logspray"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/graymeta/stow"
+	"github.com/oklog/ulid"
+)
+
+func TestArchive_Remote(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+	stowDir, err := ioutil.TempDir("", "logspray-stow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stowDir)
+
+	openArchive := func() *shardArchive {
+		sa, err := NewArchive(
+			WithArchiveDataDir(dataDir),
+			WithArchiveStowConfig(stow.ConfigMap{"path": stowDir}),
+			WithArchiveStowLocation("local", "shards"),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sa
+	}
+	sa := openArchive()
+
+	start := time.Now().Truncate(time.Minute)
+	s, err := newShard(start, dataDir, "test", bloomConfig{}, compression{codec: codecSnappy, blockSize: defaultBlockSize}, timeIndexConfig{}, fsyncRotate, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamID := ulid.MustNew(ulid.Now(), rand.Reader).String()
+	var want []string
+	for i := 0; i < 10; i++ {
+		ts, _ := ptypes.TimestampProto(start.Add(time.Duration(i) * time.Second))
+		m := &logspray.Message{StreamID: streamID, Index: uint64(i + 1), Time: ts, Text: fmt.Sprintf("line %d", i)}
+		if err := s.writeMessage(context.Background(), m, map[string]string{"job": "test"}); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, m.Text)
+	}
+	s.close()
+	sa.Lock()
+	sa.history[s.shardStart] = []*Shard{s}
+	sa.historyOrder = append(sa.historyOrder, s.shardStart)
+	sa.Unlock()
+
+	search := func(sa *shardArchive) []string {
+		var got []string
+		err := sa.Search(context.Background(), func(m *logspray.Message) error {
+			if m.ControlMessage == logspray.Message_NONE {
+				got = append(got, m.Text)
+			}
+			return nil
+		}, nil, nil, start, start.Add(time.Minute), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	check := func(what string, got []string) {
+		t.Helper()
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("%s: got %v, want %v", what, got, want)
+		}
+	}
+
+	sa.upload([]*Shard{s})
+	if !s.uploaded() {
+		t.Fatalf("shard was not uploaded")
+	}
+	items, err := filepath.Glob(filepath.Join(stowDir, "shards", "*.tar"))
+	if err != nil || len(items) != 1 {
+		t.Fatalf("expected one uploaded item, got %v, %v", items, err)
+	}
+
+	sa.evict(time.Now().Add(-time.Hour))
+	if fns, _ := filepath.Glob(filepath.Join(s.dataDir, "*.pb.log")); len(fns) != 1 {
+		t.Fatalf("recently used shard was evicted")
+	}
+
+	sa.evict(time.Now())
+	if fns, _ := filepath.Glob(filepath.Join(s.dataDir, "*.pb.log")); len(fns) != 0 {
+		t.Fatalf("shard was not evicted, found %v", fns)
+	}
+	if _, err := os.Stat(filepath.Join(s.dataDir, manifestFileName)); err != nil {
+		t.Fatalf("manifest was evicted, %v", err)
+	}
+
+	check("evicted", search(sa))
+
+	// An evicted shard found on startup is fetched when searched.
+	sa.evict(time.Now())
+	check("reopened", search(openArchive()))
+
+	sa.pruneShards(sa.remove([]*Shard{s}), "retention")
+	if items, _ := filepath.Glob(filepath.Join(stowDir, "shards", "*")); len(items) != 0 {
+		t.Fatalf("remote copy was not pruned, found %v", items)
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// truncateTornTail checks the end of a shard file for a partially
+// written record, or block, left by a crash, and truncates the file
+// to remove it. true is returned if the file was truncated.
+func truncateTornTail(fn string) (bool, error) {
+	f, err := os.OpenFile(fn, os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	end, size, err := checkTail(f)
+	if err != nil {
+		return false, err
+	}
+	if end == size {
+		return false, nil
+	}
+	if err = f.Truncate(end); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// TornTail returns the number of bytes at the end of a shard file that
+// are part of a partially written record, or block.
+func TornTail(fn string) (int64, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	end, size, err := checkTail(f)
+	return size - end, err
+}
+
+// RepairShardFile rewrites a shard file, dropping any corrupt records,
+// or blocks of records, and any partial write at its end. The number
+// of corrupt records, or blocks, dropped is returned. Files can't be
+// repaired if their stream header is corrupt.
+func RepairShardFile(fn string) (int, error) {
+	sfi, err := OpenShardFileIterator(fn)
+	if err != nil {
+		return 0, err
+	}
+	defer sfi.Close()
+
+	dropped := 0
+	sfi.SkipCorrupt(func(error) { dropped++ })
+	comp := compression{codec: sfi.rr.codec, level: gzip.DefaultCompression, blockSize: defaultBlockSize}
+	tmp, _, err := rewriteShardFile(sfi, fn, comp)
+	if err != nil {
+		return dropped, err
+	}
+	if err = os.Rename(tmp, fn); err != nil {
+		os.Remove(tmp)
+		return dropped, err
+	}
+	return dropped, nil
+}
+
+// checkTail returns the end of the last intact record, or block, of a
+// shard file, and the size of the file.
+func checkTail(f *os.File) (int64, int64, error) {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, 0, err
+	}
+	sr := io.NewSectionReader(f, 0, size)
+	v, codec, err := readFileVersion(sr)
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err = readMessageFromFile(sr, v); err != nil {
+		return 0, 0, fmt.Errorf("failed to read file header, %w", err)
+	}
+	start, _ := sr.Seek(0, io.SeekCurrent)
+
+	end, err := validEnd(sr, v, codec, start)
+	return end, size, err
+}
+
+// validEnd returns the end of a file with any partially written
+// record, or block, at its end removed. If the last one in the file is
+// intact the file is assumed to be complete. Corrupt data followed by
+// an intact record, or block, within maxResync bytes is left for
+// readers to skip.
+func validEnd(sr *io.SectionReader, v fileVersion, codec blockCodec, start int64) (int64, error) {
+	size := sr.Size()
+	if codec != codecNone {
+		bs, _, err := readBlockIndexEntries(sr, v, start, size)
+		if err == nil && bs != nil {
+			return size, nil
+		}
+		if ok, err := lastBlockIntact(sr, v, codec, start); ok || err != nil {
+			return size, err
+		}
+
+		off := start
+		for {
+			_, end, err := walkBlocks(sr, v, off, size)
+			if err != nil && !isCorrupt(err) {
+				return 0, err
+			}
+			if end == size {
+				return size, nil
+			}
+			next, ok := findBlock(sr, v, end+1, size)
+			if !ok {
+				return end, nil
+			}
+			off = next
+		}
+	}
+
+	sr.Seek(0, io.SeekEnd)
+	_, err := readMessageFromFileEnd(sr, v, start)
+	if err == nil || err == io.EOF {
+		return size, nil
+	}
+	if !isCorrupt(err) {
+		return 0, err
+	}
+
+	off := start
+	for off < size {
+		if end, ok := recordEnd(sr, v, off); ok {
+			off = end
+			continue
+		}
+		next, ok := findRecord(sr, v, off+1)
+		if !ok {
+			return off, nil
+		}
+		off = next
+	}
+	return size, nil
+}
+
+// recordEnd returns the end of the record at off, if its framing is
+// intact. The record itself may still be corrupt.
+func recordEnd(sr *io.SectionReader, v fileVersion, off int64) (int64, bool) {
+	szLen := v.sizeLen()
+	szbs := make([]byte, szLen)
+	if _, err := sr.ReadAt(szbs, off); err != nil {
+		return 0, false
+	}
+	sz := v.decodeSize(szbs)
+	end := off + int64(v.frameLen()) + int64(sz)
+	if sz > maxMessageSize || end > sr.Size() {
+		return 0, false
+	}
+	if _, err := sr.ReadAt(szbs, end-int64(szLen)); err != nil || v.decodeSize(szbs) != sz {
+		return 0, false
+	}
+	return end, true
+}
+
+// findRecord looks for the start of an intact record at, or no more
+// than maxResync bytes past, from.
+func findRecord(sr *io.SectionReader, v fileVersion, from int64) (int64, bool) {
+	for o := from; o < sr.Size() && o <= from+maxResync; o++ {
+		sr.Seek(o, io.SeekStart)
+		if _, err := readMessageFromFile(sr, v); err == nil {
+			return o, true
+		}
+	}
+	return 0, false
+}
+
+// lastBlockIntact reports whether the file ends with a complete block.
+func lastBlockIntact(sr *io.SectionReader, v fileVersion, codec blockCodec, start int64) (bool, error) {
+	size := sr.Size()
+	if size == start {
+		return true, nil
+	}
+	if size-start < int64(v.frameLen()) {
+		return false, nil
+	}
+	szbs := make([]byte, 4)
+	if _, err := sr.ReadAt(szbs, size-4); err != nil {
+		return false, err
+	}
+	b := blockInfo{size: binary.LittleEndian.Uint32(szbs)}
+	b.offset = size - int64(v.frameLen()) - int64(b.size)
+	if b.size > maxBlockSize || b.offset < start {
+		return false, nil
+	}
+	_, err := readBlock(sr, v, codec, b)
+	if isCorrupt(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,108 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTruncateTornTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logspray-repair")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Now().Truncate(time.Hour)
+	for _, codec := range testCodecs {
+		for _, midFile := range []bool{false, true} {
+			name := codec.String()
+			if midFile {
+				name += "/mid-file"
+			}
+			t.Run(name, func(t *testing.T) {
+				ms := testMessages(start, 50)
+				sf := writeTestFile(t, dir, compression{codec: codec, level: gzip.DefaultCompression, blockSize: 4096}, ms)
+				_, offs, err := readTestFile(t, sf.fn, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// Cut the file part way through the last record, or
+				// block, as a crash would.
+				tail := offs[len(offs)-1]
+				if err := os.Truncate(sf.fn, tail+int64(fileV2.frameLen())+3); err != nil {
+					t.Fatal(err)
+				}
+				if midFile {
+					// Break the framing of the record, or block,
+					// holding message 20 too.
+					f, err := os.OpenFile(sf.fn, os.O_RDWR, 0)
+					if err != nil {
+						t.Fatal(err)
+					}
+					f.WriteAt([]byte{0xff, 0xff, 0xff, 0x7f}, offs[20])
+					f.Close()
+				}
+
+				truncated, err := truncateTornTail(sf.fn)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !truncated {
+					t.Fatalf("torn tail was not truncated")
+				}
+				fi, err := os.Stat(sf.fn)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fi.Size() != tail {
+					t.Fatalf("file truncated to %d bytes, expected %d", fi.Size(), tail)
+				}
+				if truncated, err = truncateTornTail(sf.fn); err != nil || truncated {
+					t.Fatalf("truncated an intact file, %v", err)
+				}
+
+				corrupt := 0
+				got, _, err := readTestFile(t, sf.fn, func(error) { corrupt++ })
+				if err != nil {
+					t.Fatal(err)
+				}
+				var lost int
+				for i, j := 0, 0; i < len(ms); i++ {
+					if j < len(got) && got[j] == ms[i].Text {
+						j++
+						continue
+					}
+					if offs[i] != tail && (!midFile || offs[i] != offs[20]) {
+						t.Fatalf("message %d was lost", i)
+					}
+					lost++
+				}
+				if len(got)+lost != len(ms) {
+					t.Fatalf("read %d messages, lost %d, of %d", len(got), lost, len(ms))
+				}
+				if midFile != (corrupt > 0) {
+					t.Fatalf("%d corrupt records, or blocks, reported", corrupt)
+				}
+			})
+		}
+	}
+}
@@ -30,6 +30,7 @@ import (
 	"github.com/QubitProducts/logspray/proto/logspray"
 	"github.com/QubitProducts/logspray/ql"
 	"github.com/golang/glog"
+	"github.com/golang/protobuf/ptypes"
 	"github.com/oklog/ulid"
 )
 
@@ -39,15 +40,44 @@ type Shard struct {
 	id         string
 	shardStart time.Time
 	dataDir    string
+	bloom      bloomConfig
+	comp       compression
+	tidx       timeIndexConfig
+	fsync      fsyncPolicy
+	synced     *highWaterMarks // the Indexes of streams synced to disk
 
 	filesLock sync.Mutex
 	files     map[string]*ShardFile
 
-	cacheLock  sync.Mutex
-	labelCache map[string]map[string]struct{}
+	// useLock is held for reading while the files of a shard are
+	// searched, and for writing while they are replaced or removed.
+	useLock sync.RWMutex
+
+	// The label cache is kept while the shard is active, and replaced
+	// by the shard's manifest when it is closed.
+	cacheLock    sync.Mutex
+	labelCache   *labelCounts
+	manifest     *shardManifest
+	manifestLock sync.Mutex // held while reading, or building, the manifest
+
+	// Once a shard is sealed no more files are added to it, and
+	// searches use its postings index.
+	postingsLock sync.Mutex
+	sealed       bool
+	postings     *postings
+
+	// Once a closed shard is uploaded to remote storage, its local
+	// files may be evicted, and fetched again when it is searched.
+	// Encrypted shards can't be searched.
+	remoteLock sync.Mutex
+	store      *remoteStore
+	remote     *remoteInfo
+	evicted    bool
+	encrypted  bool
+	lastUsed   time.Time
 }
 
-func newShard(startTime time.Time, baseDir, indexId string) (*Shard, error) {
+func newShard(startTime time.Time, baseDir, indexId string, bloom bloomConfig, comp compression, tidx timeIndexConfig, fsync fsyncPolicy, synced *highWaterMarks) (*Shard, error) {
 	t := time.Now()
 	entropy := rand.New(rand.NewSource(t.UnixNano()))
 	id := ulid.MustNew(ulid.Timestamp(t), entropy).String()
@@ -57,10 +87,15 @@ func newShard(startTime time.Time, baseDir, indexId string) (*Shard, error) {
 		indexId:    indexId,
 		id:         id,
 		shardStart: startTime,
+		bloom:      bloom,
+		comp:       comp,
+		tidx:       tidx,
+		fsync:      fsync,
+		synced:     synced,
 
 		files: map[string]*ShardFile{},
 
-		labelCache: map[string]map[string]struct{}{},
+		labelCache: newLabelCounts(),
 	}, nil
 }
 
@@ -85,31 +120,25 @@ func (s *Shard) writeMessage(ctx context.Context, m *logspray.Message, labels ma
 			fn:     pbfn,
 			id:     m.StreamID,
 			labels: labels,
+			bloom:  s.bloom.newFilter(),
+			comp:   s.comp,
+			tidx:   s.tidx,
+
+			syncWrites: s.fsync == fsyncWrite,
+		}
+		if s.synced != nil {
+			id := m.StreamID
+			pbf.onSync = func(index uint64) { s.synced.mark(id, index, s.shardStart) }
 		}
 		s.files[m.StreamID] = pbf
 	}
 
 	/* update labels cache */
-	if !ok {
-		s.cacheLock.Lock()
-		for k, v := range labels {
-			_, ok := s.labelCache[k]
-			if !ok {
-				s.labelCache[k] = map[string]struct{}{}
-			}
-			s.labelCache[k][v] = struct{}{}
-		}
-		s.cacheLock.Unlock()
-	}
-
 	s.cacheLock.Lock()
-	for k, v := range m.Labels {
-		_, ok := s.labelCache[k]
-		if !ok {
-			s.labelCache[k] = map[string]struct{}{}
-		}
-		s.labelCache[k][v] = struct{}{}
+	if !ok {
+		s.labelCache.addStream(m.StreamID, labels)
 	}
+	s.labelCache.add(m.StreamID, m)
 	s.cacheLock.Unlock()
 
 	s.filesLock.Unlock()
@@ -124,26 +153,243 @@ func (s *Shard) writeMessage(ctx context.Context, m *logspray.Message, labels ma
 }
 
 func (s *Shard) close() {
+	s.cacheLock.Lock()
+	m := s.newManifest(s.labelCache, time.Now())
+	s.manifest = m
+	s.labelCache = nil
+	s.cacheLock.Unlock()
+
+	s.filesLock.Lock()
+	var fs []*ShardFile
+	for _, f := range s.files {
+		f.Close()
+		fs = append(fs, f)
+	}
+	s.filesLock.Unlock()
+
+	p := buildPostings(fs)
+	if err := writePostings(s.dataDir, p); err != nil {
+		glog.Errorf("failed to write postings for shard %s, %v", s.id, err)
+	}
+	if err := writeManifest(s.dataDir, m); err != nil {
+		glog.Errorf("failed to write manifest for shard %s, %v", s.id, err)
+	}
+	if err := syncDir(s.dataDir); err != nil && !os.IsNotExist(err) {
+		glog.Errorf("failed to sync shard %s, %v", s.id, err)
+	}
+
+	s.postingsLock.Lock()
+	s.sealed = true
+	s.postings = p
+	s.postingsLock.Unlock()
+}
+
+// sync writes the records pending in the files of an active shard,
+// and syncs them to disk.
+func (s *Shard) sync() {
+	s.filesLock.Lock()
+	fs := make([]*ShardFile, 0, len(s.files))
+	for _, f := range s.files {
+		fs = append(fs, f)
+	}
+	s.filesLock.Unlock()
+
+	for _, f := range fs {
+		if err := f.sync(); err != nil {
+			glog.Errorf("failed to sync shard %s, %v", s.id, err)
+		}
+	}
+}
+
+// truncateTornTails removes partially written records from the ends
+// of the shard's files, left if the server stopped while writing them.
+func (s *Shard) truncateTornTails() {
+	for _, f := range s.findFiles(nil, time.Time{}, time.Time{}) {
+		truncated, err := truncateTornTail(f.fn)
+		if err != nil {
+			glog.Errorf("failed to check %s for a torn write, %v", f.fn, err)
+			continue
+		}
+		if truncated {
+			glog.Infof("truncated torn write from the end of %s", f.fn)
+			truncatedFiles.Inc()
+		}
+	}
+}
+
+// recompress rewrites the files of a closed shard with comp.
+func (s *Shard) recompress(comp compression) {
+	s.useLock.Lock()
+	defer s.useLock.Unlock()
+	for _, f := range s.findFiles(nil, time.Time{}, time.Time{}) {
+		if err := f.recompress(comp); err != nil {
+			glog.Errorf("failed to recompress %s, %v", f.fn, err)
+		}
+	}
+}
+
+// index returns the postings index for a sealed shard, covering
+// the files in fs. The index is read from disk, or rebuilt if it is
+// missing or out of date, the first time it is needed. nil is
+// returned for shards that are still active.
+func (s *Shard) index(fs []*ShardFile) *postings {
+	s.postingsLock.Lock()
+	defer s.postingsLock.Unlock()
+
+	if !s.sealed {
+		return nil
+	}
+	if s.postings != nil && s.postings.covers(fs) {
+		return s.postings
+	}
+
+	p, err := readPostings(s.dataDir)
+	if err == nil && p.covers(fs) {
+		s.postings = p
+		return p
+	}
+	if err != nil && !os.IsNotExist(err) {
+		glog.Errorf("rebuilding postings for shard %s, %v", s.id, err)
+	}
+
+	p = buildPostings(fs)
+	if err := writePostings(s.dataDir, p); err != nil {
+		glog.Errorf("failed to write postings for shard %s, %v", s.id, err)
+	}
+	s.postings = p
+	return p
+}
+
+// candidates returns the files in the shard that matcher can not
+// rule out by their header. For sealed shards the headers are taken
+// from the postings index, so the files needn't be opened.
+func (s *Shard) candidates(matcher ql.MatchFunc, from, to time.Time) []*ShardFile {
+	fs := s.findFiles(nil, from, to)
+	if matcher == nil {
+		return fs
+	}
+	p := s.index(fs)
+	if p == nil {
+		return fs
+	}
+
+	var res []*ShardFile
+	for _, f := range fs {
+		if hdr, ok := p.header(filepath.Base(f.fn)); ok && !matcher(hdr, nil, true) {
+			continue
+		}
+		res = append(res, f)
+	}
+	glog.V(3).Infof("postings for shard %s ruled out %d of %d files", s.id, len(fs)-len(res), len(fs))
+	return res
+}
+
+// labels returns the label summary for the shard. Active shards are
+// summarised from their label cache, closed shards from their
+// manifest.
+func (s *Shard) labels() *labelSummary {
+	s.cacheLock.Lock()
+	if s.labelCache != nil {
+		defer s.cacheLock.Unlock()
+		return s.labelCache.summary()
+	}
+	s.cacheLock.Unlock()
+
+	m := s.loadManifest()
+	if m.Labels == nil {
+		return &labelSummary{Counts: map[string]map[string]uint64{}}
+	}
+	return m.Labels
+}
+
+// loadManifest returns the manifest of a closed shard. It is read from
+// disk, or built from the shard's files if it is missing, the first
+// time it is needed.
+func (s *Shard) loadManifest() *shardManifest {
+	s.manifestLock.Lock()
+	defer s.manifestLock.Unlock()
+
+	s.cacheLock.Lock()
+	m := s.manifest
+	s.cacheLock.Unlock()
+	if m != nil {
+		return m
+	}
+
+	m, err := readManifest(s.dataDir)
+	switch {
+	case err == nil:
+	case s.isEncrypted():
+		m = &shardManifest{Start: s.shardStart}
+	default:
+		if !os.IsNotExist(err) {
+			glog.Errorf("rebuilding manifest for shard %s, %v", s.id, err)
+		}
+		if m, err = buildManifest(s); err != nil {
+			glog.Errorf("failed to build manifest for shard %s, %v", s.id, err)
+			return &shardManifest{Start: s.shardStart}
+		}
+		if err := writeManifest(s.dataDir, m); err != nil {
+			glog.Errorf("failed to write manifest for shard %s, %v", s.id, err)
+		}
+	}
+
+	s.cacheLock.Lock()
+	s.manifest = m
+	s.cacheLock.Unlock()
+	return m
+}
+
+// indexes returns the highest Index written to each stream of a closed
+// shard, if its manifest has been read.
+func (s *Shard) indexes() map[string]uint64 {
 	s.cacheLock.Lock()
 	defer s.cacheLock.Unlock()
+	if s.manifest == nil {
+		return nil
+	}
+	return s.manifest.Indexes
+}
 
-	s.labelCache = nil
-	for fs := range s.files {
-		s.files[fs].Close()
+// messages returns the number of messages in the shard, if it is
+// known without reading the shard's files.
+func (s *Shard) messages() uint64 {
+	s.cacheLock.Lock()
+	defer s.cacheLock.Unlock()
+	switch {
+	case s.labelCache != nil:
+		return s.labelCache.n
+	case s.manifest != nil:
+		return s.manifest.Messages
+	default:
+		return 0
 	}
 }
 
-// Labels retursn the list of labels in the label cache
+// timeRange returns the times of the first and last messages in the
+// shard, which are zero if they aren't known.
+func (s *Shard) timeRange() (time.Time, time.Time) {
+	s.cacheLock.Lock()
+	if s.labelCache != nil {
+		defer s.cacheLock.Unlock()
+		return s.labelCache.first, s.labelCache.last
+	}
+	s.cacheLock.Unlock()
+
+	ls := s.labels()
+	return ls.From, ls.To
+}
+
+// Labels returns the label names used in the shard.
 func (s *Shard) Labels() []string {
 	res := []string{}
 	if s == nil {
 		return res
 	}
-	s.cacheLock.Lock()
-	for k := range s.labelCache {
+	for k := range s.labels().Counts {
 		res = append(res, k)
 	}
-	s.cacheLock.Unlock()
+	sort.Strings(res)
 	return res
 }
 
@@ -153,12 +399,10 @@ func (s *Shard) LabelValues(name string) []string {
 	if s == nil {
 		return res
 	}
-
-	s.cacheLock.Lock()
-	for v := range s.labelCache[name] {
+	for v := range s.labels().Counts[name] {
 		res = append(res, v)
 	}
-	s.cacheLock.Unlock()
+	sort.Strings(res)
 	return res
 }
 
@@ -217,27 +461,51 @@ func (so ShardFileByStartTime) Less(i, j int) bool { return so[i].fn < so[j].fn
 
 // Search this shard for queries between the provided time frames
 // for message matched by the provided match function.
-func (s *Shard) Search(ctx context.Context, msgFunc logspray.MessageFunc, matcher ql.MatchFunc, from, to time.Time, reverse bool) error {
+func (s *Shard) Search(ctx context.Context, msgFunc logspray.MessageFunc, matcher ql.MatchFunc, tokens ql.TokenFilter, from, to time.Time, reverse bool) error {
 	if s == nil {
 		return nil
 	}
+	return mergeSearch(ctx, []*Shard{s}, msgFunc, matcher, tokens, from, to, reverse, nil, nil)
+}
+
+// explain lists the files that a search of this shard would
+// read, and whether matcher rules each one out by its header.
+func (s *Shard) explain(matcher ql.MatchFunc, from, to time.Time, active bool) (*logspray.ExplainShard, error) {
+	if err := s.fetch(); err != nil {
+		return nil, err
+	}
+	es := &logspray.ExplainShard{
+		Id:     s.id,
+		Active: active,
+	}
+	es.Start, _ = ptypes.TimestampProto(s.shardStart)
 
-	fs := s.findFiles(msgFunc, from, to)
+	fs := s.findFiles(nil, from, to)
+	p := s.index(fs)
 	for _, f := range fs {
-		if err := f.Search(ctx, msgFunc, matcher, from, to, reverse); err != nil {
-			return err
+		var hdr *logspray.Message
+		var ok bool
+		if p != nil {
+			hdr, ok = p.header(filepath.Base(f.fn))
+		}
+		if !ok {
+			var err error
+			if hdr, err = f.Header(); err != nil {
+				return nil, fmt.Errorf("failed to read header for %s, %w", f.fn, err)
+			}
 		}
+		es.Files = append(es.Files, &logspray.ExplainFile{
+			StreamId: hdr.StreamID,
+			Name:     f.fn,
+			Labels:   hdr.Labels,
+			Pruned:   matcher != nil && !matcher(hdr, nil, true),
+		})
 	}
-	return nil
+	return es, nil
 }
 
 type shardSet []*Shard
 
-func (ss shardSet) Search(ctx context.Context, msgFunc logspray.MessageFunc, matcher ql.MatchFunc, from, to time.Time, count, offset uint64, reverse bool) error {
-	for _, s := range ss {
-		if err := s.Search(ctx, msgFunc, matcher, from, to, reverse); err != nil {
-			return err
-		}
-	}
-	return nil
+func (ss shardSet) Search(ctx context.Context, msgFunc logspray.MessageFunc, matcher ql.MatchFunc, tokens ql.TokenFilter, from, to time.Time, reverse bool) error {
+	return mergeSearch(ctx, ss, msgFunc, matcher, tokens, from, to, reverse, nil, nil)
 }
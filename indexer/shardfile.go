@@ -21,6 +21,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
@@ -38,6 +39,7 @@ type readWriteAtCloser interface {
 	io.WriterAt
 	io.ReaderAt
 	io.Closer
+	Sync() error
 }
 
 // ShardFile represents an individual stream of data in a shard.
@@ -50,6 +52,107 @@ type ShardFile struct {
 	headersSent bool
 	labels      map[string]string
 	offset      int64 // This is the current end of the file
+	comp        compression
+	tidx        timeIndexConfig
+	writer      *blockWriter
+	syncWrites  bool // sync the file after every message
+
+	// index is the highest Index written to the file, onSync is
+	// called with it each time the file is synced.
+	index  uint64
+	onSync func(index uint64)
+
+	// bloom holds the tokens written to the file, it is saved
+	// alongside the file when it is closed.
+	bloom       *bloomFilter
+	bloomLoaded bool
+
+	// marks is the time index of an archived file without blocks,
+	// loaded from disk when first searched.
+	marks       []timeMark
+	marksLoaded bool
+}
+
+// fileVersion identifies the framing of the records in a shard file.
+type fileVersion int
+
+const (
+	// fileV1 files have no file header, records are framed by a
+	// uint16 size before and after the protobuf.
+	fileV1 fileVersion = 1
+	// fileV2 files start with fileMagicV2 and a byte giving the
+	// blockCodec. Records are framed by a uint32 size and a CRC
+	// before the protobuf, and the size again after it. After the
+	// header record, records are stored in compressed blocks, framed
+	// in the same way, if the file has a codec.
+	fileV2 fileVersion = 2
+)
+
+// fileMagicV2 starts every v2 shard file. A v1 file can't start with
+// it, as 'v' is not a valid start for a protobuf.
+const fileMagicV2 = "LSv2"
+
+// maxMessageSize limits the size of records, corrupt size fields
+// shouldn't lead to huge allocations.
+const maxMessageSize = 64 << 20
+
+// crcTable is used for the record and block checksums.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// sizeLen returns the length of the size fields around each record.
+func (v fileVersion) sizeLen() int {
+	if v == fileV1 {
+		return 2
+	}
+	return 4
+}
+
+// crcLen returns the length of the checksum of each record.
+func (v fileVersion) crcLen() int {
+	if v == fileV1 {
+		return 0
+	}
+	return 4
+}
+
+// frameLen returns the length of the framing around each record.
+func (v fileVersion) frameLen() int {
+	return 2*v.sizeLen() + v.crcLen()
+}
+
+func (v fileVersion) decodeSize(bs []byte) uint32 {
+	if v == fileV1 {
+		return uint32(binary.LittleEndian.Uint16(bs))
+	}
+	return binary.LittleEndian.Uint32(bs)
+}
+
+// appendFrame appends bs to buf, framed for a v2 file.
+func appendFrame(buf *bytes.Buffer, bs []byte) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(bs)))
+	binary.Write(buf, binary.LittleEndian, crc32.Checksum(bs, crcTable))
+	buf.Write(bs)
+	binary.Write(buf, binary.LittleEndian, uint32(len(bs)))
+}
+
+// readFileVersion determines the version of a shard file, and the
+// codec used for its blocks, and leaves r positioned at the file header.
+func readFileVersion(r *io.SectionReader) (fileVersion, blockCodec, error) {
+	magic := make([]byte, len(fileMagicV2)+1)
+	n, err := r.ReadAt(magic, 0)
+	if n == len(magic) && string(magic[:len(fileMagicV2)]) == fileMagicV2 {
+		codec := blockCodec(magic[len(fileMagicV2)])
+		if codec > codecGzip {
+			return 0, 0, fmt.Errorf("unknown block codec %v", codec)
+		}
+		_, err = r.Seek(int64(len(magic)), io.SeekStart)
+		return fileV2, codec, err
+	}
+	if err != nil && err != io.EOF {
+		return 0, 0, fmt.Errorf("failed to read file version, %w", err)
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	return fileV1, codecNone, err
 }
 
 // ShardFileIterator retries messages from a shar file
@@ -57,7 +160,7 @@ type ShardFileIterator struct {
 	file    *os.File
 	hdr     *logspray.Message
 	sentHdr bool
-	sr      *io.SectionReader
+	rr      *recordReader
 	err     error
 	eof     int64
 	off     int64
@@ -82,8 +185,8 @@ func (sfi *ShardFileIterator) Next() bool {
 		return true
 	}
 
-	sfi.off, _ = sfi.sr.Seek(0, io.SeekCurrent)
-	sfi.next, sfi.err = readMessageFromFile(sfi.sr)
+	sfi.next, sfi.err = sfi.rr.read()
+	sfi.off = sfi.rr.off
 	if sfi.err != nil {
 		return false
 	}
@@ -91,7 +194,8 @@ func (sfi *ShardFileIterator) Next() bool {
 	return true
 }
 
-// Offset returns the current offset from the start of the file
+// Offset returns the current offset from the start of the file. For
+// compressed files this is the offset of the block holding the message.
 func (sfi *ShardFileIterator) Offset() int64 {
 	return sfi.off
 }
@@ -104,6 +208,14 @@ func (sfi *ShardFileIterator) Err() error {
 	return sfi.err
 }
 
+// SkipCorrupt makes the iterator skip corrupt records, calling f with
+// the reason for each, rather than stopping at the first. It must be
+// called before Next.
+func (sfi *ShardFileIterator) SkipCorrupt(f func(err error)) {
+	sfi.rr.skipCorrupt = true
+	sfi.rr.onCorrupt = f
+}
+
 // Close
 func (sfi *ShardFileIterator) Close() error {
 	return sfi.file.Close()
@@ -123,23 +235,26 @@ func OpenShardFileIterator(fn string) (*ShardFileIterator, error) {
 
 	eof, err := file.Seek(0, io.SeekEnd)
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
-	_, err = file.Seek(0, io.SeekStart)
+
+	sr := io.NewSectionReader(file, 0, eof)
+	v, codec, err := readFileVersion(sr)
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
 
-	sr := io.NewSectionReader(file, 0, eof)
-
-	hdr, err := readMessageFromFile(sr)
+	hdr, err := readMessageFromFile(sr, v)
 	if err != nil {
+		file.Close()
 		return nil, fmt.Errorf("failed to read file header %s, %w", fn, err)
 	}
 
 	return &ShardFileIterator{
 		file: file,
-		sr:   sr,
+		rr:   newRecordReader(fn, sr, v, codec, nil, nil, nil, time.Time{}, time.Time{}, false, false),
 		hdr:  hdr,
 		eof:  eof,
 	}, nil
@@ -148,12 +263,92 @@ func OpenShardFileIterator(fn string) (*ShardFileIterator, error) {
 // Search searches the shard file for messages in the provided time range,
 // matched by matcher, and passes them to msgFunc. If the reverse is true the
 // file will be searched in reverse order
-func (s *ShardFile) Search(ctx context.Context, msgFunc logspray.MessageFunc, matcher ql.MatchFunc, from, to time.Time, reverse bool) error {
+func (s *ShardFile) Search(ctx context.Context, msgFunc logspray.MessageFunc, matcher ql.MatchFunc, tokens ql.TokenFilter, from, to time.Time, reverse bool) error {
+	fs, err := s.newSearch(matcher, tokens, from, to, reverse)
+	if err != nil || fs == nil {
+		return err
+	}
+	defer fs.close()
+
+	if err = msgFunc(fs.hdr); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			err := fs.advance()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err = msgFunc(fs.next); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// fileSearch reads the messages of a shard file that match a search,
+// one at a time, in the order they were written, or the reverse.
+type fileSearch struct {
+	fn       string
+	hdr      *logspray.Message
+	file     *os.File // only set for archived files
+	rr       *recordReader
+	codec    blockCodec
+	matcher  ql.MatchFunc
+	from, to time.Time
+
+	// next is the last message found by advance, and pos its
+	// position.
+	next *logspray.Message
+	pos  Cursor
+
+	// reopen starts the search again, so that it can be resumed
+	// after being suspended.
+	reopen func() (*fileSearch, error)
+}
+
+// newSearch starts a search of the file. nil is returned if the file
+// is ruled out by its bloom filter or header, or its header is corrupt.
+func (s *ShardFile) newSearch(matcher ql.MatchFunc, tokens ql.TokenFilter, from, to time.Time, reverse bool) (*fileSearch, error) {
+	if tokens != nil {
+		if bf := s.bloomFilter(); bf != nil && !tokens(bf.has) {
+			glog.V(3).Infof("Skipping shard file %v, ruled out by bloom filter", s.fn)
+			return nil, nil
+		}
+	}
+
+	fs := &fileSearch{
+		fn:      s.fn,
+		matcher: matcher,
+		from:    from,
+		to:      to,
+	}
 	var sr *io.SectionReader
+	var blocks []blockInfo
+	var pending []byte
+	var marks []timeMark
 	if s.file != nil { // this is an active shard file
 		s.RLock()
 		glog.V(3).Infof("Searching active shard file %v from %v to %v", s.fn, from, to)
 		sr = io.NewSectionReader(s.file, 0, s.offset)
+		if s.writer != nil && s.writer.comp.codec != codecNone {
+			// Blocks are only ever appended, so we can share the
+			// slice, but the pending records are reused.
+			blocks = s.writer.blocks[:len(s.writer.blocks):len(s.writer.blocks)]
+			if blocks == nil {
+				blocks = []blockInfo{}
+			}
+			pending = s.writer.pendingRecords()
+		}
+		if s.writer != nil {
+			marks = s.writer.tidx.snapshot()
+		}
 		s.RUnlock()
 	} else { // this is an archived shard file
 		s.Lock()
@@ -161,80 +356,204 @@ func (s *ShardFile) Search(ctx context.Context, msgFunc logspray.MessageFunc, ma
 		file, err := os.Open(s.fn)
 		if err != nil {
 			s.Unlock()
-			return err
+			return nil, err
 		}
-		defer file.Close()
 		if s.offset == 0 {
 			s.offset, err = file.Seek(0, io.SeekEnd)
 			if err != nil {
 				s.Unlock()
-				return err
-			}
-			_, err = file.Seek(0, io.SeekStart)
-			if err != nil {
-				s.Unlock()
-				return err
+				file.Close()
+				return nil, err
 			}
 		}
 		sr = io.NewSectionReader(file, 0, s.offset)
+		fs.file = file
+		marks = s.timeMarks()
 		s.Unlock()
 	}
 
-	hdr, err := readMessageFromFile(sr)
+	v, codec, err := readFileVersion(sr)
 	if err != nil {
-		return fmt.Errorf("failed to read file header %s, %w", s.fn, err)
+		fs.close()
+		return nil, fmt.Errorf("failed to read %s, %w", s.fn, err)
+	}
+	fs.hdr, err = readMessageFromFile(sr, v)
+	if isCorrupt(err) || err == io.EOF {
+		glog.Errorf("skipping %s, failed to read file header, %v", s.fn, err)
+		corruptRecords.Inc()
+		fs.close()
+		return nil, nil
 	}
-	err = msgFunc(hdr)
 	if err != nil {
-		return err
+		fs.close()
+		return nil, fmt.Errorf("failed to read file header %s, %w", s.fn, err)
 	}
-	if matcher != nil {
-		if !matcher(hdr, nil, true) {
-			return nil
+	if matcher != nil && !matcher(fs.hdr, nil, true) {
+		fs.close()
+		return nil, nil
+	}
+	fs.codec = codec
+	fs.pos = Cursor{Stream: fs.hdr.StreamID, codec: codec}
+
+	fs.rr = newRecordReader(s.fn, sr, v, codec, blocks, pending, marks, from, to, reverse, true)
+	return fs, nil
+}
+
+// advance moves on to the next matching message. io.EOF is returned
+// once there are no more.
+func (fs *fileSearch) advance() error {
+	if fs.suspended() {
+		return fs.resume()
+	}
+	for {
+		nmsg, err := fs.rr.read()
+		if err == io.EOF {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read message from %s, %w", fs.fn, err)
+		}
+
+		if nmsg.Time == nil {
+			continue
 		}
+		t, _ := ptypes.Timestamp(nmsg.Time)
+		if t.Before(fs.from) || t.After(fs.to) {
+			continue
+		}
+		if fs.matcher != nil {
+			if !fs.matcher(fs.hdr, nmsg, false) {
+				continue
+			}
+		}
+		fs.next = nmsg
+		fs.pos.Time, fs.pos.Index, fs.pos.Offset = t, nmsg.Index, fs.rr.off
+		return nil
 	}
-	if reverse {
-		sr.Seek(0, io.SeekEnd)
+}
+
+func (fs *fileSearch) close() {
+	if fs.file != nil {
+		fs.file.Close()
 	}
+}
+
+// suspended reports whether the search has been suspended.
+func (fs *fileSearch) suspended() bool {
+	return fs.rr == nil
+}
+
+// suspend closes the file of a search of an archived file, keeping
+// its next message. The search is reopened when it is next advanced.
+func (fs *fileSearch) suspend() {
+	fs.close()
+	fs.file = nil
+	fs.rr = nil
+}
 
+// resume reopens a suspended search, and moves on to the message
+// following the one it was suspended at.
+func (fs *fileSearch) resume() error {
+	nfs, err := fs.reopen()
+	if os.IsNotExist(err) {
+		glog.Errorf("shard file %s removed during search", fs.fn)
+		return io.EOF
+	}
+	if err != nil {
+		return err
+	}
+	if nfs == nil {
+		return io.EOF
+	}
+	nfs.pos.Shard = fs.pos.Shard
+	nfs.rr.resumeAt(fs.pos.Offset)
 	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			var nmsg *logspray.Message
-			var err error
-			if !reverse {
-				nmsg, err = readMessageFromFile(sr)
-			} else {
-				nmsg, err = readMessageFromFileEnd(sr)
-			}
+		if err := nfs.advance(); err != nil {
+			nfs.close()
+			return err
+		}
+		if nfs.pos.after(&fs.pos, nfs.rr.reverse) {
+			break
+		}
+	}
+	nfs.reopen = fs.reopen
+	*fs = *nfs
+	return nil
+}
 
-			if err == io.EOF {
-				return nil
-			}
-			if err != nil {
-				return fmt.Errorf("failed to read message from %s, %w", s.fn, err)
-			}
+// Header returns the stream header for the file.
+func (s *ShardFile) Header() (*logspray.Message, error) {
+	s.RLock()
+	labels := s.labels
+	s.RUnlock()
+	if labels != nil {
+		return &logspray.Message{
+			ControlMessage: logspray.Message_SETHEADER,
+			StreamID:       s.id,
+			Labels:         labels,
+		}, nil
+	}
 
-			if nmsg.Time == nil {
-				continue
-			}
-			t, _ := ptypes.Timestamp(nmsg.Time)
-			if t.Before(from) || t.After(to) {
-				continue
-			}
-			if matcher != nil {
-				if !matcher(hdr, nmsg, false) {
-					continue
-				}
+	sfi, err := OpenShardFileIterator(s.fn)
+	if err != nil {
+		return nil, err
+	}
+	defer sfi.Close()
+
+	return sfi.Header(), nil
+}
+
+// bloomFilter returns the bloom filter for an archived file, loading
+// it from disk if needed. nil is returned for active files, or if the
+// file has no bloom filter.
+func (s *ShardFile) bloomFilter() *bloomFilter {
+	s.Lock()
+	defer s.Unlock()
+	if s.file != nil {
+		return nil
+	}
+	if s.bloom == nil && !s.bloomLoaded {
+		s.bloomLoaded = true
+		bf, err := readBloomFilter(bloomFileName(s.fn))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				glog.Errorf("failed to load bloom filter for %s, %v", s.fn, err)
 			}
-			err = msgFunc(nmsg)
-			if err != nil {
-				return err
+			return nil
+		}
+		s.bloom = bf
+	}
+	return s.bloom
+}
+
+// timeMarks returns the time index of an archived file, loading it
+// from disk if needed. It must be called with the file locked, once
+// the size of the file is known. nil is returned if the file has no
+// time index.
+func (s *ShardFile) timeMarks() []timeMark {
+	if !s.marksLoaded {
+		s.marksLoaded = true
+		marks, err := readTimeIndex(timeIndexFileName(s.fn), s.offset)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				glog.Errorf("failed to load time index for %s, %v", s.fn, err)
 			}
+			return nil
 		}
+		s.marks = marks
 	}
+	return s.marks
+}
+
+// addTokens adds the tokens of a message to the file's bloom filter.
+func (s *ShardFile) addTokens(m *logspray.Message) {
+	if s.bloom == nil {
+		return
+	}
+	for k, v := range m.Labels {
+		s.bloom.add(ql.LabelToken(k, v))
+	}
+	ql.TextTokens(m.Text, s.bloom.add)
 }
 
 func (s *ShardFile) writeMessageToFile(ctx context.Context, m *logspray.Message) error {
@@ -244,7 +563,9 @@ func (s *ShardFile) writeMessageToFile(ctx context.Context, m *logspray.Message)
 
 	if s.file == nil {
 		dir := filepath.Dir(s.fn)
-		err := os.MkdirAll(dir, 0777)
+		if err = os.MkdirAll(dir, 0777); err != nil {
+			return err
+		}
 		newWriter, err := os.Create(s.fn)
 		if err != nil {
 			return err
@@ -255,23 +576,68 @@ func (s *ShardFile) writeMessageToFile(ctx context.Context, m *logspray.Message)
 			Time:           m.Time,
 			Labels:         s.labels,
 		}
-		sz, err := writePBMessageToFile(newWriter, 0, hm)
+		bw, err := newBlockWriter(newWriter, s.comp, s.tidx, hm)
 		if err != nil {
-			return fmt.Errorf("failed writing file header, %w", err)
+			newWriter.Close()
+			return err
 		}
 		s.file = newWriter
-		s.offset = int64(sz)
+		s.writer = bw
+		s.offset = bw.offset
+		s.addTokens(hm)
+		if s.syncWrites {
+			if err := syncDir(dir); err != nil {
+				return fmt.Errorf("failed to sync %s, %w", dir, err)
+			}
+		}
 	}
 
-	sz, err := writePBMessageToFile(s.file, s.offset, m)
-	if err != nil {
+	if err = s.writer.write(m); err != nil {
 		return fmt.Errorf("failed writing to %s, %w", s.fn, err)
 	}
-	s.offset += int64(sz)
+	s.offset = s.writer.offset
+	s.addTokens(m)
+	if m.Index > s.index {
+		s.index = m.Index
+	}
 
+	if s.syncWrites {
+		err = s.syncFile()
+	}
 	return err
 }
 
+// sync writes any records pending in a block, and syncs the file to
+// disk.
+func (s *ShardFile) sync() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.syncFile()
+}
+
+func (s *ShardFile) syncFile() error {
+	if s.file == nil {
+		return nil
+	}
+	if err := s.writer.flush(); err != nil {
+		return fmt.Errorf("failed writing to %s, %w", s.fn, err)
+	}
+	s.offset = s.writer.offset
+	if err := s.file.Sync(); err != nil {
+		fsyncErrors.Inc()
+		return fmt.Errorf("failed to sync %s, %w", s.fn, err)
+	}
+	s.synced()
+	return nil
+}
+
+func (s *ShardFile) synced() {
+	if s.onSync != nil && s.index != 0 {
+		s.onSync(s.index)
+	}
+}
+
+// writePBMessageToFile writes a record to a v2 shard file.
 func writePBMessageToFile(w io.WriterAt, offset int64, msg *logspray.Message) (uint32, error) {
 	if w == nil {
 		return 0, nil
@@ -281,16 +647,12 @@ func writePBMessageToFile(w io.WriterAt, offset int64, msg *logspray.Message) (u
 		return 0, fmt.Errorf("marshal protobuf failed, %w", err)
 	}
 
-	// We use a uin16 for the size field, we'll drop anything that's
-	// too big here.
-	if len(bs) > 65535 {
-		return 0, fmt.Errorf("marshaled protobuf is %d bytes too large", len(bs)-65535)
+	if len(bs) > maxMessageSize {
+		return 0, fmt.Errorf("marshaled protobuf is %d bytes too large", len(bs)-maxMessageSize)
 	}
 
-	buf := bytes.NewBuffer([]byte{})
-	binary.Write(buf, binary.LittleEndian, uint16(len(bs)))
-	buf.Write(bs)
-	binary.Write(buf, binary.LittleEndian, uint16(len(bs)))
+	buf := bytes.NewBuffer(make([]byte, 0, len(bs)+fileV2.frameLen()))
+	appendFrame(buf, bs)
 
 	n, err := w.WriteAt(buf.Bytes(), offset)
 	if n != len(buf.Bytes()) || err != nil {
@@ -300,9 +662,36 @@ func writePBMessageToFile(w io.WriterAt, offset int64, msg *logspray.Message) (u
 	return uint32(n), nil
 }
 
-func readMessageFromFile(r *io.SectionReader) (*logspray.Message, error) {
-	szbs := make([]byte, 2)
-	szbuf := bytes.NewBuffer(szbs)
+// corruptError is returned for records, or blocks, that fail their
+// checks.
+type corruptError struct {
+	err error
+}
+
+func (err corruptError) Error() string {
+	return err.err.Error()
+}
+
+func (err corruptError) Unwrap() error {
+	return err.err
+}
+
+func corruptf(format string, args ...interface{}) error {
+	return corruptError{err: fmt.Errorf(format, args...)}
+}
+
+// isCorrupt reports whether err is due to corrupt data, rather than
+// failing to read it.
+func isCorrupt(err error) bool {
+	var ce corruptError
+	return errors.As(err, &ce)
+}
+
+// readMessageFromFile reads the record at the current position of r.
+func readMessageFromFile(r *io.SectionReader, v fileVersion) (*logspray.Message, error) {
+	pos, _ := r.Seek(0, io.SeekCurrent)
+	szLen := v.sizeLen()
+	szbs := make([]byte, szLen+v.crcLen())
 	n, err := r.Read(szbs)
 	if err == io.EOF {
 		return nil, err
@@ -311,62 +700,64 @@ func readMessageFromFile(r *io.SectionReader) (*logspray.Message, error) {
 		return nil, fmt.Errorf("failed read message size header, %w", err)
 	}
 	if n != len(szbs) {
-		return nil, fmt.Errorf("short read for message size header")
+		return nil, corruptf("short read for message size header")
 	}
 
-	szbytes := uint16(0)
-	err = binary.Read(szbuf, binary.LittleEndian, &szbytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode message size, %w", err)
+	szbytes := v.decodeSize(szbs)
+	if szbytes > maxMessageSize {
+		return nil, corruptf("message size %d is too large", szbytes)
+	}
+	if pos+int64(v.frameLen())+int64(szbytes) > r.Size() {
+		return nil, corruptf("short read for message")
 	}
 
 	// We read the protobuf, and the trailing size bytes.
-	pbbs := make([]byte, szbytes+2)
-	n, err = r.Read(pbbs)
-	if err == io.EOF {
-		return nil, err
+	pbbs := make([]byte, int(szbytes)+szLen)
+	_, err = io.ReadFull(r, pbbs)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, corruptf("short read for message")
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed read message, %w", err)
 	}
-	if n != len(pbbs) {
-		return nil, fmt.Errorf("short read for message")
-	}
 
-	trailSzbytes := uint16(0)
-	szbuf = bytes.NewBuffer(pbbs[len(pbbs)-2:])
-	err = binary.Read(szbuf, binary.LittleEndian, &trailSzbytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed reading trail size, %w", err)
+	trailSzbytes := v.decodeSize(pbbs[len(pbbs)-szLen:])
+	if szbytes != trailSzbytes {
+		return nil, corruptf("header and trail size mismatch")
 	}
+	pbbs = pbbs[:len(pbbs)-szLen]
 
-	if szbytes != trailSzbytes {
-		return nil, fmt.Errorf("header and trail size mismatch, %w", err)
+	if v.crcLen() != 0 && binary.LittleEndian.Uint32(szbs[szLen:]) != crc32.Checksum(pbbs, crcTable) {
+		return nil, corruptf("message checksum mismatch")
 	}
 
 	msg := logspray.Message{}
-	err = proto.Unmarshal(pbbs[:len(pbbs)-2], &msg)
+	err = proto.Unmarshal(pbbs, &msg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to umarshal log message proto, %w", err)
+		return nil, corruptf("failed to umarshal log message proto, %w", err)
 	}
 
 	return &msg, nil
 }
 
 // readMessageFromFileEnd tries to read a message before the current position
-// of the io.ReadSeeker
-func readMessageFromFileEnd(r *io.SectionReader) (*logspray.Message, error) {
-	if off, _ := r.Seek(0, io.SeekCurrent); off == 0 {
+// of the io.ReadSeeker. io.EOF is returned when start is reached.
+func readMessageFromFileEnd(r *io.SectionReader, v fileVersion, start int64) (*logspray.Message, error) {
+	end, _ := r.Seek(0, io.SeekCurrent)
+	if end <= start {
 		return nil, io.EOF
 	}
 
-	_, err := r.Seek(-2, io.SeekCurrent)
+	szLen := int64(v.sizeLen())
+	if end-start < int64(v.frameLen()) {
+		return nil, corruptf("short read for message size trailer")
+	}
+	_, err := r.Seek(-szLen, io.SeekCurrent)
 	if err != nil {
 		return nil, fmt.Errorf("could not seek back to message trailer, %w", err)
 	}
 
-	szbs := make([]byte, 2)
-	szbuf := bytes.NewBuffer(szbs)
+	szbs := make([]byte, szLen)
 	n, err := r.Read(szbs)
 	if err == io.EOF {
 		return nil, err
@@ -375,36 +766,138 @@ func readMessageFromFileEnd(r *io.SectionReader) (*logspray.Message, error) {
 		return nil, fmt.Errorf("failed read message size trailer, %w", err)
 	}
 	if n != len(szbs) {
-		return nil, errors.New("short read for message size trailer")
+		return nil, corruptf("short read for message size trailer")
 	}
 
-	szbytes := uint16(0)
-	err = binary.Read(szbuf, binary.LittleEndian, &szbytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode message size trailer, %w", err)
+	szbytes := v.decodeSize(szbs)
+	if szbytes > maxMessageSize {
+		return nil, corruptf("message size %d is too large", szbytes)
 	}
 
-	start, err := r.Seek(-1*int64(4+szbytes), io.SeekCurrent)
-	if err != nil {
+	pos := end - int64(v.frameLen()) - int64(szbytes)
+	if pos < start {
+		return nil, corruptf("message overlaps the file header")
+	}
+	if _, err = r.Seek(pos, io.SeekStart); err != nil {
 		return nil, fmt.Errorf("could not seek back to message header, %w", err)
 	}
 
-	msg, err := readMessageFromFile(r)
+	msg, err := readMessageFromFile(r, v)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read message, %w", err)
+		return nil, err
 	}
 
-	_, err = r.Seek(start, io.SeekStart)
+	if _, err = r.Seek(pos, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("could not seek back to message header, %w", err)
+	}
 
 	return msg, nil
 }
 
+// recompress rewrites an archived file with comp, leaving files
+// already compressed with comp's codec alone.
+func (s *ShardFile) recompress(comp compression) error {
+	s.RLock()
+	active := s.file != nil
+	s.RUnlock()
+	if active {
+		return nil
+	}
+
+	sfi, err := OpenShardFileIterator(s.fn)
+	if err != nil {
+		return err
+	}
+	defer sfi.Close()
+	if sfi.rr.version == fileV2 && sfi.rr.codec == comp.codec {
+		return nil
+	}
+
+	tmp, size, err := rewriteShardFile(sfi, s.fn, comp)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if err = os.Rename(tmp, s.fn); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	s.offset = size
+	s.marks, s.marksLoaded = nil, true
+	os.Remove(timeIndexFileName(s.fn))
+	return nil
+}
+
+// rewriteShardFile writes the messages read by sfi, from the file fn,
+// to a new file using comp. The name and size of the new file are
+// returned, it is left to the caller to move it into place.
+func rewriteShardFile(sfi *ShardFileIterator, fn string, comp compression) (string, int64, error) {
+	tmp := fn + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", 0, err
+	}
+	fail := func(err error) (string, int64, error) {
+		f.Close()
+		os.Remove(tmp)
+		return "", 0, err
+	}
+
+	bw, err := newBlockWriter(f, comp, timeIndexConfig{}, sfi.Header())
+	if err != nil {
+		return fail(err)
+	}
+	sfi.Next() // the header
+	for sfi.Next() {
+		if err = bw.write(sfi.Message()); err != nil {
+			return fail(err)
+		}
+	}
+	if err = sfi.Err(); err != nil {
+		return fail(fmt.Errorf("failed reading %s, %w", fn, err))
+	}
+	if err = bw.finish(); err != nil {
+		return fail(err)
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(tmp)
+		return "", 0, err
+	}
+	return tmp, bw.offset, nil
+}
+
 // Close the backing files for a shard
 func (s *ShardFile) Close() error {
+	s.Lock()
+	defer s.Unlock()
 	file := s.file
 	s.file = nil
-	if file != nil {
-		return file.Close()
+	if file == nil {
+		return nil
 	}
-	return nil
+	if err := s.writer.finish(); err != nil {
+		glog.Errorf("failed to finish %s, %v", s.fn, err)
+	}
+	s.offset = s.writer.offset
+	if marks := s.writer.tidx.marks; marks != nil {
+		if err := writeTimeIndex(timeIndexFileName(s.fn), s.offset, marks); err != nil {
+			glog.Errorf("failed to write time index for %s, %v", s.fn, err)
+		}
+		s.marks, s.marksLoaded = marks, true
+	}
+	s.writer = nil
+	if s.bloom != nil {
+		if err := writeBloomFilter(bloomFileName(s.fn), s.bloom); err != nil {
+			glog.Errorf("failed to write bloom filter for %s, %v", s.fn, err)
+		}
+	}
+	if err := file.Sync(); err != nil {
+		fsyncErrors.Inc()
+		glog.Errorf("failed to sync %s, %v", s.fn, err)
+	} else {
+		s.synced()
+	}
+	return file.Close()
 }
@@ -0,0 +1,500 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QubitProducts/logspray/proto/logspray"
+	"github.com/QubitProducts/logspray/ql"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/oklog/ulid"
+)
+
+var testCodecs = []blockCodec{codecNone, codecSnappy, codecGzip}
+
+// testMessages returns n messages a second apart from start. Every
+// tenth message is larger than a block, the last of them about 4MB.
+func testMessages(start time.Time, n int) []*logspray.Message {
+	var ms []*logspray.Message
+	for i := 0; i < n; i++ {
+		ts, _ := ptypes.TimestampProto(start.Add(time.Duration(i) * time.Second))
+		text := fmt.Sprintf("line %d", i)
+		if i%10 == 5 {
+			text += strings.Repeat(" padding", i*i*256)
+		}
+		ms = append(ms, &logspray.Message{Index: uint64(i + 1), Time: ts, Text: text})
+	}
+	return ms
+}
+
+// writeTestFile writes ms to a closed shard file in dir.
+func writeTestFile(t *testing.T, dir string, comp compression, ms []*logspray.Message) *ShardFile {
+	t.Helper()
+	id := ulid.MustNew(ulid.Now(), rand.Reader).String()
+	sf := &ShardFile{
+		fn:     filepath.Join(dir, id+".pb.log"),
+		id:     id,
+		labels: map[string]string{"job": "test"},
+		comp:   comp,
+		tidx:   timeIndexConfig{records: 10},
+	}
+	for _, m := range ms {
+		m.StreamID = id
+		if err := sf.writeMessageToFile(context.Background(), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return sf
+}
+
+func readTestFile(t *testing.T, fn string, skip func(error)) ([]string, []int64, error) {
+	t.Helper()
+	sfi, err := OpenShardFileIterator(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sfi.Close()
+	if skip != nil {
+		sfi.SkipCorrupt(skip)
+	}
+	if sfi.Header().Labels["job"] != "test" {
+		t.Fatalf("unexpected header %v", sfi.Header())
+	}
+	var texts []string
+	var offs []int64
+	for sfi.Next() {
+		if m := sfi.Message(); m.ControlMessage == logspray.Message_NONE {
+			texts = append(texts, m.Text)
+			offs = append(offs, sfi.Offset())
+		}
+	}
+	return texts, offs, sfi.Err()
+}
+
+func messageTexts(ms []*logspray.Message) []string {
+	var texts []string
+	for _, m := range ms {
+		texts = append(texts, m.Text)
+	}
+	return texts
+}
+
+// Search ranges are inclusive of both from and to.
+func TestShardFile_RoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logspray-shardfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Now().Truncate(time.Hour)
+	for _, codec := range testCodecs {
+		t.Run(codec.String(), func(t *testing.T) {
+			ms := testMessages(start, 50)
+			want := messageTexts(ms)
+			sf := writeTestFile(t, dir, compression{codec: codec, level: gzip.DefaultCompression, blockSize: 4096}, ms)
+
+			got, _, err := readTestFile(t, sf.fn, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("iterator read %d messages, want %d", len(got), len(want))
+			}
+
+			for _, reverse := range []bool{false, true} {
+				var got []string
+				archived := &ShardFile{fn: sf.fn, id: sf.id}
+				err := archived.Search(context.Background(), func(m *logspray.Message) error {
+					if m.ControlMessage == logspray.Message_NONE {
+						got = append(got, m.Text)
+					}
+					return nil
+				}, nil, nil, start.Add(10*time.Second), start.Add(30*time.Second), reverse)
+				if err != nil {
+					t.Fatal(err)
+				}
+				exp := append([]string(nil), want[10:31]...)
+				if reverse {
+					for i, j := 0, len(exp)-1; i < j; i, j = i+1, j-1 {
+						exp[i], exp[j] = exp[j], exp[i]
+					}
+				}
+				if fmt.Sprint(got) != fmt.Sprint(exp) {
+					t.Fatalf("search (reverse %v) found %d messages, want %d", reverse, len(got), len(exp))
+				}
+			}
+		})
+	}
+}
+
+func TestShardFile_Corrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logspray-shardfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Now().Truncate(time.Hour)
+	for _, codec := range testCodecs {
+		t.Run(codec.String(), func(t *testing.T) {
+			ms := testMessages(start, 50)
+			want := messageTexts(ms)
+			sf := writeTestFile(t, dir, compression{codec: codec, level: gzip.DefaultCompression, blockSize: 4096}, ms)
+			_, offs, err := readTestFile(t, sf.fn, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Flip a bit in the body of the record, or block,
+			// holding message 20.
+			f, err := os.OpenFile(sf.fn, os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pos := offs[20] + int64(fileV2.frameLen()) + 2
+			b := make([]byte, 1)
+			f.ReadAt(b, pos)
+			b[0] ^= 0x10
+			f.WriteAt(b, pos)
+			f.Close()
+
+			got, _, err := readTestFile(t, sf.fn, nil)
+			if !isCorrupt(err) {
+				t.Fatalf("expected a corrupt record error, got %v", err)
+			}
+			if len(got) > 20 {
+				t.Fatalf("read %d messages past a corrupt record", len(got))
+			}
+
+			corrupt := 0
+			got, _, err = readTestFile(t, sf.fn, func(error) { corrupt++ })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if corrupt != 1 {
+				t.Fatalf("expected one corrupt record, or block, got %d", corrupt)
+			}
+			// Only the messages sharing the corrupt record's
+			// block are lost.
+			var lost int
+			for i, j := 0, 0; i < len(want); i++ {
+				if j < len(got) && got[j] == want[i] {
+					j++
+					continue
+				}
+				if offs[i] != offs[20] {
+					t.Fatalf("message %d, outside of the corrupt block, was lost", i)
+				}
+				lost++
+			}
+			if lost == 0 || len(got)+lost != len(want) {
+				t.Fatalf("read %d messages, lost %d, of %d", len(got), lost, len(want))
+			}
+		})
+	}
+}
+
+// Reading a file backwards leaves it positioned at the start of each
+// message read.
+func TestReadMessageFromFileEnd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logspray-shardfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ms := testMessages(time.Now(), 12)
+	want := messageTexts(ms)
+	sf := writeTestFile(t, dir, compression{codec: codecNone}, ms)
+
+	f, err := os.Open(sf.fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr := io.NewSectionReader(f, 0, fi.Size())
+	v, _, err := readFileVersion(sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, _ := sr.Seek(0, io.SeekCurrent)
+	if _, err := readMessageFromFile(sr, v); err != nil {
+		t.Fatalf("failed reading header, %v", err)
+	}
+	first, _ := sr.Seek(0, io.SeekCurrent)
+	if _, err := sr.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := len(want) - 1; i >= 0; i-- {
+		m, err := readMessageFromFileEnd(sr, v, first)
+		if err != nil {
+			t.Fatalf("failed reading message %d, %v", i, err)
+		}
+		if m.Text != want[i] {
+			t.Fatalf("read %.20q, want %.20q", m.Text, want[i])
+		}
+		pos, _ := sr.Seek(0, io.SeekCurrent)
+		fm, err := readMessageFromFile(sr, v)
+		if err != nil || fm.Text != m.Text {
+			t.Fatalf("message %d wasn't at the position left, %v", i, err)
+		}
+		sr.Seek(pos, io.SeekStart)
+	}
+	if _, err := readMessageFromFileEnd(sr, v, first); err != io.EOF {
+		t.Fatalf("expected io.EOF at the first message, got %v", err)
+	}
+	if _, err := readMessageFromFileEnd(sr, v, start); err != nil {
+		t.Fatalf("failed reading header backwards, %v", err)
+	}
+}
+
+// A shard file can't be created in a directory that can't be made.
+func TestShardFile_WriteNoDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logspray-shardfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	notDir := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(notDir, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	sf := &ShardFile{fn: filepath.Join(notDir, "shard", "stream.pb.log"), id: "stream"}
+	err = sf.writeMessageToFile(context.Background(), testMessages(time.Now(), 1)[0])
+	if err == nil || !strings.HasPrefix(err.Error(), "mkdir ") {
+		t.Fatalf("expected an error creating the shard directory, got %v", err)
+	}
+}
+
+// testdata/v1.pb.log was written by the version of ShardFile before
+// file headers were added, with 20 messages a second apart, framed by
+// uint16 sizes.
+func TestShardFile_V1(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logspray-shardfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bs, err := ioutil.ReadFile(filepath.Join("testdata", "v1.pb.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := filepath.Join(dir, "01BX5ZZKBKACTAV9WEVGEMMVRZ.pb.log")
+	if err := ioutil.WriteFile(fn, bs, 0666); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	var want []string
+	for i := 0; i < 20; i++ {
+		want = append(want, fmt.Sprintf("legacy line %d", i))
+	}
+
+	readV1 := func() ([]string, []int64) {
+		sfi, err := OpenShardFileIterator(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sfi.Close()
+		if sfi.Header().Labels["job"] != "legacy" {
+			t.Fatalf("unexpected header %v", sfi.Header())
+		}
+		var texts []string
+		var offs []int64
+		for sfi.Next() {
+			if m := sfi.Message(); m.ControlMessage == logspray.Message_NONE {
+				texts = append(texts, m.Text)
+				offs = append(offs, sfi.Offset())
+			}
+		}
+		if err := sfi.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return texts, offs
+	}
+	got, offs := readV1()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("iterator read %v, want %v", got, want)
+	}
+
+	for _, reverse := range []bool{false, true} {
+		var got []string
+		sf := &ShardFile{fn: fn, id: "01BX5ZZKBKACTAV9WEVGEMMVRZ"}
+		err := sf.Search(context.Background(), func(m *logspray.Message) error {
+			if m.ControlMessage == logspray.Message_NONE {
+				got = append(got, m.Text)
+			}
+			return nil
+		}, nil, nil, start.Add(5*time.Second), start.Add(10*time.Second), reverse)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp := append([]string(nil), want[5:11]...)
+		if reverse {
+			exp = reversed(exp)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(exp) {
+			t.Fatalf("search (reverse %v) got %v, want %v", reverse, got, exp)
+		}
+	}
+
+	if truncated, err := truncateTornTail(fn); err != nil || truncated {
+		t.Fatalf("truncated an intact v1 file, %v", err)
+	}
+	// A crash part way through writing the last record.
+	if err := os.Truncate(fn, int64(len(bs))-5); err != nil {
+		t.Fatal(err)
+	}
+	if truncated, err := truncateTornTail(fn); err != nil || !truncated {
+		t.Fatalf("torn v1 file was not truncated, %v", err)
+	}
+	fi, err := os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != offs[19] {
+		t.Fatalf("v1 file truncated to %d bytes, want %d", fi.Size(), offs[19])
+	}
+	if got, _ := readV1(); fmt.Sprint(got) != fmt.Sprint(want[:19]) {
+		t.Fatalf("truncated v1 file read %v", got)
+	}
+}
+
+func TestShardFile_Bloom(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	s, err := newShard(start, dataDir, "test", bloomConfig{tokens: 1000, fpRate: 0.01}, compression{codec: codecSnappy, blockSize: defaultBlockSize}, timeIndexConfig{}, fsyncRotate, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs := []string{"api", "web", "db"}
+	var ids []string
+	for k, job := range jobs {
+		ids = append(ids, ulid.MustNew(ulid.Now(), rand.Reader).String())
+		for i := 0; i < 10; i++ {
+			ts, _ := ptypes.TimestampProto(start.Add(time.Duration(i) * time.Second))
+			m := &logspray.Message{StreamID: ids[k], Index: uint64(i + 1), Time: ts}
+			switch job {
+			case "api":
+				m.Text = fmt.Sprintf("GET /login ok %d", i)
+				if i%5 == 0 {
+					m.Text = fmt.Sprintf("upstream timeout %d", i)
+					m.Labels = map[string]string{"level": "error"}
+				}
+			case "web":
+				m.Text = fmt.Sprintf("Connection refused %d", i)
+			case "db":
+				m.Text = fmt.Sprintf("query took %dms", i)
+			}
+			if err := s.writeMessage(context.Background(), m, map[string]string{"job": job}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	s.close()
+
+	// The files are reopened, so that their bloom filters are read
+	// back from disk.
+	files := map[string]*ShardFile{}
+	for _, f := range s.findFiles(nil, time.Time{}, time.Time{}) {
+		files[f.id] = &ShardFile{fn: f.fn, id: f.id}
+	}
+
+	tests := []struct {
+		q string
+		// searched lists the jobs whose file is not ruled out by
+		// its bloom filter or header.
+		searched []string
+	}{
+		{`timeout`, []string{"api"}},
+		{`TIMEOUT`, []string{"api"}},
+		{`"connection refused"`, []string{"web"}},
+		{`__text__~*login`, []string{"api"}},
+		{`job=web`, []string{"web"}},
+		{`job=cache`, nil},
+		{`level=error`, []string{"api"}},
+		{`job=web timeout`, nil},
+		{`job=db or refused`, []string{"web", "db"}},
+		{`took`, []string{"db"}},
+		{`not timeout`, jobs},
+		{`job~a.i`, []string{"api"}},
+		{`login or took`, []string{"api", "db"}},
+		{`ok`, jobs}, // too short to have any tokens
+	}
+	for _, st := range tests {
+		t.Run(st.q, func(t *testing.T) {
+			pl, err := ql.CompilePipeline(st.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var searched []string
+			for k, job := range jobs {
+				f := files[ids[k]]
+				fs, err := f.newSearch(pl.Match, pl.Tokens, time.Time{}, time.Now(), false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fs != nil {
+					searched = append(searched, job)
+					fs.close()
+					continue
+				}
+
+				// A file ruled out must not hold any matching
+				// message.
+				sfi, err := OpenShardFileIterator(f.fn)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for sfi.Next() {
+					if m := sfi.Message(); m.ControlMessage == logspray.Message_NONE && pl.Match(sfi.Header(), m, false) {
+						t.Errorf("%s file ruled out, but %q matches", job, m.Text)
+					}
+				}
+				sfi.Close()
+			}
+			if fmt.Sprint(searched) != fmt.Sprint(st.searched) {
+				t.Fatalf("searched %v, want %v", searched, st.searched)
+			}
+		})
+	}
+}
@@ -15,43 +15,141 @@
 
 package indexer
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// diskUsedMaxAge is how long the size of the data directory is cached
+// for, as walking it is expensive for large indexes.
+const diskUsedMaxAge = time.Minute
+
+var (
+	corruptRecords = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "logspray_index_corrupt_records_total",
+		Help: "Counter of corrupt records, or blocks of records, skipped when reading shard files.",
+	})
+	truncatedFiles = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "logspray_index_truncated_files_total",
+		Help: "Counter of shard files truncated to remove a torn write.",
+	})
+	prunedShards = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "logspray_index_pruned_shards_total",
+		Help: "Counter of shards deleted from the archive, by the reason for pruning them.",
+	}, []string{"reason"})
+	duplicateMessages = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "logspray_index_duplicate_messages_total",
+		Help: "Counter of messages dropped because their Index was already written to their stream.",
+	})
+	fsyncErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "logspray_index_fsync_errors_total",
+		Help: "Counter of failures to sync shard files to disk.",
+	})
+	remoteUploadErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "logspray_index_remote_upload_errors_total",
+		Help: "Counter of failed uploads of archived shards to remote storage.",
+	})
+	remoteFetches = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "logspray_index_remote_fetches_total",
+		Help: "Counter of evicted shards fetched from remote storage to be searched.",
+	})
+	evictedShards = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "logspray_index_evicted_shards_total",
+		Help: "Counter of uploaded shards whose local copy was evicted.",
+	})
+	encryptErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "logspray_index_encrypt_errors_total",
+		Help: "Counter of failures to encrypt archived shards.",
+	})
+
+	diskUsedDesc = prometheus.NewDesc(
+		"logspray_index_disk_used_bytes",
+		"Amount of disk space used by the index data directory, measured at most once a minute.",
+		nil, nil)
+	shardsDesc = prometheus.NewDesc(
+		"logspray_index_shards",
+		"Number of shards in the index, including the active shard.",
+		nil, nil)
+	messagesDesc = prometheus.NewDesc(
+		"logspray_index_messages",
+		"Number of messages in the index, not counting archived shards without a manifest.",
+		nil, nil)
+	oldestDesc = prometheus.NewDesc(
+		"logspray_index_oldest_shard_timestamp_seconds",
+		"Start time of the oldest shard retained by the index.",
+		nil, nil)
+)
 
 // Describe implements the prometheus describe interfaces for metric
 // collection
 func (i *Indexer) Describe(ch chan<- *prometheus.Desc) {
-	// Must send one description, or the registry panics
-	ch <- prometheus.NewDesc("dummy", "dummy", nil, nil)
+	corruptRecords.Describe(ch)
+	truncatedFiles.Describe(ch)
+	prunedShards.Describe(ch)
+	duplicateMessages.Describe(ch)
+	fsyncErrors.Describe(ch)
+	remoteUploadErrors.Describe(ch)
+	remoteFetches.Describe(ch)
+	evictedShards.Describe(ch)
+	encryptErrors.Describe(ch)
+	ch <- diskUsedDesc
+	ch <- shardsDesc
+	ch <- messagesDesc
+	ch <- oldestDesc
 }
 
 // Collect implements the prom metrics collection Collector
 // interface
 func (i *Indexer) Collect(ch chan<- prometheus.Metric) {
+	corruptRecords.Collect(ch)
+	truncatedFiles.Collect(ch)
+	prunedShards.Collect(ch)
+	duplicateMessages.Collect(ch)
+	fsyncErrors.Collect(ch)
+	remoteUploadErrors.Collect(ch)
+	remoteFetches.Collect(ch)
+	evictedShards.Collect(ch)
+	encryptErrors.Collect(ch)
+
 	i.RLock()
-	defer i.RUnlock()
+	active := i.activeShard
+	i.RUnlock()
+
+	shards, oldest, messages := i.archive.stats()
+	if active != nil {
+		shards++
+		messages += active.messages()
+		if oldest.IsZero() || active.shardStart.Before(oldest) {
+			oldest = active.shardStart
+		}
+	}
 
-	if i.activeShard == nil {
-		return
+	ch <- prometheus.MustNewConstMetric(diskUsedDesc, prometheus.GaugeValue, float64(i.diskUsed.get()))
+	ch <- prometheus.MustNewConstMetric(shardsDesc, prometheus.GaugeValue, float64(shards))
+	ch <- prometheus.MustNewConstMetric(messagesDesc, prometheus.GaugeValue, float64(messages))
+	if !oldest.IsZero() {
+		ch <- prometheus.MustNewConstMetric(oldestDesc, prometheus.GaugeValue, float64(oldest.UnixNano())/1e9)
 	}
+}
+
+// sizeCache caches the size of a directory for up to maxAge.
+type sizeCache struct {
+	dir    string
+	maxAge time.Duration
 
-	/*
-		activeSpace := int64(0)
-		path := i.activeShard.bindex.Name()
-		// We should probably unlock before doinng this walk
-		filepath.Walk(path, func(p string, i os.FileInfo, err error) error {
-			if !i.IsDir() {
-				activeSpace += i.Size()
-			}
-			return nil
-		})
-
-		ls := []string{"store"}
-		lvs := []string{"goleveldb"}
-		ch <- prometheus.MustNewConstMetric(
-			prometheus.NewDesc("logspray_index_kv_disk_used_bytes", "Amount of disk space used by the current index.", ls, nil),
-			prometheus.GaugeValue,
-			float64(activeSpace),
-			lvs...,
-		)
-	*/
+	sync.Mutex
+	size    int64
+	updated time.Time
+}
+
+func (c *sizeCache) get() int64 {
+	c.Lock()
+	defer c.Unlock()
+	if time.Since(c.updated) > c.maxAge {
+		c.size = dirSize(c.dir)
+		c.updated = time.Now()
+	}
+	return c.size
 }
@@ -0,0 +1,204 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// timeIndexMagic starts every time index sidecar file.
+const timeIndexMagic = "LSTI\x01"
+
+// timeIndexConfig sets how often a mark is added to the time index of
+// a shard file without blocks. A new mark is started after records
+// records, or bytes bytes, whichever comes first. Zero disables either
+// limit.
+type timeIndexConfig struct {
+	records int
+	bytes   int64
+}
+
+func (c timeIndexConfig) enabled() bool {
+	return c.records > 0 || c.bytes > 0
+}
+
+// timeMark is an entry in the sparse time index of a file without
+// blocks. It gives the offset of a record, and the range of message
+// times, in nanoseconds, from that record up to the next mark.
+type timeMark struct {
+	offset   int64
+	min, max int64
+}
+
+// timeIndex builds the time index of a file as it is written.
+type timeIndex struct {
+	cfg   timeIndexConfig
+	marks []timeMark
+	count int // records since the last mark
+}
+
+// add records a record written at offset, with time ns, or
+// math.MinInt64 if the message has no time.
+func (ti *timeIndex) add(offset int64, ns int64) {
+	if !ti.cfg.enabled() {
+		return
+	}
+	n := len(ti.marks)
+	if n == 0 ||
+		(ti.cfg.records > 0 && ti.count >= ti.cfg.records) ||
+		(ti.cfg.bytes > 0 && offset-ti.marks[n-1].offset >= ti.cfg.bytes) {
+		ti.marks = append(ti.marks, timeMark{offset: offset, min: math.MaxInt64, max: math.MinInt64})
+		ti.count = 0
+		n++
+	}
+	ti.count++
+	if ns == math.MinInt64 {
+		return
+	}
+	m := &ti.marks[n-1]
+	if ns < m.min {
+		m.min = ns
+	}
+	if ns > m.max {
+		m.max = ns
+	}
+}
+
+// snapshot returns a copy of the marks so far.
+func (ti *timeIndex) snapshot() []timeMark {
+	if ti.marks == nil {
+		return nil
+	}
+	return append([]timeMark(nil), ti.marks...)
+}
+
+// markRange uses binary searches of the marks of a file to find the
+// part of it that may hold messages between from and to. Records
+// before start hold no message after from, and records from end on
+// hold no message before to. ok is false if no records need reading.
+func markRange(marks []timeMark, from, to int64, size int64) (start, end int64, ok bool) {
+	n := len(marks)
+	// The running max of the times up to each mark, and the running
+	// min of those following, are both sorted, so can be searched.
+	maxs := make([]int64, n)
+	mins := make([]int64, n)
+	for i := range marks {
+		maxs[i] = marks[i].max
+		if i > 0 && maxs[i-1] > maxs[i] {
+			maxs[i] = maxs[i-1]
+		}
+		j := n - 1 - i
+		mins[j] = marks[j].min
+		if j < n-1 && mins[j+1] < mins[j] {
+			mins[j] = mins[j+1]
+		}
+	}
+
+	lo := sort.Search(n, func(i int) bool { return maxs[i] >= from })
+	hi := sort.Search(n, func(i int) bool { return mins[i] > to })
+	if lo >= hi {
+		return 0, 0, false
+	}
+	end = size
+	if hi < n {
+		end = marks[hi].offset
+	}
+	return marks[lo].offset, end, true
+}
+
+// timeIndexFileName returns the name of the time index sidecar for a
+// shard file.
+func timeIndexFileName(fn string) string {
+	return strings.TrimSuffix(fn, ".pb.log") + ".tidx"
+}
+
+// writeTimeIndex saves the marks of a file of the given size.
+func writeTimeIndex(fn string, size int64, marks []timeMark) error {
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	w.WriteString(timeIndexMagic)
+	binary.Write(w, binary.LittleEndian, size)
+	binary.Write(w, binary.LittleEndian, uint32(len(marks)))
+	for _, m := range marks {
+		binary.Write(w, binary.LittleEndian, m.offset)
+		binary.Write(w, binary.LittleEndian, m.min)
+		binary.Write(w, binary.LittleEndian, m.max)
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed writing time index, %w", err)
+	}
+	return f.Close()
+}
+
+// readTimeIndex reads the marks of a file, which must be of the given
+// size. An index written for a file of another size is out of date,
+// and is ignored.
+func readTimeIndex(fn string, size int64) ([]timeMark, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	magic := make([]byte, len(timeIndexMagic))
+	if _, err = io.ReadFull(r, magic); err != nil || string(magic) != timeIndexMagic {
+		return nil, errors.New("not a time index")
+	}
+
+	var isize int64
+	var n uint32
+	if err = binary.Read(r, binary.LittleEndian, &isize); err != nil {
+		return nil, fmt.Errorf("failed reading time index, %w", err)
+	}
+	if isize != size {
+		return nil, fmt.Errorf("time index is for a file of %d bytes, not %d", isize, size)
+	}
+	if err = binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, fmt.Errorf("failed reading time index, %w", err)
+	}
+	if int64(n) > size {
+		return nil, errors.New("corrupt time index")
+	}
+
+	marks := make([]timeMark, n)
+	last := int64(-1)
+	for i := range marks {
+		m := &marks[i]
+		for _, v := range []*int64{&m.offset, &m.min, &m.max} {
+			if err = binary.Read(r, binary.LittleEndian, v); err != nil {
+				return nil, fmt.Errorf("failed reading time index, %w", err)
+			}
+		}
+		if m.offset <= last || m.offset >= size {
+			return nil, errors.New("corrupt time index")
+		}
+		last = m.offset
+	}
+	return marks, nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package indexer
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTimeIndex_Add(t *testing.T) {
+	ti := &timeIndex{cfg: timeIndexConfig{records: 2, bytes: 100}}
+	ti.add(0, 10)
+	ti.add(10, math.MinInt64)
+	ti.add(20, 5)   // records limit
+	ti.add(200, 30) // bytes limit
+	ti.add(210, 20)
+
+	want := []timeMark{
+		{offset: 0, min: 10, max: 10},
+		{offset: 20, min: 5, max: 5},
+		{offset: 200, min: 20, max: 30},
+	}
+	if got := ti.snapshot(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	ti = &timeIndex{}
+	ti.add(0, 10)
+	if ti.snapshot() != nil {
+		t.Fatalf("disabled time index added marks")
+	}
+}
+
+func TestTimeIndex_MarkRange(t *testing.T) {
+	// The times of the marks overlap, and are out of order, as those
+	// of messages written to a stream may be.
+	marks := []timeMark{
+		{offset: 0, min: 10, max: 20},
+		{offset: 100, min: 15, max: 40},
+		{offset: 200, min: 30, max: 35},
+		{offset: 300, min: 50, max: 60},
+		// Searches skip messages without a time.
+		{offset: 400, min: math.MaxInt64, max: math.MinInt64},
+	}
+	const size = 500
+
+	tests := []struct {
+		from, to   int64
+		start, end int64
+		ok         bool
+	}{
+		{from: 0, to: 100, start: 0, end: 400, ok: true},
+		{from: 0, to: 5, ok: false},
+		{from: 70, to: 80, ok: false},
+		{from: 41, to: 45, ok: false},
+		// Seeking into the middle of the file.
+		{from: 36, to: 38, start: 100, end: 300, ok: true},
+		{from: 32, to: 33, start: 100, end: 300, ok: true},
+		{from: 55, to: 55, start: 300, end: 400, ok: true},
+		{from: 0, to: 12, start: 0, end: 100, ok: true},
+	}
+	for _, tt := range tests {
+		start, end, ok := markRange(marks, tt.from, tt.to, size)
+		if ok != tt.ok || ok && (start != tt.start || end != tt.end) {
+			t.Errorf("markRange(%d, %d) = %d, %d, %v, want %d, %d, %v", tt.from, tt.to, start, end, ok, tt.start, tt.end, tt.ok)
+		}
+	}
+}
+
+func TestTimeIndex_File(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logspray-tidx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fn := filepath.Join(dir, "test.tidx")
+	marks := []timeMark{
+		{offset: 10, min: 1, max: 2},
+		{offset: 20, min: 3, max: 4},
+	}
+	if err := writeTimeIndex(fn, 30, marks); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readTimeIndex(fn, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, marks) {
+		t.Fatalf("got %v, want %v", got, marks)
+	}
+
+	// The index is out of date once the file it is for changes size.
+	if _, err := readTimeIndex(fn, 40); err == nil {
+		t.Fatalf("read a time index for a file of another size")
+	}
+
+	bs, _ := ioutil.ReadFile(fn)
+	bs[len(bs)-24] = 0xff // the offset of the last mark
+	ioutil.WriteFile(fn, bs, 0644)
+	if _, err := readTimeIndex(fn, 30); err == nil {
+		t.Fatalf("read a corrupt time index")
+	}
+}
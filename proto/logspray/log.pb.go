@@ -10,6 +10,7 @@ It is generated from these files:
 It has these top-level messages:
 	Message
 	LogSummary
+	LogStreamAck
 	TailRequest
 	LabelsRequest
 	LabelsResponse
@@ -17,6 +18,15 @@ It has these top-level messages:
 	LabelValuesResponse
 	SearchRequest
 	SearchResponse
+	AggregateRequest
+	Point
+	Series
+	AggregateResponse
+	ExplainRequest
+	ExplainTerm
+	ExplainFile
+	ExplainShard
+	ExplainResponse
 */
 package logspray
 
@@ -25,6 +35,7 @@ import fmt "fmt"
 import math "math"
 import _ "google.golang.org/genproto/googleapis/api/annotations"
 import google_protobuf1 "github.com/golang/protobuf/ptypes/timestamp"
+import google_protobuf2 "github.com/golang/protobuf/ptypes/duration"
 
 import (
 	context "golang.org/x/net/context"
@@ -154,27 +165,70 @@ func (m *LogSummary) GetCount() int64 {
 	return 0
 }
 
+// LogStreamAck acknowledges the messages of a stream sent to
+// LogStreamV2 that the server has indexed.
+type LogStreamAck struct {
+	StreamID string `protobuf:"bytes,1,opt,name=StreamID,json=streamID" json:"StreamID,omitempty"`
+	// Index is the highest Index of the stream indexed.
+	Index uint64 `protobuf:"varint,2,opt,name=Index,json=index" json:"Index,omitempty"`
+}
+
+func (m *LogStreamAck) Reset()                    { *m = LogStreamAck{} }
+func (m *LogStreamAck) String() string            { return proto.CompactTextString(m) }
+func (*LogStreamAck) ProtoMessage()               {}
+func (*LogStreamAck) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *LogStreamAck) GetStreamID() string {
+	if m != nil {
+		return m.StreamID
+	}
+	return ""
+}
+
+func (m *LogStreamAck) GetIndex() uint64 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
 // TailRequest
 type TailRequest struct {
-	Max   int64  `protobuf:"varint,1,opt,name=max" json:"max,omitempty"`
-	Query string `protobuf:"bytes,2,opt,name=query" json:"query,omitempty"`
+	Query    string                      `protobuf:"bytes,2,opt,name=query" json:"query,omitempty"`
+	Backfill int64                       `protobuf:"varint,3,opt,name=backfill" json:"backfill,omitempty"`
+	Since    *google_protobuf1.Timestamp `protobuf:"bytes,4,opt,name=since" json:"since,omitempty"`
+	Cursor   string                      `protobuf:"bytes,5,opt,name=cursor" json:"cursor,omitempty"`
 }
 
 func (m *TailRequest) Reset()                    { *m = TailRequest{} }
 func (m *TailRequest) String() string            { return proto.CompactTextString(m) }
 func (*TailRequest) ProtoMessage()               {}
-func (*TailRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+func (*TailRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
 
-func (m *TailRequest) GetMax() int64 {
+func (m *TailRequest) GetQuery() string {
 	if m != nil {
-		return m.Max
+		return m.Query
+	}
+	return ""
+}
+
+func (m *TailRequest) GetBackfill() int64 {
+	if m != nil {
+		return m.Backfill
 	}
 	return 0
 }
 
-func (m *TailRequest) GetQuery() string {
+func (m *TailRequest) GetSince() *google_protobuf1.Timestamp {
 	if m != nil {
-		return m.Query
+		return m.Since
+	}
+	return nil
+}
+
+func (m *TailRequest) GetCursor() string {
+	if m != nil {
+		return m.Cursor
 	}
 	return ""
 }
@@ -188,7 +242,7 @@ type LabelsRequest struct {
 func (m *LabelsRequest) Reset()                    { *m = LabelsRequest{} }
 func (m *LabelsRequest) String() string            { return proto.CompactTextString(m) }
 func (*LabelsRequest) ProtoMessage()               {}
-func (*LabelsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+func (*LabelsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
 
 func (m *LabelsRequest) GetFrom() *google_protobuf1.Timestamp {
 	if m != nil {
@@ -212,7 +266,7 @@ type LabelsResponse struct {
 func (m *LabelsResponse) Reset()                    { *m = LabelsResponse{} }
 func (m *LabelsResponse) String() string            { return proto.CompactTextString(m) }
 func (*LabelsResponse) ProtoMessage()               {}
-func (*LabelsResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+func (*LabelsResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
 
 func (m *LabelsResponse) GetNames() []string {
 	if m != nil {
@@ -223,16 +277,18 @@ func (m *LabelsResponse) GetNames() []string {
 
 // LabelValuesRequest
 type LabelValuesRequest struct {
-	From  *google_protobuf1.Timestamp `protobuf:"bytes,1,opt,name=from" json:"from,omitempty"`
-	To    *google_protobuf1.Timestamp `protobuf:"bytes,2,opt,name=to" json:"to,omitempty"`
-	Name  string                      `protobuf:"bytes,3,opt,name=name" json:"name,omitempty"`
-	Count int64                       `protobuf:"varint,4,opt,name=count" json:"count,omitempty"`
+	From   *google_protobuf1.Timestamp `protobuf:"bytes,1,opt,name=from" json:"from,omitempty"`
+	To     *google_protobuf1.Timestamp `protobuf:"bytes,2,opt,name=to" json:"to,omitempty"`
+	Name   string                      `protobuf:"bytes,3,opt,name=name" json:"name,omitempty"`
+	Count  int64                       `protobuf:"varint,4,opt,name=count" json:"count,omitempty"`
+	Prefix string                      `protobuf:"bytes,5,opt,name=prefix" json:"prefix,omitempty"`
+	Regex  string                      `protobuf:"bytes,6,opt,name=regex" json:"regex,omitempty"`
 }
 
 func (m *LabelValuesRequest) Reset()                    { *m = LabelValuesRequest{} }
 func (m *LabelValuesRequest) String() string            { return proto.CompactTextString(m) }
 func (*LabelValuesRequest) ProtoMessage()               {}
-func (*LabelValuesRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+func (*LabelValuesRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
 
 func (m *LabelValuesRequest) GetFrom() *google_protobuf1.Timestamp {
 	if m != nil {
@@ -262,16 +318,31 @@ func (m *LabelValuesRequest) GetCount() int64 {
 	return 0
 }
 
+func (m *LabelValuesRequest) GetPrefix() string {
+	if m != nil {
+		return m.Prefix
+	}
+	return ""
+}
+
+func (m *LabelValuesRequest) GetRegex() string {
+	if m != nil {
+		return m.Regex
+	}
+	return ""
+}
+
 // LabelValuesResponse
 type LabelValuesResponse struct {
 	Values        []string `protobuf:"bytes,1,rep,name=values" json:"values,omitempty"`
 	TotalHitCount uint64   `protobuf:"varint,2,opt,name=total_hit_count,json=totalHitCount" json:"total_hit_count,omitempty"`
+	Counts        []uint64 `protobuf:"varint,3,rep,packed,name=counts" json:"counts,omitempty"`
 }
 
 func (m *LabelValuesResponse) Reset()                    { *m = LabelValuesResponse{} }
 func (m *LabelValuesResponse) String() string            { return proto.CompactTextString(m) }
 func (*LabelValuesResponse) ProtoMessage()               {}
-func (*LabelValuesResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+func (*LabelValuesResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
 
 func (m *LabelValuesResponse) GetValues() []string {
 	if m != nil {
@@ -287,6 +358,13 @@ func (m *LabelValuesResponse) GetTotalHitCount() uint64 {
 	return 0
 }
 
+func (m *LabelValuesResponse) GetCounts() []uint64 {
+	if m != nil {
+		return m.Counts
+	}
+	return nil
+}
+
 // SearchRequest
 type SearchRequest struct {
 	From    *google_protobuf1.Timestamp `protobuf:"bytes,1,opt,name=from" json:"from,omitempty"`
@@ -295,12 +373,13 @@ type SearchRequest struct {
 	Count   uint64                      `protobuf:"varint,4,opt,name=count" json:"count,omitempty"`
 	Offset  uint64                      `protobuf:"varint,5,opt,name=offset" json:"offset,omitempty"`
 	Reverse bool                        `protobuf:"varint,6,opt,name=reverse" json:"reverse,omitempty"`
+	Cursor  string                      `protobuf:"bytes,7,opt,name=cursor" json:"cursor,omitempty"`
 }
 
 func (m *SearchRequest) Reset()                    { *m = SearchRequest{} }
 func (m *SearchRequest) String() string            { return proto.CompactTextString(m) }
 func (*SearchRequest) ProtoMessage()               {}
-func (*SearchRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+func (*SearchRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
 
 func (m *SearchRequest) GetFrom() *google_protobuf1.Timestamp {
 	if m != nil {
@@ -344,16 +423,24 @@ func (m *SearchRequest) GetReverse() bool {
 	return false
 }
 
+func (m *SearchRequest) GetCursor() string {
+	if m != nil {
+		return m.Cursor
+	}
+	return ""
+}
+
 // SearchResponse
 type SearchResponse struct {
 	Messages      []*Message `protobuf:"bytes,1,rep,name=messages" json:"messages,omitempty"`
 	TotalHitCount uint64     `protobuf:"varint,2,opt,name=total_hit_count,json=totalHitCount" json:"total_hit_count,omitempty"`
+	Cursor        string     `protobuf:"bytes,3,opt,name=cursor" json:"cursor,omitempty"`
 }
 
 func (m *SearchResponse) Reset()                    { *m = SearchResponse{} }
 func (m *SearchResponse) String() string            { return proto.CompactTextString(m) }
 func (*SearchResponse) ProtoMessage()               {}
-func (*SearchResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+func (*SearchResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
 
 func (m *SearchResponse) GetMessages() []*Message {
 	if m != nil {
@@ -369,9 +456,298 @@ func (m *SearchResponse) GetTotalHitCount() uint64 {
 	return 0
 }
 
+func (m *SearchResponse) GetCursor() string {
+	if m != nil {
+		return m.Cursor
+	}
+	return ""
+}
+
+// AggregateRequest
+type AggregateRequest struct {
+	From  *google_protobuf1.Timestamp `protobuf:"bytes,1,opt,name=from" json:"from,omitempty"`
+	To    *google_protobuf1.Timestamp `protobuf:"bytes,2,opt,name=to" json:"to,omitempty"`
+	Query string                      `protobuf:"bytes,3,opt,name=query" json:"query,omitempty"`
+	Step  *google_protobuf2.Duration  `protobuf:"bytes,4,opt,name=step" json:"step,omitempty"`
+}
+
+func (m *AggregateRequest) Reset()                    { *m = AggregateRequest{} }
+func (m *AggregateRequest) String() string            { return proto.CompactTextString(m) }
+func (*AggregateRequest) ProtoMessage()               {}
+func (*AggregateRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *AggregateRequest) GetFrom() *google_protobuf1.Timestamp {
+	if m != nil {
+		return m.From
+	}
+	return nil
+}
+
+func (m *AggregateRequest) GetTo() *google_protobuf1.Timestamp {
+	if m != nil {
+		return m.To
+	}
+	return nil
+}
+
+func (m *AggregateRequest) GetQuery() string {
+	if m != nil {
+		return m.Query
+	}
+	return ""
+}
+
+func (m *AggregateRequest) GetStep() *google_protobuf2.Duration {
+	if m != nil {
+		return m.Step
+	}
+	return nil
+}
+
+// Point
+type Point struct {
+	Time  *google_protobuf1.Timestamp `protobuf:"bytes,1,opt,name=time" json:"time,omitempty"`
+	Value float64                     `protobuf:"fixed64,2,opt,name=value" json:"value,omitempty"`
+}
+
+func (m *Point) Reset()                    { *m = Point{} }
+func (m *Point) String() string            { return proto.CompactTextString(m) }
+func (*Point) ProtoMessage()               {}
+func (*Point) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *Point) GetTime() *google_protobuf1.Timestamp {
+	if m != nil {
+		return m.Time
+	}
+	return nil
+}
+
+func (m *Point) GetValue() float64 {
+	if m != nil {
+		return m.Value
+	}
+	return 0
+}
+
+// Series
+type Series struct {
+	Labels map[string]string `protobuf:"bytes,1,rep,name=labels" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Points []*Point          `protobuf:"bytes,2,rep,name=points" json:"points,omitempty"`
+}
+
+func (m *Series) Reset()                    { *m = Series{} }
+func (m *Series) String() string            { return proto.CompactTextString(m) }
+func (*Series) ProtoMessage()               {}
+func (*Series) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+func (m *Series) GetLabels() map[string]string {
+	if m != nil {
+		return m.Labels
+	}
+	return nil
+}
+
+func (m *Series) GetPoints() []*Point {
+	if m != nil {
+		return m.Points
+	}
+	return nil
+}
+
+// AggregateResponse
+type AggregateResponse struct {
+	Series []*Series `protobuf:"bytes,1,rep,name=series" json:"series,omitempty"`
+}
+
+func (m *AggregateResponse) Reset()                    { *m = AggregateResponse{} }
+func (m *AggregateResponse) String() string            { return proto.CompactTextString(m) }
+func (*AggregateResponse) ProtoMessage()               {}
+func (*AggregateResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+func (m *AggregateResponse) GetSeries() []*Series {
+	if m != nil {
+		return m.Series
+	}
+	return nil
+}
+
+// ExplainRequest
+type ExplainRequest struct {
+	From  *google_protobuf1.Timestamp `protobuf:"bytes,1,opt,name=from" json:"from,omitempty"`
+	To    *google_protobuf1.Timestamp `protobuf:"bytes,2,opt,name=to" json:"to,omitempty"`
+	Query string                      `protobuf:"bytes,3,opt,name=query" json:"query,omitempty"`
+}
+
+func (m *ExplainRequest) Reset()                    { *m = ExplainRequest{} }
+func (m *ExplainRequest) String() string            { return proto.CompactTextString(m) }
+func (*ExplainRequest) ProtoMessage()               {}
+func (*ExplainRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
+
+func (m *ExplainRequest) GetFrom() *google_protobuf1.Timestamp {
+	if m != nil {
+		return m.From
+	}
+	return nil
+}
+
+func (m *ExplainRequest) GetTo() *google_protobuf1.Timestamp {
+	if m != nil {
+		return m.To
+	}
+	return nil
+}
+
+func (m *ExplainRequest) GetQuery() string {
+	if m != nil {
+		return m.Query
+	}
+	return ""
+}
+
+// ExplainTerm
+type ExplainTerm struct {
+	Term       string `protobuf:"bytes,1,opt,name=term" json:"term,omitempty"`
+	HeaderOnly bool   `protobuf:"varint,2,opt,name=header_only,json=headerOnly" json:"header_only,omitempty"`
+}
+
+func (m *ExplainTerm) Reset()                    { *m = ExplainTerm{} }
+func (m *ExplainTerm) String() string            { return proto.CompactTextString(m) }
+func (*ExplainTerm) ProtoMessage()               {}
+func (*ExplainTerm) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{15} }
+
+func (m *ExplainTerm) GetTerm() string {
+	if m != nil {
+		return m.Term
+	}
+	return ""
+}
+
+func (m *ExplainTerm) GetHeaderOnly() bool {
+	if m != nil {
+		return m.HeaderOnly
+	}
+	return false
+}
+
+// ExplainFile
+type ExplainFile struct {
+	StreamId string            `protobuf:"bytes,1,opt,name=stream_id,json=streamId" json:"stream_id,omitempty"`
+	Name     string            `protobuf:"bytes,2,opt,name=name" json:"name,omitempty"`
+	Labels   map[string]string `protobuf:"bytes,3,rep,name=labels" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Pruned   bool              `protobuf:"varint,4,opt,name=pruned" json:"pruned,omitempty"`
+}
+
+func (m *ExplainFile) Reset()                    { *m = ExplainFile{} }
+func (m *ExplainFile) String() string            { return proto.CompactTextString(m) }
+func (*ExplainFile) ProtoMessage()               {}
+func (*ExplainFile) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{16} }
+
+func (m *ExplainFile) GetStreamId() string {
+	if m != nil {
+		return m.StreamId
+	}
+	return ""
+}
+
+func (m *ExplainFile) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *ExplainFile) GetLabels() map[string]string {
+	if m != nil {
+		return m.Labels
+	}
+	return nil
+}
+
+func (m *ExplainFile) GetPruned() bool {
+	if m != nil {
+		return m.Pruned
+	}
+	return false
+}
+
+// ExplainShard
+type ExplainShard struct {
+	Id     string                      `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Start  *google_protobuf1.Timestamp `protobuf:"bytes,2,opt,name=start" json:"start,omitempty"`
+	Active bool                        `protobuf:"varint,3,opt,name=active" json:"active,omitempty"`
+	Files  []*ExplainFile              `protobuf:"bytes,4,rep,name=files" json:"files,omitempty"`
+}
+
+func (m *ExplainShard) Reset()                    { *m = ExplainShard{} }
+func (m *ExplainShard) String() string            { return proto.CompactTextString(m) }
+func (*ExplainShard) ProtoMessage()               {}
+func (*ExplainShard) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{17} }
+
+func (m *ExplainShard) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *ExplainShard) GetStart() *google_protobuf1.Timestamp {
+	if m != nil {
+		return m.Start
+	}
+	return nil
+}
+
+func (m *ExplainShard) GetActive() bool {
+	if m != nil {
+		return m.Active
+	}
+	return false
+}
+
+func (m *ExplainShard) GetFiles() []*ExplainFile {
+	if m != nil {
+		return m.Files
+	}
+	return nil
+}
+
+// ExplainResponse
+type ExplainResponse struct {
+	Query  string          `protobuf:"bytes,1,opt,name=query" json:"query,omitempty"`
+	Terms  []*ExplainTerm  `protobuf:"bytes,2,rep,name=terms" json:"terms,omitempty"`
+	Shards []*ExplainShard `protobuf:"bytes,3,rep,name=shards" json:"shards,omitempty"`
+}
+
+func (m *ExplainResponse) Reset()                    { *m = ExplainResponse{} }
+func (m *ExplainResponse) String() string            { return proto.CompactTextString(m) }
+func (*ExplainResponse) ProtoMessage()               {}
+func (*ExplainResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{18} }
+
+func (m *ExplainResponse) GetQuery() string {
+	if m != nil {
+		return m.Query
+	}
+	return ""
+}
+
+func (m *ExplainResponse) GetTerms() []*ExplainTerm {
+	if m != nil {
+		return m.Terms
+	}
+	return nil
+}
+
+func (m *ExplainResponse) GetShards() []*ExplainShard {
+	if m != nil {
+		return m.Shards
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*Message)(nil), "logspray.Message")
 	proto.RegisterType((*LogSummary)(nil), "logspray.LogSummary")
+	proto.RegisterType((*LogStreamAck)(nil), "logspray.LogStreamAck")
 	proto.RegisterType((*TailRequest)(nil), "logspray.TailRequest")
 	proto.RegisterType((*LabelsRequest)(nil), "logspray.LabelsRequest")
 	proto.RegisterType((*LabelsResponse)(nil), "logspray.LabelsResponse")
@@ -379,6 +755,15 @@ func init() {
 	proto.RegisterType((*LabelValuesResponse)(nil), "logspray.LabelValuesResponse")
 	proto.RegisterType((*SearchRequest)(nil), "logspray.SearchRequest")
 	proto.RegisterType((*SearchResponse)(nil), "logspray.SearchResponse")
+	proto.RegisterType((*AggregateRequest)(nil), "logspray.AggregateRequest")
+	proto.RegisterType((*Point)(nil), "logspray.Point")
+	proto.RegisterType((*Series)(nil), "logspray.Series")
+	proto.RegisterType((*AggregateResponse)(nil), "logspray.AggregateResponse")
+	proto.RegisterType((*ExplainRequest)(nil), "logspray.ExplainRequest")
+	proto.RegisterType((*ExplainTerm)(nil), "logspray.ExplainTerm")
+	proto.RegisterType((*ExplainFile)(nil), "logspray.ExplainFile")
+	proto.RegisterType((*ExplainShard)(nil), "logspray.ExplainShard")
+	proto.RegisterType((*ExplainResponse)(nil), "logspray.ExplainResponse")
 	proto.RegisterEnum("logspray.Message_ControlMessage", Message_ControlMessage_name, Message_ControlMessage_value)
 }
 
@@ -402,6 +787,14 @@ type LogServiceClient interface {
 	// making it the singe source of truth for a given log item, and allowing
 	// potential deduplication of log itmes later on.
 	LogStream(ctx context.Context, opts ...grpc.CallOption) (LogService_LogStreamClient, error)
+	// LogStreamV2 ingests a stream of messages as LogStream does, and
+	// acknowledges them once they are indexed, and synced to disk unless
+	// the server only syncs files when shards are closed. An ack is sent as
+	// soon as the header is received, giving the highest Index of the
+	// stream already indexed, and periodically after that. Clients
+	// reconnecting with the same StreamID need only resend messages after
+	// the last acknowledged Index.
+	LogStreamV2(ctx context.Context, opts ...grpc.CallOption) (LogService_LogStreamV2Client, error)
 	// Log logs an individual message.
 	Log(ctx context.Context, in *Message, opts ...grpc.CallOption) (*LogSummary, error)
 	// Tail returns a stream of log data that matches the
@@ -421,6 +814,13 @@ type LogServiceClient interface {
 	// LabelsValue returns the set of values for a label  known in the current
 	// index active index. (from/to are currently ignored)
 	LabelValues(ctx context.Context, in *LabelValuesRequest, opts ...grpc.CallOption) (*LabelValuesResponse, error)
+	// Aggregate takes an aggregation query and returns the resulting
+	// time series.
+	Aggregate(ctx context.Context, in *AggregateRequest, opts ...grpc.CallOption) (*AggregateResponse, error)
+	// Explain parses a query and reports how a search would be run,
+	// which terms can rule out files using only their headers, and
+	// which shards and files would be read.
+	Explain(ctx context.Context, in *ExplainRequest, opts ...grpc.CallOption) (*ExplainResponse, error)
 }
 
 type logServiceClient struct {
@@ -465,6 +865,37 @@ func (x *logServiceLogStreamClient) CloseAndRecv() (*LogSummary, error) {
 	return m, nil
 }
 
+func (c *logServiceClient) LogStreamV2(ctx context.Context, opts ...grpc.CallOption) (LogService_LogStreamV2Client, error) {
+	stream, err := grpc.NewClientStream(ctx, &_LogService_serviceDesc.Streams[1], c.cc, "/logspray.LogService/LogStreamV2", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &logServiceLogStreamV2Client{stream}
+	return x, nil
+}
+
+type LogService_LogStreamV2Client interface {
+	Send(*Message) error
+	Recv() (*LogStreamAck, error)
+	grpc.ClientStream
+}
+
+type logServiceLogStreamV2Client struct {
+	grpc.ClientStream
+}
+
+func (x *logServiceLogStreamV2Client) Send(m *Message) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *logServiceLogStreamV2Client) Recv() (*LogStreamAck, error) {
+	m := new(LogStreamAck)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *logServiceClient) Log(ctx context.Context, in *Message, opts ...grpc.CallOption) (*LogSummary, error) {
 	out := new(LogSummary)
 	err := grpc.Invoke(ctx, "/logspray.LogService/Log", in, out, c.cc, opts...)
@@ -475,7 +906,7 @@ func (c *logServiceClient) Log(ctx context.Context, in *Message, opts ...grpc.Ca
 }
 
 func (c *logServiceClient) Tail(ctx context.Context, in *TailRequest, opts ...grpc.CallOption) (LogService_TailClient, error) {
-	stream, err := grpc.NewClientStream(ctx, &_LogService_serviceDesc.Streams[1], c.cc, "/logspray.LogService/Tail", opts...)
+	stream, err := grpc.NewClientStream(ctx, &_LogService_serviceDesc.Streams[2], c.cc, "/logspray.LogService/Tail", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -516,7 +947,7 @@ func (c *logServiceClient) Search(ctx context.Context, in *SearchRequest, opts .
 }
 
 func (c *logServiceClient) SearchStream(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (LogService_SearchStreamClient, error) {
-	stream, err := grpc.NewClientStream(ctx, &_LogService_serviceDesc.Streams[2], c.cc, "/logspray.LogService/SearchStream", opts...)
+	stream, err := grpc.NewClientStream(ctx, &_LogService_serviceDesc.Streams[3], c.cc, "/logspray.LogService/SearchStream", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -565,6 +996,24 @@ func (c *logServiceClient) LabelValues(ctx context.Context, in *LabelValuesReque
 	return out, nil
 }
 
+func (c *logServiceClient) Aggregate(ctx context.Context, in *AggregateRequest, opts ...grpc.CallOption) (*AggregateResponse, error) {
+	out := new(AggregateResponse)
+	err := grpc.Invoke(ctx, "/logspray.LogService/Aggregate", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logServiceClient) Explain(ctx context.Context, in *ExplainRequest, opts ...grpc.CallOption) (*ExplainResponse, error) {
+	out := new(ExplainResponse)
+	err := grpc.Invoke(ctx, "/logspray.LogService/Explain", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for LogService service
 
 type LogServiceServer interface {
@@ -577,6 +1026,14 @@ type LogServiceServer interface {
 	// making it the singe source of truth for a given log item, and allowing
 	// potential deduplication of log itmes later on.
 	LogStream(LogService_LogStreamServer) error
+	// LogStreamV2 ingests a stream of messages as LogStream does, and
+	// acknowledges them once they are indexed, and synced to disk unless
+	// the server only syncs files when shards are closed. An ack is sent as
+	// soon as the header is received, giving the highest Index of the
+	// stream already indexed, and periodically after that. Clients
+	// reconnecting with the same StreamID need only resend messages after
+	// the last acknowledged Index.
+	LogStreamV2(LogService_LogStreamV2Server) error
 	// Log logs an individual message.
 	Log(context.Context, *Message) (*LogSummary, error)
 	// Tail returns a stream of log data that matches the
@@ -596,6 +1053,13 @@ type LogServiceServer interface {
 	// LabelsValue returns the set of values for a label  known in the current
 	// index active index. (from/to are currently ignored)
 	LabelValues(context.Context, *LabelValuesRequest) (*LabelValuesResponse, error)
+	// Aggregate takes an aggregation query and returns the resulting
+	// time series.
+	Aggregate(context.Context, *AggregateRequest) (*AggregateResponse, error)
+	// Explain parses a query and reports how a search would be run,
+	// which terms can rule out files using only their headers, and
+	// which shards and files would be read.
+	Explain(context.Context, *ExplainRequest) (*ExplainResponse, error)
 }
 
 func RegisterLogServiceServer(s *grpc.Server, srv LogServiceServer) {
@@ -628,6 +1092,32 @@ func (x *logServiceLogStreamServer) Recv() (*Message, error) {
 	return m, nil
 }
 
+func _LogService_LogStreamV2_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(LogServiceServer).LogStreamV2(&logServiceLogStreamV2Server{stream})
+}
+
+type LogService_LogStreamV2Server interface {
+	Send(*LogStreamAck) error
+	Recv() (*Message, error)
+	grpc.ServerStream
+}
+
+type logServiceLogStreamV2Server struct {
+	grpc.ServerStream
+}
+
+func (x *logServiceLogStreamV2Server) Send(m *LogStreamAck) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *logServiceLogStreamV2Server) Recv() (*Message, error) {
+	m := new(Message)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func _LogService_Log_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(Message)
 	if err := dec(in); err != nil {
@@ -742,6 +1232,42 @@ func _LogService_LabelValues_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _LogService_Aggregate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AggregateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServiceServer).Aggregate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/logspray.LogService/Aggregate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServiceServer).Aggregate(ctx, req.(*AggregateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LogService_Explain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExplainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServiceServer).Explain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/logspray.LogService/Explain",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServiceServer).Explain(ctx, req.(*ExplainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _LogService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "logspray.LogService",
 	HandlerType: (*LogServiceServer)(nil),
@@ -762,6 +1288,14 @@ var _LogService_serviceDesc = grpc.ServiceDesc{
 			MethodName: "LabelValues",
 			Handler:    _LogService_LabelValues_Handler,
 		},
+		{
+			MethodName: "Aggregate",
+			Handler:    _LogService_Aggregate_Handler,
+		},
+		{
+			MethodName: "Explain",
+			Handler:    _LogService_Explain_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -769,6 +1303,12 @@ var _LogService_serviceDesc = grpc.ServiceDesc{
 			Handler:       _LogService_LogStream_Handler,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "LogStreamV2",
+			Handler:       _LogService_LogStreamV2_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 		{
 			StreamName:    "Tail",
 			Handler:       _LogService_Tail_Handler,
@@ -786,58 +1326,89 @@ var _LogService_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("proto/logspray/log.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 838 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xbc, 0x54, 0xcd, 0x6e, 0xdb, 0x46,
-	0x10, 0x2e, 0x7f, 0x24, 0x4b, 0xa3, 0x58, 0x56, 0x26, 0x69, 0xc3, 0x10, 0x0e, 0x20, 0xf0, 0x60,
-	0x08, 0x06, 0x42, 0xa6, 0x2a, 0xdc, 0x1f, 0x03, 0x3d, 0xa4, 0xb1, 0x80, 0x18, 0x76, 0x6c, 0x74,
-	0xed, 0xf6, 0x6a, 0xd0, 0xf2, 0x5a, 0x26, 0x4c, 0x72, 0x15, 0xee, 0xca, 0xb0, 0x61, 0xf8, 0xd0,
-	0xbe, 0x42, 0x6f, 0x7d, 0x81, 0x3e, 0x4b, 0xcf, 0x79, 0x85, 0x3e, 0x48, 0xb1, 0xb3, 0xa4, 0x25,
-	0x55, 0x4d, 0xa0, 0x5c, 0x7c, 0xdb, 0x6f, 0x67, 0xf6, 0xfb, 0x66, 0x66, 0x67, 0x06, 0xbc, 0x71,
-	0x21, 0x94, 0x88, 0x52, 0x31, 0x92, 0xe3, 0x22, 0xbe, 0xd1, 0x87, 0x90, 0xae, 0xb0, 0x51, 0xdd,
-	0xf9, 0xeb, 0x23, 0x21, 0x46, 0x29, 0x8f, 0xe2, 0x71, 0x12, 0xc5, 0x79, 0x2e, 0x54, 0xac, 0x12,
-	0x91, 0x4b, 0xe3, 0xe7, 0x3f, 0x57, 0x49, 0xc6, 0xa5, 0x8a, 0xb3, 0x71, 0x74, 0x7f, 0x32, 0xa6,
-	0xe0, 0x2f, 0x07, 0x56, 0xde, 0x71, 0x29, 0xe3, 0x11, 0xc7, 0x10, 0x5c, 0x6d, 0xf6, 0xac, 0xae,
-	0xd5, 0x6b, 0xf5, 0xfd, 0xd0, 0x70, 0x1a, 0xc7, 0xd3, 0xc9, 0x79, 0x78, 0x5c, 0xbd, 0x65, 0xe4,
-	0x87, 0x5b, 0x50, 0x4f, 0xe3, 0x53, 0x9e, 0x4a, 0xcf, 0xee, 0x3a, 0xbd, 0x56, 0xff, 0x45, 0x58,
-	0xc5, 0x13, 0x96, 0x94, 0xe1, 0x3e, 0xd9, 0x07, 0xb9, 0x2a, 0x6e, 0x58, 0xe9, 0x8c, 0xeb, 0xd0,
-	0x94, 0x5c, 0x5d, 0xf0, 0xf8, 0x8c, 0x17, 0x9e, 0xd3, 0xb5, 0x7a, 0x0d, 0x36, 0xbd, 0x40, 0x04,
-	0x57, 0xf1, 0x6b, 0xe5, 0xb9, 0x5d, 0xab, 0xd7, 0x64, 0x74, 0xc6, 0x5d, 0x58, 0x1b, 0x8a, 0x5c,
-	0x15, 0x22, 0x3d, 0xc9, 0x0c, 0xb1, 0x57, 0xeb, 0x5a, 0xbd, 0x76, 0xbf, 0xbb, 0xa8, 0xf8, 0xc6,
-	0x38, 0x96, 0x90, 0xb5, 0x87, 0x73, 0x18, 0x7d, 0x68, 0x1c, 0xa9, 0x82, 0xc7, 0xd9, 0xee, 0x8e,
-	0x57, 0x27, 0x89, 0x86, 0x2c, 0x31, 0x3e, 0x85, 0xda, 0x6e, 0x7e, 0xc6, 0xaf, 0xbd, 0x95, 0xae,
-	0xd5, 0x73, 0x59, 0x2d, 0xd1, 0xc0, 0xff, 0x01, 0x5a, 0x33, 0x59, 0x60, 0x07, 0x9c, 0x4b, 0x7e,
-	0x43, 0x35, 0x6a, 0x32, 0x7d, 0xd4, 0xcf, 0xae, 0xe2, 0x74, 0xc2, 0x3d, 0x9b, 0xee, 0x0c, 0xd8,
-	0xb6, 0xbf, 0xb7, 0x82, 0x3d, 0x68, 0xcf, 0x87, 0x83, 0x0d, 0x70, 0x0f, 0x0e, 0x0f, 0x06, 0x9d,
-	0x2f, 0xb0, 0x0e, 0xf6, 0xe1, 0x5e, 0xc7, 0xc2, 0x26, 0xd4, 0x06, 0x8c, 0x1d, 0xb2, 0x8e, 0x8d,
-	0xab, 0xd0, 0x3c, 0x1a, 0x1c, 0xbf, 0x1d, 0xbc, 0xde, 0x19, 0xb0, 0x8e, 0x43, 0xf0, 0x98, 0x0d,
-	0x5e, 0xbf, 0x1b, 0x1c, 0xec, 0x74, 0xdc, 0x20, 0x00, 0xd8, 0x17, 0xa3, 0xa3, 0x49, 0x96, 0xc5,
-	0x05, 0x89, 0x0e, 0xc5, 0x24, 0x57, 0x14, 0x88, 0xc3, 0x0c, 0x08, 0xb6, 0xa0, 0x75, 0x1c, 0x27,
-	0x29, 0xe3, 0xef, 0x27, 0x5c, 0x2a, 0x1d, 0x6b, 0x16, 0x5f, 0x97, 0x2e, 0xfa, 0xa8, 0x9f, 0xbd,
-	0x9f, 0xf0, 0xe2, 0xa6, 0x8a, 0x95, 0x40, 0x70, 0x09, 0xab, 0x26, 0xc5, 0xea, 0x61, 0x08, 0xee,
-	0x79, 0x21, 0xb2, 0x65, 0x3a, 0x41, 0xfb, 0xe1, 0x26, 0xd8, 0x4a, 0x10, 0xe7, 0xa7, 0xbd, 0x6d,
-	0x25, 0x82, 0x0d, 0x68, 0x57, 0x62, 0x72, 0x2c, 0x72, 0xc9, 0x75, 0x50, 0x79, 0x9c, 0x71, 0xe9,
-	0x59, 0x5d, 0x47, 0x07, 0x45, 0x20, 0xf8, 0xd3, 0x02, 0x24, 0xc7, 0x5f, 0x75, 0x3d, 0x1f, 0x22,
-	0x34, 0xdd, 0x7b, 0x5a, 0x9b, 0x9a, 0xb2, 0xc9, 0xe8, 0x3c, 0x2d, 0xb4, 0x3b, 0x5b, 0xe8, 0x5f,
-	0xe0, 0xc9, 0x5c, 0x6c, 0x65, 0x26, 0x5f, 0x41, 0x9d, 0x7e, 0xbf, 0x4a, 0xa5, 0x44, 0xb8, 0x01,
-	0x6b, 0x4a, 0xa8, 0x38, 0x3d, 0xb9, 0x48, 0xd4, 0x89, 0xa1, 0xb3, 0xa9, 0xc7, 0x56, 0xe9, 0xfa,
-	0x6d, 0xa2, 0xde, 0x10, 0xed, 0xdf, 0x16, 0xac, 0x1e, 0xf1, 0xb8, 0x18, 0x5e, 0x3c, 0x44, 0xba,
-	0xf7, 0xcd, 0xe0, 0xcc, 0x34, 0xc3, 0x7c, 0xc2, 0x6e, 0x99, 0xb0, 0xce, 0x4c, 0x9c, 0x9f, 0x4b,
-	0xae, 0x68, 0xf2, 0x5c, 0x56, 0x22, 0xf4, 0x60, 0xa5, 0xe0, 0x57, 0xbc, 0x90, 0x9c, 0xc6, 0xa9,
-	0xc1, 0x2a, 0x18, 0x8c, 0xa0, 0x5d, 0xa5, 0x52, 0x56, 0xe7, 0x25, 0x34, 0xca, 0xf1, 0x35, 0xf5,
-	0x69, 0xf5, 0x1f, 0x2f, 0xcc, 0x2f, 0xbb, 0x77, 0x59, 0xb6, 0x68, 0xfd, 0x0f, 0x35, 0x33, 0x19,
-	0xbc, 0xb8, 0x4a, 0x86, 0x1c, 0x7f, 0x86, 0xa6, 0x46, 0x34, 0xd4, 0xb8, 0x28, 0xe0, 0x3f, 0x9d,
-	0x5e, 0x4d, 0xe7, 0x29, 0x78, 0xfe, 0xfb, 0x87, 0x7f, 0xfe, 0xb0, 0x9f, 0x04, 0xed, 0xe8, 0xea,
-	0x6b, 0xbd, 0x61, 0x23, 0xb3, 0x15, 0xb6, 0xad, 0xcd, 0x9e, 0x85, 0x3f, 0x81, 0xb3, 0x2f, 0x46,
-	0xcb, 0x93, 0x21, 0x91, 0x3d, 0x0a, 0x56, 0x4a, 0xb2, 0x6d, 0x6b, 0x13, 0xf7, 0xc0, 0xd5, 0xa3,
-	0x89, 0x5f, 0x4e, 0x5f, 0xcc, 0x8c, 0xaa, 0xbf, 0xc8, 0x1d, 0x3c, 0x23, 0x96, 0xc7, 0xb8, 0xa6,
-	0x59, 0x54, 0x9c, 0xa4, 0x65, 0x4c, 0xaf, 0x2c, 0x94, 0x50, 0x37, 0xb5, 0xc5, 0x67, 0xd3, 0x77,
-	0x73, 0x8d, 0xe3, 0x7b, 0x8b, 0x06, 0xf3, 0x0d, 0xc1, 0xb7, 0xc4, 0xfb, 0x0a, 0x43, 0xcd, 0x2b,
-	0xc9, 0x16, 0xdd, 0xea, 0xe6, 0x09, 0x25, 0x1f, 0x8a, 0xfc, 0x4c, 0xde, 0x45, 0xb7, 0x4a, 0xcc,
-	0x00, 0xea, 0x8b, 0x3b, 0xbc, 0x85, 0x47, 0x86, 0xa9, 0xac, 0xed, 0x47, 0xa5, 0xff, 0x27, 0x97,
-	0x1f, 0x49, 0xf3, 0x3b, 0xdc, 0xfa, 0x3c, 0xcd, 0x69, 0xc6, 0x97, 0x50, 0x37, 0x5b, 0x63, 0x56,
-	0x76, 0x6e, 0x69, 0xcd, 0x66, 0x3c, 0xbf, 0x60, 0x82, 0x90, 0xd4, 0x7b, 0xb8, 0x41, 0xff, 0x41,
-	0xb6, 0x4f, 0xa9, 0xe3, 0x6f, 0x56, 0xb9, 0xf3, 0xcd, 0x78, 0xe3, 0xfa, 0x7f, 0x98, 0xe7, 0x36,
-	0x92, 0xff, 0xe2, 0x23, 0xd6, 0x52, 0x7c, 0x8b, 0xc4, 0x23, 0x7c, 0xb9, 0x9c, 0x78, 0x74, 0xab,
-	0xd7, 0xce, 0xdd, 0x69, 0x9d, 0x86, 0xf6, 0x9b, 0x7f, 0x03, 0x00, 0x00, 0xff, 0xff, 0xe4, 0x70,
-	0xa8, 0xc8, 0xfe, 0x07, 0x00, 0x00,
+	// 1335 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xc4, 0x56, 0x5f, 0x6f, 0x13, 0x47,
+	0x10, 0x67, 0xef, 0xce, 0x8e, 0x3d, 0x4e, 0x1c, 0xb3, 0xd0, 0x70, 0x31, 0x41, 0x75, 0xef, 0x81,
+	0x5a, 0x20, 0xec, 0x10, 0x4a, 0x29, 0x48, 0x54, 0x0d, 0xc4, 0x15, 0x94, 0x3f, 0x69, 0x37, 0x11,
+	0xaf, 0xd1, 0xc5, 0x5e, 0x3b, 0xa7, 0xdc, 0x1f, 0xb3, 0xbb, 0x4e, 0x13, 0x45, 0x08, 0x15, 0xf5,
+	0xb5, 0x4f, 0x6d, 0xbf, 0x40, 0x5f, 0xfa, 0x01, 0xfa, 0x25, 0xfa, 0xd0, 0xb7, 0x7e, 0x82, 0x4a,
+	0xfd, 0x20, 0xd5, 0xfe, 0xb9, 0xf3, 0x39, 0x36, 0x25, 0x08, 0x09, 0xde, 0x76, 0x76, 0xe6, 0xe6,
+	0x37, 0xf3, 0x9b, 0xb9, 0x99, 0x05, 0x77, 0xc8, 0x12, 0x91, 0xb4, 0xc3, 0x64, 0xc0, 0x87, 0xcc,
+	0x3f, 0x92, 0x87, 0x96, 0xba, 0xc2, 0xa5, 0xf4, 0xae, 0xbe, 0x32, 0x48, 0x92, 0x41, 0x48, 0xdb,
+	0xfe, 0x30, 0x68, 0xfb, 0x71, 0x9c, 0x08, 0x5f, 0x04, 0x49, 0xcc, 0xb5, 0x5d, 0x7d, 0x59, 0x04,
+	0x11, 0xe5, 0xc2, 0x8f, 0x86, 0xed, 0xec, 0x64, 0x54, 0x17, 0x7a, 0x23, 0xa6, 0x6c, 0xdb, 0xe9,
+	0x41, 0x2b, 0xbc, 0xdf, 0x6d, 0x98, 0x7b, 0x42, 0x39, 0xf7, 0x07, 0x14, 0xb7, 0xc0, 0x91, 0xdf,
+	0xb9, 0xa8, 0x81, 0x9a, 0x95, 0xb5, 0x7a, 0x4b, 0x83, 0x69, 0xc3, 0xdd, 0x51, 0xbf, 0xb5, 0x9d,
+	0x3a, 0x25, 0xca, 0x0e, 0xdf, 0x84, 0x62, 0xe8, 0xef, 0xd2, 0x90, 0xbb, 0x56, 0xc3, 0x6e, 0x56,
+	0xd6, 0x2e, 0xb5, 0xd2, 0x40, 0x5b, 0xc6, 0x65, 0xeb, 0xb1, 0xd2, 0x77, 0x62, 0xc1, 0x8e, 0x88,
+	0x31, 0xc6, 0x2b, 0x50, 0xe6, 0x54, 0xec, 0x51, 0xbf, 0x47, 0x99, 0x6b, 0x37, 0x50, 0xb3, 0x44,
+	0xc6, 0x17, 0x18, 0x83, 0x23, 0xe8, 0xa1, 0x70, 0x9d, 0x06, 0x6a, 0x96, 0x89, 0x3a, 0xe3, 0x87,
+	0xb0, 0xd8, 0x4d, 0x62, 0xc1, 0x92, 0x70, 0x27, 0xd2, 0x8e, 0xdd, 0x42, 0x03, 0x35, 0xab, 0x6b,
+	0x8d, 0x69, 0xc4, 0xfb, 0xda, 0xd0, 0x88, 0xa4, 0xda, 0x9d, 0x90, 0x71, 0x1d, 0x4a, 0x5b, 0x82,
+	0x51, 0x3f, 0x7a, 0xb8, 0xe1, 0x16, 0x15, 0x44, 0x89, 0x1b, 0x19, 0x9f, 0x87, 0xc2, 0xc3, 0xb8,
+	0x47, 0x0f, 0xdd, 0xb9, 0x06, 0x6a, 0x3a, 0xa4, 0x10, 0x48, 0xa1, 0x7e, 0x1b, 0x2a, 0xb9, 0x2c,
+	0x70, 0x0d, 0xec, 0x7d, 0x7a, 0xa4, 0x38, 0x2a, 0x13, 0x79, 0x94, 0x9f, 0x1d, 0xf8, 0xe1, 0x88,
+	0xba, 0x96, 0xba, 0xd3, 0xc2, 0x1d, 0xeb, 0x0b, 0xe4, 0x3d, 0x82, 0xea, 0x64, 0x38, 0xb8, 0x04,
+	0xce, 0xd3, 0xcd, 0xa7, 0x9d, 0xda, 0x19, 0x5c, 0x04, 0x6b, 0xf3, 0x51, 0x0d, 0xe1, 0x32, 0x14,
+	0x3a, 0x84, 0x6c, 0x92, 0x9a, 0x85, 0x17, 0xa0, 0xbc, 0xd5, 0xd9, 0x7e, 0xd0, 0x59, 0xdf, 0xe8,
+	0x90, 0x9a, 0xad, 0xc4, 0x6d, 0xd2, 0x59, 0x7f, 0xd2, 0x79, 0xba, 0x51, 0x73, 0x3c, 0x0f, 0xe0,
+	0x71, 0x32, 0xd8, 0x1a, 0x45, 0x91, 0xcf, 0x14, 0x68, 0x37, 0x19, 0xc5, 0x42, 0x05, 0x62, 0x13,
+	0x2d, 0x78, 0x5f, 0xc1, 0xbc, 0xb4, 0x51, 0x09, 0xad, 0x77, 0xf7, 0x27, 0xb2, 0x45, 0xaf, 0xcb,
+	0xd6, 0xca, 0x65, 0xeb, 0xfd, 0x82, 0xa0, 0xb2, 0xed, 0x07, 0x21, 0xa1, 0xcf, 0x47, 0x94, 0x0b,
+	0x69, 0xf5, 0x7c, 0x44, 0xd9, 0x51, 0x9a, 0x9c, 0x12, 0xa4, 0xdf, 0x5d, 0xbf, 0xbb, 0xdf, 0x0f,
+	0xc2, 0x50, 0x55, 0xd0, 0x26, 0x99, 0x8c, 0x57, 0xa1, 0xc0, 0x83, 0xb8, 0x4b, 0x5d, 0xe7, 0x8d,
+	0x6d, 0xa4, 0x0d, 0xf1, 0x12, 0x14, 0xbb, 0x23, 0xc6, 0x13, 0xa6, 0xaa, 0x5a, 0x26, 0x46, 0xfa,
+	0xc6, 0x29, 0xa1, 0x9a, 0x45, 0xec, 0xc8, 0x3f, 0xf4, 0xf6, 0x61, 0x41, 0x17, 0x21, 0x8d, 0xab,
+	0x05, 0x4e, 0x9f, 0x25, 0xd1, 0x69, 0x7a, 0x55, 0xda, 0xe1, 0x2b, 0x60, 0x89, 0xc4, 0xb5, 0xde,
+	0x68, 0x6d, 0x89, 0xc4, 0xbb, 0x0c, 0xd5, 0x14, 0x8c, 0x0f, 0x93, 0x98, 0x53, 0xc9, 0x42, 0xec,
+	0x47, 0x94, 0xbb, 0xa8, 0x61, 0x4b, 0x16, 0x94, 0xe0, 0xfd, 0x89, 0x00, 0x2b, 0xc3, 0x67, 0xb2,
+	0xe2, 0xef, 0x23, 0x34, 0xf9, 0x77, 0x48, 0x6c, 0x45, 0x7a, 0x99, 0xa8, 0xf3, 0xb8, 0x15, 0x9c,
+	0x5c, 0x2b, 0x48, 0x52, 0x87, 0x8c, 0xf6, 0x83, 0xc3, 0x94, 0x54, 0x2d, 0x49, 0x6b, 0x46, 0x07,
+	0xf4, 0xd0, 0x74, 0xbf, 0x16, 0xbc, 0x08, 0xce, 0x4d, 0x64, 0x62, 0xf2, 0x5e, 0x82, 0xa2, 0xea,
+	0xe6, 0x34, 0x71, 0x23, 0xe1, 0xcb, 0xb0, 0x28, 0x12, 0xe1, 0x87, 0x3b, 0x7b, 0x81, 0xd8, 0xd1,
+	0xe0, 0xba, 0x8b, 0x16, 0xd4, 0xf5, 0x83, 0x40, 0xdc, 0x4f, 0x83, 0x50, 0x5a, 0xee, 0xda, 0x0d,
+	0xbb, 0xe9, 0x10, 0x23, 0x79, 0xff, 0x20, 0x58, 0xd8, 0xa2, 0x3e, 0xeb, 0xee, 0xbd, 0x0f, 0xd2,
+	0xb2, 0x1e, 0xb6, 0xf3, 0x3d, 0x3c, 0x41, 0x9b, 0x93, 0xa3, 0x2d, 0xe9, 0xf7, 0x39, 0x15, 0x8a,
+	0x36, 0x87, 0x18, 0x09, 0xbb, 0x30, 0xc7, 0xe8, 0x01, 0x65, 0x9c, 0x2a, 0xe2, 0x4a, 0x24, 0x15,
+	0x73, 0xdd, 0x3b, 0x97, 0xef, 0x5e, 0xef, 0x25, 0x54, 0xd3, 0x14, 0x0d, 0x9b, 0xd7, 0xa0, 0x64,
+	0xc6, 0x97, 0xe6, 0xb3, 0xb2, 0x76, 0x76, 0x6a, 0x7e, 0x91, 0xcc, 0xe4, 0xad, 0x48, 0xd6, 0x01,
+	0xd8, 0x13, 0x01, 0xfc, 0x81, 0xa0, 0xb6, 0x3e, 0x18, 0x30, 0x3a, 0xf0, 0x05, 0xfd, 0x70, 0x3c,
+	0x5f, 0x03, 0x87, 0x0b, 0x3a, 0x34, 0xe3, 0x60, 0x79, 0xca, 0xc7, 0x86, 0x59, 0x48, 0x44, 0x99,
+	0x79, 0x4f, 0xa0, 0xf0, 0x6d, 0x12, 0xc4, 0xe2, 0xad, 0xb7, 0xd1, 0xc4, 0x18, 0x46, 0x66, 0x0c,
+	0x7b, 0xbf, 0x21, 0x28, 0x6e, 0x51, 0x16, 0x50, 0x8e, 0x3f, 0xcb, 0xd6, 0x95, 0x26, 0x7f, 0x65,
+	0x4c, 0xbe, 0xb6, 0x98, 0xb9, 0xad, 0x3e, 0x85, 0xe2, 0x50, 0xc6, 0x93, 0x2e, 0xb9, 0xc5, 0xf1,
+	0x57, 0x2a, 0x4e, 0x62, 0xd4, 0xef, 0xb2, 0x27, 0xee, 0xc2, 0xd9, 0x5c, 0xa1, 0x4c, 0xb7, 0x34,
+	0xa1, 0xc8, 0x55, 0x58, 0x26, 0xdc, 0xda, 0xc9, 0x70, 0x89, 0xd1, 0x7b, 0xaf, 0x10, 0x54, 0x3b,
+	0x87, 0xc3, 0xd0, 0x0f, 0xe2, 0x0f, 0x56, 0x66, 0xef, 0x1e, 0x54, 0x4c, 0x0c, 0xdb, 0x94, 0x45,
+	0x7a, 0x8d, 0xb3, 0xc8, 0xe4, 0xaf, 0xce, 0xf8, 0x63, 0xa8, 0xe8, 0x25, 0xbf, 0x93, 0xc4, 0xa1,
+	0xde, 0x28, 0x25, 0x02, 0xfa, 0x6a, 0x33, 0x0e, 0x8f, 0xbc, 0xbf, 0x50, 0xe6, 0xe4, 0xeb, 0x20,
+	0xa4, 0xf8, 0x22, 0x94, 0xf5, 0xba, 0xda, 0x09, 0x7a, 0x27, 0xf6, 0x57, 0x2f, 0x1b, 0x85, 0x56,
+	0x6e, 0x14, 0xde, 0xce, 0x4a, 0x6c, 0x2b, 0xce, 0x3e, 0x19, 0x73, 0x96, 0xf3, 0x3b, 0xb3, 0xce,
+	0x6a, 0x5e, 0x8e, 0x62, 0xda, 0x53, 0x8d, 0x5a, 0x22, 0x46, 0x7a, 0x97, 0xb2, 0xfe, 0x8a, 0x60,
+	0xde, 0xc0, 0x6e, 0xed, 0xf9, 0xac, 0x87, 0xab, 0x60, 0x65, 0x89, 0x58, 0x41, 0x4f, 0xad, 0x4a,
+	0xe1, 0x33, 0x71, 0x0a, 0xe2, 0xb5, 0xa1, 0x8c, 0xd2, 0xef, 0x8a, 0xe0, 0x80, 0x9a, 0x87, 0x93,
+	0x91, 0xf0, 0x55, 0x28, 0xf4, 0x83, 0x90, 0x72, 0xd7, 0x51, 0x79, 0x7f, 0x34, 0x33, 0x6f, 0xa2,
+	0x6d, 0xbc, 0x1f, 0x11, 0x2c, 0x66, 0xfd, 0x32, 0xde, 0x70, 0xba, 0xa8, 0x28, 0xff, 0xef, 0x5e,
+	0x85, 0x82, 0xac, 0x5c, 0xda, 0xfb, 0xd3, 0x6e, 0x65, 0xad, 0x89, 0xb6, 0xc1, 0x2d, 0x28, 0x72,
+	0x99, 0x66, 0x4a, 0xfe, 0xd2, 0x94, 0xb5, 0x62, 0x81, 0x18, 0xab, 0xb5, 0x9f, 0x4a, 0xfa, 0x45,
+	0x43, 0xd9, 0x41, 0xd0, 0xa5, 0xf8, 0x3b, 0x28, 0x67, 0x6f, 0x17, 0x3c, 0x3d, 0x18, 0xeb, 0xe7,
+	0xc7, 0x57, 0xe3, 0x77, 0x90, 0xb7, 0xfc, 0xea, 0xef, 0x7f, 0x7f, 0xb6, 0xce, 0x79, 0xd5, 0xf6,
+	0xc1, 0x75, 0xf9, 0x64, 0x6e, 0xeb, 0xfe, 0xb8, 0x83, 0xae, 0x34, 0x11, 0xfe, 0x12, 0x2a, 0x99,
+	0xcb, 0x67, 0x6b, 0xb3, 0x9c, 0x2e, 0x4d, 0x3a, 0x4d, 0x1f, 0x4e, 0xde, 0x99, 0x26, 0x5a, 0x45,
+	0xf8, 0x1e, 0xd8, 0x8f, 0x93, 0xc1, 0xe9, 0x83, 0xc1, 0x2a, 0x98, 0x79, 0x6f, 0xce, 0x04, 0x73,
+	0x07, 0x5d, 0xc1, 0x8f, 0xc0, 0x91, 0xef, 0x29, 0x9c, 0xe3, 0x2e, 0xf7, 0xbe, 0xaa, 0x4f, 0xfb,
+	0xf6, 0x2e, 0x28, 0x2f, 0x67, 0xf1, 0xa2, 0xf4, 0x22, 0xfc, 0x20, 0x34, 0x39, 0xad, 0x22, 0xcc,
+	0xa1, 0xa8, 0x77, 0x0a, 0xbe, 0x90, 0x9f, 0x06, 0xb9, 0x45, 0x5a, 0x77, 0xa7, 0x15, 0xba, 0xc4,
+	0xde, 0xe7, 0xca, 0xef, 0x2a, 0x6e, 0x49, 0xbf, 0x5c, 0xe9, 0xda, 0xc7, 0xf2, 0xef, 0x6f, 0x71,
+	0xda, 0x4d, 0xe2, 0x1e, 0x7f, 0xd1, 0x3e, 0x16, 0x49, 0x4e, 0x50, 0x3d, 0xf0, 0x02, 0x1f, 0xc3,
+	0xbc, 0xf6, 0x64, 0x6a, 0xf3, 0x5a, 0xe8, 0x19, 0xb9, 0xdc, 0x55, 0x98, 0xb7, 0xf0, 0xcd, 0xb7,
+	0xc3, 0x1c, 0x67, 0xbc, 0x0f, 0x45, 0xfd, 0xfb, 0xe5, 0x61, 0x27, 0x9e, 0x82, 0x75, 0x77, 0x5a,
+	0x61, 0x32, 0x6e, 0x29, 0xf4, 0x26, 0xbe, 0xac, 0xea, 0xa1, 0x74, 0xff, 0x87, 0x8e, 0x7f, 0x40,
+	0x50, 0xc9, 0x3d, 0x83, 0xf0, 0xca, 0x09, 0xcf, 0x13, 0xef, 0xbc, 0xfa, 0xa5, 0xd7, 0x68, 0x0d,
+	0xf8, 0x4d, 0x05, 0xde, 0xc6, 0xd7, 0x4e, 0x07, 0xde, 0x3e, 0x96, 0x13, 0xec, 0x05, 0x7e, 0x09,
+	0xe5, 0x6c, 0x17, 0xe0, 0xfa, 0x18, 0xe2, 0xe4, 0x26, 0xaf, 0x5f, 0x9c, 0xa9, 0x33, 0xe0, 0xb7,
+	0x15, 0xf8, 0x0d, 0x7c, 0x5d, 0x82, 0xfb, 0xa9, 0xfa, 0x54, 0xe5, 0xfe, 0x1e, 0xe6, 0xcc, 0xef,
+	0x8a, 0xdd, 0xa9, 0x3f, 0x38, 0x05, 0x5f, 0x9e, 0xa1, 0x31, 0xd0, 0xb7, 0x14, 0xf4, 0x75, 0xdc,
+	0x96, 0xd0, 0x54, 0x2b, 0x4f, 0x03, 0xbc, 0x5b, 0x54, 0x63, 0xef, 0xc6, 0x7f, 0x03, 0x00, 0xe3,
+	0x35, 0x0c, 0xd0, 0x09, 0x0f, 0x00, 0x00,
 }
@@ -21,6 +21,10 @@ import (
 	"github.com/oklog/ulid"
 )
 
+// CursorTrailer is the name of the gRPC trailer holding the cursor of
+// the last message sent by SearchStream.
+const CursorTrailer = "logspray-cursor"
+
 // Copy  copies a message into an existing message struct
 // It will allocate a labelset if none
 func (m *Message) Copy() (tm *Message) {
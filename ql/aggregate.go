@@ -0,0 +1,689 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package ql
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QubitProducts/logspray/proto/logspray"
+	"github.com/golang/protobuf/ptypes"
+)
+
+// maxPoints limits the number of points in each series of an
+// aggregation.
+const maxPoints = 11000
+
+var rangeFuncs = map[string]bool{
+	"count_over_time": true,
+	"rate":            true,
+}
+
+var vectorFuncs = map[string]bool{
+	"sum":   true,
+	"min":   true,
+	"max":   true,
+	"avg":   true,
+	"count": true,
+	"topk":  true,
+}
+
+// aggrExpr is a node in a parsed aggregation. The leaf of the tree
+// is always a single rangeAggr.
+type aggrExpr interface {
+	String() string
+}
+
+// rangeAggr counts the messages selected by a query over a range
+// of time starting at each point.
+type rangeAggr struct {
+	fn    string
+	query *Query
+	rng   time.Duration
+	by    []string
+}
+
+func (e rangeAggr) String() string {
+	if e.rng == 0 {
+		// A plain query, counted at each step
+		return e.query.String()
+	}
+	return fmt.Sprintf("%s({%s} [%s])%s", e.fn, e.query, e.rng, byString(e.by))
+}
+
+// vectorAggr combines the series of an inner aggregation.
+type vectorAggr struct {
+	fn    string
+	by    []string
+	inner aggrExpr
+}
+
+func (e vectorAggr) String() string {
+	if len(e.by) == 0 {
+		return fmt.Sprintf("%s(%s)", e.fn, e.inner)
+	}
+	return fmt.Sprintf("%s%s (%s)", e.fn, byString(e.by), e.inner)
+}
+
+// topkAggr keeps the k largest values at each point.
+type topkAggr struct {
+	k     int
+	inner aggrExpr
+}
+
+func (e topkAggr) String() string {
+	return fmt.Sprintf("topk(%d, %s)", e.k, e.inner)
+}
+
+func byString(by []string) string {
+	if len(by) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" by (%s)", strings.Join(by, ", "))
+}
+
+// aggrParser reads aggregation expressions. The selector of the
+// range function is passed on to the query parser.
+type aggrParser struct {
+	src string
+	pos int
+}
+
+func (p *aggrParser) errorf(format string, args ...interface{}) error {
+	return Error{err: fmt.Errorf(format, args...), tok: p.tokenAt(p.pos)}
+}
+
+// tokenAt returns a token giving the line and column of pos.
+func (p *aggrParser) tokenAt(pos int) Token {
+	tok := Token{Line: 1, Col: pos + 1}
+	if i := strings.LastIndexByte(p.src[:pos], '\n'); i != -1 {
+		tok.Line += strings.Count(p.src[:pos], "\n")
+		tok.Col = pos - i
+	}
+	return tok
+}
+
+func (p *aggrParser) skipSpace() {
+	for p.pos < len(p.src) && (isSpace(rune(p.src[p.pos])) || isEndOfLine(rune(p.src[p.pos]))) {
+		p.pos++
+	}
+}
+
+// peek returns the next non space character, or 0 at the end of
+// the input.
+func (p *aggrParser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *aggrParser) expect(c byte) error {
+	if n := p.peek(); n != c {
+		if n == 0 {
+			return p.errorf("expected %q, got EOF", c)
+		}
+		return p.errorf("expected %q, got %q", c, n)
+	}
+	p.pos++
+	return nil
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (p *aggrParser) ident() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && isIdentChar(p.src[p.pos]) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// keyword consumes the next identifier if it is kw.
+func (p *aggrParser) keyword(kw string) bool {
+	pos := p.pos
+	if strings.EqualFold(p.ident(), kw) {
+		return true
+	}
+	p.pos = pos
+	return false
+}
+
+// BY: "by" "(" LABEL { "," LABEL } ")"
+func (p *aggrParser) readBy() ([]string, error) {
+	if !p.keyword("by") {
+		return nil, nil
+	}
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	var ls []string
+	for {
+		l := p.ident()
+		if l == "" {
+			return nil, p.errorf("expected a label name")
+		}
+		ls = append(ls, l)
+		if p.peek() != ',' {
+			break
+		}
+		p.pos++
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	return ls, nil
+}
+
+// readSelector reads a query in braces, up to the matching close
+// brace. Braces in quoted strings, or balanced braces in regexps,
+// do not end the selector.
+func (p *aggrParser) readSelector() (*Query, error) {
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
+	start := p.pos
+	depth := 0
+	var quote byte
+Loop:
+	for ; p.pos < len(p.src); p.pos++ {
+		c := p.src[p.pos]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"', c == '\'', c == '`':
+			quote = c
+		case c == '{':
+			depth++
+		case c == '}':
+			if depth == 0 {
+				break Loop
+			}
+			depth--
+		}
+	}
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("expected '}', got EOF")
+	}
+	str := p.src[start:p.pos]
+	p.pos++
+
+	q, err := Parse(str)
+	if err != nil {
+		// Report the position within the whole aggregation
+		perr := err.(Error)
+		tok := p.tokenAt(start)
+		if perr.tok.Line == 1 {
+			perr.tok.Col += tok.Col - 1
+		}
+		perr.tok.Line += tok.Line - 1
+		return nil, perr
+	}
+	return q, nil
+}
+
+// readRange reads a duration in square brackets.
+func (p *aggrParser) readRange() (time.Duration, error) {
+	if err := p.expect('['); err != nil {
+		return 0, err
+	}
+	end := strings.IndexByte(p.src[p.pos:], ']')
+	if end == -1 {
+		return 0, p.errorf("expected ']', got EOF")
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(p.src[p.pos : p.pos+end]))
+	if err != nil {
+		return 0, p.errorf("bad range, %v", err)
+	}
+	if d <= 0 {
+		return 0, p.errorf("range must be positive")
+	}
+	p.pos += end + 1
+	return d, nil
+}
+
+// AGGR: RANGEFN "(" "{" PIPELINE "}" "[" DURATION "]" ")" [BY]
+//     | VECFN [BY] "(" AGGR ")" [BY]
+//     | "topk" "(" NUMBER "," AGGR ")"
+func (p *aggrParser) readAggr() (aggrExpr, error) {
+	start := p.pos
+	fn := strings.ToLower(p.ident())
+	switch {
+	case fn == "topk":
+		if err := p.expect('('); err != nil {
+			return nil, err
+		}
+		nstr := p.ident()
+		k, err := strconv.Atoi(nstr)
+		if err != nil || k <= 0 {
+			return nil, p.errorf("expected a positive count, got %q", nstr)
+		}
+		if err := p.expect(','); err != nil {
+			return nil, err
+		}
+		inner, err := p.readAggr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+		return topkAggr{k: k, inner: inner}, nil
+	case rangeFuncs[fn]:
+		if err := p.expect('('); err != nil {
+			return nil, err
+		}
+		q, err := p.readSelector()
+		if err != nil {
+			return nil, err
+		}
+		rng, err := p.readRange()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+		by, err := p.readBy()
+		if err != nil {
+			return nil, err
+		}
+		return rangeAggr{fn: fn, query: q, rng: rng, by: by}, nil
+	case vectorFuncs[fn]:
+		by, err := p.readBy()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect('('); err != nil {
+			return nil, err
+		}
+		inner, err := p.readAggr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+		if by == nil {
+			if by, err = p.readBy(); err != nil {
+				return nil, err
+			}
+		}
+		return vectorAggr{fn: fn, by: by, inner: inner}, nil
+	default:
+		p.pos = start
+		return nil, p.errorf("expected aggregation function, got %q", fn)
+	}
+}
+
+// isAggregation reports whether a query string starts with an
+// aggregation function.
+func isAggregation(qstr string) bool {
+	p := &aggrParser{src: qstr}
+	fn := strings.ToLower(p.ident())
+	if !rangeFuncs[fn] && !vectorFuncs[fn] {
+		return false
+	}
+	return p.peek() == '(' || p.keyword("by")
+}
+
+// readAggregation reads a complete aggregation expression.
+func (p *aggrParser) readAggregation() (aggrExpr, error) {
+	e, err := p.readAggr()
+	if err != nil {
+		return nil, err
+	}
+	if c := p.peek(); c != 0 {
+		return nil, p.errorf("unexpected %q", c)
+	}
+	return e, nil
+}
+
+// leaf returns the range aggregation at the bottom of an aggregation
+// expression.
+func leaf(e aggrExpr) rangeAggr {
+	for {
+		switch te := e.(type) {
+		case rangeAggr:
+			return te
+		case vectorAggr:
+			e = te.inner
+		case topkAggr:
+			e = te.inner
+		}
+	}
+}
+
+// Aggregation is a compiled aggregation query.
+type Aggregation struct {
+	// Pipeline selects, and transforms, the messages to be
+	// aggregated.
+	Pipeline *Pipeline
+
+	expr aggrExpr
+}
+
+// CompileAggregation parses an aggregation query such as
+//
+//   sum by (status) (rate({job=api | json} [5m]))
+//
+// A query that does not start with an aggregation function is
+// treated as a count of the matching messages at each step.
+func CompileAggregation(qstr string) (*Aggregation, error) {
+	var e aggrExpr
+	if isAggregation(qstr) {
+		p := &aggrParser{src: qstr}
+		var err error
+		if e, err = p.readAggregation(); err != nil {
+			return nil, fmt.Errorf("failed to read aggregation, %w", err)
+		}
+	} else {
+		q, err := Parse(qstr)
+		if err != nil {
+			return nil, fmt.Errorf("failed ot read query, %w", err)
+		}
+		// A zero range is replaced by the step when evaluated
+		e = rangeAggr{fn: "count_over_time", query: q}
+	}
+
+	pl, err := leaf(e).query.Compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Aggregation{Pipeline: pl, expr: e}, nil
+}
+
+func (a *Aggregation) String() string {
+	return a.expr.String()
+}
+
+// series is a series of values, one per step, NaN where there is
+// no value.
+type series struct {
+	labels map[string]string
+	vals   []float64
+}
+
+// Aggregator accumulates the messages for a single evaluation of an
+// Aggregation.
+type Aggregator struct {
+	expr  aggrExpr
+	leaf  rangeAggr
+	start time.Time
+	step  time.Duration
+	n     int
+
+	// counts hold the differences between the counts at each
+	// step, indexed by the series key.
+	counts map[string]*series
+}
+
+// Aggregator creates an Aggregator for points at each step from from,
+// until to.
+func (a *Aggregation) Aggregator(from, to time.Time, step time.Duration) (*Aggregator, error) {
+	if step <= 0 {
+		return nil, fmt.Errorf("step must be positive")
+	}
+	start := from.Truncate(step)
+	n := int((to.Sub(start) + step - 1) / step)
+	if n > maxPoints {
+		return nil, fmt.Errorf("too many points, %d, increase the step", n)
+	}
+	if n < 0 {
+		n = 0
+	}
+
+	l := leaf(a.expr)
+	if l.rng == 0 {
+		l.rng = step
+	}
+
+	ag := &Aggregator{
+		expr:   a.expr,
+		leaf:   l,
+		start:  start,
+		step:   step,
+		n:      n,
+		counts: map[string]*series{},
+	}
+	if len(l.by) == 0 {
+		// Without a grouping there is always a single series, even
+		// if no messages match.
+		ag.counts[""] = &series{labels: map[string]string{}, vals: make([]float64, n+1)}
+	}
+	return ag, nil
+}
+
+// SearchRange returns the range of times that messages must be
+// searched for. The range window for each point starts at the
+// point itself.
+func (ag *Aggregator) SearchRange() (time.Time, time.Time) {
+	return ag.start, ag.start.Add(time.Duration(ag.n-1)*ag.step + ag.leaf.rng)
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// Add counts a message, the message should include the labels
+// from its stream header.
+func (ag *Aggregator) Add(m *logspray.Message) {
+	t, err := ptypes.Timestamp(m.Time)
+	if err != nil {
+		return
+	}
+	// m counts towards the points at t_k where
+	//   t_k <= t < t_k + range
+	off := int64(t.Sub(ag.start))
+	kmax := floorDiv(off, int64(ag.step))
+	kmin := floorDiv(off-int64(ag.leaf.rng), int64(ag.step)) + 1
+	if kmin < 0 {
+		kmin = 0
+	}
+	if kmax >= int64(ag.n) {
+		kmax = int64(ag.n) - 1
+	}
+	if kmin > kmax {
+		return
+	}
+
+	key, ls := groupLabels(ag.leaf.by, m.Labels)
+	s, ok := ag.counts[key]
+	if !ok {
+		s = &series{labels: ls, vals: make([]float64, ag.n+1)}
+		ag.counts[key] = s
+	}
+	s.vals[kmin]++
+	s.vals[kmax+1]--
+}
+
+// groupLabels returns the labels in by, and a key that identifies
+// them.
+func groupLabels(by []string, ls map[string]string) (string, map[string]string) {
+	gls := map[string]string{}
+	var key []string
+	for _, l := range by {
+		if v, ok := ls[l]; ok {
+			gls[l] = v
+			key = append(key, fmt.Sprintf("%s=%q", l, v))
+		}
+	}
+	return strings.Join(key, ","), gls
+}
+
+// Series returns the result of the aggregation.
+func (ag *Aggregator) Series() []*logspray.Series {
+	var ss []*series
+	for _, c := range ag.counts {
+		s := &series{labels: c.labels, vals: make([]float64, ag.n)}
+		v := 0.0
+		for i := 0; i < ag.n; i++ {
+			v += c.vals[i]
+			s.vals[i] = v
+			if ag.leaf.fn == "rate" {
+				s.vals[i] = v / ag.leaf.rng.Seconds()
+			}
+		}
+		ss = append(ss, s)
+	}
+
+	ss = ag.eval(ag.expr, ss)
+
+	var res []*logspray.Series
+	for _, s := range ss {
+		ps := &logspray.Series{Labels: s.labels}
+		for i, v := range s.vals {
+			if math.IsNaN(v) {
+				continue
+			}
+			t, _ := ptypes.TimestampProto(ag.start.Add(time.Duration(i) * ag.step))
+			ps.Points = append(ps.Points, &logspray.Point{Time: t, Value: v})
+		}
+		if len(ps.Points) == 0 {
+			continue
+		}
+		res = append(res, ps)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		ki, _ := groupLabels(sortedKeys(res[i].Labels), res[i].Labels)
+		kj, _ := groupLabels(sortedKeys(res[j].Labels), res[j].Labels)
+		return ki < kj
+	})
+
+	return res
+}
+
+func sortedKeys(ls map[string]string) []string {
+	var ks []string
+	for k := range ls {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+func (ag *Aggregator) eval(e aggrExpr, ss []*series) []*series {
+	switch e := e.(type) {
+	case vectorAggr:
+		return evalVector(e.fn, e.by, ag.eval(e.inner, ss), ag.n)
+	case topkAggr:
+		return evalTopk(e.k, ag.eval(e.inner, ss), ag.n)
+	default:
+		return ss
+	}
+}
+
+// evalVector combines the values of series with the same labels in
+// by at each point.
+func evalVector(fn string, by []string, ss []*series, n int) []*series {
+	groups := map[string][]*series{}
+	gls := map[string]map[string]string{}
+	for _, s := range ss {
+		key, ls := groupLabels(by, s.labels)
+		groups[key] = append(groups[key], s)
+		gls[key] = ls
+	}
+
+	var res []*series
+	for key, g := range groups {
+		out := &series{labels: gls[key], vals: make([]float64, n)}
+		for i := 0; i < n; i++ {
+			var vs []float64
+			for _, s := range g {
+				if !math.IsNaN(s.vals[i]) {
+					vs = append(vs, s.vals[i])
+				}
+			}
+			out.vals[i] = combine(fn, vs)
+		}
+		res = append(res, out)
+	}
+	return res
+}
+
+func combine(fn string, vs []float64) float64 {
+	if len(vs) == 0 {
+		return math.NaN()
+	}
+	switch fn {
+	case "count":
+		return float64(len(vs))
+	case "min":
+		r := vs[0]
+		for _, v := range vs[1:] {
+			r = math.Min(r, v)
+		}
+		return r
+	case "max":
+		r := vs[0]
+		for _, v := range vs[1:] {
+			r = math.Max(r, v)
+		}
+		return r
+	}
+
+	sum := 0.0
+	for _, v := range vs {
+		sum += v
+	}
+	if fn == "avg" {
+		return sum / float64(len(vs))
+	}
+	return sum
+}
+
+// evalTopk keeps the k largest values at each point, the values of
+// other series at that point are dropped.
+func evalTopk(k int, ss []*series, n int) []*series {
+	res := make([]*series, len(ss))
+	for i, s := range ss {
+		res[i] = &series{labels: s.labels, vals: make([]float64, n)}
+	}
+
+	idx := make([]int, len(ss))
+	for i := 0; i < n; i++ {
+		idx = idx[:0]
+		for j, s := range ss {
+			res[j].vals[i] = math.NaN()
+			if !math.IsNaN(s.vals[i]) {
+				idx = append(idx, j)
+			}
+		}
+		sort.SliceStable(idx, func(a, b int) bool {
+			return ss[idx[a]].vals[i] > ss[idx[b]].vals[i]
+		})
+		for j := 0; j < len(idx) && j < k; j++ {
+			res[idx[j]].vals[i] = ss[idx[j]].vals[i]
+		}
+	}
+	return res
+}
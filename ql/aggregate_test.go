@@ -0,0 +1,188 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package ql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QubitProducts/logspray/proto/logspray"
+	"github.com/golang/protobuf/ptypes"
+)
+
+func TestAggregation_Parse(t *testing.T) {
+	tests := []struct {
+		src string
+		exp string
+		err string
+	}{
+		{`job=api`, `job="api"`, ``},
+		{`count_over_time({job=api} [1m])`, `count_over_time({job="api"} [1m0s])`, ``},
+		{`count_over_time({job=api} [1m]) by (status)`, `count_over_time({job="api"} [1m0s]) by (status)`, ``},
+		{`rate({job=api | json | status>=500}[5m])`, `rate({job="api" | json | status>="500"} [5m0s])`, ``},
+		{`sum by (status) (rate({job=api} [1m]) by (status, path))`, `sum by (status) (rate({job="api"} [1m0s]) by (status, path))`, ``},
+		{`sum(rate({job=api} [1m]) by (status)) by (status)`, `sum by (status) (rate({job="api"} [1m0s]) by (status))`, ``},
+		{`topk(5, count_over_time({job~"a{2}" msg="}"} [1m]) by (path))`, `topk(5, count_over_time({job~"a{2}" and msg="}"} [1m0s]) by (path))`, ``},
+		{`count_over_time({job=api})`, ``, `column 26, expected '[', got ')'`},
+		{`count_over_time({job=api} [soon])`, ``, `column 28, bad range, time: invalid duration "soon"`},
+		{`count_over_time({job=api [1m])`, ``, `column 31, expected '}', got EOF`},
+		{`count_over_time({job=} [1m])`, ``, `column 22, expected a label value`},
+		{`topk(x, count_over_time({job=api} [1m]))`, ``, `column 7, expected a positive count, got "x"`},
+		{`sum(job=api)`, ``, `column 5, expected aggregation function, got "job"`},
+		{`sum(count_over_time({job=api} [1m])) extra`, ``, `column 38, unexpected 'e'`},
+	}
+
+	for i, st := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a, err := CompileAggregation(st.src)
+			if err != nil {
+				if st.err == "" || !strings.HasSuffix(err.Error(), st.err) {
+					t.Fatalf("\nexpected err: %#v\ngot: %#v", st.err, err.Error())
+				}
+				return
+			}
+			if st.err != "" {
+				t.Fatalf("\nexpected err:  %#v", st.err)
+			}
+
+			if str := a.String(); str != st.exp {
+				t.Fatalf("\nexpected: %#v\ngot: %#v", st.exp, str)
+			}
+		})
+	}
+}
+
+func TestAggregation_Series(t *testing.T) {
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	msgs := []struct {
+		offset time.Duration
+		status string
+		path   string
+	}{
+		{10 * time.Second, "200", "/"},
+		{20 * time.Second, "500", "/"},
+		{70 * time.Second, "200", "/login"},
+		{80 * time.Second, "200", "/"},
+		{90 * time.Second, "404", "/login"},
+		{150 * time.Second, "200", "/"},
+		{300 * time.Second, "200", "/"},
+	}
+
+	tests := []struct {
+		q   string
+		exp string
+	}{
+		{
+			`job=api`,
+			`{} 2 3 1`,
+		},
+		{
+			`count_over_time({job=api} [2m])`,
+			`{} 5 4 1`,
+		},
+		{
+			`rate({job=api} [1m])`,
+			`{} 0.03333333333333333 0.05 0.016666666666666666`,
+		},
+		{
+			`count_over_time({job=api} [1m]) by (status)`,
+			`{status="200"} 1 2 1;{status="404"} 0 1 0;{status="500"} 1 0 0`,
+		},
+		{
+			`sum by (path) (count_over_time({job=api} [1m]) by (status, path))`,
+			`{path="/"} 2 1 1;{path="/login"} 0 2 0`,
+		},
+		{
+			`count(count_over_time({job=api} [1m]) by (status))`,
+			`{} 3 3 3`,
+		},
+		{
+			`max(count_over_time({job=api} [1m]) by (status))`,
+			`{} 1 2 1`,
+		},
+		{
+			`topk(1, count_over_time({job=api} [1m]) by (path))`,
+			`{path="/"} 2 _ 1;{path="/login"} _ 2 _`,
+		},
+		{
+			`count_over_time({job=api status=500} [1m])`,
+			`{} 1 0 0`,
+		},
+		{
+			`count_over_time({job=other} [1m]) by (status)`,
+			``,
+		},
+	}
+
+	for i, st := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a, err := CompileAggregation(st.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ag, err := a.Aggregator(start, start.Add(3*time.Minute), time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			hdr := &logspray.Message{Labels: map[string]string{"job": "api"}}
+			for _, mi := range msgs {
+				ts, _ := ptypes.TimestampProto(start.Add(mi.offset))
+				m := &logspray.Message{
+					Time: ts,
+					Labels: map[string]string{
+						"job":    "api",
+						"status": mi.status,
+						"path":   mi.path,
+					},
+				}
+				if !a.Pipeline.Match(hdr, m, false) {
+					continue
+				}
+				ag.Add(m)
+			}
+
+			var strs []string
+			for _, s := range ag.Series() {
+				var ls []string
+				for _, k := range sortedKeys(s.Labels) {
+					ls = append(ls, fmt.Sprintf("%s=%q", k, s.Labels[k]))
+				}
+				str := "{" + strings.Join(ls, ",") + "}"
+				pt := 0
+				for i := 0; i < 3; i++ {
+					t := start.Add(time.Duration(i) * time.Minute)
+					if pt < len(s.Points) {
+						if pts, _ := ptypes.Timestamp(s.Points[pt].Time); pts.Equal(t) {
+							str += fmt.Sprintf(" %v", s.Points[pt].Value)
+							pt++
+							continue
+						}
+					}
+					str += " _"
+				}
+				strs = append(strs, str)
+			}
+
+			if got := strings.Join(strs, ";"); got != st.exp {
+				t.Fatalf("\nexpected: %#v\ngot: %#v", st.exp, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package ql
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Query is a parsed query, the expression selecting messages and
+// the pipeline stages they are then passed through. The String
+// method returns the query in a canonical form that will parse
+// back to the same Query.
+type Query struct {
+	Expr   Expr
+	Stages []Stage
+}
+
+// Parse parses a query string, including any pipeline stages.
+// Syntax errors are returned as an Error, giving the position of
+// the problem in the query.
+func Parse(qstr string) (*Query, error) {
+	p := newParser(newScanner(bytes.NewBuffer([]byte(qstr))))
+
+	q, err := p.readPipeline()
+	if err != nil {
+		return nil, p.errorf("%w", err)
+	}
+	return q, nil
+}
+
+func (q Query) String() string {
+	str := ""
+	if q.Expr != nil {
+		str = q.Expr.String()
+	}
+	for i := range q.Stages {
+		str += " | " + q.Stages[i].String()
+	}
+	return strings.TrimSpace(str)
+}
+
+// Expr is a node in a parsed query expression, one of Term,
+// TextTerm, And, Or, or Not.
+type Expr interface {
+	String() string
+	isExpr()
+}
+
+// Term matches the value of a label.
+type Term struct {
+	Label string
+	Op    string
+	Value string
+}
+
+func (Term) isExpr() {}
+
+func (e Term) String() string {
+	return formatLabel(e.Label) + e.Op + quote(e.Value)
+}
+
+// TextTerm matches the message text. An empty Op is a plain
+// substring match, as used for bare words and phrases.
+type TextTerm struct {
+	Op    string
+	Value string
+}
+
+func (TextTerm) isExpr() {}
+
+func (e TextTerm) String() string {
+	return e.Op + quote(e.Value)
+}
+
+// And matches if all of its expressions match. An empty And
+// matches everything.
+type And []Expr
+
+func (And) isExpr() {}
+
+func (e And) String() string {
+	strs := make([]string, len(e))
+	for i := range e {
+		strs[i] = group(e[i], func(e Expr) bool {
+			switch e.(type) {
+			case And, Or:
+				return true
+			}
+			return false
+		})
+	}
+	return strings.Join(strs, " and ")
+}
+
+// Or matches if any of its expressions match.
+type Or []Expr
+
+func (Or) isExpr() {}
+
+func (e Or) String() string {
+	strs := make([]string, len(e))
+	for i := range e {
+		strs[i] = group(e[i], func(e Expr) bool {
+			_, ok := e.(Or)
+			return ok
+		})
+	}
+	return strings.Join(strs, " or ")
+}
+
+// Not matches if its expression does not.
+type Not struct {
+	Expr Expr
+}
+
+func (Not) isExpr() {}
+
+func (e Not) String() string {
+	return "not " + group(e.Expr, func(e Expr) bool {
+		switch e.(type) {
+		case And, Or:
+			return true
+		}
+		return false
+	})
+}
+
+// group formats e, in parentheses if needs reports that it would
+// otherwise parse differently.
+func group(e Expr, needs func(Expr) bool) string {
+	if needs(e) {
+		return "(" + e.String() + ")"
+	}
+	return e.String()
+}
+
+// Stage is a single stage of a query pipeline, one of
+// ParserStage, RegexStage, LineFormatStage, or FilterStage.
+type Stage interface {
+	String() string
+	isStage()
+}
+
+// ParserStage extracts labels from the message text. Format is
+// either json or logfmt.
+type ParserStage struct {
+	Format string
+}
+
+func (ParserStage) isStage() {}
+
+func (s ParserStage) String() string {
+	return s.Format
+}
+
+// RegexStage extracts the named groups of a regexp match against
+// the message text as labels.
+type RegexStage struct {
+	Regex string
+}
+
+func (RegexStage) isStage() {}
+
+func (s RegexStage) String() string {
+	return "regex " + quote(s.Regex)
+}
+
+// LineFormatStage replaces the message text by executing a template
+// against the message labels.
+type LineFormatStage struct {
+	Template string
+}
+
+func (LineFormatStage) isStage() {}
+
+func (s LineFormatStage) String() string {
+	return "line_format " + quote(s.Template)
+}
+
+// FilterStage drops messages that do not match an expression.
+type FilterStage struct {
+	Expr Expr
+}
+
+func (FilterStage) isStage() {}
+
+func (s FilterStage) String() string {
+	return s.Expr.String()
+}
+
+// reserved words can only be used as labels if they are quoted.
+var reserved = map[string]bool{
+	"and":         true,
+	"or":          true,
+	"not":         true,
+	"json":        true,
+	"logfmt":      true,
+	"regex":       true,
+	"line_format": true,
+}
+
+// formatLabel returns a label as a bare word, if it would be read
+// back as one, or quoted otherwise.
+func formatLabel(s string) string {
+	if s == "" || reserved[strings.ToLower(s)] || strings.ContainsAny(s[:1], "\"'`") {
+		return quote(s)
+	}
+	for _, r := range s {
+		if !isAlphaNumeric(r) {
+			return quote(s)
+		}
+	}
+	return s
+}
+
+// quote quotes s using the first quote character that s does
+// not contain.
+func quote(s string) string {
+	for _, q := range []string{`"`, `'`, "`"} {
+		if !strings.Contains(s, q) {
+			return q + s + q
+		}
+	}
+	return `"` + s + `"`
+}
+
+// TermInfo describes a single term of a query.
+type TermInfo struct {
+	Term string
+	// Prunable is true if the term can rule out whole files using
+	// only the labels in their stream headers, without reading
+	// the messages.
+	Prunable bool
+}
+
+// Terms lists the terms of the query, the terms of any filter
+// stages included.
+func (q Query) Terms() []TermInfo {
+	var ts []TermInfo
+	if q.Expr != nil {
+		ts = appendTerms(ts, q.Expr, true)
+	}
+	for i := range q.Stages {
+		// Filter stages are only applied to messages, after
+		// the earlier stages.
+		if s, ok := q.Stages[i].(FilterStage); ok {
+			ts = appendTerms(ts, s.Expr, false)
+		}
+	}
+	return ts
+}
+
+func appendTerms(ts []TermInfo, e Expr, prunable bool) []TermInfo {
+	switch e := e.(type) {
+	case Term:
+		ts = append(ts, TermInfo{Term: e.String(), Prunable: prunable && e.Label != "__text__"})
+	case TextTerm:
+		ts = append(ts, TermInfo{Term: e.String()})
+	case And:
+		for i := range e {
+			ts = appendTerms(ts, e[i], prunable)
+		}
+	case Or:
+		for i := range e {
+			ts = appendTerms(ts, e[i], prunable && canPrune(e))
+		}
+	case Not:
+		ts = appendTerms(ts, e.Expr, prunable && canPrune(e))
+	}
+	return ts
+}
+
+// canPrune reports whether e can ever reject a file when matched
+// against the file's header alone.
+func canPrune(e Expr) bool {
+	switch e := e.(type) {
+	case Term:
+		return e.Label != "__text__"
+	case And:
+		for i := range e {
+			if canPrune(e[i]) {
+				return true
+			}
+		}
+		return false
+	case Or:
+		for i := range e {
+			if !canPrune(e[i]) {
+				return false
+			}
+		}
+		return len(e) > 0
+	case Not:
+		ls := exprLabels(e.Expr)
+		for _, l := range ls {
+			if l == "__text__" {
+				return false
+			}
+		}
+		return len(ls) > 0
+	default:
+		return false
+	}
+}
@@ -14,7 +14,6 @@
 package ql
 
 import (
-	"bytes"
 	"fmt"
 
 	"github.com/QubitProducts/logspray/proto/logspray"
@@ -27,14 +26,18 @@ import (
 // files before filtering individual messages.
 type MatchFunc func(hdr, m *logspray.Message, headerOnly bool) bool
 
+func makeTextMatch(op op) (MatchFunc, error) {
+	return func(hdr, m *logspray.Message, headerOnly bool) bool {
+		if headerOnly {
+			return true
+		}
+		return op.match(m.Text)
+	}, nil
+}
+
 func makeLabelMatch(op op, label string) (MatchFunc, error) {
 	if label == "__text__" {
-		return func(hdr, m *logspray.Message, headerOnly bool) bool {
-			if headerOnly {
-				return true
-			}
-			return op.match(m.Text)
-		}, nil
+		return makeTextMatch(op)
 	}
 	return func(hdr, m *logspray.Message, headerOnly bool) bool {
 		if rv, ok := hdr.Labels[label]; ok {
@@ -76,36 +79,81 @@ func makeDisjunctionMatch(fs ...MatchFunc) (MatchFunc, error) {
 	}, nil
 }
 
-func Compile(qstr string) (MatchFunc, error) {
-	s := newScanner(bytes.NewBuffer([]byte(qstr)))
-	p := newParser(s)
-
-	qts, err := p.readQueryTerms()
-	if err != nil {
-		return nil, fmt.Errorf("failed ot read query, %w", err)
-	}
-
-	terms := []MatchFunc{}
+// makeNegationMatch inverts the result of f. When matching in headerOnly
+// mode, f can only be trusted to reject a file if every label it
+// depends on is present in the header. If any are missing we can't
+// know how f would match the individual messages, so must accept the
+// file.
+func makeNegationMatch(f MatchFunc, labels []string) (MatchFunc, error) {
+	return func(hdr, m *logspray.Message, headerOnly bool) bool {
+		if headerOnly {
+			for _, l := range labels {
+				if _, ok := hdr.Labels[l]; !ok || l == "__text__" {
+					return true
+				}
+			}
+		}
+		return !f(hdr, m, headerOnly)
+	}, nil
+}
 
-	labelMatches := map[string][]MatchFunc{}
-	for _, a := range qts {
-		currts := labelMatches[a.label]
-		mf, err := makeLabelMatch(a.operator, a.label)
+func compileExpr(e Expr) (MatchFunc, error) {
+	switch e := e.(type) {
+	case Term:
+		op, err := buildOp(e.Op, e.Value)
 		if err != nil {
 			return nil, err
 		}
-
-		labelMatches[a.label] = append(currts, mf)
+		return makeLabelMatch(op, e.Label)
+	case TextTerm:
+		op, err := buildOp(e.Op, e.Value)
+		if err != nil {
+			return nil, err
+		}
+		return makeTextMatch(op)
+	case And:
+		fs, err := compileExprs(e)
+		if err != nil {
+			return nil, err
+		}
+		return makeConjunctionMatch(fs...)
+	case Or:
+		fs, err := compileExprs(e)
+		if err != nil {
+			return nil, err
+		}
+		return makeDisjunctionMatch(fs...)
+	case Not:
+		f, err := compileExpr(e.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return makeNegationMatch(f, exprLabels(e.Expr))
+	default:
+		return nil, fmt.Errorf("unknown query expression %T", e)
 	}
+}
 
-	for _, lqs := range labelMatches {
-		lq, err := makeDisjunctionMatch(lqs...)
+func compileExprs(es []Expr) ([]MatchFunc, error) {
+	var fs []MatchFunc
+	for i := range es {
+		f, err := compileExpr(es[i])
 		if err != nil {
 			return nil, err
 		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
 
-		terms = append(terms, lq)
+// Compile parses a query string and returns a MatchFunc that
+// implements it. If the query has pipeline stages, only messages
+// that make it through all the stages are matched.
+func Compile(qstr string) (MatchFunc, error) {
+	pl, err := CompilePipeline(qstr)
+	if err != nil {
+		return nil, err
 	}
 
-	return makeConjunctionMatch(terms...)
+	return pl.MatchFunc(), nil
 }
@@ -16,6 +16,7 @@
 package ql
 
 import (
+	"reflect"
 	"strconv"
 	"testing"
 
@@ -163,6 +164,286 @@ func TestQuery_Matches(t *testing.T) {
 			true,
 			false,
 		},
+		{
+			`job=myjob and (level=error or status~"5..")`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{Labels: map[string]string{
+				"level":  "info",
+				"status": "503",
+			}},
+			true,
+			true,
+		},
+		{
+			`job=myjob and (level=error or status~"5..")`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{Labels: map[string]string{
+				"level":  "info",
+				"status": "200",
+			}},
+			true,
+			false,
+		},
+		{
+			`job=otherjob or level=error`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{Labels: map[string]string{
+				"level": "error",
+			}},
+			true,
+			true,
+		},
+		{
+			`job=myjob and job=otherjob`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{Labels: map[string]string{}},
+			true,
+			false,
+		},
+		{
+			`job=myjob not (level=debug or level=trace)`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{Labels: map[string]string{
+				"level": "debug",
+			}},
+			true,
+			false,
+		},
+		{
+			`job=myjob not (level=debug or level=trace)`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{Labels: map[string]string{
+				"level": "info",
+			}},
+			true,
+			true,
+		},
+		{
+			`job=myjob (level=error`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{Labels: map[string]string{}},
+			false,
+			false,
+		},
+		{
+			`job=myjob "connection refused"`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{
+				Text: "dial tcp: connection refused",
+			},
+			true,
+			true,
+		},
+		{
+			`job=myjob "connection refused"`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{
+				Text: "dial tcp: Connection Refused",
+			},
+			true,
+			false,
+		},
+		{
+			`job=myjob dial refused`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{
+				Text: "dial tcp: connection refused",
+			},
+			true,
+			true,
+		},
+		{
+			`job=myjob dial timeout`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{
+				Text: "dial tcp: connection refused",
+			},
+			true,
+			false,
+		},
+		{
+			`job=myjob ~*"connection refused"`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{
+				Text: "dial tcp: Connection Refused",
+			},
+			true,
+			true,
+		},
+		{
+			`job~*MYJ`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{Labels: map[string]string{}},
+			true,
+			true,
+		},
+		{
+			`job!~*MYJ`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{Labels: map[string]string{}},
+			true,
+			false,
+		},
+		{
+			`job=myjob not healthcheck`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{
+				Text: "GET /healthcheck 200",
+			},
+			true,
+			false,
+		},
+		{
+			`job=myjob status>=500`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{Labels: map[string]string{
+				"status": "500",
+			}},
+			true,
+			true,
+		},
+		{
+			`job=myjob status>=500`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{Labels: map[string]string{
+				"status": "404",
+			}},
+			true,
+			false,
+		},
+		{
+			`job=myjob status>500`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{Labels: map[string]string{
+				"status": "500",
+			}},
+			true,
+			false,
+		},
+		{
+			`job=myjob status<500`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{Labels: map[string]string{
+				"status": "200",
+			}},
+			true,
+			true,
+		},
+		{
+			`job=myjob status<=500`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{Labels: map[string]string{
+				"status": "500",
+			}},
+			true,
+			true,
+		},
+		{
+			`job=myjob bytes>1e3`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{Labels: map[string]string{
+				"bytes": "1500.5",
+			}},
+			true,
+			true,
+		},
+		{
+			`job=myjob bytes>1e3`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{Labels: map[string]string{
+				"bytes": "lots",
+			}},
+			true,
+			false,
+		},
+		{
+			`job=myjob latency>250ms`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{Labels: map[string]string{
+				"latency": "1.5s",
+			}},
+			true,
+			true,
+		},
+		{
+			`job=myjob latency>250ms`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{Labels: map[string]string{
+				"latency": "100ms",
+			}},
+			true,
+			false,
+		},
+		{
+			`job=myjob latency>250ms`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{Labels: map[string]string{
+				"latency": "300",
+			}},
+			true,
+			false,
+		},
+		{
+			`job=myjob latency<1s`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			&logspray.Message{Labels: map[string]string{
+				"latency": "",
+			}},
+			true,
+			false,
+		},
 	}
 
 	for i, st := range tests {
@@ -335,6 +616,97 @@ func TestQuery_HeaderMatches(t *testing.T) {
 			true,
 			true,
 		},
+		{
+			`job=otherjob or level=error`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			nil,
+			true,
+			true,
+		},
+		{
+			`job=otherjob and level=error`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			nil,
+			true,
+			false,
+		},
+		{
+			`not job=myjob`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			nil,
+			true,
+			false,
+		},
+		{
+			`not level=error`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			nil,
+			true,
+			true,
+		},
+		{
+			`not (job=myjob and level=error)`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			nil,
+			true,
+			true,
+		},
+		{
+			`not (job=myjob and level=error)`,
+			&logspray.Message{Labels: map[string]string{
+				"job":   "myjob",
+				"level": "error",
+			}},
+			nil,
+			true,
+			false,
+		},
+		{
+			`not __text__~"haha"`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			nil,
+			true,
+			true,
+		},
+		{
+			`job=myjob "connection refused"`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			nil,
+			true,
+			true,
+		},
+		{
+			`job=otherjob "connection refused"`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			nil,
+			true,
+			false,
+		},
+		{
+			`job=myjob not healthcheck`,
+			&logspray.Message{Labels: map[string]string{
+				"job": "myjob",
+			}},
+			nil,
+			true,
+			true,
+		},
 	}
 
 	for i, st := range tests {
@@ -363,3 +735,104 @@ func TestQuery_HeaderMatches(t *testing.T) {
 		})
 	}
 }
+
+func TestPipeline_Process(t *testing.T) {
+	hdr := &logspray.Message{Labels: map[string]string{
+		"job": "myjob",
+	}}
+	tests := []struct {
+		q    string
+		text string
+		res  bool
+		exp  string
+		ls   map[string]string
+	}{
+		{
+			`job=myjob`,
+			`GET /status 200`,
+			true,
+			`GET /status 200`,
+			nil,
+		},
+		{
+			`job=myjob | json`,
+			`{"path":"/status","status":200}`,
+			true,
+			`{"path":"/status","status":200}`,
+			map[string]string{"path": "/status", "status": "200"},
+		},
+		{
+			`job=myjob | json | status>=500`,
+			`{"path":"/status","status":200}`,
+			false,
+			``,
+			nil,
+		},
+		{
+			`job=myjob | logfmt | status>=500 | line_format "{{.job}} {{.path}} {{.status}}"`,
+			`path=/login status=503`,
+			true,
+			`myjob /login 503`,
+			map[string]string{"path": "/login", "status": "503"},
+		},
+		{
+			`job=myjob | regex "(?P<method>[A-Z]+) (?P<path>\S+)" | method=GET`,
+			`GET /status 200`,
+			true,
+			`GET /status 200`,
+			map[string]string{"method": "GET", "path": "/status"},
+		},
+		{
+			`job=myjob | regex "(?P<method>[A-Z]+) (?P<path>\S+)" | method=GET`,
+			`not a request`,
+			false,
+			``,
+			nil,
+		},
+		{
+			`job=myjob | line_format "{{.missing}}[{{.__text__}}]"`,
+			`hello`,
+			true,
+			`[hello]`,
+			map[string]string{},
+		},
+	}
+
+	for i, st := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			pl, err := CompilePipeline(st.q)
+			if err != nil {
+				t.Fatalf("%s: compile failed, %v", t.Name(), err)
+			}
+
+			m := &logspray.Message{Text: st.text, StreamID: "stream", Index: 1}
+			if !pl.Match(hdr, m, false) {
+				t.Fatalf("%s: message not matched", t.Name())
+			}
+
+			nm, ok := pl.Process(hdr, m)
+			if ok != st.res {
+				t.Fatalf("%s: got res = %v, expected %v", t.Name(), ok, st.res)
+			}
+			if !ok {
+				return
+			}
+			if m.Text != st.text || len(m.Labels) != 0 {
+				t.Fatalf("%s: original message was modified", t.Name())
+			}
+			if nm.Text != st.exp {
+				t.Fatalf("%s: got text = %q, expected %q", t.Name(), nm.Text, st.exp)
+			}
+			if !reflect.DeepEqual(nm.Labels, st.ls) {
+				t.Fatalf("%s: got labels = %v, expected %v", t.Name(), nm.Labels, st.ls)
+			}
+			if nm.StreamID != m.StreamID || nm.Index != m.Index {
+				t.Fatalf("%s: stream position was not kept", t.Name())
+			}
+
+			if mf, _ := Compile(st.q); mf(hdr, m, false) != st.res {
+				t.Fatalf("%s: compiled match did not agree with pipeline", t.Name())
+			}
+		})
+	}
+}
@@ -1,18 +1,40 @@
 // Package ql implements support for parsing and running logsprays
 // simple query expressions.
 //
-// Query expressions set of label and value matches. The ql does not currently
-// support matching of the message text itself, this will be corrected in future
-// Either label or value can be given as a quoted string using ",', or `
-// quotes. Four match types are supported:
+// Query expressions set of label and value matches, and searches of the
+// message text. Either label or value can be given as a quoted string using
+// ",', or ` quotes. Six match types are supported:
 //
 //   = : an exact match, or the single wild card "*" for any value
 //   != : any value not equal to the value
 //   ~ : A regular expression match, against the label value
 //   !~ : A negated regular expression match against the label value
+//   ~* : A case insensitive match for a substring of the label value
+//   !~* : A negated case insensitive substring match
+//   >, >=, <, <= : An ordered comparison against the label value
 //
-// Matches for the same label are or'd together. Matches for different labels
-// are and'd together.
+// The value for an ordered comparison must be either a number, or a Go
+// duration such as 250ms. Label values are parsed the same way, values that
+// cannot be parsed never match. Label values containing any of the operator
+// characters, !=~<>, must be quoted, path=/a>b is an error rather than a
+// match of path against /a>b.
+//
+// A bare word or quoted phrase that is not followed by an operator matches
+// messages whose text contains it. A match with the label left off is applied
+// to the message text, so ~"regex" and ~*"phrase" may also be used to search
+// the text. A text match following a word is told apart from a label match
+// by its spacing, error ~*timeout is two text matches, where error ~* timeout
+// and error~*timeout match the error label.
+//
+// Equality and regex matches, =, ~ and ~*, for the same label are or'd
+// together. All other matches, including negated and ordered comparisons on
+// the same label, are and'd together, so bytes>0 bytes<1e6 selects a range.
+//
+// More complex queries can be built using the and, or, and not keywords, and
+// parentheses. not binds most tightly, followed by and, then or. Runs of
+// matches with no keyword between them are grouped as above. Keywords are
+// case insensitive. A label name that clashes with a keyword, or any label or
+// value containing a parenthesis, must be quoted.
 //
 // For example:
 //
@@ -22,4 +44,54 @@
 //   job~things-.* : all jobs matching things
 //   job=billing customer=* : all billing logs with any customer label set
 //   job=billing customer~acme-.* : all billing logs with any acme- customer label set
+//   job=api and (level=error or status~"5..") : api errors, or api 5xx responses
+//   job=api not (level=debug or level=trace) : api logs, without the debug noise
+//   job=api "connection refused" : api logs mentioning a refused connection
+//   job=api ~*timeout : api logs mentioning timeouts, in any case
+//   job=api status>=500 : api requests that failed
+//   job=api latency>250ms : slow api requests
+//
+// A query may be followed by a pipeline of stages, separated by |, that
+// parse and reshape the matched messages at query time. A | only separates
+// stages when it is not within a word, so job~api|web is still a regex match
+// of job against api|web, while job~api | web filters its messages on the text
+// web. The stages are:
+//
+//   json : extract the fields of a JSON object in the text as labels
+//   logfmt : extract the keys of logfmt formatted text as labels
+//   regex "re" : extract the named groups of a regexp match as labels
+//   line_format "tmpl" : replace the text using a Go text/template, executed
+//                        against the labels, and the text as __text__
+//
+// Any other stage is a query expression, used to filter messages on the
+// labels extracted so far. As with and/or/not, a label that clashes with a
+// stage name must be quoted. For example:
+//
+//   job=api | json | status>=500 | line_format "{{.path}}"
+//   job=nginx | regex "(?P<method>[A-Z]+) (?P<path>\S+)" | method=POST
+//
+// Aggregations turn the messages matched by a query into time series. The
+// query, with any pipeline, is given in braces, followed by a range:
+//
+//   count_over_time({query} [range]) : the number of messages in the range
+//   rate({query} [range]) : the per second rate of messages in the range
+//
+// The range starts at each point in the series. Adding by (label, ...)
+// produces a series for each distinct set of values of those labels, rather
+// than a single series. The series can be combined further using sum, min,
+// max, avg, and count, which also accept a by clause, and topk(k, ...) which
+// keeps the k largest values at each point. For example:
+//
+//   count_over_time({job=api} [1m]) by (status)
+//   sum by (status) (rate({job=api | json} [5m]) by (status, path))
+//   topk(5, count_over_time({job=api | json} [1m]) by (path))
+//
+// A query that is not an aggregation is counted at each step, as if it were
+// count_over_time({query} [step]).
+//
+// Parse returns the syntax tree of a query. Its String method gives the
+// query in a canonical form, with explicit and keywords, every value quoted,
+// and parentheses only where they are needed, which parses back to the same
+// tree. Syntax errors are reported as an Error, giving the line and column at
+// which the problem was found.
 package ql
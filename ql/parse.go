@@ -15,6 +15,7 @@ package ql
 
 import (
 	"fmt"
+	"strings"
 )
 
 // Parser stores the state for the ivy parser.
@@ -27,6 +28,10 @@ type Parser struct {
 
 	peekTok Token
 	curTok  Token // most recent token from scanner
+
+	// pending is a term already read, returned by the next call
+	// to readUnary.
+	pending Expr
 }
 
 // Error provides details of a syntax error
@@ -36,7 +41,22 @@ type Error struct {
 }
 
 func (err Error) Error() string {
-	return fmt.Sprintf("line %v,  %v", err.tok.Line, err.err)
+	return fmt.Sprintf("line %v, column %v, %v", err.tok.Line, err.tok.Col, err.err)
+}
+
+// Unwrap returns the underlying error.
+func (err Error) Unwrap() error {
+	return err.err
+}
+
+// Line returns the line of the query on which the error was found.
+func (err Error) Line() int {
+	return err.tok.Line
+}
+
+// Column returns the column at which the error was found.
+func (err Error) Column() int {
+	return err.tok.Col
 }
 
 // newParser returns a new parser that will read from the scanner.
@@ -47,17 +67,27 @@ func newParser(scanner *Scanner) *Parser {
 	}
 }
 
+// scan returns the next token from the scanner. Newlines are
+// treated as any other white space.
+func (p *Parser) scan() Token {
+	tok := p.scanner.Next()
+	for tok.Type == Newline {
+		tok = p.scanner.Next()
+	}
+	return tok
+}
+
 func (p *Parser) next() (Token, error) {
 	tok := p.peekTok
 	if tok.Type != EOF {
 		p.peekTok = Token{Type: EOF}
 	} else {
-		tok = p.scanner.Next()
+		tok = p.scan()
 	}
+	p.curTok = tok
 	if tok.Type == TokError {
 		return tok, fmt.Errorf("error parsing query, %w", tok)
 	}
-	p.curTok = tok
 	if tok.Type != Newline {
 		// Show the line number before we hit the newline.
 		p.lineNum = tok.Line
@@ -70,10 +100,33 @@ func (p *Parser) peek() Token {
 	if tok.Type != EOF {
 		return tok
 	}
-	p.peekTok = p.scanner.Next()
+	p.peekTok = p.scan()
 	return p.peekTok
 }
 
-func (p *Parser) errorf(args ...interface{}) Error {
-	return Error{}
+// isKeyword reports whether tok is the given keyword. Keywords are
+// case insensitive, and are only recognised as bare words. A quoted
+// string is never a keyword.
+func (p *Parser) isKeyword(tok Token, kw string) bool {
+	return tok.Type == Atom && strings.EqualFold(tok.Text, kw)
+}
+
+// keyword consumes the next token if it is the given keyword.
+func (p *Parser) keyword(kw string) bool {
+	if !p.isKeyword(p.peek(), kw) {
+		return false
+	}
+	p.next()
+	return true
+}
+
+// errorf returns an Error at the position of the token the parser
+// failed on, the token it peeked at if there is one, or the last
+// token read.
+func (p *Parser) errorf(format string, args ...interface{}) Error {
+	tok := p.curTok
+	if p.peekTok.Line != 0 {
+		tok = p.peekTok
+	}
+	return Error{err: fmt.Errorf(format, args...), tok: tok}
 }
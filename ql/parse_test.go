@@ -18,6 +18,7 @@ package ql
 import (
 	"bytes"
 	"fmt"
+	"reflect"
 	"strconv"
 	"testing"
 )
@@ -62,3 +63,218 @@ func TestParser(t *testing.T) {
 		})
 	}
 }
+
+func TestParser_Expr(t *testing.T) {
+	type test struct {
+		src string
+		exp string
+		err string
+	}
+
+	var tests = []test{
+		{``, ``, ``},
+		{`job=thing`, `job="thing"`, ``},
+		{`job=thing other=more`, `job="thing" and other="more"`, ``},
+		{`job=thing job=other`, `job="thing" or job="other"`, ``},
+		{`job=a other=b job=c`, `(job="a" or job="c") and other="b"`, ``},
+		{`job=a and job=b`, `job="a" and job="b"`, ``},
+		{`job=a or other=b`, `job="a" or other="b"`, ``},
+		{`job=a OR other=b AND x=y`, `job="a" or other="b" and x="y"`, ``},
+		{`job=a and (other=b or x=y)`, `job="a" and (other="b" or x="y")`, ``},
+		{`not job=a`, `not job="a"`, ``},
+		{`not job=a other=b`, `not job="a" and other="b"`, ``},
+		{`not (job=a or job=b)`, `not (job="a" or job="b")`, ``},
+		{`job=api (level=error or status~"5..")`, `job="api" and (level="error" or status~"5..")`, ``},
+		{`"not"=a`, `"not"="a"`, ``},
+		{`job=not`, `job="not"`, ``},
+		{`(job=a`, ``, `expected ")", got "EOF"`},
+		{`job=a)`, ``, `unexpected ")"`},
+		{`job=a and`, ``, `expected query term, got EOF`},
+		{`not`, ``, `expected query term, got EOF`},
+		{`()`, ``, `expected query term, got ")"`},
+		{`error`, `"error"`, ``},
+		{`job=api "connection refused"`, `job="api" and "connection refused"`, ``},
+		{`job=api error timeout`, `job="api" and "error" and "timeout"`, ``},
+		{`job=api not "GET /status"`, `job="api" and not "GET /status"`, ``},
+		{`~"conn.+refused"`, `~"conn.+refused"`, ``},
+		{`job=api ~*"Connection Refused"`, `job="api" and ~*"Connection Refused"`, ``},
+		{`path~*Login`, `path~*"Login"`, ``},
+		{`path!~*Login`, `path!~*"Login"`, ``},
+		{`job=api ~*`, ``, `expected a label value`},
+		{`job=api error ~*timeout`, `job="api" and "error" and ~*"timeout"`, ``},
+		{`job=api error !~"GET .*"`, `job="api" and "error" and !~"GET .*"`, ``},
+		{`job=api path ~* Login`, `job="api" and path~*"Login"`, ``},
+		{`job=api path~* Login`, `job="api" and path~*"Login"`, ``},
+		{`job=api status >500`, `job="api" and status>"500"`, ``},
+		{`status>=500`, `status>="500"`, ``},
+		{`latency > 250ms`, `latency>"250ms"`, ``},
+		{`job=api bytes<1e6 bytes>0`, `job="api" and bytes<"1e6" and bytes>"0"`, ``},
+		{`latency>100 latency<500 job=a job=b`, `latency>"100" and latency<"500" and (job="a" or job="b")`, ``},
+		{`job!=a job!=b job~c job~*d`, `job!="a" and job!="b" and (job~"c" or job~*"d")`, ``},
+		{`latency<=fast`, ``, `"fast" is not a number or a duration`},
+		{`status=<500`, ``, `unknown operator, got "=<"`},
+		{`job~api|web`, `job~"api|web"`, ``},
+		{`job~api|web level=error`, `job~"api|web" and level="error"`, ``},
+		{`job~"api|web"`, `job~"api|web"`, ``},
+		{`path=/a>b`, ``, `unexpected ">" after value "/a", values containing any of !=~<> must be quoted`},
+		{`path="/a>b"`, `path="/a>b"`, ``},
+		{`latency>1s<2s`, ``, `unexpected "<" after value "1s", values containing any of !=~<> must be quoted`},
+	}
+
+	for i, st := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			s := newScanner(bytes.NewBuffer([]byte(st.src)))
+			p := newParser(s)
+
+			e, err := p.readExpr()
+			if err != nil && st.err != err.Error() {
+				t.Fatalf("\nexpected err: %#v\ngot: %#v", st.err, err.Error())
+			}
+			if err == nil && st.err != "" {
+				t.Fatalf("\nexpected err:  %#v", st.err)
+			}
+			if err != nil {
+				return
+			}
+
+			str := fmt.Sprintf("%s", e)
+			if str != st.exp {
+				t.Fatalf("\nexpected: %#v\ngot: %#v", st.exp, str)
+			}
+		})
+	}
+}
+
+func TestParser_Pipeline(t *testing.T) {
+	type test struct {
+		src string
+		exp string
+		err string
+	}
+
+	var tests = []test{
+		{`job=api`, `job="api"`, ``},
+		{`job=api | json`, `job="api" | json`, ``},
+		{`job=api | logfmt | status>=500`, `job="api" | logfmt | status>="500"`, ``},
+		{`| json`, `| json`, ``},
+		{`job=api | JSON | status>=500 or level=error | line_format "{{.path}}"`, `job="api" | json | status>="500" or level="error" | line_format "{{.path}}"`, ``},
+		{`job=api | regex "(?P<method>[A-Z]+) (?P<path>\S+)"`, `job="api" | regex "(?P<method>[A-Z]+) (?P<path>\S+)"`, ``},
+		{`job=api | "json"=true`, `job="api" | "json"="true"`, ``},
+		{`job~api|web`, `job~"api|web"`, ``},
+		{`job~api | web`, `job~"api" | "web"`, ``},
+		{`job=api| json`, `job="api" | json`, ``},
+		{`job="api"|json`, `job="api" | json`, ``},
+		{`job=api |`, ``, `expected pipeline stage, got "EOF"`},
+		{`job=api | | json`, ``, `expected pipeline stage, got "|"`},
+		{`job=api | regex "(.+)"`, ``, `regex stage "(.+)" has no named groups`},
+		{`job=api | regex`, ``, `expected a label value`},
+		{`job=api | json )`, ``, `unexpected ")"`},
+		{`job=api | line_format "{{.path"`, ``, `build line_format stage failed, template: line_format:1: unclosed action`},
+	}
+
+	for i, st := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			s := newScanner(bytes.NewBuffer([]byte(st.src)))
+			p := newParser(s)
+
+			pl, err := p.readPipeline()
+			if err != nil && st.err != err.Error() {
+				t.Fatalf("\nexpected err: %#v\ngot: %#v", st.err, err.Error())
+			}
+			if err == nil && st.err != "" {
+				t.Fatalf("\nexpected err:  %#v", st.err)
+			}
+			if err != nil {
+				return
+			}
+
+			str := fmt.Sprintf("%s", pl)
+			if str != st.exp {
+				t.Fatalf("\nexpected: %#v\ngot: %#v", st.exp, str)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	type test struct {
+		src string
+		exp string
+		err string
+	}
+
+	var tests = []test{
+		{`job=api`, `job="api"`, ``},
+		{`"and"=x 'a b'!="c" | json`, `"and"="x" and "a b"!="c" | json`, ``},
+		{`job=a other=b job=c | status>=500 | line_format '{{printf "%s" .path}}'`, `(job="a" or job="c") and other="b" | status>="500" | line_format '{{printf "%s" .path}}'`, ``},
+		{`not (a=b c=d) or e~"x.*"`, `not (a="b" and c="d") or e~"x.*"`, ``},
+		{`msg='say "hi"'`, `msg='say "hi"'`, ``},
+		{`job=api (level=error`, ``, `line 1, column 21, expected ")", got "EOF"`},
+		{`job=api level=~"("`, ``, `line 1, column 14, unknown operator, got "=~"`},
+		{`job=api level~"("`, ``, "line 1, column 15, build regexp match failed, error parsing regexp: missing closing ): `(`"},
+		{"job=api\n  and x=", ``, `line 2, column 9, expected a label value`},
+		{`job=api | regex "(.+)"`, ``, `line 1, column 17, regex stage "(.+)" has no named groups`},
+		{`job=api ) x=y`, ``, `line 1, column 9, unexpected ")"`},
+		{`job=api path=/a>b`, ``, `line 1, column 16, unexpected ">" after value "/a", values containing any of !=~<> must be quoted`},
+		{`job~api|web`, `job~"api|web"`, ``},
+	}
+
+	for i, st := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			q, err := Parse(st.src)
+			if err != nil && st.err != err.Error() {
+				t.Fatalf("\nexpected err: %#v\ngot: %#v", st.err, err.Error())
+			}
+			if err == nil && st.err != "" {
+				t.Fatalf("\nexpected err:  %#v", st.err)
+			}
+			if err != nil {
+				return
+			}
+
+			str := q.String()
+			if str != st.exp {
+				t.Fatalf("\nexpected: %#v\ngot: %#v", st.exp, str)
+			}
+
+			// The canonical form should parse to the same query
+			rq, err := Parse(str)
+			if err != nil {
+				t.Fatalf("parsing canonical query failed, %v", err)
+			}
+			if !reflect.DeepEqual(q, rq) {
+				t.Fatalf("\nexpected: %#v\ngot: %#v", q, rq)
+			}
+		})
+	}
+}
+
+func TestQuery_Terms(t *testing.T) {
+	type test struct {
+		src string
+		exp []TermInfo
+	}
+
+	var tests = []test{
+		{`job=api`, []TermInfo{{`job="api"`, true}}},
+		{`job=api error`, []TermInfo{{`job="api"`, true}, {`"error"`, false}}},
+		{`job=api or error`, []TermInfo{{`job="api"`, false}, {`"error"`, false}}},
+		{`job=api or job=web`, []TermInfo{{`job="api"`, true}, {`job="web"`, true}}},
+		{`not (job=api level=debug)`, []TermInfo{{`job="api"`, true}, {`level="debug"`, true}}},
+		{`not (job=api error)`, []TermInfo{{`job="api"`, false}, {`"error"`, false}}},
+		{`job=api | json | status=500`, []TermInfo{{`job="api"`, true}, {`status="500"`, false}}},
+		{`__text__~foo`, []TermInfo{{`__text__~"foo"`, false}}},
+	}
+
+	for i, st := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			q, err := Parse(st.src)
+			if err != nil {
+				t.Fatalf("parse failed, %v", err)
+			}
+			if ts := q.Terms(); !reflect.DeepEqual(st.exp, ts) {
+				t.Fatalf("\nexpected: %#v\ngot: %#v", st.exp, ts)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package ql
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/QubitProducts/logspray/proto/logspray"
+	"github.com/QubitProducts/logspray/relabel"
+)
+
+// readPipeline reads a query expression, and any pipeline stages
+// that follow it.
+//
+// PIPELINE: EXPR | EXPR "|" STAGES
+// STAGES: STAGE | STAGE "|" STAGES
+func (p *Parser) readPipeline() (*Query, error) {
+	e, err := p.readExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Query{Expr: e}
+	for p.peek().Type == Pipe {
+		p.next()
+		s, err := p.readStage()
+		if err != nil {
+			return nil, err
+		}
+		q.Stages = append(q.Stages, s)
+
+		switch tok := p.peek(); tok.Type {
+		case EOF, Pipe:
+		case TokError:
+			_, err := p.next()
+			return nil, err
+		default:
+			return nil, fmt.Errorf("unexpected %q", tok.Text)
+		}
+	}
+
+	return q, nil
+}
+
+// STAGE: "json" | "logfmt" | "regex" STR | "line_format" STR | OR
+func (p *Parser) readStage() (Stage, error) {
+	switch {
+	case p.keyword("json"):
+		return ParserStage{Format: "json"}, nil
+	case p.keyword("logfmt"):
+		return ParserStage{Format: "logfmt"}, nil
+	case p.keyword("regex"):
+		str, err := p.string()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := buildRegex(str); err != nil {
+			return nil, err
+		}
+		return RegexStage{Regex: str}, nil
+	case p.keyword("line_format"):
+		str, err := p.string()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := buildLineFormat(str); err != nil {
+			return nil, err
+		}
+		return LineFormatStage{Template: str}, nil
+	}
+
+	switch tok := p.peek(); tok.Type {
+	case EOF, Pipe:
+		return nil, fmt.Errorf("expected pipeline stage, got %q", tok.Text)
+	}
+
+	e, err := p.readOr()
+	if err != nil {
+		return nil, err
+	}
+	return FilterStage{e}, nil
+}
+
+func buildRegex(str string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(str)
+	if err != nil {
+		return nil, fmt.Errorf("build regex stage failed, %w", err)
+	}
+	if strings.Join(re.SubexpNames(), "") == "" {
+		return nil, fmt.Errorf("regex stage %q has no named groups", str)
+	}
+	return re, nil
+}
+
+func buildLineFormat(str string) (*template.Template, error) {
+	tmpl, err := template.New("line_format").Option("missingkey=zero").Parse(str)
+	if err != nil {
+		return nil, fmt.Errorf("build line_format stage failed, %w", err)
+	}
+	return tmpl, nil
+}
+
+// stageFunc applies a pipeline stage to a copy of a message. It
+// returns false if the message should be dropped.
+type stageFunc func(hdr, m *logspray.Message) bool
+
+func makeParserStage(action string) (stageFunc, error) {
+	r, err := relabel.NewTextRule(action)
+	if err != nil {
+		return nil, err
+	}
+	rs := relabel.Config{r}
+	return func(hdr, m *logspray.Message) bool {
+		// Text that fails to parse is passed on, with any labels
+		// that could be extracted.
+		rs.Relabel(m)
+		return true
+	}, nil
+}
+
+func makeRegexStage(re *regexp.Regexp) (stageFunc, error) {
+	names := re.SubexpNames()
+	return func(hdr, m *logspray.Message) bool {
+		matches := re.FindStringSubmatch(m.Text)
+		for i := 1; i < len(matches); i++ {
+			if names[i] == "" {
+				continue
+			}
+			m.Labels[names[i]] = matches[i]
+		}
+		return true
+	}, nil
+}
+
+func makeLineFormatStage(tmpl *template.Template) (stageFunc, error) {
+	return func(hdr, m *logspray.Message) bool {
+		ls := map[string]string{}
+		for k, v := range hdr.Labels {
+			ls[k] = v
+		}
+		for k, v := range m.Labels {
+			ls[k] = v
+		}
+		ls["__text__"] = m.Text
+
+		buf := &bytes.Buffer{}
+		if err := tmpl.Execute(buf, ls); err != nil {
+			return true
+		}
+		m.Text = buf.String()
+		return true
+	}, nil
+}
+
+func makeFilterStage(f MatchFunc) (stageFunc, error) {
+	return func(hdr, m *logspray.Message) bool {
+		return f(hdr, m, false)
+	}, nil
+}
+
+// parserActions maps the parser stages to the relabel action
+// that implements them.
+var parserActions = map[string]string{
+	"json":   "structuredlogging",
+	"logfmt": "logfmt",
+}
+
+func compileStage(s Stage) (stageFunc, error) {
+	switch s := s.(type) {
+	case ParserStage:
+		action, ok := parserActions[s.Format]
+		if !ok {
+			return nil, fmt.Errorf("unknown parser stage %q", s.Format)
+		}
+		return makeParserStage(action)
+	case RegexStage:
+		re, err := buildRegex(s.Regex)
+		if err != nil {
+			return nil, err
+		}
+		return makeRegexStage(re)
+	case LineFormatStage:
+		tmpl, err := buildLineFormat(s.Template)
+		if err != nil {
+			return nil, err
+		}
+		return makeLineFormatStage(tmpl)
+	case FilterStage:
+		f, err := compileExpr(s.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return makeFilterStage(f)
+	default:
+		return nil, fmt.Errorf("unknown pipeline stage %T", s)
+	}
+}
+
+// Pipeline is a compiled query. Messages selected by Match are
+// passed through each of the pipeline stages in turn.
+type Pipeline struct {
+	// Match selects the messages that are passed to the pipeline
+	// stages. It can be used to filter files and messages in the
+	// same way as the MatchFunc returned by Compile.
+	Match MatchFunc
+
+	// Tokens can be used to rule out sets of messages, using a
+	// filter of the tokens they contain, before calling Match.
+	Tokens TokenFilter
+
+	stages []stageFunc
+}
+
+// CompilePipeline parses a query string, including any pipeline
+// stages, and returns a Pipeline that implements it.
+func CompilePipeline(qstr string) (*Pipeline, error) {
+	q, err := Parse(qstr)
+	if err != nil {
+		return nil, fmt.Errorf("failed ot read query, %w", err)
+	}
+
+	return q.Compile()
+}
+
+// Compile returns a Pipeline that implements the query.
+func (q Query) Compile() (*Pipeline, error) {
+	e := q.Expr
+	if e == nil {
+		e = And{}
+	}
+	m, err := compileExpr(e)
+	if err != nil {
+		return nil, err
+	}
+
+	pl := &Pipeline{Match: m, Tokens: compileTokenFilter(e)}
+	for i := range q.Stages {
+		sf, err := compileStage(q.Stages[i])
+		if err != nil {
+			return nil, err
+		}
+		pl.stages = append(pl.stages, sf)
+	}
+
+	return pl, nil
+}
+
+// Process passes a message, already accepted by Match, through
+// the pipeline stages. It returns the transformed message, or false if
+// one of the stages rejected it. The original message is never modified.
+func (pl *Pipeline) Process(hdr, m *logspray.Message) (*logspray.Message, bool) {
+	if len(pl.stages) == 0 {
+		return m, true
+	}
+
+	if hdr == nil {
+		hdr = &logspray.Message{}
+	}
+	nm := m.Copy()
+	nm.StreamID = m.StreamID
+	nm.Index = m.Index
+	for _, s := range pl.stages {
+		if !s(hdr, nm) {
+			return nil, false
+		}
+	}
+	return nm, true
+}
+
+// MatchFunc returns a MatchFunc that accepts the messages that would
+// make it through the whole pipeline. Only Match is used when matching
+// headers.
+func (pl *Pipeline) MatchFunc() MatchFunc {
+	if len(pl.stages) == 0 {
+		return pl.Match
+	}
+	return func(hdr, m *logspray.Message, headerOnly bool) bool {
+		if !pl.Match(hdr, m, headerOnly) {
+			return false
+		}
+		if headerOnly {
+			return true
+		}
+		_, ok := pl.Process(hdr, m)
+		return ok
+	}
+}
+
+// MessageFunc returns a logspray.MessageFunc that passes messages
+// through the pipeline stages before handing them on to next. Stream
+// headers and control messages are passed on unaltered, messages
+// rejected by a stage are dropped.
+func (pl *Pipeline) MessageFunc(next logspray.MessageFunc) logspray.MessageFunc {
+	if len(pl.stages) == 0 {
+		return next
+	}
+	hdrs := map[string]*logspray.Message{}
+	return func(m *logspray.Message) error {
+		switch m.ControlMessage {
+		case logspray.Message_SETHEADER:
+			hdrs[m.StreamID] = m
+		case logspray.Message_STREAMEND:
+			delete(hdrs, m.StreamID)
+		}
+		if m.ControlMessage != logspray.Message_NONE {
+			return next(m)
+		}
+
+		nm, ok := pl.Process(hdrs[m.StreamID], m)
+		if !ok {
+			return nil
+		}
+		return next(nm)
+	}
+}
@@ -27,23 +27,28 @@ import (
 type Token struct {
 	Type Type   // The type of this item.
 	Line int    // The line number on which this token appears
+	Col  int    // The column (in bytes, from 1) at which this token starts
 	Text string // The text of this item.
 }
 
-//go:generate stringer -type Type
 // Type identifies the type of lex items.
+//
+//go:generate stringer -type Type
 type Type int
 
 const (
 	EOF      Type = iota // zero value so closed channel delivers EOF
 	TokError             // error occurred; value is text of error
 	Newline
-	String   // A quoted string
-	Atom     // a bare word
-	Operator // Symbol made up of special chars
+	String     // A quoted string
+	Atom       // a bare word
+	Operator   // Symbol made up of special chars
+	LeftParen  // (
+	RightParen // )
+	Pipe       // |
 )
 
-const special = "!=~"
+const special = "!=~<>"
 
 func (i Token) String() string {
 	switch {
@@ -83,6 +88,8 @@ type Scanner struct {
 	pos        int     // current position in the input
 	start      int     // start position of this item
 	width      int     // width of last rune read from input
+	base       int     // offset of input within the whole text
+	lineStart  int     // offset of the current line within the whole text
 
 	debug bool
 }
@@ -106,6 +113,7 @@ func (l *Scanner) loadLine() {
 		}
 	}
 	l.input = l.input[l.start:l.pos] + string(l.buf)
+	l.base += l.start
 	l.pos -= l.start
 	l.start = 0
 }
@@ -137,16 +145,23 @@ func (l *Scanner) backup() {
 	l.pos -= l.width
 }
 
-//  passes an item back to the client.
+// column returns the column of position pos in the input.
+func (l *Scanner) column(pos int) int {
+	return l.base + pos - l.lineStart + 1
+}
+
+// passes an item back to the client.
 func (l *Scanner) emit(t Type) {
+	col := l.column(l.start)
 	if t == Newline {
 		l.line++
+		l.lineStart = l.base + l.pos
 	}
 	s := l.input[l.start:l.pos]
 	if l.debug {
-		fmt.Fprintf(os.Stderr, "%d: emit %s\n", l.line, Token{t, l.line, s})
+		fmt.Fprintf(os.Stderr, "%d: emit %s\n", l.line, Token{t, l.line, col, s})
 	}
-	l.tokens <- Token{t, l.line, s}
+	l.tokens <- Token{t, l.line, col, s}
 	l.start = l.pos
 	l.width = 0
 }
@@ -174,7 +189,7 @@ func (l *Scanner) acceptRun(valid string) {
 
 // errorf returns an error token and continues to scan.
 func (l *Scanner) errorf(format string, args ...interface{}) stateFn {
-	l.tokens <- Token{TokError, l.start, fmt.Sprintf(format, args...)}
+	l.tokens <- Token{TokError, l.line, l.column(l.start), fmt.Sprintf(format, args...)}
 	return lexAny
 }
 
@@ -207,7 +222,7 @@ func (l *Scanner) Next() Token {
 		close(l.tokens)
 		l.tokens = nil
 	}
-	return Token{EOF, l.pos, "EOF"}
+	return Token{EOF, l.line, l.column(l.pos), "EOF"}
 }
 
 // state functions
@@ -224,6 +239,15 @@ func lexAny(l *Scanner) stateFn {
 		return lexSpace
 	case r == '"', r == '\'', r == '`':
 		return lexQuote
+	case r == '(':
+		l.emit(LeftParen)
+		return lexAny
+	case r == ')':
+		l.emit(RightParen)
+		return lexAny
+	case r == '|':
+		l.emit(Pipe)
+		return lexAny
 	case isSpecial(r):
 		return lexSpecialAtom
 	case isAlphaNumeric(r):
@@ -271,6 +295,10 @@ Loop:
 		c := l.next()
 		switch {
 		case isAlphaNumeric(c):
+		case c == '|' && isAlphaNumeric(l.peek()):
+			// A | within a word, as in the regex api|web, is
+			// part of it. Only a | with space, or the end of the
+			// query, after it starts a pipeline stage.
 		default:
 			l.backup()
 			break Loop
@@ -288,6 +316,10 @@ Loop:
 		c := l.next()
 		switch {
 		case isSpecial(c):
+		case c == '*' && strings.HasSuffix(l.input[l.start:l.pos-1], "~"):
+			// ~* is the only operator to include a non-special
+			// character, and it must come last.
+			break Loop
 		default:
 			l.backup()
 			break Loop
@@ -299,7 +331,12 @@ Loop:
 
 // isAlphaNumeric reports whether r is an alphabetic, digit, or punctuation.
 func isAlphaNumeric(r rune) bool {
-	return !isSpecial(r) && !isSpace(r) && !isEndOfLine(r) && r != rune(-1)
+	return !isSpecial(r) && !isParen(r) && r != '|' && !isSpace(r) && !isEndOfLine(r) && r != rune(-1)
+}
+
+// isParen reports whether r is an opening or closing parenthesis.
+func isParen(r rune) bool {
+	return r == '(' || r == ')'
 }
 
 // isSpecial
@@ -31,29 +31,93 @@ func TestScanner(t *testing.T) {
 	var tests = []test{
 		{`job=test`,
 			[]Token{
-				Token{Type: 4, Line: 1, Text: "job"},
-				Token{Type: 5, Line: 1, Text: "="},
-				Token{Type: 4, Line: 1, Text: "test"}}},
+				Token{Type: 4, Line: 1, Col: 1, Text: "job"},
+				Token{Type: 5, Line: 1, Col: 4, Text: "="},
+				Token{Type: 4, Line: 1, Col: 5, Text: "test"}}},
 		{`job!=test`,
 			[]Token{
-				Token{Type: 4, Line: 1, Text: "job"},
-				Token{Type: 5, Line: 1, Text: "!="},
-				Token{Type: 4, Line: 1, Text: "test"}}},
+				Token{Type: 4, Line: 1, Col: 1, Text: "job"},
+				Token{Type: 5, Line: 1, Col: 4, Text: "!="},
+				Token{Type: 4, Line: 1, Col: 6, Text: "test"}}},
 		{`job=~test`,
 			[]Token{
-				Token{Type: 4, Line: 1, Text: "job"},
-				Token{Type: 5, Line: 1, Text: "=~"},
-				Token{Type: 4, Line: 1, Text: "test"}}},
+				Token{Type: 4, Line: 1, Col: 1, Text: "job"},
+				Token{Type: 5, Line: 1, Col: 4, Text: "=~"},
+				Token{Type: 4, Line: 1, Col: 6, Text: "test"}}},
 		{`job="test"`,
 			[]Token{
-				Token{Type: 4, Line: 1, Text: "job"},
-				Token{Type: 5, Line: 1, Text: "="},
-				Token{Type: 3, Line: 1, Text: "\"test\""}}},
+				Token{Type: 4, Line: 1, Col: 1, Text: "job"},
+				Token{Type: 5, Line: 1, Col: 4, Text: "="},
+				Token{Type: 3, Line: 1, Col: 5, Text: "\"test\""}}},
 		{`job=*`,
 			[]Token{
-				Token{Type: 4, Line: 1, Text: "job"},
-				Token{Type: 5, Line: 1, Text: "="},
-				Token{Type: 4, Line: 1, Text: "*"}}},
+				Token{Type: 4, Line: 1, Col: 1, Text: "job"},
+				Token{Type: 5, Line: 1, Col: 4, Text: "="},
+				Token{Type: 4, Line: 1, Col: 5, Text: "*"}}},
+		{`not (job=test)`,
+			[]Token{
+				Token{Type: 4, Line: 1, Col: 1, Text: "not"},
+				Token{Type: 6, Line: 1, Col: 5, Text: "("},
+				Token{Type: 4, Line: 1, Col: 6, Text: "job"},
+				Token{Type: 5, Line: 1, Col: 9, Text: "="},
+				Token{Type: 4, Line: 1, Col: 10, Text: "test"},
+				Token{Type: 7, Line: 1, Col: 14, Text: ")"}}},
+		{`job~*test "some text"`,
+			[]Token{
+				Token{Type: 4, Line: 1, Col: 1, Text: "job"},
+				Token{Type: 5, Line: 1, Col: 4, Text: "~*"},
+				Token{Type: 4, Line: 1, Col: 6, Text: "test"},
+				Token{Type: 3, Line: 1, Col: 11, Text: "\"some text\""}}},
+		{`status>=500 latency<1s`,
+			[]Token{
+				Token{Type: 4, Line: 1, Col: 1, Text: "status"},
+				Token{Type: 5, Line: 1, Col: 7, Text: ">="},
+				Token{Type: 4, Line: 1, Col: 9, Text: "500"},
+				Token{Type: 4, Line: 1, Col: 13, Text: "latency"},
+				Token{Type: 5, Line: 1, Col: 20, Text: "<"},
+				Token{Type: 4, Line: 1, Col: 21, Text: "1s"}}},
+		{`job="test"|json`,
+			[]Token{
+				Token{Type: 4, Line: 1, Col: 1, Text: "job"},
+				Token{Type: 5, Line: 1, Col: 4, Text: "="},
+				Token{Type: 3, Line: 1, Col: 5, Text: "\"test\""},
+				Token{Type: 8, Line: 1, Col: 11, Text: "|"},
+				Token{Type: 4, Line: 1, Col: 12, Text: "json"}}},
+		{`job=test | json`,
+			[]Token{
+				Token{Type: 4, Line: 1, Col: 1, Text: "job"},
+				Token{Type: 5, Line: 1, Col: 4, Text: "="},
+				Token{Type: 4, Line: 1, Col: 5, Text: "test"},
+				Token{Type: 8, Line: 1, Col: 10, Text: "|"},
+				Token{Type: 4, Line: 1, Col: 12, Text: "json"}}},
+		{`job=test| json`,
+			[]Token{
+				Token{Type: 4, Line: 1, Col: 1, Text: "job"},
+				Token{Type: 5, Line: 1, Col: 4, Text: "="},
+				Token{Type: 4, Line: 1, Col: 5, Text: "test"},
+				Token{Type: 8, Line: 1, Col: 9, Text: "|"},
+				Token{Type: 4, Line: 1, Col: 11, Text: "json"}}},
+		{`job~api|web`,
+			[]Token{
+				Token{Type: 4, Line: 1, Col: 1, Text: "job"},
+				Token{Type: 5, Line: 1, Col: 4, Text: "~"},
+				Token{Type: 4, Line: 1, Col: 5, Text: "api|web"}}},
+		{`path=/a>b`,
+			[]Token{
+				Token{Type: 4, Line: 1, Col: 1, Text: "path"},
+				Token{Type: 5, Line: 1, Col: 5, Text: "="},
+				Token{Type: 4, Line: 1, Col: 6, Text: "/a"},
+				Token{Type: 5, Line: 1, Col: 8, Text: ">"},
+				Token{Type: 4, Line: 1, Col: 9, Text: "b"}}},
+		{"job=a\n  x=b",
+			[]Token{
+				Token{Type: 4, Line: 1, Col: 1, Text: "job"},
+				Token{Type: 5, Line: 1, Col: 4, Text: "="},
+				Token{Type: 4, Line: 1, Col: 5, Text: "a"},
+				Token{Type: 2, Line: 2, Col: 6, Text: "\n"},
+				Token{Type: 4, Line: 2, Col: 3, Text: "x"},
+				Token{Type: 5, Line: 2, Col: 4, Text: "="},
+				Token{Type: 4, Line: 2, Col: 5, Text: "b"}}},
 	}
 
 	for i, st := range tests {
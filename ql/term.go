@@ -19,22 +19,19 @@ import (
 	"fmt"
 	"io"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
-type queryTerm struct {
-	label    string
-	operator op
-	value    string
-}
-
-type query []queryTerm
+// query is a flat list of terms, as read by readQueryTerms.
+type query []Term
 
 // readTerm reads the next available search term
 //
 // QTS:  QTS | QT
 func (p *Parser) readQueryTerms() (query, error) {
-	var qts []queryTerm
+	var qts []Term
 	for {
 		qt, err := p.readQueryTerm()
 		if err == io.EOF {
@@ -50,44 +47,321 @@ func (p *Parser) readQueryTerms() (query, error) {
 }
 
 func (qs query) String() string {
-	return fmt.Sprintf("%s", []queryTerm(qs))
+	strs := make([]string, len(qs))
+	for i, qt := range qs {
+		strs[i] = fmt.Sprintf("{%s %s %s}", qt.Label, qt.Op, qt.Value)
+	}
+	return "[" + strings.Join(strs, " ") + "]"
 }
 
 // QT: LABEL OP STR
-func (p *Parser) readQueryTerm() (queryTerm, error) {
+func (p *Parser) readQueryTerm() (Term, error) {
 	if p.peek().Type == EOF {
-		return queryTerm{}, io.EOF
+		return Term{}, io.EOF
 	}
 
 	lval, err := p.label()
 	if err != nil {
-		return queryTerm{}, err
+		return Term{}, err
+	}
+
+	op, rval, err := p.readMatch()
+	if err != nil {
+		return Term{}, err
 	}
 
+	return Term{
+		Label: lval,
+		Op:    op,
+		Value: rval,
+	}, nil
+}
+
+// MATCH: OP STR
+func (p *Parser) readMatch() (string, string, error) {
 	opb, err := p.operator()
 	if err != nil {
-		return queryTerm{}, err
+		return "", "", err
 	}
+	return p.readValue(opb)
+}
+
+// readValue reads the value of a match, after its operator.
+func (p *Parser) readValue(opb opBuilder) (string, string, error) {
+	opstr := p.curTok.Text
 
 	rval, err := p.string()
 	if err != nil {
-		return queryTerm{}, err
+		return "", "", err
+	}
+
+	// The op is built here only to check the value is valid for it.
+	if _, err := opb(rval); err != nil {
+		return "", "", err
+	}
+
+	// An operator directly after a value, as in path=/a>b, is
+	// most likely part of it.
+	vtok := p.curTok
+	if tok := p.peek(); tok.Type == Operator && adjacent(vtok, tok) {
+		return "", "", fmt.Errorf("unexpected %q after value %q, values containing any of %s must be quoted", tok.Text, rval, special)
+	}
+
+	return opstr, rval, nil
+}
+
+// buildOp returns the op for a term. An empty opstr is a
+// substring match.
+func buildOp(opstr, rval string) (op, error) {
+	if opstr == "" {
+		return buildOpContains(rval)
+	}
+	opb, ok := defaultOps.lookup(opstr)
+	if !ok {
+		return nil, fmt.Errorf("unknown operator, got %q", opstr)
 	}
+	return opb(rval)
+}
+
+// exprLabels returns the set of labels referred to by the terms in
+// an expression.
+func exprLabels(e Expr) []string {
+	var ls []string
+	switch e := e.(type) {
+	case Term:
+		ls = append(ls, e.Label)
+	case TextTerm:
+		ls = append(ls, "__text__")
+	case And:
+		for i := range e {
+			ls = append(ls, exprLabels(e[i])...)
+		}
+	case Or:
+		for i := range e {
+			ls = append(ls, exprLabels(e[i])...)
+		}
+	case Not:
+		ls = append(ls, exprLabels(e.Expr)...)
+	}
+	return ls
+}
 
-	op, err := opb(rval)
+// readExpr reads a complete query expression, up to the end of
+// the query or the start of the pipeline. An empty query is an
+// empty conjunction, and matches everything.
+//
+// EXPR: OR | <empty>
+func (p *Parser) readExpr() (Expr, error) {
+	if t := p.peek().Type; t == EOF || t == Pipe {
+		return And{}, nil
+	}
+
+	e, err := p.readOr()
 	if err != nil {
-		return queryTerm{}, err
+		return nil, err
 	}
 
-	return queryTerm{
-		label:    lval,
-		operator: op,
-		value:    rval,
-	}, nil
+	switch tok := p.peek(); tok.Type {
+	case EOF, Pipe:
+		return e, nil
+	case TokError:
+		_, err := p.next()
+		return nil, err
+	default:
+		return nil, fmt.Errorf("unexpected %q", tok.Text)
+	}
 }
 
-func (qs queryTerm) String() string {
-	return fmt.Sprintf("{%s %s %s}", qs.label, qs.operator, qs.value)
+// OR: AND | AND "or" OR
+func (p *Parser) readOr() (Expr, error) {
+	var es Or
+	for {
+		e, err := p.readAnd()
+		if err != nil {
+			return nil, err
+		}
+		es = append(es, e)
+
+		if !p.keyword("or") {
+			break
+		}
+	}
+
+	if len(es) == 1 {
+		return es[0], nil
+	}
+	return es, nil
+}
+
+// AND: TERMS | TERMS "and" AND
+func (p *Parser) readAnd() (Expr, error) {
+	var es And
+	for {
+		ts, err := p.readTerms()
+		if err != nil {
+			return nil, err
+		}
+		es = append(es, ts...)
+
+		if !p.keyword("and") {
+			break
+		}
+	}
+
+	if len(es) == 1 {
+		return es[0], nil
+	}
+	return es, nil
+}
+
+// readTerms reads a run of terms with no explicit boolean
+// operator between them. These are and'd together, except for
+// equality and regex matches on the same label, which are or'd
+// together. Negated and ordered comparisons are always and'd, so
+// that bytes>0 bytes<1e6 gives a range.
+//
+// TERMS: UNARY | UNARY TERMS
+func (p *Parser) readTerms() ([]Expr, error) {
+	var es []Expr
+	labelTerms := map[string]int{}
+	for {
+		e, err := p.readUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		qt, ok := e.(Term)
+		if !ok || !orOps[qt.Op] {
+			es = append(es, e)
+		} else if i, ok := labelTerms[qt.Label]; ok {
+			switch le := es[i].(type) {
+			case Or:
+				es[i] = append(le, qt)
+			default:
+				es[i] = Or{le, qt}
+			}
+		} else {
+			labelTerms[qt.Label] = len(es)
+			es = append(es, qt)
+		}
+
+		if !p.atUnary() {
+			break
+		}
+	}
+	return es, nil
+}
+
+// orOps are the operators of matches that are or'd with others on
+// the same label.
+var orOps = map[string]bool{"=": true, "~": true, "~*": true}
+
+// atUnary reports whether the next token can start a new UNARY.
+func (p *Parser) atUnary() bool {
+	if p.pending != nil {
+		return true
+	}
+	switch tok := p.peek(); tok.Type {
+	case String, Operator, LeftParen:
+		return true
+	case Atom:
+		return !p.isKeyword(tok, "and") && !p.isKeyword(tok, "or")
+	default:
+		return false
+	}
+}
+
+// UNARY: "not" UNARY | "(" OR ")" | QT | TT
+func (p *Parser) readUnary() (Expr, error) {
+	if e := p.pending; e != nil {
+		p.pending = nil
+		return e, nil
+	}
+
+	if p.keyword("not") {
+		e, err := p.readUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{e}, nil
+	}
+
+	if p.peek().Type == LeftParen {
+		p.next()
+		e, err := p.readOr()
+		if err != nil {
+			return nil, err
+		}
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if tok.Type != RightParen {
+			return nil, fmt.Errorf("expected \")\", got %q", tok.Text)
+		}
+		return e, nil
+	}
+
+	switch tok := p.peek(); tok.Type {
+	case Operator:
+		return p.readTextTerm()
+	case String, Atom:
+		lval, err := p.label()
+		if err != nil {
+			return nil, err
+		}
+		ltok := p.curTok
+
+		// A bare word, or quoted phrase, not followed by an
+		// operator, searches the message text.
+		optok := p.peek()
+		if optok.Type != Operator {
+			return TextTerm{Value: lval}, nil
+		}
+
+		opb, err := p.operator()
+		if err != nil {
+			return nil, err
+		}
+		textMatch := textOps[optok.Text] && !adjacent(ltok, optok) && adjacent(optok, p.peek())
+		op, rval, err := p.readValue(opb)
+		if err != nil {
+			return nil, err
+		}
+
+		// A text match, with its operator set apart from the
+		// word before it, as in error ~*timeout, is a second
+		// term, read by the next call.
+		if textMatch {
+			p.pending = TextTerm{Op: op, Value: rval}
+			return TextTerm{Value: lval}, nil
+		}
+		return Term{Label: lval, Op: op, Value: rval}, nil
+	case EOF:
+		return nil, fmt.Errorf("expected query term, got EOF")
+	default:
+		return nil, fmt.Errorf("expected query term, got %q", tok.Text)
+	}
+}
+
+// textOps are the operators that may be applied to the message text.
+var textOps = map[string]bool{"~": true, "!~": true, "~*": true, "!~*": true}
+
+// adjacent reports whether there is no space between two tokens.
+func adjacent(a, b Token) bool {
+	return a.Line == b.Line && a.Col+len(a.Text) == b.Col
+}
+
+// readTextTerm reads a match with no label, which is
+// applied to the message text.
+//
+// TT: STR | OP STR
+func (p *Parser) readTextTerm() (Expr, error) {
+	op, rval, err := p.readMatch()
+	if err != nil {
+		return nil, err
+	}
+	return TextTerm{Op: op, Value: rval}, nil
 }
 
 type op interface {
@@ -101,10 +375,16 @@ type opSet map[string]opBuilder
 // defaultOps is a set of ops totally stolen from SWI, I have
 // literally no idea what 90% of these do.
 var defaultOps = opSet{
-	"=":  buildOpEqual,
-	"~":  buildOpMatch,
-	"!=": buildOpNotEqual,
-	"!~": buildOpNotMatch,
+	"=":   buildOpEqual,
+	"~":   buildOpMatch,
+	"!=":  buildOpNotEqual,
+	"!~":  buildOpNotMatch,
+	"~*":  buildOpContainsFold,
+	"!~*": buildOpNotContainsFold,
+	">":   buildOpGreater,
+	">=":  buildOpGreaterEqual,
+	"<":   buildOpLess,
+	"<=":  buildOpLessEqual,
 }
 
 type compareOp string
@@ -167,6 +447,127 @@ func buildOpNotMatch(lv string) (op, error) {
 	return (*matchNeOp)(re), nil
 }
 
+// containsOp is a simple substring match, used for bare words and
+// phrases in a query.
+type containsOp string
+
+func (lv containsOp) match(rv string) bool {
+	return strings.Contains(rv, string(lv))
+}
+func buildOpContains(lv string) (op, error) {
+	return containsOp(lv), nil
+}
+
+type containsFoldOp string
+
+func (lv containsFoldOp) String() string {
+	return "~*"
+}
+func (lv containsFoldOp) match(rv string) bool {
+	return strings.Contains(strings.ToLower(rv), string(lv))
+}
+func buildOpContainsFold(lv string) (op, error) {
+	return containsFoldOp(strings.ToLower(lv)), nil
+}
+
+type containsFoldNeOp string
+
+func (lv containsFoldNeOp) String() string {
+	return "!~*"
+}
+func (lv containsFoldNeOp) match(rv string) bool {
+	return !strings.Contains(strings.ToLower(rv), string(lv))
+}
+func buildOpNotContainsFold(lv string) (op, error) {
+	return containsFoldNeOp(strings.ToLower(lv)), nil
+}
+
+// orderedValue is a label value that can be ordered, either a plain
+// number, or a Go duration string such as 150ms.
+type orderedValue struct {
+	duration bool
+	v        float64
+}
+
+// parseOrderedValue parses the right hand side of an ordered comparison
+// to determine the kind of values it will be compared with.
+func parseOrderedValue(s string) (orderedValue, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return orderedValue{v: f}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return orderedValue{duration: true, v: float64(d)}, nil
+	}
+	return orderedValue{}, fmt.Errorf("%q is not a number or a duration", s)
+}
+
+// parse parses s as the same kind of value as ov. ok is false if s
+// cannot be parsed.
+func (ov orderedValue) parse(s string) (float64, bool) {
+	if ov.duration {
+		d, err := time.ParseDuration(s)
+		return float64(d), err == nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
+
+type greaterOp orderedValue
+
+func (lv greaterOp) String() string {
+	return ">"
+}
+func (lv greaterOp) match(rv string) bool {
+	v, ok := orderedValue(lv).parse(rv)
+	return ok && v > lv.v
+}
+func buildOpGreater(lv string) (op, error) {
+	ov, err := parseOrderedValue(lv)
+	return greaterOp(ov), err
+}
+
+type greaterEqualOp orderedValue
+
+func (lv greaterEqualOp) String() string {
+	return ">="
+}
+func (lv greaterEqualOp) match(rv string) bool {
+	v, ok := orderedValue(lv).parse(rv)
+	return ok && v >= lv.v
+}
+func buildOpGreaterEqual(lv string) (op, error) {
+	ov, err := parseOrderedValue(lv)
+	return greaterEqualOp(ov), err
+}
+
+type lessOp orderedValue
+
+func (lv lessOp) String() string {
+	return "<"
+}
+func (lv lessOp) match(rv string) bool {
+	v, ok := orderedValue(lv).parse(rv)
+	return ok && v < lv.v
+}
+func buildOpLess(lv string) (op, error) {
+	ov, err := parseOrderedValue(lv)
+	return lessOp(ov), err
+}
+
+type lessEqualOp orderedValue
+
+func (lv lessEqualOp) String() string {
+	return "<="
+}
+func (lv lessEqualOp) match(rv string) bool {
+	v, ok := orderedValue(lv).parse(rv)
+	return ok && v <= lv.v
+}
+func buildOpLessEqual(lv string) (op, error) {
+	ov, err := parseOrderedValue(lv)
+	return lessEqualOp(ov), err
+}
+
 func (os opSet) lookup(s string) (opBuilder, bool) {
 	opb, ok := os[s]
 	if !ok {
@@ -175,7 +576,7 @@ func (os opSet) lookup(s string) (opBuilder, bool) {
 	return opb, true
 }
 
-// OP: "=" | "!=" | "~" | "!~"
+// OP: "=" | "!=" | "~" | "!~" | "~*" | "!~*" | ">" | ">=" | "<" | "<="
 func (p *Parser) operator() (opBuilder, error) {
 	tok, err := p.next()
 	if err != nil {
@@ -0,0 +1,115 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package ql
+
+import "strings"
+
+// TokenFilter reports whether a set of messages might include one
+// matched by a query. has reports whether a token, as produced by
+// TextTokens or LabelToken, might be present in one of the messages.
+// A TokenFilter is used to skip whole files using a bloom filter of
+// the tokens in them.
+type TokenFilter func(has func(token string) bool) bool
+
+// TextTokens calls add with each token of the message text. The tokens
+// are the trigrams of the lower cased text, so any text containing a
+// substring includes all of the substring's tokens.
+func TextTokens(text string, add func(token string)) {
+	rs := []rune(strings.ToLower(text))
+	for i := 0; i+3 <= len(rs); i++ {
+		add(string(rs[i : i+3]))
+	}
+}
+
+// LabelToken returns the token for a label value.
+func LabelToken(name, value string) string {
+	return name + "\x00" + value
+}
+
+func matchAnyTokens(has func(string) bool) bool {
+	return true
+}
+
+// textTokenFilter returns a TokenFilter for a match against the
+// message text.
+func textTokenFilter(op, value string) TokenFilter {
+	switch {
+	case op == "", op == "~*", op == "=" && value != "*":
+	default:
+		return matchAnyTokens
+	}
+
+	var toks []string
+	TextTokens(value, func(t string) { toks = append(toks, t) })
+	return func(has func(string) bool) bool {
+		for _, t := range toks {
+			if !has(t) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// compileTokenFilter returns a TokenFilter for an expression. Only
+// substring and exact matches are checked, any other term might
+// match.
+func compileTokenFilter(e Expr) TokenFilter {
+	switch e := e.(type) {
+	case Term:
+		if e.Label == "__text__" {
+			return textTokenFilter(e.Op, e.Value)
+		}
+		if e.Op == "=" && e.Value != "*" {
+			tok := LabelToken(e.Label, e.Value)
+			return func(has func(string) bool) bool {
+				return has(tok)
+			}
+		}
+	case TextTerm:
+		return textTokenFilter(e.Op, e.Value)
+	case And:
+		fs := make([]TokenFilter, len(e))
+		for i := range e {
+			fs[i] = compileTokenFilter(e[i])
+		}
+		return func(has func(string) bool) bool {
+			for _, f := range fs {
+				if !f(has) {
+					return false
+				}
+			}
+			return true
+		}
+	case Or:
+		if len(e) == 0 {
+			break
+		}
+		fs := make([]TokenFilter, len(e))
+		for i := range e {
+			fs[i] = compileTokenFilter(e[i])
+		}
+		return func(has func(string) bool) bool {
+			for _, f := range fs {
+				if f(has) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return matchAnyTokens
+}
@@ -0,0 +1,66 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package ql
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestPipeline_Tokens(t *testing.T) {
+	labels := map[string]string{"job": "api", "status": "500"}
+	text := "GET /login: Connection Refused"
+
+	toks := map[string]bool{}
+	TextTokens(text, func(t string) { toks[t] = true })
+	for k, v := range labels {
+		toks[LabelToken(k, v)] = true
+	}
+	has := func(t string) bool { return toks[t] }
+
+	tests := []struct {
+		q   string
+		exp bool
+	}{
+		{`job=api`, true},
+		{`job=web`, false},
+		{`job=*`, true},
+		{`job~w.b`, true},
+		{`"connection refused"`, true}, // tokens are case insensitive
+		{`~*"connection refused"`, true},
+		{`"Connection Refused"`, true},
+		{`job=api timeout`, false},
+		{`job=api or timeout`, true},
+		{`job=web or timeout`, false},
+		{`not timeout`, true},
+		{`ok`, true},
+		{`__text__~*login`, true},
+		{`__text__~*logout`, false},
+		{`job=api | json | level=error`, true},
+	}
+
+	for i, st := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			pl, err := CompilePipeline(st.q)
+			if err != nil {
+				t.Fatalf("compile failed, %v", err)
+			}
+			if got := pl.Tokens(has); got != st.exp {
+				t.Fatalf("expected %v, got %v", st.exp, got)
+			}
+		})
+	}
+}
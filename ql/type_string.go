@@ -4,9 +4,9 @@ package ql
 
 import "fmt"
 
-const _Type_name = "EOFErrorNewlineAtomFunctorAtomLeftParenRightParenCommaSemiColonAmpersandSpecialAtom"
+const _Type_name = "EOFTokErrorNewlineStringAtomOperatorLeftParenRightParenPipe"
 
-var _Type_index = [...]uint8{0, 3, 8, 15, 19, 30, 39, 49, 54, 63, 72, 83}
+var _Type_index = [...]uint8{0, 3, 11, 18, 24, 28, 36, 45, 55, 59}
 
 func (i Type) String() string {
 	if i < 0 || i >= Type(len(_Type_index)-1) {
@@ -80,6 +80,19 @@ func defaultRule() Rule {
 	}
 }
 
+// NewTextRule returns a rule that applies the named action to the
+// full text of a message.
+func NewTextRule(action string) (*Rule, error) {
+	a, ok := actions[action]
+	if !ok {
+		return nil, errors.Errorf("unkown relabel action %q", action)
+	}
+	r := defaultRule()
+	r.Action = a
+	r.SrcLabels = []string{"__text__"}
+	return &r, nil
+}
+
 type defdRelabelRule Rule
 
 // UnmarshalYAML unmarshals yaml to a Relabel rule with appropriate defaults
@@ -102,18 +102,20 @@ func Register(ctx context.Context, srv *http.Server, grpcServer *grpc.Server, do
 		return err
 	}
 
-	sjch := basicAuth(simplejson.New(
+	sjh := simplejson.New(
 		simplejson.WithQuerier(lsrv.indx),
 		simplejson.WithTableQuerier(lsrv.indx),
 		simplejson.WithSearcher(lsrv.indx),
 		simplejson.WithTagSearcher(lsrv.indx),
 		simplejson.WithAnnotator(lsrv.indx),
-	), lsrv.grafanaUser, lsrv.grafanaPass)
+	)
+	sjch := basicAuth(sjh, lsrv.grafanaUser, lsrv.grafanaPass)
+	sjqh := basicAuth(lsrv.indx.GrafanaQueryHandler(sjh), lsrv.grafanaUser, lsrv.grafanaPass)
 
 	mux := http.NewServeMux()
 	mux.Handle("/", sjch)
 	mux.Handle("/v1/", gwmux)
-	mux.Handle("/query", sjch)
+	mux.Handle("/query", sjqh)
 	mux.Handle("/search", sjch)
 	mux.Handle("/annotations", sjch)
 	mux.Handle("/tag-keys", sjch)
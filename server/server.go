@@ -18,9 +18,14 @@ package server
 import (
 	"errors"
 	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	"github.com/QubitProducts/logspray/common"
@@ -149,11 +154,95 @@ func (l *logServer) Log(ctx context.Context, r *logspray.Message) (*logspray.Log
 }
 
 func (l *logServer) LogStream(s logspray.LogService_LogStreamServer) error {
+	return l.logStream(s.Context(), s.Recv, nil, nil)
+}
+
+// ackInterval is how often LogStreamV2 acknowledges the messages that
+// have been indexed.
+const ackInterval = time.Second
+
+func (l *logServer) LogStreamV2(s logspray.LogService_LogStreamV2Server) error {
+	// Without an index messages are acknowledged as soon as they are
+	// received, there's nothing more to wait for.
+	var received uint64
+	acked := func(id string) uint64 {
+		if l.indx != nil {
+			return l.indx.Synced(id)
+		}
+		return atomic.LoadUint64(&received)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	started := false
+	err := l.logStream(s.Context(), s.Recv,
+		func(hdr *logspray.Message) {
+			started = true
+			go func() {
+				defer close(done)
+				ackStream(s, hdr.StreamID, acked, stop)
+			}()
+		},
+		func(m *logspray.Message) {
+			atomic.StoreUint64(&received, m.Index)
+		})
+	close(stop)
+	if started {
+		<-done
+	}
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// ackStream sends an ack for stream id straight away, then whenever
+// the Index acknowledged changes, until stop is closed.
+func ackStream(s logspray.LogService_LogStreamV2Server, id string, acked func(string) uint64, stop <-chan struct{}) {
+	t := time.NewTicker(ackInterval)
+	defer t.Stop()
+
+	var last uint64
+	send := func(force bool) bool {
+		idx := acked(id)
+		if !force && idx <= last {
+			return true
+		}
+		if err := s.Send(&logspray.LogStreamAck{StreamID: id, Index: idx}); err != nil {
+			if glog.V(1) {
+				glog.Infof("failed sending ack, %v", err)
+			}
+			return false
+		}
+		last = idx
+		return true
+	}
+
+	if !send(true) {
+		return
+	}
+	for {
+		select {
+		case <-t.C:
+			if !send(false) {
+				return
+			}
+		case <-stop:
+			send(false)
+			return
+		}
+	}
+}
+
+// logStream ingests the messages returned by recv, until it fails.
+// header is called with the stream's header, and written with each
+// message once it is indexed, if they aren't nil.
+func (l *logServer) logStream(ctx context.Context, recv func() (*logspray.Message, error), header, written func(*logspray.Message)) error {
 	sourcesGauge.Add(1.0)
 	defer sourcesGauge.Sub(1.0)
 
 	var err error
-	if err := l.ensureScope(s.Context(), common.WriteScope); err != nil {
+	if err := l.ensureScope(ctx, common.WriteScope); err != nil {
 		return err
 	}
 
@@ -170,7 +259,7 @@ func (l *logServer) LogStream(s logspray.LogService_LogStreamServer) error {
 
 	var iw sinks.MessageWriter
 	for {
-		m, err := s.Recv()
+		m, err := recv()
 		if err != nil {
 			return err
 		}
@@ -182,8 +271,11 @@ func (l *logServer) LogStream(s logspray.LogService_LogStreamServer) error {
 				return errors.New("Multiple headers in one steram are not allowed")
 			}
 			hdr = m
+			if header != nil {
+				header(hdr)
+			}
 			if l.indx != nil {
-				iw, err = l.indx.AddSource(s.Context(), m.StreamID, m.Labels)
+				iw, err = l.indx.AddSource(ctx, m.StreamID, m.Labels)
 				if err != nil {
 					glog.Errorf("Error adding index source, err = %v\n", err)
 				}
@@ -220,12 +312,21 @@ func (l *logServer) LogStream(s logspray.LogService_LogStreamServer) error {
 			m.Labels = map[string]string{}
 		}
 
-		l.subs.publish(hdr, m)
-
-		err = iw.WriteMessage(s.Context(), m)
+		// Messages the index has already seen aren't published
+		// again.
+		if iw != nil {
+			err = iw.WriteMessage(ctx, m)
+			if errors.Is(err, indexer.ErrDuplicate) {
+				continue
+			}
+		}
 		if err != nil {
 			glog.Errorf("Error adding index source, err = %v\n", err)
+		} else if written != nil {
+			written(m)
 		}
+
+		l.subs.publish(hdr, m)
 	}
 }
 
@@ -249,59 +350,131 @@ func (l *logServer) Tail(r *logspray.TailRequest, s logspray.LogService_TailServ
 	tick := time.NewTicker(5 * time.Second)
 	defer tick.Stop()
 
-	matcher, err := ql.Compile(r.Query)
+	pl, err := ql.CompilePipeline(r.Query)
 	if err != nil {
 		return status.Errorf(codes.InvalidArgument, err.Error())
 	}
 
+	// Live messages are spilled while the backfill is sent, rather
+	// than left to fill mc, which would cause publish to drop them.
+	// If more than maxTailSpill arrive the tail is ended, rather than
+	// holding an unbounded number of them.
+	var spill []*logspray.Message
+	var overflowed bool
+	bctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopSpill := make(chan struct{})
+	spilled := make(chan struct{})
+	go func() {
+		defer close(spilled)
+		for {
+			select {
+			case m := <-mc:
+				if len(spill) == maxTailSpill {
+					overflowed = true
+					cancel()
+					return
+				}
+				spill = append(spill, m)
+			case <-stopSpill:
+				return
+			}
+		}
+	}()
+	seen, err := l.backfill(bctx, r, pl, func(m *logspray.Message) error {
+		if err := s.Send(m); err != nil {
+			glog.Errorf("Error sending backfill to subscribe err = %v", err)
+			return err
+		}
+		if m.ControlMessage == logspray.Message_NONE {
+			lineTxCount.Inc()
+		}
+		return nil
+	})
+	close(stopSpill)
+	<-spilled
+	if overflowed {
+		return status.Errorf(codes.ResourceExhausted, "more than %d live messages arrived during the backfill, request a shorter backfill", maxTailSpill)
+	}
+	if err != nil {
+		return err
+	}
+
 	headers := map[string]*logspray.Message{}
 	sentHeaders := map[*logspray.Message]struct{}{}
 
-	for {
-		select {
-		case m := <-mc:
-			if m.ControlMessage == logspray.Message_SETHEADER {
-				headers[m.StreamID] = m
-				continue
-			}
+	send := func(m *logspray.Message) error {
+		if m.ControlMessage == logspray.Message_SETHEADER {
+			headers[m.StreamID] = m
+			return nil
+		}
 
-			if m.ControlMessage == logspray.Message_STREAMEND {
-				hm, ok := headers[m.StreamID]
-				if !ok {
-					glog.Errorf("Got close for untracked stream")
-					continue
-				}
-				delete(headers, hm.StreamID)
-				delete(sentHeaders, hm)
-				if err := s.Send(m); err != nil {
-					glog.Errorf("Error sending stream end to subscribe err = %v", err)
-					return err
-				}
-				continue
+		// Messages without an Index can't be told apart from those
+		// in the backfill, so are always sent.
+		if idx, ok := seen[m.StreamID]; ok && m.ControlMessage == logspray.Message_NONE && m.Index != 0 {
+			if m.Index <= idx {
+				return nil
 			}
+			delete(seen, m.StreamID)
+		}
 
-			hdr, ok := headers[m.StreamID]
+		if m.ControlMessage == logspray.Message_STREAMEND {
+			hm, ok := headers[m.StreamID]
 			if !ok {
-				glog.Info("Error no known header for Stream %s", fmt.Sprintf("%s", m.StreamID))
+				glog.Errorf("Got close for untracked stream")
+				return nil
 			}
-
-			if !matcher(hdr, m, false) {
-				continue
+			delete(headers, hm.StreamID)
+			delete(sentHeaders, hm)
+			if err := s.Send(m); err != nil {
+				glog.Errorf("Error sending stream end to subscribe err = %v", err)
+				return err
 			}
-			if hdr != nil {
-				if _, ok := sentHeaders[hdr]; !ok {
-					if err := s.Send(hdr); err != nil {
-						glog.Info("Error sending to subscribe err = %v", err)
-						return err
-					}
-					sentHeaders[hdr] = struct{}{}
+			return nil
+		}
+
+		hdr, ok := headers[m.StreamID]
+		if !ok {
+			glog.Info("Error no known header for Stream %s", fmt.Sprintf("%s", m.StreamID))
+		}
+
+		if !pl.Match(hdr, m, false) {
+			return nil
+		}
+		m, ok = pl.Process(hdr, m)
+		if !ok {
+			return nil
+		}
+		if hdr != nil {
+			if _, ok := sentHeaders[hdr]; !ok {
+				if err := s.Send(hdr); err != nil {
+					glog.Info("Error sending to subscribe err = %v", err)
+					return err
 				}
+				sentHeaders[hdr] = struct{}{}
 			}
-			if err := s.Send(m); err != nil {
-				glog.Errorf("Error sending to subscribe err = %v", err)
+		}
+		if err := s.Send(m); err != nil {
+			glog.Errorf("Error sending to subscribe err = %v", err)
+			return err
+		}
+		lineTxCount.Inc()
+		return nil
+	}
+
+	for _, m := range spill {
+		if err := send(m); err != nil {
+			return err
+		}
+	}
+	spill = nil
+
+	for {
+		select {
+		case m := <-mc:
+			if err := send(m); err != nil {
 				return err
 			}
-			lineTxCount.Inc()
 		case <-tick.C:
 			err := s.Send(&logspray.Message{
 				ControlMessage: logspray.Message_OK,
@@ -320,6 +493,96 @@ func (l *logServer) Tail(r *logspray.TailRequest, s logspray.LogService_TailServ
 	}
 }
 
+// maxTailSpill is the most live messages held by a Tail while its
+// backfill is sent.
+var maxTailSpill = 100000
+
+// defaultBackfillWindow limits the search for the most recent messages
+// of a backfill when no since time or cursor is given.
+const defaultBackfillWindow = time.Hour
+
+// backfill replays the history asked for by a Tail request from the
+// index, passing it to send in time order. The highest index sent for
+// each stream is returned, so that live messages that were also
+// replayed can be dropped. Messages without an Index are not
+// recorded.
+func (l *logServer) backfill(ctx context.Context, r *logspray.TailRequest, pl *ql.Pipeline, send logspray.MessageFunc) (map[string]uint64, error) {
+	seen := map[string]uint64{}
+	if l.indx == nil || (r.Backfill <= 0 && r.Since == nil && r.Cursor == "") {
+		return seen, nil
+	}
+
+	after, err := parseCursor(r.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	var from time.Time
+	if r.Since != nil {
+		if from, err = ptypes.Timestamp(r.Since); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, err.Error())
+		}
+	}
+	to := time.Now()
+	sendFunc := logspray.MakeInjectStreamHeadersFunc(func(m *logspray.Message) error {
+		if m.ControlMessage == logspray.Message_NONE && m.Index != 0 && m.Index >= seen[m.StreamID] {
+			seen[m.StreamID] = m.Index
+		}
+		return send(m)
+	})
+
+	if r.Backfill <= 0 {
+		msgFunc := pl.MessageFunc(sendFunc)
+		err = l.indx.SearchAfter(ctx, msgFunc, pl.Match, pl.Tokens, from, to, false, after, nil)
+		return seen, err
+	}
+
+	// Find the most recent messages with a reverse search, stopping at
+	// the cursor, and then send them oldest first.
+	if after != nil && after.Time.After(from) {
+		from = after.Time
+	}
+	if r.Since == nil && after == nil {
+		from = to.Add(-defaultBackfillWindow)
+	}
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var pos *indexer.Cursor
+	var hdrs, msgs []*logspray.Message
+	msgFunc := pl.MessageFunc(func(m *logspray.Message) error {
+		switch m.ControlMessage {
+		case logspray.Message_SETHEADER:
+			hdrs = append(hdrs, m)
+		case logspray.Message_NONE:
+			if after != nil && !after.Before(pos) {
+				cancel()
+				return nil
+			}
+			msgs = append(msgs, m)
+			if int64(len(msgs)) == r.Backfill {
+				cancel()
+			}
+		}
+		return nil
+	})
+	setPos := func(c *indexer.Cursor) { pos = c }
+	err = l.indx.SearchAfter(sctx, msgFunc, pl.Match, pl.Tokens, from, to, true, nil, setPos)
+	if err != nil && (err != context.Canceled || ctx.Err() != nil) {
+		return nil, err
+	}
+
+	for _, hdr := range hdrs {
+		if err = sendFunc(hdr); err != nil {
+			return nil, err
+		}
+	}
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if err = sendFunc(msgs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return seen, nil
+}
+
 func (l *logServer) Labels(ctx context.Context, r *logspray.LabelsRequest) (*logspray.LabelsResponse, error) {
 	var err error
 	if err = l.ensureScope(ctx, common.ReadScope); err != nil {
@@ -347,16 +610,43 @@ func (l *logServer) LabelValues(ctx context.Context, r *logspray.LabelValuesRequ
 	if err != nil {
 		return nil, err
 	}
-	vs, hitcount, err := l.indx.LabelValues(r.Name, from, to, int(r.Count))
+	match, err := valueMatcher(r.Prefix, r.Regex)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+	vs, hitcount, err := l.indx.LabelValues(r.Name, from, to, int(r.Count), match)
 	if err != nil {
 		return nil, err
 	}
 
-	res := &logspray.LabelValuesResponse{Values: vs, TotalHitCount: uint64(hitcount)}
+	res := &logspray.LabelValuesResponse{TotalHitCount: uint64(hitcount)}
+	for _, v := range vs {
+		res.Values = append(res.Values, v.Value)
+		res.Counts = append(res.Counts, v.Count)
+	}
 
 	return res, nil
 }
 
+// valueMatcher returns a function accepting label values that start
+// with prefix, and match the regular expression re. An empty prefix,
+// or expression, accepts anything.
+func valueMatcher(prefix, re string) (func(string) bool, error) {
+	if prefix == "" && re == "" {
+		return nil, nil
+	}
+	var rx *regexp.Regexp
+	if re != "" {
+		var err error
+		if rx, err = regexp.Compile(re); err != nil {
+			return nil, fmt.Errorf("invalid value regex, %w", err)
+		}
+	}
+	return func(v string) bool {
+		return strings.HasPrefix(v, prefix) && (rx == nil || rx.MatchString(v))
+	}, nil
+}
+
 func (l *logServer) Search(ctx context.Context, r *logspray.SearchRequest) (*logspray.SearchResponse, error) {
 	ctx, cancel := context.WithCancel(ctx)
 
@@ -373,15 +663,22 @@ func (l *logServer) Search(ctx context.Context, r *logspray.SearchRequest) (*log
 		return nil, status.Errorf(codes.InvalidArgument, "count must be non-zero")
 	}
 
-	matcher, err := ql.Compile(r.Query)
+	pl, err := ql.CompilePipeline(r.Query)
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, err.Error())
 	}
 
+	after, err := parseCursor(r.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	var pos, last *indexer.Cursor
+	setPos := func(c *indexer.Cursor) { pos = c }
+
 	offset := r.Offset
 	count := r.Count
 	res := &logspray.SearchResponse{}
-	msgFunc := logspray.MakeFlattenStreamFunc(func(m *logspray.Message) error {
+	msgFunc := pl.MessageFunc(logspray.MakeFlattenStreamFunc(func(m *logspray.Message) error {
 		t, _ := ptypes.Timestamp(m.Time)
 		if m.ControlMessage == 0 {
 			if t.Before(from) || t.After(to) {
@@ -395,14 +692,18 @@ func (l *logServer) Search(ctx context.Context, r *logspray.SearchRequest) (*log
 		res.TotalHitCount++
 		res.Messages = append(res.Messages, m)
 		if m.ControlMessage == 0 {
+			last = pos
 			count--
 			if count == 0 {
 				cancel()
 			}
 		}
 		return nil
-	})
-	err = l.indx.Search(ctx, msgFunc, matcher, from, to, r.Reverse)
+	}))
+	err = l.indx.SearchAfter(ctx, msgFunc, pl.Match, pl.Tokens, from, to, r.Reverse, after, setPos)
+	if last != nil {
+		res.Cursor = last.String()
+	}
 	if err != nil && err != context.Canceled {
 		return res, err
 	}
@@ -410,6 +711,18 @@ func (l *logServer) Search(ctx context.Context, r *logspray.SearchRequest) (*log
 	return res, nil
 }
 
+// parseCursor decodes the cursor of a search request, if it has one.
+func parseCursor(s string) (*indexer.Cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	c, err := indexer.ParseCursor(s)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+	return c, nil
+}
+
 func (l *logServer) SearchStream(r *logspray.SearchRequest, s logspray.LogService_SearchStreamServer) error {
 	ctx := s.Context()
 	ctx, cancel := context.WithCancel(ctx)
@@ -423,15 +736,22 @@ func (l *logServer) SearchStream(r *logspray.SearchRequest, s logspray.LogServic
 		return err
 	}
 
-	matcher, err := ql.Compile(r.Query)
+	pl, err := ql.CompilePipeline(r.Query)
 	if err != nil {
 		return status.Errorf(codes.InvalidArgument, err.Error())
 	}
 
+	after, err := parseCursor(r.Cursor)
+	if err != nil {
+		return err
+	}
+	var pos, last *indexer.Cursor
+	setPos := func(c *indexer.Cursor) { pos = c }
+
 	enforceCount := r.Count != 0
 	count := r.Count
 	offset := r.Offset
-	msgFunc := logspray.MakeInjectStreamHeadersFunc(func(m *logspray.Message) error {
+	msgFunc := pl.MessageFunc(logspray.MakeInjectStreamHeadersFunc(func(m *logspray.Message) error {
 		t, _ := ptypes.Timestamp(m.Time)
 		if m.ControlMessage == 0 {
 			if t.Before(from) || t.After(to) {
@@ -446,6 +766,9 @@ func (l *logServer) SearchStream(r *logspray.SearchRequest, s logspray.LogServic
 		if err := s.Send(m); err != nil {
 			return err
 		}
+		if m.ControlMessage == 0 {
+			last = pos
+		}
 
 		if enforceCount && m.ControlMessage == 0 {
 			count--
@@ -454,12 +777,82 @@ func (l *logServer) SearchStream(r *logspray.SearchRequest, s logspray.LogServic
 			}
 		}
 		return nil
-	})
+	}))
 
-	err = l.indx.Search(ctx, msgFunc, matcher, from, to, r.Reverse)
+	err = l.indx.SearchAfter(ctx, msgFunc, pl.Match, pl.Tokens, from, to, r.Reverse, after, setPos)
+	if last != nil {
+		s.SetTrailer(metadata.Pairs(logspray.CursorTrailer, last.String()))
+	}
 	if err != nil && err != context.Canceled {
 		return err
 	}
 
 	return nil
 }
+
+func (l *logServer) Aggregate(ctx context.Context, r *logspray.AggregateRequest) (*logspray.AggregateResponse, error) {
+	var err error
+	if err = l.ensureScope(ctx, common.ReadScope); err != nil {
+		return nil, err
+	}
+
+	from, to, err := getRange(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Step == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "step must be non-zero")
+	}
+	step, err := ptypes.Duration(r.Step)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+	if step <= 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "step must be non-zero")
+	}
+
+	agg, err := ql.CompileAggregation(r.Query)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+
+	ss, err := l.indx.Aggregate(ctx, agg, from, to, step)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logspray.AggregateResponse{Series: ss}, nil
+}
+
+func (l *logServer) Explain(ctx context.Context, r *logspray.ExplainRequest) (*logspray.ExplainResponse, error) {
+	var err error
+	if err = l.ensureScope(ctx, common.ReadScope); err != nil {
+		return nil, err
+	}
+
+	from, to, err := getRange(r)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := ql.Parse(r.Query)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+	pl, err := q.Compile()
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+
+	res := &logspray.ExplainResponse{Query: q.String()}
+	for _, t := range q.Terms() {
+		res.Terms = append(res.Terms, &logspray.ExplainTerm{Term: t.Term, HeaderOnly: t.Prunable})
+	}
+
+	if res.Shards, err = l.indx.Explain(pl.Match, from, to); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
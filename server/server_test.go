@@ -0,0 +1,488 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package server
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/QubitProducts/logspray/indexer"
+	"github.com/QubitProducts/logspray/proto/logspray"
+	"github.com/QubitProducts/logspray/sinks"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/oklog/ulid"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// tailStream is a logspray.LogService_TailServer that passes the
+// messages sent to it to the test.
+type tailStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent chan *logspray.Message
+}
+
+func (s *tailStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *tailStream) Send(m *logspray.Message) error {
+	select {
+	case s.sent <- m:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+// testMessage returns message index of a stream, written at t.
+func testMessage(streamID string, index int, t time.Time) *logspray.Message {
+	ts, _ := ptypes.TimestampProto(t)
+	return &logspray.Message{
+		StreamID: streamID,
+		Index:    uint64(index),
+		Time:     ts,
+		Text:     fmt.Sprintf("line %d", index),
+	}
+}
+
+func TestTail_Backfill(t *testing.T) {
+	tests := []struct {
+		name     string
+		old      int   // messages indexed more than an hour ago
+		indexed  int   // messages indexed recently
+		backfill int64 // messages asked for
+		live     []int // Indexes published once the backfill starts
+		spill    int
+		want     []int
+		code     codes.Code
+	}{
+		{
+			name:     "overlapping",
+			indexed:  10,
+			backfill: 5,
+			live:     []int{8, 9, 10, 11, 12},
+			want:     []int{6, 7, 8, 9, 10, 11, 12},
+		},
+		{
+			name:     "after",
+			indexed:  10,
+			backfill: 3,
+			live:     []int{11, 12},
+			want:     []int{8, 9, 10, 11, 12},
+		},
+		{
+			name:     "default window",
+			old:      5,
+			indexed:  5,
+			backfill: 10,
+			live:     []int{10, 11},
+			want:     []int{6, 7, 8, 9, 10, 11},
+		},
+		{
+			name:     "spill overflow",
+			indexed:  10,
+			backfill: 5,
+			live:     []int{11, 12, 13, 14},
+			spill:    2,
+			code:     codes.ResourceExhausted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataDir, err := ioutil.TempDir("", "logspray-data")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dataDir)
+			if tt.spill != 0 {
+				defer func(n int) { maxTailSpill = n }(maxTailSpill)
+				maxTailSpill = tt.spill
+			}
+
+			idx, err := indexer.New(indexer.WithDataDir(dataDir), indexer.WithSharDuration(time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer idx.Close()
+
+			streamID := ulid.MustNew(ulid.Now(), rand.Reader).String()
+			labels := map[string]string{"job": "test"}
+			w, err := idx.AddSource(context.Background(), streamID, labels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Now()
+			for i := 1; i <= tt.old+tt.indexed; i++ {
+				mt := now.Add(-time.Second)
+				if i <= tt.old {
+					mt = now.Add(-2 * time.Hour)
+				}
+				if err := w.WriteMessage(context.Background(), testMessage(streamID, i, mt)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			l := new(WithIndex(idx), WithCheckClaims(false))
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			s := &tailStream{ctx: ctx, sent: make(chan *logspray.Message)}
+			done := make(chan error, 1)
+			go func() {
+				done <- l.Tail(&logspray.TailRequest{Backfill: tt.backfill}, s)
+			}()
+
+			// The live messages arrive while the first message of the
+			// backfill is being sent.
+			var got []int
+			record := func(m *logspray.Message) {
+				if m.ControlMessage == logspray.Message_NONE {
+					got = append(got, int(m.Index))
+				}
+			}
+			record(<-s.sent)
+			hdr := &logspray.Message{StreamID: streamID, Labels: labels, ControlMessage: logspray.Message_SETHEADER}
+			for _, i := range tt.live {
+				l.subs.publish(hdr, testMessage(streamID, i, time.Now()))
+			}
+			// Give the spill time to take the live messages.
+			time.Sleep(50 * time.Millisecond)
+
+			timeout := time.After(5 * time.Second)
+			for tt.code == codes.OK && len(got) < len(tt.want) {
+				select {
+				case m := <-s.sent:
+					record(m)
+				case err := <-done:
+					t.Fatalf("tail ended early, %v", err)
+				case <-timeout:
+					t.Fatalf("timed out, got %v, want %v", got, tt.want)
+				}
+			}
+			for tt.code != codes.OK {
+				select {
+				case m := <-s.sent:
+					record(m)
+					continue
+				case err = <-done:
+				case <-timeout:
+					t.Fatalf("timed out waiting for the tail to end")
+				}
+				if status.Code(err) != tt.code {
+					t.Fatalf("tail ended with %v, want %v", err, tt.code)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+
+			// Nothing further is sent, other than heartbeats.
+			select {
+			case m := <-s.sent:
+				if m.ControlMessage != logspray.Message_OK {
+					t.Fatalf("unexpected message %v", m)
+				}
+			case <-time.After(50 * time.Millisecond):
+			}
+			cancel()
+			if err := <-done; err != nil {
+				t.Fatalf("tail failed, %v", err)
+			}
+		})
+	}
+}
+
+func TestLabelValues(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	idx, err := indexer.New(indexer.WithDataDir(dataDir), indexer.WithSharDuration(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	now := time.Now()
+	for job, n := range map[string]int{"api": 4, "web": 2, "worker": 1} {
+		streamID := ulid.MustNew(ulid.Now(), rand.Reader).String()
+		w, err := idx.AddSource(context.Background(), streamID, map[string]string{"job": job})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i <= n; i++ {
+			if err := w.WriteMessage(context.Background(), testMessage(streamID, i, now)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	l := new(WithIndex(idx), WithCheckClaims(false))
+	from, _ := ptypes.TimestampProto(now.Add(-time.Minute))
+	to, _ := ptypes.TimestampProto(now.Add(time.Minute))
+
+	names, err := l.Labels(context.Background(), &logspray.LabelsRequest{From: from, To: to})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names.Names, []string{"job"}) {
+		t.Fatalf("got label names %v, want [job]", names.Names)
+	}
+
+	tests := []struct {
+		name   string
+		req    logspray.LabelValuesRequest
+		values []string
+		counts []uint64
+		total  uint64
+		code   codes.Code
+	}{
+		{
+			name:   "all",
+			req:    logspray.LabelValuesRequest{Name: "job"},
+			values: []string{"api", "web", "worker"},
+			counts: []uint64{4, 2, 1},
+			total:  3,
+		},
+		{
+			name:   "count",
+			req:    logspray.LabelValuesRequest{Name: "job", Count: 1},
+			values: []string{"api"},
+			counts: []uint64{4},
+			total:  3,
+		},
+		{
+			name:   "prefix",
+			req:    logspray.LabelValuesRequest{Name: "job", Prefix: "w"},
+			values: []string{"web", "worker"},
+			counts: []uint64{2, 1},
+			total:  2,
+		},
+		{
+			name:   "regex",
+			req:    logspray.LabelValuesRequest{Name: "job", Regex: "^a|er$"},
+			values: []string{"api", "worker"},
+			counts: []uint64{4, 1},
+			total:  2,
+		},
+		{
+			name:   "prefix and regex",
+			req:    logspray.LabelValuesRequest{Name: "job", Prefix: "w", Regex: "b"},
+			values: []string{"web"},
+			counts: []uint64{2},
+			total:  1,
+		},
+		{
+			name: "bad regex",
+			req:  logspray.LabelValuesRequest{Name: "job", Regex: "("},
+			code: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			req.From, req.To = from, to
+			res, err := l.LabelValues(context.Background(), &req)
+			if status.Code(err) != tt.code {
+				t.Fatalf("got error %v, want %v", err, tt.code)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(res.Values, tt.values) || !reflect.DeepEqual(res.Counts, tt.counts) || res.TotalHitCount != tt.total {
+				t.Fatalf("got %v %v of %d, want %v %v of %d", res.Values, res.Counts, res.TotalHitCount, tt.values, tt.counts, tt.total)
+			}
+		})
+	}
+}
+
+// writeRequests writes a request log for the api and web jobs to idx,
+// with the messages timed from start.
+func writeRequests(t *testing.T, idx *indexer.Indexer, start time.Time) {
+	t.Helper()
+	reqs := []struct {
+		offset time.Duration
+		job    string
+		status string
+	}{
+		{10 * time.Second, "api", "200"},
+		{20 * time.Second, "api", "500"},
+		{30 * time.Second, "web", "200"},
+		{70 * time.Second, "api", "200"},
+		{90 * time.Second, "api", "404"},
+		{150 * time.Second, "api", "200"},
+	}
+	ws := map[string]sinks.MessageWriter{}
+	ids := map[string]string{}
+	idxs := map[string]int{}
+	for _, r := range reqs {
+		if _, ok := ws[r.job]; !ok {
+			ids[r.job] = ulid.MustNew(ulid.Now(), rand.Reader).String()
+			w, err := idx.AddSource(context.Background(), ids[r.job], map[string]string{"job": r.job})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ws[r.job] = w
+		}
+		idxs[r.job]++
+		m := testMessage(ids[r.job], idxs[r.job], start.Add(r.offset))
+		m.Text = fmt.Sprintf("status=%s", r.status)
+		if err := ws[r.job].WriteMessage(context.Background(), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	idx, err := indexer.New(indexer.WithDataDir(dataDir), indexer.WithSharDuration(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	start := time.Now().Truncate(time.Hour)
+	writeRequests(t, idx, start)
+
+	l := new(WithIndex(idx), WithCheckClaims(false))
+	from, _ := ptypes.TimestampProto(start)
+	to, _ := ptypes.TimestampProto(start.Add(3 * time.Minute))
+	step := ptypes.DurationProto(time.Minute)
+
+	tests := []struct {
+		q    string
+		step *duration.Duration
+		exp  map[string][]float64
+		code codes.Code
+	}{
+		{
+			q:    `count_over_time({job=*} [1m]) by (job)`,
+			step: step,
+			exp:  map[string][]float64{"api": {2, 2, 1}, "web": {1, 0, 0}},
+		},
+		{
+			q:    `count_over_time({job=api | logfmt} [2m]) by (status)`,
+			step: step,
+			exp:  map[string][]float64{"200": {2, 2, 1}, "404": {1, 1, 0}, "500": {1, 0, 0}},
+		},
+		{
+			q:    `rate({job=web} [1m])`,
+			step: step,
+			exp:  map[string][]float64{"": {1.0 / 60, 0, 0}},
+		},
+		{
+			q:    `count_over_time({job=api} [1m])`,
+			code: codes.InvalidArgument,
+		},
+		{
+			q:    `count_over_time({job=api})`,
+			step: step,
+			code: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			res, err := l.Aggregate(context.Background(), &logspray.AggregateRequest{From: from, To: to, Query: tt.q, Step: tt.step})
+			if status.Code(err) != tt.code {
+				t.Fatalf("got error %v, want %v", err, tt.code)
+			}
+			if err != nil {
+				return
+			}
+			got := map[string][]float64{}
+			for _, s := range res.Series {
+				var key string
+				for _, v := range s.Labels {
+					key = v
+				}
+				for _, p := range s.Points {
+					got[key] = append(got[key], p.Value)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.exp) {
+				t.Fatalf("got %v, want %v", got, tt.exp)
+			}
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "logspray-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	idx, err := indexer.New(indexer.WithDataDir(dataDir), indexer.WithSharDuration(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	start := time.Now().Truncate(time.Hour)
+	writeRequests(t, idx, start)
+
+	l := new(WithIndex(idx), WithCheckClaims(false))
+	from, _ := ptypes.TimestampProto(start)
+	to, _ := ptypes.TimestampProto(start.Add(3 * time.Minute))
+
+	res, err := l.Explain(context.Background(), &logspray.ExplainRequest{From: from, To: to, Query: `job=web "timeout" | logfmt | status=500`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := `job="web" and "timeout" | logfmt | status="500"`; res.Query != exp {
+		t.Fatalf("got query %q, want %q", res.Query, exp)
+	}
+	expTerms := []*logspray.ExplainTerm{
+		{Term: `job="web"`, HeaderOnly: true},
+		{Term: `"timeout"`},
+		{Term: `status="500"`},
+	}
+	if !reflect.DeepEqual(res.Terms, expTerms) {
+		t.Fatalf("got terms %v, want %v", res.Terms, expTerms)
+	}
+	if len(res.Shards) != 1 || !res.Shards[0].Active {
+		t.Fatalf("expected the active shard, got %v", res.Shards)
+	}
+	pruned := map[string]bool{}
+	for _, f := range res.Shards[0].Files {
+		pruned[f.Labels["job"]] = f.Pruned
+	}
+	if exp := map[string]bool{"api": true, "web": false}; !reflect.DeepEqual(pruned, exp) {
+		t.Fatalf("got pruned files %v, want %v", pruned, exp)
+	}
+
+	_, err = l.Explain(context.Background(), &logspray.ExplainRequest{From: from, To: to, Query: `job=`})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("got error %v for a bad query, want %v", err, codes.InvalidArgument)
+	}
+}
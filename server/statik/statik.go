@@ -8,6 +8,6 @@ import (
 )
 
 func init() {
-	data := "PK\x03\x04\x14\x00\x08\x00\x08\x00$nDN\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x0c\x00	\x00swagger.jsonUT\x05\x00\x01\xd4BX\\\xecZ_o\xdb6\x10\x7f\xcf\xa78h{h\x81,\xee\x8aa\x0f}+\x16\x03\x0b\xd6?\x83\x93n\x18\xe6\"\xb8H'\x89\xadD\xaa\xe4\xc9i\x10\xe4\xbb\x0f$%\x99V\x14\xff\x8b\xd3\xa6\x9d\xf3\x12Y$\x8f\xbf\xe3\x1d\x7fw<\xea\xfa\x00 2\x97\x98e\xa4\xa3\x17\x10=?z\x16\x1d\xdawB\xa6*z\x01\xb6\x1d b\xc1\x05\xd9\xf6J+V\xa3Be\xa6\xd2xe\x1f\x8e\xdc+7\x08 \x9a\x916BI\xdb\xb5y\x04\xa9\x18\x0cqt\x00p\xe3D\x9b8\xa7\x92L\xf4\x02\xfe\xf5\x83r\xe6\xaa\x15`\x9f\x8d\xed\xfb\xde\xf5\x8d\x954\xf5Bg\xac\xaaB\xc4\xc8B\xc9\xd1\x07\xa3\xe4\xbco\xa5UR\xc7k\xf6E\xce\xcd\\\xbf\xd1\xec\xe7Q\x81\x17T\x98\xd1u\xaaUyd(V217\xa3kV\xdd\x8f\xae?@\x94\x11\x07?\xadVuY\xa2\xbe\xb2\x9a\xbfr\x92@\x13\xd7Z\x1a\xe0\x9c\xec\x02\x80J\xc1\xcf\x01\x1f\xa5\xba\x94 \xa4k\x8ak\xadI2\x08\x99\xd0\xe7\xa9\xc4\x98\xc5\x8c\xfc\xaf\xa3fU\xdc\x04\xaa\"\xedT9I\xe6\x93\x84\x1d4\x99JICf\x01\x18@\xf4\xfc\xd9\xb3\xde+\x80(!\x13kQqc\xad@\x90kvF\xc2[\xc3\x00\xa2\x1f5\xa5v\xc4\x0f\xa3\x84R!\x85\x95`:\x97\xf0\xb0&\x0d\x94ha\xf0\xcd\xc1\xd0\xf3M\xa0B\x85\x1aKb\xd2s#\xfa\xbf\x1ex\x89\xa5s\xc7\xd0V}\x0d\x84\xd3\xcb\x1a\xba\xdf\xa2\xe9S-4\xd9ed]S\xaf\x95\xaf*'\xdb\xb0\x162\xeb\x8fM\x95.\xd1\x9a>\x12\x92\x7f\xfd%T\xf0\xe6p5\xe0\xb97}\x13p\xed\xfa\x0e\x03\xfdT\x93\xbeZ\x824\xc5\xc2l\x0b5A\xa6\x9fX\x94\xb4)\xdc\x8e\x87\xba\x96\xaf\x05\xb6{~\x1fx7c\xd6\xf7\xeb\xe8\x95\xcaNI\xcfD\x1c\x8c\x7f\x7f\x10Ji\x14_\x93\xa3F\xd7v16\xa4\xaa\xbf\xb0\xa8i\x88\xaff\xb6\xc1@\xaa4\xa0'/\x18$\xaf\xa9t|\x05\x0b\xe4\x05O,\xcc\x11+@\xdd\xf5,\xae@dRiJ\x9e\xae\"7\x87\xea\x912\x9c\xc7\xb6\xa7\xb9\xef\x83\xe6\xdc\xff\x87\x01\xba!\x92=\xe1>\x1c\xd8X\xd5\x92\xbf<\xde\xdb\x1e\xd9=\xef88\xa8,\xa4\xfdJ\x99%\xbc\xaf2\xb0\x84\x06h\xa9<\x113\x91\xd4X@I\xc6`FK\xf3N\x95=>JV\xd9i\xa3\xdb\xc2\xc0\xdd3\xf1\x85Jn\xf9\x89w\xa1\xa1\x96\xe5L\xb1\xad\xb6\xaf\xbd\x91\xd6Qu\xe7\x0e62\xac	\xcbM\xfc\xec\xd4\x8d\x00!32\xdcd\x17\xfe\x95J[\x873Sy\x96\x13\xa4B\x1bn\xdf\x81\xf1\xa7\"\xc0\xb6\xbf\xc9U]$67\x99J\xe7\xb7\x82\x05\x16m\xae\x92P\x8au\xc1\xcd\x19\xeb\x08\xfeQ5\x94\xb5a\xa8\xb4\x9a\x89\x84\x00\xe1\xdd\xbb\x93\xe3\xa9\xb4\xd9\xcc\x1c\xc5!\xa0L\x800\xce\xe7\x13\xfby\x84\x8c\x8b\xda\x8e\xb3S\xc5\x9aJ\x92,d\xd6\xe6:B\xb2\x02\xce\x91\x1bAGS	`\xb5\x88\x0ba\x91\xa31\"\xf3Y\x92\x9d\xd7\xcfsb\x87\x1e\x82F\xce\xc9\xa2@\xd9\xa4[zF\xfap*K\xfc(d\x06\x82\xfdk\xbbh`T\xadc\xb2*\xb2\xae9o\xb2\xb1L\xccH\xda=\x0c\x82\xa9\xd1\x02\x8bB]:\x88\x95b\x8b\x16\x0bH(\xa9\xbbS\xb0;\x83\xba!%\x19(\x90I\x83\x92+\xb6\xbb7\xe0~\xd3/\xb4\xf5\x94z\xe2}\xc0\xd9NV5\x9b\xa7\xff[\x960\x84:\xce\x97\x9fQ\\\xbc]\xfb\x90r\xea$\x02\xe3G2\x9e\x0d\xec:\xf7cv\xdfs\xfd\xa8G\xe7\xb6\x1e\xd6\xfe\xe4\xf0}\x9c\x1c\x06\x13\xc7\x1d!\xdd\x10\xca\xfe\xe8\xf0p`\xbf\xd2\xd1\xa1\xde\xca'U\x9a\x1a\xfaf\xd0j\x9a\x916w\x1c\xbf\xef\x03\xf7B\xa9\x82P\xde\x8d\xb7\xed\x10\x02\xee\x9e\xbfVL\x1cH\xb0W\x87\xc6&\xc5\x1e\n\x90./l+{\x9aL]\xb0\x014]R\xbd,\xf7\x0b\x85\xef,\x8e\x06\x99R'\xe7V\xb2\xb4mp\xdd \xe9\xd9GUx\xb4p\xf7Qu\xdb@\xb5\x8f\xaa\xab\xf1n\x17\xa7\xf6Q\xf5\xd1EUFQl\x18/\xcfP\x14]8\xc4\xa0\x10U\xa8\x0c\x12d\xf4\x15\x9d\x129\xce\xc9\x15\xab\xa6\xd2\xc7\xd1\xe6\xc2\xcb\n\x98\xd0\xa7\x9a\x0c\x1f\xc1\xdf9I\xd0\x84\x89\x8dfAak*}\x0d\xc8\xb4\xa5\xa4\n\xaf\x00\xd9\x95d\x94\x04V\xf0\x9b\x92\xacU\xd1\xc4\xab\xf3\xd3\xf1\xd9\xef\xe3\x97\xc7\xe3\xc9T\xd2\xcc\x0d\xbc\xccE\x9cw%(+\xfb\x02\x8d\xbb\x973\xc1\x87\x04\xbe\x14\xd4\xd5\x9f\xcer2\xd4\xb65S_\x10`\x92Pbg\xb5J\x92d-l\x9a`\x8c\x8a\x052%p)8\x9f\xca\xb0\x94\xb5$)\xb0\x0b\xb0O\x06\x82\xadV\xe2\xe7\xddo\xb3U\xac\xb0\x15),	\xab;\xc0\xfaP\x1b\xbe\xfbn(\xb0q\xe7\x0cQc\xe4\xc5\xfd\x14\xb2\xc1\x1d+\x1a\x91\xac\xcb\x054\xd1\x9b\xb7o\xc6\xa1g\xbf\xfd#\xfc5\x9eL\xdeN\xc2\x17\xdd\x9e]xy6\x19\xbf|=~s\xdc\xaa\xd3-@\xd4\xd4\xa7-\x167\x93\xd7\xae\xa1\xb3e\x97\xcb\x03\xca\xa8\x8b\x0f\x14\xcf\x83QTi\xbbKY\xf4va\xe4\xef\xef\xfb;\xb3\x95\x82Z\xe3\xa2\xd1#\xc1T\xf6\xfb\xafi\xeap\x0f\xb1b,\xces\xc1\xe7>\xc6\xdf\x01`\xc0\xcb\x97D\xbev\xaan\xa2\xf9giw\xde\xc9\x0f\xae\xefN\x96\xd6n\xaa/\xb5\xb2+\x14_\xa5\xf3\xbcP~\x0f}\xefa\xc8\x0d\xec\xd8\xab\xe9\xf7UY\xb2\xbf\xd7\xd5\xc3%\xaa[\xa91\x90\xe7\x86>\xef\xc3\xee]\xa2{\xf0\\\x1b&\x89\xa33,\xfe\x1c\x06\x0bk{H\x80\xc3\x10\xe7\x84\x89\xfb\x9es\x10\xcaP\n\xb7,\x81[\xd8\xd8\xf4y\x95\x13\x0c\x8e\x8b=?\x9f\x97\xb7\x0c\xe8\x9a\x87\x03\xf90\xb7\x0f\xca\xf7E\x8a\x93\xe3m\xb0\xb9;\xb9\xed\\b\x03\x8aZ\x80\xdf\xf7\xeb\xde\xa5\xc0=\xdc\xbb\xbdP\xbd?3m\x9c\\=\x8eP0t\xbf\xe2r\x88\x83\x9b\x83\xff\x02\x00\x00\xff\xffPK\x07\x08D\x10CR\xda\x05\x00\x00\xee,\x00\x00PK\x01\x02\x14\x03\x14\x00\x08\x00\x08\x00$nDND\x10CR\xda\x05\x00\x00\xee,\x00\x00\x0c\x00	\x00\x00\x00\x00\x00\x00\x00\x00\x00\xa4\x81\x00\x00\x00\x00swagger.jsonUT\x05\x00\x01\xd4BX\\PK\x05\x06\x00\x00\x00\x00\x01\x00\x01\x00C\x00\x00\x00\x1d\x06\x00\x00\x00\x00"
+	data := "PK\x03\x04\x14\x00\x08\x00\x08\x007LQ]\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x0c\x00	\x00swagger.jsonUT\x05\x00\x01\xfa@\xd3j\xec\x1b]o\xdb8\xf2\xdd\xbfb\xa0\xbb\x87m/\xb5\xd3t\xdbC\xf3V\\\xb3\xb8\xe2\xb6\xed\"\xc9\xee\xe1p.RZ\x1aK\xdcJ\xa4JRNsE\xfe\xfbaH}P\x8a\xe4\xd8\x8e\x9dM\xbb\x0e\x10@\xa6\xc4\xf9\xe6\xccp8\xfc:\x02\x08\xf4%\x8bcT\xc11\x04G\xe3\xc3\xe0\x80\xc6\xb8\x98\xcb\xe0\x18\xe8=@`\xb8I\x91\xde\xe7J\x1a9Ie\xacs\xc5\xae\xe8al\x87\xec$\x80`\x81Js)\x82\xe3\xfa\x11\x844\xa0\xd1\x04#\x80k\xfa*\xd0a\x82\x19\xea\xe0\x18\xfe\xeb\xa0'\xc6\xe4\x15\x00z\xd6\xf4\xed\x07\x1a\x08B)t\xd1\xfa\x98\xe5y\xcaCf\xb8\x14\x93\xdf\xb5\x14\xcd\xb7\xb9\x92Q\x11\xae\xf8-3\x89n\xf8\x9b,\x9eNX\x1c+\x8c\x99\xc1\xc9\xd7\xb9\x92\xd9Xc(E\xa4\xaf'_\x8d\xf4~|.P]]\xd7S\x01\x82\x18\x8d\xf7\x93\xe4Yd\x19SW$\x84W\x15P0\xec\x13j`\x02*<$\x1b\x0b\x0c\x98\x88@\xa1)\x94\xd0`\x12\x04\x85\xbaH\x0d\x17\xf1T\x18\x9e!hT\x1c\xf5\xb8\x14\x11\xfd\x052GeA\xbc\x89Zh\xfco\x14\xea\\\n\x8d\x0d\x9f\xe5\x8b\xa3\xc3\xc3\xce\x10@\x10\xa1\x0e\x15\xcfM\xa9=\x0f\x10\xfd9\xa5\xb1\x1b\xd3\x00\x82\xbf*\x9c\xd3\x8c\xbfL\"\x9cs\xc1	\x82\xaeM\xa4\x16\xc0iIM\xd0\x82{=\xea{\xben\x90\x079S,C\x83\xaa\xd1\xab\xfbk\x13\x12\x08\x96Y\x0b\xf5u\xd7e\x82[\xc3$\xddw\xdf(\xfc\\p\x85$L\xa3\n\xec\xbc5W\xb9\x85\xad\x8d\xe2\"\xee\xce\x9dK\x9512\x81\x80\x0b\xf3\xe2G\x9fA\x8f\x91A\x82\x1b\xeb\xfa&\xc8\xb5&\xbb#\xc1\xae)9Ru?%\xbdDz:\x9e\xb3To*\xb5\x88\x19|B\xebr}E\x7fC\xc4j\x83\xf9\x90]v\\\xc5\x19\x8f\x05FP~\x0crn\x9d\x98\xce\x99\xb0\xcf<\xc31\xbc-\xb4\x81\x19\x02\xa9\x0c\x9e<{\xfa\xfc\xe0\xf9\xdf_\x1c\x1c\x1e\x1e\xd2\xffT\x18	\x7f\xeb\x0c\x02\x17aZh\xbe\xc01\xbc\x93\x06\x8f	\xaaF\x98\xc9BD\x1a\x98B\x08e\x96\x17\x06#\x0b\xf5x*^\x1c\x12\x11\x93\x8c\x0bx\x0c/\x0e!\xe3b\x92(x\x0cG?B\xa2&\x11\xbb\x82\xc7\xf0\xec\xc5\xf3\xf1\xd1s\x88\xd8\x95\x9e\\!\xa3\xd7O\x0f	\x1f\xfdh9\xd9{R\xd1FN\xc3\xaaG0!WT\xce\\\xb1\x90\x86\xaczX\xa9+`\x06,\x0c\xab9\n;2-\xe8+_\x87SQ+\xf1u\xe1b\x8e\x86\x145\x85*\xd2\xb0\xc0\x1a\x9a\xa2\xd0\x95+\xd4(H+\x97\xdc$\xc0\xe0p*>\x96\xb6\xf1\x11\xe6\x1c\xd3\xc8\x06<\x06\xb9\xd4\xdc\xf0\x05\x82T (H\xd2\xf3GKP\xf9\xe1\x18~\x92\n\xa2\n\xad%\xc5C(\x15dR\xe1\x010\x10R<\xf9\x1f*	\x0b\x96\x16\x08s\xa9,\x03-h\x909#\xb4`\xe8\xadf\x14\\y,\x80\x117\x08\x1d:\xbbf\xfb\xf2\xe5\xcb\x83\xf2\xdf\x99\xac7\xe0\x99\xeb\xf6M\x88\x0b\x831\xaa\xe1e\xce\x85yv\xd4\xb2\xa1\xfa\xf9C3)0,\xeeF\xd1\xe0g\x19\x9f\xa1Z\xf0\xd0s\x11\x1f\xca\xa7\xeb\x91g\x8f6I\xc2/y\xca\xb8\xd8f\x8at\xe2@B\xce\x94\xa6\x0c\xa9\x95\x14\xe5R\x19\x0d\x89\xbc\xb4F\xcbT\x98\xc0\xa5,\xd2\x88\x94\xa2\nq0\x15\x97	\x0f\x130\xa82\x0d!\x13\xa0\x8a\x14A\x16\x06\xe6<E\x0d\x85\xe6\"\x06)\xd2+\xd20W\x90 \x8bP\xe9\x03BP\xcd\xd6	S\xe4SDT\xcejp \x8b\x96%^%\xf1\x0f.\xed*\xe9\xda']\xfb\xa4k\x9ft=\x8c\xa4kG\x1e9e3L\xf52\x87\xbc\xaa#\xfe\xd9Bj\xedD5\x1a\xca\x05\x1c\x0e\xf8$\xe4\xa5\x00.\xc8\x93BX(\x85\xc2\x00\x17\x11~\x99\n\x16\xda\xf0m\x7f-s\x98\x0e\xc9\x83\xdb\xa6:\xb2\xf6\xee\xf2\xfbp\x97\xfb\x8d\xe1\x86\x1b\xc3\x1de\x8d\xb7\xfb\xa8\xc9W2\xb55]\xd5o6\xd7\xef\xf1Wv\x13\xa0\xed.\x809\xe7\x05\xbd\xcek*\xac\xbf\x82\x96\xf3\x82\x1f\xc8|&F\xba=\xa6\xfb2\xbd\x02\x1e\x0b\xa90z\xb4\xa4\x0cg\xbd\x88\xa5\xea\x81z8G\xdb\xde\xcd}\x1fn\xce\x92\xbd\x9b\x12\xe7\x9a\x82\xdb;\xdc\x0d\x1d\xee\n\xc4\x86\xb2\x10\xe6\xfe\x85\xbbQY*W8\xe7_vF\xec\x9a\xd4(\x8c\xf1~\x88\xd9Q\xb5%\x95\xb1\x1f\x11s\xa9\x97d\xef2\x06\xf2\xf5t\xc4\xc4E\xc4\x17<*X\n\x19j\xcdb\\\x9a\x92\xcb\xf8\xe1\xe5\xe32>+O\xd1Z\xf8\xaeG}\xcfw9/\x9a\xc9\xe8\x86Ur1\xf4f\xb9\xb7\xdf\x94\xdb\xb7NI\xab\xb0\xba\xddr^*\xe3\x896\nY\xb6\x8e\x9d\x9d\xd9\x19\xc0E\x8c\xda\x94\x89\x97\x1b\x92\xf3\xca\xe0\xf4T\x9c'\x08s\xae\xb4\xa9\xc6\x80\n\xc3\x94\x82\xb1\xea{\x9d\xd8B\x9bF3\x15\xd6n\xb9\xe1,\xad\xd2\xb8\x08\xe7\xacHM\xb9\xfd\x1c\xc3\x7fd\xe1j\xb8\xb9\x92\x0b\x1e!0\xf8\xf5\xd77\xaf\xa7\xa2*\xf7:\xa8\xb6\xb4\x07\xc8\xc2\xa4A\xec\xf0\xd8\x1a-\xcd#T\xa1\xc2\x0c\x85;fui \xa7\xa2\xaeI\x98)\x01\x8d\xa7\x02\x80\xb8\x08SN\x943m\x8b\xc5\xb4\xfb%\xbc\x0e\xcf\x1b\x9az\x00\x8a\x99\x04\xa9\xe8\xccD\xb9sV\x0bT\x07S\x91\xb1OT\x81\xe4\xc6\x0d\x93\xd0@\xcbB\x85H\xd22\xaa0I\x99\xa8\xc6|\x81\x82\xd60p\x83%\x17,M\xe5\xa5%1\x97\x86\xa8e)D\x18\x15\xf5\xb96\x01qS2\xd4\x902\x83\n\xa4\xb8e\xb9;\x05>\xb8\xb3\xe2?v\xd1w\xf2\xee\x1f\x9c\x0dX\xdd\x89\xbc0\xfaQ\x7fX\xfb\x13x	Wx\xdff\xcd\xff\xccB\xacz\"h\xb9\x91\x9c\xbbA\xb9\xbb\xbdr\xb3\x1e\\\x8b\x83#k\xbf\xa9\xfa>6U\xbd\x99\xe1\x96\x1aG\xd6\x94\xdc~W\xf5\xdd\xed\xaa\x8a\x8d\xb6Ur>\xa7\x0e\xba{7\x85\xcd\xa8UH]\x80\xb8}c\x98I\x99\"\x13\xc3\xf4V\x1f\xac)\xde\xb0PZ\xaa\x9d\xad\xb4{\xc8\xe3W\x8f\xd0=\xe9\xfe\xb2\xfeE\x17\xdb\xca\x84\xbf/\\\xb7\x9a\x17]\xe3\xa2\xa6v\x8a*\xc5_\x96\x89\xfa\xc0\xb7\xd6\xb8\xe8\xe5mu\x03\xe4\xa3m\x85\xfa56jw\xd9\x93\xeec\xfc>\xc6\xefc\xfc>\xc6\xefc\xfc>\xc6\xd71\xde0\x9e\xae\x19\xbd\xcf\x19O\xeb\xf3\xd1\xba\xe8VV\x8c\"f\x18\xd5\xab\x0cd\xcc\x84	\xdaB\xdeTX\xe5UM\x1e\x04\xe0\x14?\x17\xa8\xcd\x18\xfe\x9d\xa0\xb0\x0dq\xb4W\xf7\x8a~S\xe1\xeac\xba*\xb3\xe5\xec\n\x98\xb1\xe5*)\xc0H\xf8\x87\x14F\xc9\xb4\x8c\x9e\x17g'\xe7\xff<y\xf5\xfa\xe4t*pa'\xba&\xbe\xaa<G\xb0gLS\x11\xcdh\xaf\xff\xc4\x95\xc9\xea\xda\xdc\xb9\xed\x07.{SJ\xd43\x04\x16E\x18\x11Vb\x12\x85\xa1\xbb\x13T\xba\x93!gU[\xeaT\xf8e\xbe%%\x07\x12\x80\xff\xfaNw*\xbe\x87\xd4\xa4w\xad\xecd\x19\xad\x10\x8df,\xfc4\xe7iz\xff\x01t#\xa7\xa9\xb9\x08w\xb0-\xba\xcd\xc3o\x1e?\xbfM\x9fY_4\xf3\x8auuU/(\x9dP\xdb%yE\xbf!\xb1\x06(\x8a\xacEM\xf0\xee\xfd\xbb\x13O\x04\xc1\xfb\x7f\xf9\xbfNNO\xdf\x9f\xfa\x03\xb5\xdbk\x0d\x9e\x9f\x9e\xbcz{\xf2\xeeu0\xea\x94n\x83\xf2\xf8\x83\xcc\xd1bj5c\x0f\xdf\xaf\xeaaE\xce~\xc7\xb0\xa9\x1f\xd0M\xb9\x1c\x95\xe1\x9d\x8e\x94\xc0]4k\x8dyP\x98R\xac\xbd\x9e\x02:\xa8\xe8~\x7f\xebi\xd7\x99\xc3\xd2\x1b2\xab\xa7z95W\x0f\x07n\x92u\x05Rv>\xff\xc4\xd3\xbb\x89\xc2\x86\x86\x0b\x1e\x0dI\xa3\x1b\xf8k\x8a\x1bg\xd9+\xc5e\xf3\\,\x1b\xc2\xd8\xd1\"\xfd\x05,\x8a\xactY\xfaK?#Kq\x0f\xd6\xa4U!p\x90\xf3\xbe2\x8c\x97P\xde(\xc2,\xd1\xa9\xaf\xac^\xf3.?\xd8\x86q;\x8f\xb5\x81V\xec\xdd\x82]/\x8a\x92\xd1sT\xd9\xc0\xcah\xc4\x1d\xb8\xfb\n\xf7D\xd2\x19!\x1b\xa0i\xd4\xa1\xed\x86fk\xc5-\xd3\xae\xc3\xd0p3d\xf1\x83~k\xb3U\xaa\x0dS\xed\xca\xd7\xcd\x89\x07\xa3\xde\xf0\xdf\x13R}\xfd\xb8~\xc2!\xd0k\xaf\x1f\x0f\xb2\xbd\x9e\xb2k\x07]\xdab\xb3*K-\x8f\xbaO\xc3z\xf7\x8c\xa6\x1b\xb1|Ko\x18\x19\xf2r\x83:7\xed\xf9}\xca+A\xb4u\xe3\xee\xff\\\xd0\x8d\xa0\xa1\xe9k+h\xd4Asc\x194\xcb\xba+\x8d\xbe\xb6\xcc;H\xc5u\xbe\xde=\x82\x0fIr(b\x18iXz\x91ps\xe1\x0eV\x06\x08X\xbe\xaa\xba\xc7\x0d\xbe\xda,\xd8\xed36\x984\xf7\x1c}\x8c\xbaO=\xfa\x1e\xec\xb2\xed\xe6m\x9d\x0b\x07\x0d\x9dC\xe1~p!P\xb2\xb1}\xc1x/Wg\xfc6\x9e\xab\xce\x93W\xe1\xa7\xbbp\xecN\x0c\xde\xbc\xbe\xc5\xca\xea	\xb5\x9e\x00\x02\xdb\xaes\xcb\xc4\xe5\xe6y0\x1an\x1a\xb1\xd0\x81\xdbR\n$<NP\x1b\xd7!T_\xa9\xadz\xa7\"\xfc\x82\xd1\xd8#r\xd4!\xb6\xdb\x90\xe2K\x0fXH\xfd\xec)F\xb1\xab\xdbT\x9dN\xd5\xfd\xde\xb2\xbd\x8a\xba\x96\x8c\x9c\x8az\xeeoG\xae\xe4\xd3\xb4(A\xc2t\x87\x9c\x1b\xb6\xda\xf4\xe5\xdcAkwp\x0c\x9d\xb5\xb8\xc4\xdfv[\x88\xba\xac\x94\xbb\xbf\xbb\xf0a\x8f\xc6\xfd\x91\x95\xd9\xb8%kx(\xd9\xbfF\xe3\x82\xe4\xd6\xe2\xa3\x07\xdc\xe0\x97\xdbr\xae\xdey\xa1\xdb\xaf_\x94v\xde\x05\xd1\xdf_\xd9\xbf\xd7\xef\x85\xff\xc7\xfa\x94UL\xbbE~\xb7\x0c\xf0\x8b\xe4\xad\xc5\xf5p\xac\xdaf$C\x90E\x91\xcdP\x0d\x19R$\x8bY\x8a+\xad{\xc7\x7f\xafh:\xedY\x0d%k\xcb\xa8\xf2\xb1\xad\xd1\x1d\x14Hn\x18j\xc3\xfa}g]\xae\x02\xb8\x1c\xee\xa8Kd\x8f\xf1\xf6\xf5\xc8uKYe]\xa8A\xb6\xb6k~0\xf5\x13\xb2\xc6\x9d\x9b\x89g\xf3m\xf9/])~\xf5\xedz\x04p=\xba\x1e\xfd\x7f\x00PK\x07\x08\xc0|\xc0\x1f\xc3	\x00\x00\x06L\x00\x00PK\x01\x02\x14\x03\x14\x00\x08\x00\x08\x007LQ]\xc0|\xc0\x1f\xc3	\x00\x00\x06L\x00\x00\x0c\x00	\x00\x00\x00\x00\x00\x00\x00\x00\x00\xa4\x81\x00\x00\x00\x00swagger.jsonUT\x05\x00\x01\xfa@\xd3jPK\x05\x06\x00\x00\x00\x00\x01\x00\x01\x00C\x00\x00\x00\x06\n\x00\x00\x00\x00"
 	fs.Register(data)
 }
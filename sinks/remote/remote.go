@@ -17,6 +17,9 @@ package remote
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"path/filepath"
 	"time"
 
 	"github.com/QubitProducts/logspray/proto/logspray"
@@ -29,17 +32,77 @@ import (
 // logspray gRPC server
 type Remote struct {
 	client logspray.LogServiceClient
+
+	acks      bool
+	replayMax int
+	replayDir string
+}
+
+// Opt is a type for configuration options for the Remote sink
+type Opt func(*Remote)
+
+// WithAcks streams messages with LogStreamV2, keeping up to max of the
+// messages of each stream that the server hasn't yet acknowledged, or
+// all of them if max is 0. They are resent if the stream has to be
+// reopened. If dir isn't empty the
+// messages are kept in files in dir, rather than in memory, and those
+// left by a previous reader can be resent with ResendBuffered.
+func WithAcks(max int, dir string) Opt {
+	return func(r *Remote) {
+		r.acks = true
+		r.replayMax = max
+		r.replayDir = dir
+	}
 }
 
 // New creates a new sink that send via the provided client
-func New(client logspray.LogServiceClient) *Remote {
+func New(client logspray.LogServiceClient, opts ...Opt) *Remote {
 	r := &Remote{
 		client: client,
 	}
 
+	for _, o := range opts {
+		o(r)
+	}
+
 	return r
 }
 
+// ResendBuffered resends, in the background, the messages left in the
+// replay buffers of dir by a previous reader that exited before the
+// server acknowledged them.
+func (r *Remote) ResendBuffered() error {
+	if !r.acks || r.replayDir == "" {
+		return nil
+	}
+	fns, err := filepath.Glob(filepath.Join(r.replayDir, "*"+replaySuffix))
+	if err != nil {
+		return err
+	}
+
+	for _, fn := range fns {
+		go func(fn string) {
+			buf, header, err := openDiskBuffer(fn, r.replayMax)
+			if err != nil {
+				glog.Errorf("failed opening replay buffer, %v", err)
+				return
+			}
+			if glog.V(1) {
+				glog.Infof("resending %d messages of stream %s", buf.len(), header.StreamID)
+			}
+			w := &AckedMessageWriter{
+				Remote: r,
+				header: header,
+				buf:    buf,
+			}
+			if err := w.Close(); err != nil {
+				glog.Errorf("failed resending stream %s, %v", header.StreamID, err)
+			}
+		}(fn)
+	}
+	return nil
+}
+
 // MessageWriter is a sinks.MessageWriter that writes to a remote server
 type MessageWriter struct {
 	*Remote
@@ -50,14 +113,32 @@ type MessageWriter struct {
 
 // AddSource adds a new source ot the remote server
 func (r *Remote) AddSource(id string, labels map[string]string) (sinks.MessageWriter, error) {
+	header := &logspray.Message{
+		Labels:         labels,
+		StreamID:       id,
+		ControlMessage: logspray.Message_SETHEADER,
+	}
+
+	if r.acks {
+		var buf replayBuffer = newMemBuffer(r.replayMax)
+		if r.replayDir != "" {
+			db, err := newDiskBuffer(r.replayDir, header, r.replayMax)
+			if err != nil {
+				return nil, fmt.Errorf("failed creating replay buffer, %w", err)
+			}
+			buf = db
+		}
+		return &AckedMessageWriter{
+			Remote: r,
+			header: header,
+			buf:    buf,
+		}, nil
+	}
+
 	return &MessageWriter{
-		strc:   nil,
-		Remote: r,
-		header: &logspray.Message{
-			Labels:         labels,
-			StreamID:       id,
-			ControlMessage: logspray.Message_SETHEADER,
-		},
+		strc:       nil,
+		Remote:     r,
+		header:     header,
 		headerSent: false,
 	}, nil
 }
@@ -122,3 +203,194 @@ func (r *MessageWriter) Close() error {
 	_, err := r.strc.CloseAndRecv()
 	return err
 }
+
+// closeTimeout is how long Close waits for the server's final ack.
+const closeTimeout = 10 * time.Second
+
+// AckedMessageWriter is a sinks.MessageWriter that writes to a remote
+// server with LogStreamV2, resending the messages the server hasn't
+// acknowledged when the stream is reopened.
+type AckedMessageWriter struct {
+	*Remote
+	header *logspray.Message
+	buf    replayBuffer
+
+	strc   logspray.LogService_LogStreamV2Client
+	cancel context.CancelFunc
+	acks   *ackRecv
+	opened bool
+}
+
+// ackRecv is the state of the goroutine receiving the acks of a
+// stream.
+type ackRecv struct {
+	done  chan struct{} // closed when acks are no longer received
+	ended bool          // the server ended the stream, only read after done is closed
+}
+
+// WriteMessage writes a message to the log stream.
+func (r *AckedMessageWriter) WriteMessage(ctx context.Context, m *logspray.Message) error {
+	// Messages without an Index can't be acknowledged, so they aren't
+	// resent.
+	if m.Index != 0 {
+		if err := r.buf.add(m); err != nil {
+			return err
+		}
+	}
+
+	b := backoff.New(10*time.Second, 1*time.Second)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if r.strc != nil {
+			select {
+			case <-r.acks.done:
+				r.reset()
+			default:
+			}
+		}
+
+		if r.strc == nil {
+			if err := r.open(ctx); err != nil {
+				if glog.V(1) {
+					glog.Infof("failed opening log stream, %v", err)
+				}
+				<-time.After(b.Duration())
+				continue
+			}
+			// Opening the stream sent the buffered messages,
+			// including this one.
+			if m.Index != 0 {
+				return nil
+			}
+		}
+
+		if err := r.strc.Send(m); err != nil {
+			if glog.V(1) {
+				glog.Infof("failed sending message, %v", err)
+			}
+			r.reset()
+			<-time.After(b.Duration())
+			continue
+		}
+		return nil
+	}
+}
+
+// open starts a new stream, and resends the buffered messages the
+// server hasn't acknowledged.
+func (r *AckedMessageWriter) open(ctx context.Context) error {
+	sctx, cancel := context.WithCancel(ctx)
+	strc, err := r.client.LogStreamV2(sctx)
+	if err == nil {
+		err = strc.Send(r.header)
+	}
+
+	// The server acknowledges the header with the last Index of the
+	// stream it has indexed, only the messages after it are resent.
+	var ack *logspray.LogStreamAck
+	if err == nil {
+		ack, err = strc.Recv()
+	}
+	if err != nil {
+		cancel()
+		return err
+	}
+	r.buf.ack(ack.Index)
+
+	acks := &ackRecv{done: make(chan struct{})}
+	go r.recvAcks(strc, acks)
+
+	n := 0
+	err = r.buf.messages(func(m *logspray.Message) error {
+		n++
+		return strc.Send(m)
+	})
+	if r.opened {
+		replayedMessages.Add(float64(n))
+	}
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	r.strc, r.cancel, r.acks = strc, cancel, acks
+	r.opened = true
+	return nil
+}
+
+// recvAcks drops the messages the server acknowledges from the
+// buffer, until the stream ends.
+func (r *AckedMessageWriter) recvAcks(strc logspray.LogService_LogStreamV2Client, acks *ackRecv) {
+	defer close(acks.done)
+	for {
+		ack, err := strc.Recv()
+		if err == io.EOF {
+			acks.ended = true
+			return
+		}
+		if err != nil {
+			if glog.V(1) {
+				glog.Infof("failed receiving ack, %v", err)
+			}
+			return
+		}
+		r.buf.ack(ack.Index)
+	}
+}
+
+func (r *AckedMessageWriter) reset() {
+	r.cancel()
+	r.strc = nil
+}
+
+// Close closes the remote stream, once the server has received every
+// message. If the stream has failed it is reopened to resend the
+// messages the server hasn't acknowledged. Close gives up after
+// closeTimeout.
+func (r *AckedMessageWriter) Close() error {
+	defer r.buf.close()
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+
+	var err error
+	for r.strc != nil || r.buf.len() > 0 {
+		if r.strc == nil {
+			if err = r.open(ctx); err != nil {
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Second):
+					continue
+				}
+				break
+			}
+		}
+
+		// The server ends the stream once it has received the
+		// messages sent before CloseSend.
+		err = r.strc.CloseSend()
+		acks := r.acks
+		ended := false
+		select {
+		case <-acks.done:
+			ended = acks.ended
+		case <-ctx.Done():
+		}
+		r.reset()
+		if ctx.Err() != nil || ended {
+			break
+		}
+	}
+
+	if n := r.buf.len(); n > 0 && glog.V(1) {
+		glog.Infof("closed stream %s with %d unacknowledged messages", r.header.StreamID, n)
+	}
+	if ctx.Err() != nil && err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
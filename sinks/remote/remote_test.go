@@ -0,0 +1,257 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/QubitProducts/logspray/proto/logspray"
+	"google.golang.org/grpc"
+)
+
+// fakeServer is a logspray.LogServiceClient implementing LogStreamV2
+// the way the server does, acking every ackEvery messages, and once the
+// stream is closed. The first stream to reach failAt messages breaks,
+// silently dropping the rest of its messages.
+type fakeServer struct {
+	logspray.LogServiceClient
+	ackEvery int
+	failAt   int
+
+	sync.Mutex
+	sent    int
+	indexed map[string][]uint64
+	dups    int
+}
+
+func newFakeServer(ackEvery, failAt int) *fakeServer {
+	return &fakeServer{
+		ackEvery: ackEvery,
+		failAt:   failAt,
+		indexed:  map[string][]uint64{},
+	}
+}
+
+func (s *fakeServer) LogStreamV2(ctx context.Context, opts ...grpc.CallOption) (logspray.LogService_LogStreamV2Client, error) {
+	return &fakeStream{
+		srv:    s,
+		ctx:    ctx,
+		acks:   make(chan *logspray.LogStreamAck, 1024),
+		broken: make(chan struct{}),
+	}, nil
+}
+
+func (s *fakeServer) last(id string) uint64 {
+	is := s.indexed[id]
+	if len(is) == 0 {
+		return 0
+	}
+	return is[len(is)-1]
+}
+
+func (s *fakeServer) messages(id string) []uint64 {
+	s.Lock()
+	defer s.Unlock()
+	return append([]uint64(nil), s.indexed[id]...)
+}
+
+type fakeStream struct {
+	grpc.ClientStream
+	srv      *fakeServer
+	ctx      context.Context
+	header   *logspray.Message
+	acks     chan *logspray.LogStreamAck
+	broken   chan struct{}
+	isBroken bool
+	n        int
+}
+
+var errBroken = errors.New("stream broken")
+
+func (s *fakeStream) Send(m *logspray.Message) error {
+	srv := s.srv
+	srv.Lock()
+	defer srv.Unlock()
+	if s.isBroken {
+		return nil
+	}
+	if s.header == nil {
+		s.header = m
+		s.acks <- &logspray.LogStreamAck{StreamID: m.StreamID, Index: srv.last(m.StreamID)}
+		return nil
+	}
+
+	srv.sent++
+	if srv.failAt > 0 && srv.sent == srv.failAt {
+		srv.failAt = 0
+		s.isBroken = true
+		close(s.broken)
+		return nil
+	}
+	id := s.header.StreamID
+	if m.Index <= srv.last(id) {
+		srv.dups++
+		return nil
+	}
+	srv.indexed[id] = append(srv.indexed[id], m.Index)
+	if s.n++; s.n%srv.ackEvery == 0 {
+		s.acks <- &logspray.LogStreamAck{StreamID: id, Index: m.Index}
+	}
+	return nil
+}
+
+func (s *fakeStream) Recv() (*logspray.LogStreamAck, error) {
+	select {
+	case ack, ok := <-s.acks:
+		if !ok {
+			return nil, io.EOF
+		}
+		return ack, nil
+	case <-s.broken:
+		return nil, errBroken
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
+
+func (s *fakeStream) CloseSend() error {
+	srv := s.srv
+	srv.Lock()
+	defer srv.Unlock()
+	if !s.isBroken {
+		id := s.header.StreamID
+		s.acks <- &logspray.LogStreamAck{StreamID: id, Index: srv.last(id)}
+		close(s.acks)
+	}
+	return nil
+}
+
+func checkIndexed(t *testing.T, got []uint64, n int) {
+	if len(got) != n {
+		t.Fatalf("server indexed %d messages, want %d", len(got), n)
+	}
+	for i, idx := range got {
+		if idx != uint64(i+1) {
+			t.Fatalf("server indexed message %d at %d, want %d", idx, i, i+1)
+		}
+	}
+}
+
+func TestAckedMessageWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		disk   bool
+		failAt int
+	}{
+		{name: "memory"},
+		{name: "memory fail", failAt: 20},
+		{name: "memory fail last", failAt: 50},
+		{name: "disk", disk: true},
+		{name: "disk fail", disk: true, failAt: 20},
+		{name: "disk fail last", disk: true, failAt: 50},
+	}
+
+	for _, st := range tests {
+		t.Run(st.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "remote")
+			if err != nil {
+				t.Fatalf("failed creating temp dir, %v", err)
+			}
+			defer os.RemoveAll(dir)
+
+			srv := newFakeServer(7, st.failAt)
+			replayDir := ""
+			if st.disk {
+				replayDir = dir
+			}
+			r := New(srv, WithAcks(0, replayDir))
+			id := testHeader().StreamID
+			w, err := r.AddSource(id, map[string]string{"job": "test"})
+			if err != nil {
+				t.Fatalf("failed adding source, %v", err)
+			}
+
+			const n = 50
+			for i := 1; i <= n; i++ {
+				m := &logspray.Message{Index: uint64(i), Text: "message"}
+				if err := w.WriteMessage(context.Background(), m); err != nil {
+					t.Fatalf("failed writing message %d, %v", i, err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("failed closing writer, %v", err)
+			}
+
+			checkIndexed(t, srv.messages(id), n)
+			if srv.dups != 0 {
+				t.Fatalf("server received %d acknowledged messages again", srv.dups)
+			}
+			if l := w.(*AckedMessageWriter).buf.len(); l != 0 {
+				t.Fatalf("%d messages left in the buffer", l)
+			}
+			if fns, _ := filepath.Glob(filepath.Join(dir, "*"+replaySuffix)); len(fns) != 0 {
+				t.Fatalf("replay buffers left behind, %v", fns)
+			}
+		})
+	}
+}
+
+func TestRemote_ResendBuffered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remote")
+	if err != nil {
+		t.Fatalf("failed creating temp dir, %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A reader that exited before the server acknowledged its
+	// messages.
+	header := testHeader()
+	b, err := newDiskBuffer(dir, header, 0)
+	if err != nil {
+		t.Fatalf("failed creating buffer, %v", err)
+	}
+	addMessages(t, b, 1, 20)
+	if err := b.close(); err != nil {
+		t.Fatalf("failed closing buffer, %v", err)
+	}
+
+	srv := newFakeServer(7, 0)
+	r := New(srv, WithAcks(0, dir))
+	if err := r.ResendBuffered(); err != nil {
+		t.Fatalf("failed resending buffers, %v", err)
+	}
+
+	fn := filepath.Join(dir, header.StreamID+replaySuffix)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(fn); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replay buffer wasn't removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	checkIndexed(t, srv.messages(header.StreamID), 20)
+}
@@ -0,0 +1,370 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package remote
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/QubitProducts/logspray/proto/logspray"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	replayedMessages = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "logspray_reader_replayed_messages_total",
+		Help: "Counter of unacknowledged messages resent after reconnecting to the server.",
+	})
+	replayDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "logspray_reader_replay_dropped_messages_total",
+		Help: "Counter of unacknowledged messages dropped from full replay buffers.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(replayedMessages)
+	prometheus.MustRegister(replayDropped)
+}
+
+// replayBuffer holds the messages of a stream that have been sent to
+// the server, but not yet acknowledged, so that they can be resent if
+// the stream fails. Messages are added in order of their Index. Once a
+// buffer holds its maximum number of messages, if it has one, the
+// oldest are dropped.
+type replayBuffer interface {
+	add(m *logspray.Message) error
+	// ack drops the messages with an Index no greater than index.
+	ack(index uint64)
+	// messages calls fn with each message in the buffer, oldest
+	// first.
+	messages(fn func(*logspray.Message) error) error
+	len() int
+	close() error
+}
+
+// memBuffer is a replayBuffer holding messages in memory.
+type memBuffer struct {
+	max int
+
+	sync.Mutex
+	msgs []*logspray.Message
+}
+
+func newMemBuffer(max int) *memBuffer {
+	return &memBuffer{max: max}
+}
+
+func (b *memBuffer) add(m *logspray.Message) error {
+	b.Lock()
+	defer b.Unlock()
+	if b.max > 0 && len(b.msgs) >= b.max {
+		b.drop(len(b.msgs) - b.max + 1)
+		replayDropped.Inc()
+	}
+	b.msgs = append(b.msgs, m)
+	return nil
+}
+
+func (b *memBuffer) ack(index uint64) {
+	b.Lock()
+	defer b.Unlock()
+	b.drop(sort.Search(len(b.msgs), func(i int) bool { return b.msgs[i].Index > index }))
+}
+
+// drop removes the oldest n messages.
+func (b *memBuffer) drop(n int) {
+	for i := 0; i < n; i++ {
+		b.msgs[i] = nil
+	}
+	b.msgs = b.msgs[n:]
+}
+
+func (b *memBuffer) messages(fn func(*logspray.Message) error) error {
+	b.Lock()
+	msgs := append([]*logspray.Message(nil), b.msgs...)
+	b.Unlock()
+
+	for _, m := range msgs {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *memBuffer) len() int {
+	b.Lock()
+	defer b.Unlock()
+	return len(b.msgs)
+}
+
+func (b *memBuffer) close() error {
+	return nil
+}
+
+// compactBytes is the amount of acknowledged data at the start of a
+// diskBuffer's file that causes it to be compacted, once it is also
+// more than half the file.
+var compactBytes int64 = 16 << 20
+
+// replaySuffix ends the names of the files of diskBuffers.
+const replaySuffix = ".replay"
+
+// diskBuffer is a replayBuffer holding messages in a file, so that
+// large buffers don't have to be kept in memory, and messages that
+// were never acknowledged can be resent after the reader restarts.
+// Each record of the file is a message, preceded by its size as a
+// little endian uint32, and the first is the stream header. Only the
+// offsets of the messages are kept in memory. The file is removed when
+// the buffer is closed, if every message was acknowledged.
+type diskBuffer struct {
+	max int
+	fn  string
+
+	sync.Mutex
+	f     *os.File
+	start int64 // the end of the header
+	end   int64 // the end of the data written to the file
+	recs  []diskRecord
+}
+
+// diskRecord is the location of a message in a diskBuffer's file.
+type diskRecord struct {
+	index  uint64
+	offset int64
+	size   int
+}
+
+// newDiskBuffer creates the buffer for a new stream in dir.
+func newDiskBuffer(dir string, header *logspray.Message, max int) (*diskBuffer, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	fn := filepath.Join(dir, header.StreamID+replaySuffix)
+	f, err := os.OpenFile(fn, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, err
+	}
+	b := &diskBuffer{max: max, fn: fn, f: f}
+	if err = b.write(header); err != nil {
+		f.Close()
+		os.Remove(fn)
+		return nil, fmt.Errorf("failed writing replay buffer header, %w", err)
+	}
+	b.start = b.end
+	return b, nil
+}
+
+// openDiskBuffer opens the buffer left in fn by a reader that stopped
+// before its messages were acknowledged, returning it and the header of
+// its stream. A partially written record at the end of the file is
+// dropped.
+func openDiskBuffer(fn string, max int) (*diskBuffer, *logspray.Message, error) {
+	f, err := os.OpenFile(fn, os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	b := &diskBuffer{max: max, fn: fn, f: f}
+
+	var header *logspray.Message
+	r := bufio.NewReader(f)
+	for {
+		m, n, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			glog.Errorf("dropping the end of replay buffer %s, %v", fn, err)
+			break
+		}
+		if header == nil {
+			header = m
+			b.start = int64(n)
+		} else {
+			b.append(diskRecord{index: m.Index, offset: b.end, size: n})
+		}
+		b.end += int64(n)
+	}
+	if header == nil || header.ControlMessage != logspray.Message_SETHEADER {
+		f.Close()
+		return nil, nil, fmt.Errorf("replay buffer %s has no stream header", fn)
+	}
+	if err = f.Truncate(b.end); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return b, header, nil
+}
+
+// readRecord reads a record of a diskBuffer's file, returning its
+// message and the size of the record.
+func readRecord(r io.Reader) (*logspray.Message, int, error) {
+	szbs := make([]byte, 4)
+	if _, err := io.ReadFull(r, szbs); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, 0, errors.New("short read for record size")
+		}
+		return nil, 0, err
+	}
+	sz := binary.LittleEndian.Uint32(szbs)
+	if sz > maxRecordSize {
+		return nil, 0, fmt.Errorf("record size %d is too large", sz)
+	}
+	bs := make([]byte, sz)
+	if _, err := io.ReadFull(r, bs); err != nil {
+		return nil, 0, errors.New("short read for record")
+	}
+	m := &logspray.Message{}
+	if err := proto.Unmarshal(bs, m); err != nil {
+		return nil, 0, fmt.Errorf("failed unmarshaling record, %w", err)
+	}
+	return m, len(szbs) + len(bs), nil
+}
+
+// maxRecordSize limits the size of the messages read from a
+// diskBuffer's file.
+const maxRecordSize = 64 << 20
+
+// write appends a record of m to the file.
+func (b *diskBuffer) write(m *logspray.Message) error {
+	bs, err := proto.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("marshal protobuf failed, %w", err)
+	}
+	rec := make([]byte, 4+len(bs))
+	binary.LittleEndian.PutUint32(rec, uint32(len(bs)))
+	copy(rec[4:], bs)
+	if _, err = b.f.WriteAt(rec, b.end); err != nil {
+		return err
+	}
+	b.end += int64(len(rec))
+	return nil
+}
+
+// append adds a record, dropping the oldest if the buffer is full.
+func (b *diskBuffer) append(r diskRecord) {
+	if b.max > 0 && len(b.recs) >= b.max {
+		b.recs = b.recs[len(b.recs)-b.max+1:]
+		replayDropped.Inc()
+	}
+	b.recs = append(b.recs, r)
+}
+
+func (b *diskBuffer) add(m *logspray.Message) error {
+	b.Lock()
+	defer b.Unlock()
+	if err := b.compact(); err != nil {
+		return fmt.Errorf("failed compacting replay buffer, %w", err)
+	}
+	offset := b.end
+	if err := b.write(m); err != nil {
+		return fmt.Errorf("failed writing to replay buffer, %w", err)
+	}
+	b.append(diskRecord{index: m.Index, offset: offset, size: int(b.end - offset)})
+	return nil
+}
+
+// compact moves the unacknowledged messages to just after the header,
+// once enough of the file has been acknowledged. It is only called
+// while adding messages, so never while they are being read.
+func (b *diskBuffer) compact() error {
+	start := b.end
+	if len(b.recs) > 0 {
+		start = b.recs[0].offset
+	}
+	acked := start - b.start
+	if acked == 0 || start < b.end && (acked < compactBytes || start < b.end/2) {
+		return nil
+	}
+
+	// The data is moved towards the start of the file, so it can be
+	// copied in place.
+	buf := make([]byte, 64*1024)
+	var n int64
+	for start+n < b.end {
+		c, err := b.f.ReadAt(buf, start+n)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if c == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		if _, err = b.f.WriteAt(buf[:c], b.start+n); err != nil {
+			return err
+		}
+		n += int64(c)
+	}
+	if err := b.f.Truncate(b.start + n); err != nil {
+		return err
+	}
+	for i := range b.recs {
+		b.recs[i].offset -= acked
+	}
+	b.end = b.start + n
+	return nil
+}
+
+func (b *diskBuffer) ack(index uint64) {
+	b.Lock()
+	defer b.Unlock()
+	b.recs = b.recs[sort.Search(len(b.recs), func(i int) bool { return b.recs[i].index > index }):]
+}
+
+func (b *diskBuffer) messages(fn func(*logspray.Message) error) error {
+	b.Lock()
+	recs := append([]diskRecord(nil), b.recs...)
+	b.Unlock()
+
+	for _, r := range recs {
+		m, _, err := readRecord(io.NewSectionReader(b.f, r.offset, int64(r.size)))
+		if err != nil {
+			return fmt.Errorf("failed reading replay buffer, %w", err)
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *diskBuffer) len() int {
+	b.Lock()
+	defer b.Unlock()
+	return len(b.recs)
+}
+
+// close closes the file, and removes it if every message was
+// acknowledged.
+func (b *diskBuffer) close() error {
+	b.Lock()
+	defer b.Unlock()
+	err := b.f.Close()
+	if len(b.recs) == 0 {
+		if rerr := os.Remove(b.fn); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,240 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logspray is a collection of tools for streaming and indexing
+// large volumes of dynamic logs.
+
+package remote
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/QubitProducts/logspray/proto/logspray"
+	"github.com/oklog/ulid"
+)
+
+func testHeader() *logspray.Message {
+	return &logspray.Message{
+		StreamID:       ulid.MustNew(ulid.Now(), rand.Reader).String(),
+		Labels:         map[string]string{"job": "test"},
+		ControlMessage: logspray.Message_SETHEADER,
+	}
+}
+
+func bufferIndexes(t *testing.T, b replayBuffer) []uint64 {
+	var is []uint64
+	err := b.messages(func(m *logspray.Message) error {
+		is = append(is, m.Index)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed reading buffer, %v", err)
+	}
+	return is
+}
+
+func addMessages(t *testing.T, b replayBuffer, first, last uint64) {
+	for i := first; i <= last; i++ {
+		m := &logspray.Message{Index: i, Text: "message"}
+		if err := b.add(m); err != nil {
+			t.Fatalf("failed adding message %d, %v", i, err)
+		}
+	}
+}
+
+func TestReplayBuffer(t *testing.T) {
+	tests := []struct {
+		name string
+		max  int
+		last uint64
+		ack  uint64
+		want []uint64
+	}{
+		{name: "empty", ack: 0},
+		{name: "unacked", last: 5, want: []uint64{1, 2, 3, 4, 5}},
+		{name: "acked", last: 5, ack: 3, want: []uint64{4, 5}},
+		{name: "all acked", last: 5, ack: 5},
+		{name: "full", max: 3, last: 5, want: []uint64{3, 4, 5}},
+		{name: "full acked", max: 3, last: 5, ack: 4, want: []uint64{5}},
+	}
+
+	for _, st := range tests {
+		bufs := map[string]func(t *testing.T, dir string) replayBuffer{
+			"memory": func(t *testing.T, dir string) replayBuffer {
+				return newMemBuffer(st.max)
+			},
+			"disk": func(t *testing.T, dir string) replayBuffer {
+				b, err := newDiskBuffer(dir, testHeader(), st.max)
+				if err != nil {
+					t.Fatalf("failed creating buffer, %v", err)
+				}
+				return b
+			},
+		}
+		for bn, newBuf := range bufs {
+			t.Run(st.name+"/"+bn, func(t *testing.T) {
+				dir, err := ioutil.TempDir("", "replay")
+				if err != nil {
+					t.Fatalf("failed creating temp dir, %v", err)
+				}
+				defer os.RemoveAll(dir)
+
+				b := newBuf(t, dir)
+				addMessages(t, b, 1, st.last)
+				b.ack(st.ack)
+				if got := bufferIndexes(t, b); !reflect.DeepEqual(got, st.want) {
+					t.Fatalf("wrong messages, got %v, want %v", got, st.want)
+				}
+				if b.len() != len(st.want) {
+					t.Fatalf("wrong length, got %d, want %d", b.len(), len(st.want))
+				}
+				if err := b.close(); err != nil {
+					t.Fatalf("failed closing buffer, %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestDiskBuffer_Reopen(t *testing.T) {
+	tests := []struct {
+		name string
+		tail []byte
+		ack  uint64
+		want []uint64
+	}{
+		{name: "clean", ack: 3, want: []uint64{4, 5, 6}},
+		{name: "torn size", tail: []byte{10, 0}, ack: 3, want: []uint64{4, 5, 6}},
+		{name: "torn record", tail: []byte{10, 0, 0, 0, 1, 2}, ack: 3, want: []uint64{4, 5, 6}},
+		{name: "all acked", ack: 6},
+	}
+
+	for _, st := range tests {
+		t.Run(st.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "replay")
+			if err != nil {
+				t.Fatalf("failed creating temp dir, %v", err)
+			}
+			defer os.RemoveAll(dir)
+
+			header := testHeader()
+			b, err := newDiskBuffer(dir, header, 0)
+			if err != nil {
+				t.Fatalf("failed creating buffer, %v", err)
+			}
+			addMessages(t, b, 1, 6)
+			b.ack(st.ack)
+			if err := b.close(); err != nil {
+				t.Fatalf("failed closing buffer, %v", err)
+			}
+
+			fn := filepath.Join(dir, header.StreamID+replaySuffix)
+			if len(st.want) == 0 {
+				if _, err := os.Stat(fn); !os.IsNotExist(err) {
+					t.Fatalf("buffer file wasn't removed, %v", err)
+				}
+				return
+			}
+			fi, err := os.Stat(fn)
+			if err != nil {
+				t.Fatalf("buffer file was removed, %v", err)
+			}
+
+			if len(st.tail) > 0 {
+				f, err := os.OpenFile(fn, os.O_WRONLY|os.O_APPEND, 0)
+				if err != nil {
+					t.Fatalf("failed opening buffer file, %v", err)
+				}
+				f.Write(st.tail)
+				f.Close()
+			}
+
+			rb, rheader, err := openDiskBuffer(fn, 0)
+			if err != nil {
+				t.Fatalf("failed reopening buffer, %v", err)
+			}
+			if !reflect.DeepEqual(rheader, header) {
+				t.Fatalf("wrong header, got %v, want %v", rheader, header)
+			}
+			// The acknowledged messages are still in the file, the
+			// server drops them when they are resent.
+			if got := bufferIndexes(t, rb); !reflect.DeepEqual(got, []uint64{1, 2, 3, 4, 5, 6}) {
+				t.Fatalf("wrong messages, got %v", got)
+			}
+			if nfi, _ := os.Stat(fn); nfi.Size() != fi.Size() {
+				t.Fatalf("torn tail wasn't truncated, size %d, want %d", nfi.Size(), fi.Size())
+			}
+
+			addMessages(t, rb, 7, 8)
+			rb.ack(st.ack)
+			want := append(st.want, 7, 8)
+			if got := bufferIndexes(t, rb); !reflect.DeepEqual(got, want) {
+				t.Fatalf("wrong messages after adding, got %v, want %v", got, want)
+			}
+			rb.ack(8)
+			if err := rb.close(); err != nil {
+				t.Fatalf("failed closing buffer, %v", err)
+			}
+			if _, err := os.Stat(fn); !os.IsNotExist(err) {
+				t.Fatalf("empty buffer file wasn't removed, %v", err)
+			}
+		})
+	}
+}
+
+func TestDiskBuffer_Compact(t *testing.T) {
+	defer func(n int64) { compactBytes = n }(compactBytes)
+	compactBytes = 64
+
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatalf("failed creating temp dir, %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	header := testHeader()
+	b, err := newDiskBuffer(dir, header, 0)
+	if err != nil {
+		t.Fatalf("failed creating buffer, %v", err)
+	}
+	addMessages(t, b, 1, 20)
+	b.ack(15)
+	size := b.end
+	addMessages(t, b, 21, 22)
+	if b.end >= size {
+		t.Fatalf("buffer wasn't compacted, size %d, was %d", b.end, size)
+	}
+	want := []uint64{16, 17, 18, 19, 20, 21, 22}
+	if got := bufferIndexes(t, b); !reflect.DeepEqual(got, want) {
+		t.Fatalf("wrong messages, got %v, want %v", got, want)
+	}
+	if err := b.close(); err != nil {
+		t.Fatalf("failed closing buffer, %v", err)
+	}
+
+	rb, rheader, err := openDiskBuffer(filepath.Join(dir, header.StreamID+replaySuffix), 0)
+	if err != nil {
+		t.Fatalf("failed reopening buffer, %v", err)
+	}
+	defer rb.close()
+	if rheader.StreamID != header.StreamID {
+		t.Fatalf("wrong header after compaction, got %v", rheader)
+	}
+	if got := bufferIndexes(t, rb); !reflect.DeepEqual(got, want) {
+		t.Fatalf("wrong messages after reopening, got %v, want %v", got, want)
+	}
+}